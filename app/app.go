@@ -3,44 +3,92 @@ package app
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/alitto/pond"
 	c "github.com/iomarmochtar/cir-rotator/app/config"
+	"github.com/iomarmochtar/cir-rotator/app/journal"
 	fl "github.com/iomarmochtar/cir-rotator/pkg/filter"
 	"github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
 	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/iomarmochtar/cir-rotator/pkg/skiplist"
 	"github.com/rs/zerolog/log"
 )
 
 type App struct {
-	config c.IConfig
+	config  c.IConfig
+	journal *journal.Journal
 }
 
 func New(config c.IConfig) *App {
-	return &App{config}
+	return &App{config: config}
 }
 
-func (a App) ListRepositories() ([]reg.Repository, error) {
+// WithJournal recording each of digest deletion outcome to the journal
+func (a *App) WithJournal(j *journal.Journal) *App {
+	a.journal = j
+	return a
+}
+
+func (a App) ListRepositories(ctx context.Context) ([]reg.Repository, error) {
 	if configRepos := a.config.RepositoryList(); len(configRepos) != 0 {
 		return configRepos, nil
 	}
-	return a.fetchAndFilterRepositories()
+	return a.fetchAndFilterRepositories(ctx)
+}
+
+// ExcludeSkipList removing the digests that are listed in skip list, used by kubernetes workloads or locked in registry and the
+// platform manifests that are still referenced by the kept index, the repository without any digest left will be removed as well
+func (a App) ExcludeSkipList(repositories []reg.Repository) []reg.Repository {
+	skipList := a.config.SkipList()
+	runningImages := kube.NewIndex(a.config.RunningImages())
+	result := []reg.Repository{}
+	for idr := range repositories {
+		repo := repositories[idr]
+		if !skipList.IsEmpty() {
+			filterRepositoryDigestBySkipList(&repo, skipList)
+		}
+		if !runningImages.IsEmpty() {
+			filterRepositoryDigestByRunningImages(&repo, runningImages)
+		}
+		filterLockedDigests(&repo)
+		filterReferencedDigests(&repo)
+		if len(repo.Digests) != 0 {
+			result = append(result, repo)
+		}
+	}
+	return result
 }
 
-func (a App) DeleteRepositories(repositories []reg.Repository) (err error) {
+// DeleteRepositories deleting the digests of repositories in parallel. if the context is done (eg: interrupted by signal)
+// then the digests that are being deleted will be finished first, the rest of them are listed as pending in report.
+// the index and the subject are deleted before their platform manifests and referrers, which are kept if the deletion of it is failed
+func (a App) DeleteRepositories(ctx context.Context, repositories []reg.Repository) (*Report, error) {
 	skipList := a.config.SkipList()
+	runningImages := kube.NewIndex(a.config.RunningImages())
 	totalRepository := len(repositories)
+	report := newReport()
+	var enqueued []reg.Repository
 	// create worker pool for parallel deletion for each repository
 	pool := pond.New(a.config.HTTPWorkerCount(), totalRepository)
-	defer pool.StopAndWait()
-	workers, _ := pool.GroupContext(context.Background())
+	workers, workerCtx := pool.GroupContext(ctx)
 	for idr := range repositories {
 		repo := repositories[idr]
-		// filter the list of tags if skiplist provided, if it's matched then ignore the related digest for deletion
-		if len(skipList) != 0 {
-			filterRepositoryDigestBySkipList(&repo, skipList)
+		// filter the digests if skiplist provided, if it's matched then ignore the related digest for deletion
+		if !skipList.IsEmpty() {
+			report.protect(filterRepositoryDigestBySkipList(&repo, skipList))
+		}
+		// the digest that is used by kubernetes workload is matched by it's digest as well, not only the tag
+		if !runningImages.IsEmpty() {
+			report.protect(filterRepositoryDigestByRunningImages(&repo, runningImages))
 		}
+		// the locked digest cannot be deleted, it's platform manifests are kept as well by the following filter
+		report.protect(filterLockedDigests(&repo))
+		// the platform manifests of the kept index must be kept as well, otherwise the multi-arch image is broken
+		filterReferencedDigests(&repo)
+		repo.Digests = sortDigestsByParent(repo.Digests)
 		// if there is no such digests in repository so then nothing todo with it.
 		if len(repo.Digests) == 0 {
 			log.Warn().Str("repo", repo.Name).Msg("no digest found as for deleting in repository, skip it")
@@ -57,33 +105,63 @@ func (a App) DeleteRepositories(repositories []reg.Repository) (err error) {
 		}
 
 		lg.Msg("enqueue for deletion")
+		enqueued = append(enqueued, repo)
 		workers.Submit(func() error {
 			repoLog := log.With().Str("repo", repo.Name).
 				Int("total_digest", len(repo.Digests)).
 				Str("total_size", getDigestTotalSize(repo.Digests)).Logger()
 			repoLog.Info().Msg("begin deletion process")
 			begin := time.Now()
-			if err := a.config.ImageRegistry().Delete(repo); err != nil {
-				err = fmt.Errorf("error while deleting repository %s: %w", repo.Name, err)
-				if !a.config.SkipDeletionErr() {
+			imageReg := a.config.ImageRegistry()
+			failed := map[string]bool{}
+			// deleting one by one digest so the outcome of each of them can be recorded in journal
+			for idd := range repo.Digests {
+				if err := workerCtx.Err(); err != nil {
+					repoLog.Warn().Int("deleted_digest", idd).Msg("stopped")
 					return err
 				}
-				repoLog.Err(err).Msg("skip")
+				digest := repo.Digests[idd]
+				var err error
+				if parent := findParentDigest(digest, func(name string) bool { return failed[name] }); parent != "" {
+					err = fmt.Errorf("digest %s is still referenced by %s that is failed to be deleted", digest.Name, parent)
+				} else {
+					// the digest deletion is not interrupted in the middle, so the tags and the digest are not deleted partially
+					err = imageReg.Delete(context.WithoutCancel(workerCtx), reg.Repository{Name: repo.Name, Digests: []reg.Digest{digest}})
+				}
+				if err != nil {
+					failed[digest.Name] = true
+				}
+				report.record(repo.Name, digest, err)
+				a.record(repo.Name, digest, err)
+				if err != nil {
+					err = fmt.Errorf("error while deleting repository %s: %w", repo.Name, err)
+					if !a.config.SkipDeletionErr() {
+						return err
+					}
+					repoLog.Err(err).Str("digest", digest.Name).Msg("skip")
+				}
 			}
 			duration := time.Since(begin)
 			repoLog.Info().Str("duration", helpers.HumanizeDuration(duration)).Msg("done")
 			return nil
 		})
 	}
-	return workers.Wait()
+	err := workers.Wait()
+	// draining the workers, the running deletion is waited to be finished
+	pool.StopAndWait()
+	report.finalize(enqueued)
+	return report, err
 }
 
-func (a App) fetchAndFilterRepositories() ([]reg.Repository, error) {
+func (a App) fetchAndFilterRepositories(ctx context.Context) ([]reg.Repository, error) {
 	log.Info().Msg("listing repository catalog")
-	repositories, err := a.config.ImageRegistry().Catalog()
+	repositories, err := a.config.ImageRegistry().Catalog(ctx)
 	if err != nil {
 		return nil, err
 	}
+	for idr := range repositories {
+		reg.LinkReferrers(repositories[idr].Digests)
+	}
 
 	includeFilter := a.config.IncludeEngine()
 	excludeFilter := a.config.ExcludeEngine()
@@ -92,7 +170,64 @@ func (a App) fetchAndFilterRepositories() ([]reg.Repository, error) {
 		return repositories, nil
 	}
 
-	return doFilter(repositories, includeFilter, excludeFilter)
+	filtered, err := doFilter(repositories, includeFilter, excludeFilter)
+	if err != nil {
+		return nil, err
+	}
+	return cascadeDependents(repositories, filtered), nil
+}
+
+// cascadeDependents adding the digests that are depending on the filtered digests (and their dependents as well) but not matched
+// by the filters. they are the referrers of the selected subject, so the artifacts such as signature and sbom are not left orphaned,
+// and the platform manifests whose parent indexes are all selected, so they are not left dangling after their index is deleted
+func cascadeDependents(repositories, filtered []reg.Repository) []reg.Repository {
+	repoIndex := map[string]int{}
+	for idr := range repositories {
+		repoIndex[repositories[idr].Name] = idr
+	}
+
+	for idr := range filtered {
+		repo := &filtered[idr]
+		idx, ok := repoIndex[repo.Name]
+		if !ok {
+			continue
+		}
+		selected := map[string]bool{}
+		for _, digest := range repo.Digests {
+			selected[digest.Name] = true
+		}
+
+		for added := true; added; {
+			added = false
+			for _, digest := range repositories[idx].Digests {
+				if selected[digest.Name] {
+					continue
+				}
+				switch {
+				case selected[digest.Subject]:
+					log.Debug().Str("repo", repo.Name).Str("digest", digest.Name).Str("subject", digest.Subject).Msg("including the referrer of subject")
+				case len(digest.ParentDigests) != 0 && allSelected(digest.ParentDigests, selected):
+					log.Debug().Str("repo", repo.Name).Str("digest", digest.Name).Strs("parents", digest.ParentDigests).Msg("including the platform manifest of index")
+				default:
+					continue
+				}
+				selected[digest.Name] = true
+				repo.Digests = append(repo.Digests, digest)
+				added = true
+			}
+		}
+	}
+	return filtered
+}
+
+// allSelected all of the digest names are selected
+func allSelected(names []string, selected map[string]bool) bool {
+	for _, name := range names {
+		if !selected[name] {
+			return false
+		}
+	}
+	return true
 }
 
 // filterRepositories filter listing repositories and digest based in include and exclude filters
@@ -103,15 +238,31 @@ func doFilter(repositories []reg.Repository, includeFilter, excludeFilter fl.IFi
 	for idr := range repositories {
 		repo := repositories[idr]
 		var resultDigest []reg.Digest
+		// ranks are computed by the top level digests in repository before it's filtered, the platform manifests and
+		// the referrers are following their index and subject so they are not ranked
+		rankByUploaded, totalDigests := rankDigests(repo.Digests, func(d reg.Digest) time.Time { return d.Uploaded })
+		rankByCreated, _ := rankDigests(repo.Digests, func(d reg.Digest) time.Time { return d.Created })
 		for idd := range repo.Digests {
 			digest := repo.Digests[idd]
 			fields := fl.Fields{
-				Repository: repo.Name,
-				Digest:     digest.Name,
-				ImageSize:  digest.ImageSizeBytes,
-				Tags:       digest.Tag,
-				CreatedAt:  digest.Created,
-				UploadedAt: digest.Uploaded,
+				Repository:     repo.Name,
+				Digest:         digest.Name,
+				ImageSize:      digest.ImageSizeBytes,
+				Tags:           digest.Tag,
+				CreatedAt:      digest.Created,
+				UploadedAt:     digest.Uploaded,
+				PulledAt:       digest.PulledAt(),
+				RankByUploaded: rankByUploaded[idd],
+				RankByCreated:  rankByCreated[idd],
+				TotalDigests:   totalDigests,
+				MediaType:      digest.MediaType,
+				IsIndex:        digest.IsIndex(),
+				Platforms:      digest.Platforms,
+				ParentDigests:  digest.ParentDigests,
+				Subject:        digest.Subject,
+				ArtifactType:   digest.ArtifactType,
+				Referrers:      digest.Referrers,
+				HasSignature:   digest.HasSignature,
 			}
 
 			if includeFilter != nil {
@@ -144,24 +295,171 @@ func doFilter(repositories []reg.Repository, includeFilter, excludeFilter fl.IFi
 	return result, nil
 }
 
-func filterRepositoryDigestBySkipList(repo *reg.Repository, skipList []string) {
+// rankDigests returning the rank of each top level digest (by it's index) ordered by the newest time, started from 1,
+// and the total of ranked digests. the rank of the other digests is 0. digests with the same time are ordered by it's name
+// so the result is deterministic
+func rankDigests(digests []reg.Digest, timeOf func(reg.Digest) time.Time) ([]int, int) {
+	order := make([]int, 0, len(digests))
+	for idx := range digests {
+		if digests[idx].IsTopLevel() {
+			order = append(order, idx)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		ti, tj := timeOf(digests[order[i]]), timeOf(digests[order[j]])
+		if ti.Equal(tj) {
+			return digests[order[i]].Name < digests[order[j]].Name
+		}
+		return ti.After(tj)
+	})
+
+	ranks := make([]int, len(digests))
+	for rank, idx := range order {
+		ranks[idx] = rank + 1
+	}
+	return ranks, len(order)
+}
+
+// filterRepositoryDigestBySkipList removing the digests that are matched by the skip rules, it's returning the removed one
+// and the rule that is matched
+func filterRepositoryDigestBySkipList(repo *reg.Repository, skipList skiplist.Matcher) []Protection {
+	var protections []Protection
 	tmpDigests := []reg.Digest{}
 	for idd := range repo.Digests {
-		includeDigest := true
 		digest := repo.Digests[idd]
-		for idt := range digest.Tag {
-			imageName := fmt.Sprintf("%s:%s", repo.Name, digest.Tag[idt])
-			if helpers.IsInList(imageName, skipList) {
-				includeDigest = false
-				log.Info().Str("image", imageName).Str("digest", digest.Name).Msg("listed in skip list, ignoring related digest")
-				break
-			}
+		if rule, ok := skipList.Match(repo.Name, digest.Name, digest.Tag); ok {
+			log.Info().Str("repo", repo.Name).Str("digest", digest.Name).Str("rule", rule.Text).Int("line", rule.Line).Msg("listed in skip list, ignoring related digest")
+			protections = append(protections, Protection{Repository: repo.Name, Digest: digest.Name, Rule: "skip list " + rule.String()})
+			continue
 		}
-		if includeDigest {
-			tmpDigests = append(tmpDigests, digest)
+		tmpDigests = append(tmpDigests, digest)
+	}
+	repo.Digests = tmpDigests
+	return protections
+}
+
+// filterRepositoryDigestByRunningImages removing the digests that are used by the containers of kubernetes workloads
+func filterRepositoryDigestByRunningImages(repo *reg.Repository, runningImages kube.Index) []Protection {
+	var protections []Protection
+	tmpDigests := []reg.Digest{}
+	for idd := range repo.Digests {
+		digest := repo.Digests[idd]
+		if image, ok := runningImages.Match(repo.Name, digest.Name, digest.Tag); ok {
+			log.Info().Str("repo", repo.Name).Str("image", image.String()).Str("digest", digest.Name).Msg("used by kubernetes workload, ignoring related digest")
+			protections = append(protections, Protection{Repository: repo.Name, Digest: digest.Name, Rule: "kubernetes workload " + image.String()})
+			continue
+		}
+		tmpDigests = append(tmpDigests, digest)
+	}
+	repo.Digests = tmpDigests
+	return protections
+}
+
+// filterLockedDigests removing the digests that are locked for deletion in registry
+func filterLockedDigests(repo *reg.Repository) []Protection {
+	var protections []Protection
+	tmpDigests := []reg.Digest{}
+	for idd := range repo.Digests {
+		digest := repo.Digests[idd]
+		if digest.Locked {
+			log.Info().Str("repo", repo.Name).Str("digest", digest.Name).Msg("locked in registry, ignoring related digest")
+			protections = append(protections, Protection{Repository: repo.Name, Digest: digest.Name, Rule: "locked in registry"})
+			continue
 		}
+		tmpDigests = append(tmpDigests, digest)
 	}
 	repo.Digests = tmpDigests
+	return protections
+}
+
+// filterReferencedDigests removing the digests that are referenced by the index which is not going to be deleted and
+// the referrers of the subject that is kept. it's repeated since the removed one can be referenced by the others as well
+func filterReferencedDigests(repo *reg.Repository) {
+	for {
+		deleted := map[string]bool{}
+		for idd := range repo.Digests {
+			deleted[repo.Digests[idd].Name] = true
+		}
+
+		tmpDigests := []reg.Digest{}
+		for idd := range repo.Digests {
+			digest := repo.Digests[idd]
+			if parent := findParentDigest(digest, func(name string) bool { return !deleted[name] }); parent != "" {
+				log.Warn().Str("repo", repo.Name).Str("digest", digest.Name).Str("parent", parent).Msg("referenced by the kept index or subject, ignoring related digest")
+				continue
+			}
+			tmpDigests = append(tmpDigests, digest)
+		}
+		if len(tmpDigests) == len(repo.Digests) {
+			return
+		}
+		repo.Digests = tmpDigests
+	}
+}
+
+// sortDigestsByParent ordering the digests so the index is placed before it's children that are deleted as well,
+// if the child is deleted first then the registry may refuse it or the index is left broken. the subject is placed before
+// it's referrers, so the signature is not deleted if the deletion of image is failed.
+// it's returning the sorted copy, so the given digests are not reordered
+func sortDigestsByParent(digests []reg.Digest) []reg.Digest {
+	byName := map[string]reg.Digest{}
+	for _, digest := range digests {
+		byName[digest.Name] = digest
+	}
+
+	// depth is the longest chain of parents in the list, the visited is guarding against cyclic references
+	depths := map[string]int{}
+	var depthOf func(digest reg.Digest, visited map[string]bool) int
+	depthOf = func(digest reg.Digest, visited map[string]bool) int {
+		if depth, ok := depths[digest.Name]; ok {
+			return depth
+		}
+		visited[digest.Name] = true
+		depth := 0
+		for _, parentName := range parentDigests(digest) {
+			parent, ok := byName[parentName]
+			if !ok || visited[parentName] {
+				continue
+			}
+			depth = max(depth, depthOf(parent, visited)+1)
+		}
+		depths[digest.Name] = depth
+		return depth
+	}
+	for _, digest := range digests {
+		depthOf(digest, map[string]bool{})
+	}
+	sorted := append([]reg.Digest{}, digests...)
+	sort.SliceStable(sorted, func(i, j int) bool { return depths[sorted[i].Name] < depths[sorted[j].Name] })
+	return sorted
+}
+
+// parentDigests the index that are referencing the digest and the subject of artifact
+func parentDigests(digest reg.Digest) []string {
+	if digest.Subject == "" {
+		return digest.ParentDigests
+	}
+	return append(append([]string{}, digest.ParentDigests...), digest.Subject)
+}
+
+// findParentDigest returning the first parent (index or subject) of digest that is matched
+func findParentDigest(digest reg.Digest, match func(name string) bool) string {
+	for _, parent := range parentDigests(digest) {
+		if match(parent) {
+			return parent
+		}
+	}
+	return ""
+}
+
+// record the deletion outcome to journal if it's set, failing to write will not stop the deletion process
+func (a App) record(repoName string, digest reg.Digest, deleteErr error) {
+	if a.journal == nil {
+		return
+	}
+	if err := a.journal.Record(repoName, digest, deleteErr); err != nil {
+		log.Err(err).Str("repo", repoName).Str("digest", digest.Name).Msg("error while writing journal")
+	}
 }
 
 func getDigestTotalSize(digests []reg.Digest) string {
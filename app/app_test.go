@@ -1,16 +1,21 @@
 package app_test
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/iomarmochtar/cir-rotator/app"
 	mc "github.com/iomarmochtar/cir-rotator/app/config/mock_config"
+	"github.com/iomarmochtar/cir-rotator/app/journal"
 	fl "github.com/iomarmochtar/cir-rotator/pkg/filter"
 	mf "github.com/iomarmochtar/cir-rotator/pkg/filter/mock_filter"
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
 	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
 	mr "github.com/iomarmochtar/cir-rotator/pkg/registry/mock_registry"
+	"github.com/iomarmochtar/cir-rotator/pkg/skiplist"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 )
@@ -44,7 +49,41 @@ var (
 	}
 )
 
+func newSkipList(t *testing.T, lines ...string) skiplist.Matcher {
+	matcher, err := skiplist.New(lines)
+	assert.NoError(t, err)
+	return matcher
+}
+
 func TestApp_ListRepositories(t *testing.T) {
+	rankedRepos := []reg.Repository{
+		{
+			Name: "image-ranked",
+			Digests: []reg.Digest{
+				{
+					Name:     "sha256:0000000000000000000000000000000000000000000000000000000000000001",
+					Tag:      []string{"oldest"},
+					Uploaded: time.Date(2021, time.Month(1), 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					Name:     "sha256:0000000000000000000000000000000000000000000000000000000000000002",
+					Tag:      []string{"newest"},
+					Uploaded: time.Date(2021, time.Month(4), 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					Name:     "sha256:0000000000000000000000000000000000000000000000000000000000000003",
+					Tag:      []string{"keep"},
+					Uploaded: time.Date(2021, time.Month(2), 1, 0, 0, 0, 0, time.UTC),
+				},
+				{
+					Name:     "sha256:0000000000000000000000000000000000000000000000000000000000000004",
+					Tag:      []string{"second"},
+					Uploaded: time.Date(2021, time.Month(3), 1, 0, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+
 	testCases := map[string]struct {
 		mockConfig         func(*gomock.Controller) *mc.MockIConfig
 		mockImageRegistry  func(*mr.MockImageRegistry)
@@ -56,7 +95,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"error while get repository catalog": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(nil, fmt.Errorf("an error while fetching catalog"))
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(nil, fmt.Errorf("an error while fetching catalog"))
 
 				mockConfig := mc.NewMockIConfig(ctrl)
 
@@ -72,7 +111,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"error in include filter": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(sampleRepos, nil)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(sampleRepos, nil)
 
 				mif := mf.NewMockIFilterEngine(ctrl)
 				mif.EXPECT().Process(gomock.Any()).Times(1).Return(false, fmt.Errorf("error in include filter"))
@@ -90,7 +129,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"error in exclude filter": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(sampleRepos, nil)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(sampleRepos, nil)
 
 				mif := mf.NewMockIFilterEngine(ctrl)
 				mif.EXPECT().Process(gomock.Any()).Times(1).Return(true, nil)
@@ -111,7 +150,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"if filters are not provided then will returning all results": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(sampleRepos, nil)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(sampleRepos, nil)
 
 				mockConfig := mc.NewMockIConfig(ctrl)
 				mockConfig.EXPECT().RepositoryList().Times(1).Return([]reg.Repository{})
@@ -125,7 +164,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"if match with exclude filter then it will not passed as result": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(sampleRepos, nil)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(sampleRepos, nil)
 
 				mif := mf.NewMockIFilterEngine(ctrl)
 				mif.EXPECT().Process(gomock.Any()).Times(2).Return(true, nil)
@@ -150,7 +189,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"include filter is provided but none match with it": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(sampleRepos, nil)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(sampleRepos, nil)
 
 				mif := mf.NewMockIFilterEngine(ctrl)
 				mif.EXPECT().Process(gomock.Any()).AnyTimes().Return(false, nil)
@@ -170,7 +209,7 @@ func TestApp_ListRepositories(t *testing.T) {
 		"exclude filter is provided but no one match with it": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Catalog().Times(1).Return(sampleRepos, nil)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(sampleRepos, nil)
 
 				mef := mf.NewMockIFilterEngine(ctrl)
 				mef.EXPECT().Process(gomock.Any()).AnyTimes().Return(false, nil)
@@ -184,6 +223,101 @@ func TestApp_ListRepositories(t *testing.T) {
 			},
 			expectRepositories: sampleRepos,
 		},
+		"keep the newest digests of each repository by rank": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(rankedRepos, nil)
+
+				// the rank is computed before filtering, so the excluded digest is still counted
+				includeFilter, err := fl.New([]string{"RankByUploaded > 2 && TotalDigests == 4"})
+				assert.NoError(t, err)
+				excludeFilter, err := fl.New([]string{"'keep' in Tags"})
+				assert.NoError(t, err)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().RepositoryList().Times(1).Return([]reg.Repository{})
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().IncludeEngine().Times(1).Return(includeFilter)
+				mockConfig.EXPECT().ExcludeEngine().Times(1).Return(excludeFilter)
+				return mockConfig
+			},
+			expectRepositories: []reg.Repository{
+				{Name: "image-ranked", Digests: []reg.Digest{rankedRepos[0].Digests[0]}},
+			},
+		},
+		"the platform manifests and the referrers are not ranked": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				repos := multiArchRepos()
+				repos[0].Digests = append(repos[0].Digests[:2],
+					reg.Digest{Name: "sha256:5555555555555555555555555555555555555555555555555555555555555555", Subject: "sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+					reg.Digest{Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Tag: []string{"v0.1.0"}})
+				// the platform manifest and the referrer are newer than the old image, but the old one is still ranked 2nd
+				for idd, day := range []int{3, 4, 5, 1} {
+					repos[0].Digests[idd].Uploaded = time.Date(2023, time.March, day, 0, 0, 0, 0, time.UTC)
+				}
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(repos, nil)
+
+				includeFilter, err := fl.New([]string{"RankByUploaded > 1 && TotalDigests == 2"})
+				assert.NoError(t, err)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().RepositoryList().Times(1).Return([]reg.Repository{})
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().IncludeEngine().Times(1).Return(includeFilter)
+				mockConfig.EXPECT().ExcludeEngine().Times(1).Return(nil)
+				return mockConfig
+			},
+			expectRepositories: []reg.Repository{{Name: "image-6", Digests: []reg.Digest{{
+				Name:     "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+				Tag:      []string{"v0.1.0"},
+				Uploaded: time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC),
+			}}}},
+		},
+		"the referrers of selected subject are included": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(referrerRepos(), nil)
+
+				includeFilter, err := fl.New([]string{"'v1.0.0' in Tags || 'orphan' in Tags"})
+				assert.NoError(t, err)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().RepositoryList().Times(1).Return([]reg.Repository{})
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().IncludeEngine().Times(1).Return(includeFilter)
+				mockConfig.EXPECT().ExcludeEngine().Times(1).Return(nil)
+				return mockConfig
+			},
+			expectRepositories: func() []reg.Repository {
+				repos := referrerRepos()
+				reg.LinkReferrers(repos[0].Digests)
+				d := repos[0].Digests
+				// the sbom, it's signature and the cosign signature are following the subject
+				return []reg.Repository{{Name: "image-5", Digests: []reg.Digest{d[0], d[4], d[1], d[2], d[3]}}}
+			}(),
+		},
+		"the platform manifests of selected index are included": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Catalog(gomock.Any()).Times(1).Return(multiArchRepos(), nil)
+
+				includeFilter, err := fl.New([]string{"'v1.0.0' in Tags"})
+				assert.NoError(t, err)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().RepositoryList().Times(1).Return([]reg.Repository{})
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().IncludeEngine().Times(1).Return(includeFilter)
+				mockConfig.EXPECT().ExcludeEngine().Times(1).Return(nil)
+				return mockConfig
+			},
+			expectRepositories: func() []reg.Repository {
+				d := multiArchRepos()[0].Digests
+				// the platform manifest that is shared with the kept index is not included
+				return []reg.Repository{{Name: "image-6", Digests: []reg.Digest{d[0], d[1]}}}
+			}(),
+		},
 		"repository list provided in config initialization": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockConfig := mc.NewMockIConfig(ctrl)
@@ -201,7 +335,7 @@ func TestApp_ListRepositories(t *testing.T) {
 
 			mockConfig := tc.mockConfig(ctrl)
 
-			repositories, err := app.New(mockConfig).ListRepositories()
+			repositories, err := app.New(mockConfig).ListRepositories(context.Background())
 			assert.Equal(t, tc.expectRepositories, repositories)
 			if tc.expectErrMsg != "" {
 				assert.EqualError(t, err, tc.expectErrMsg)
@@ -210,6 +344,35 @@ func TestApp_ListRepositories(t *testing.T) {
 	}
 }
 
+// referrerRepos an image that has cosign signature (by tag schema) and sbom which is signed by notation,
+// the orphaned signature has no subject in repository
+func referrerRepos() []reg.Repository {
+	return []reg.Repository{{
+		Name: "image-5",
+		Digests: []reg.Digest{
+			{Name: "sha256:1111111111111111111111111111111111111111111111111111111111111111", Tag: []string{"v1.0.0"}},
+			{Name: "sha256:5555555555555555555555555555555555555555555555555555555555555555", Subject: "sha256:1111111111111111111111111111111111111111111111111111111111111111", ArtifactType: "application/spdx+json"},
+			{Name: "sha256:6666666666666666666666666666666666666666666666666666666666666666", Subject: "sha256:5555555555555555555555555555555555555555555555555555555555555555", ArtifactType: reg.ArtifactTypeNotarySignature},
+			{Name: "sha256:2222222222222222222222222222222222222222222222222222222222222222", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111.sig"}},
+			{Name: "sha256:9999999999999999999999999999999999999999999999999999999999999999", Tag: []string{"orphan", "sha256-8888888888888888888888888888888888888888888888888888888888888888.sig"}},
+		},
+	}}
+}
+
+// multiArchRepos two indexes that are sharing a platform manifest, each of them has it's own platform manifest as well
+func multiArchRepos() []reg.Repository {
+	return []reg.Repository{{
+		Name: "image-6",
+		Digests: []reg.Digest{
+			{Name: "sha256:1111111111111111111111111111111111111111111111111111111111111111", Tag: []string{"v1.0.0"}, MediaType: reg.MediaTypeOCIIndex},
+			{Name: "sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11", ParentDigests: []string{"sha256:1111111111111111111111111111111111111111111111111111111111111111"}},
+			{Name: "sha256:bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22", ParentDigests: []string{"sha256:1111111111111111111111111111111111111111111111111111111111111111", "sha256:2222222222222222222222222222222222222222222222222222222222222222"}},
+			{Name: "sha256:2222222222222222222222222222222222222222222222222222222222222222", Tag: []string{"v2.0.0"}, MediaType: reg.MediaTypeOCIIndex},
+			{Name: "sha256:cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33cc33", ParentDigests: []string{"sha256:2222222222222222222222222222222222222222222222222222222222222222"}},
+		},
+	}}
+}
+
 func TestApp_DeleteRepositories(t *testing.T) {
 	// construct sample data from sample repo with some additions to match with the test case
 	var repoWithMoreDigest []reg.Repository
@@ -235,19 +398,138 @@ func TestApp_DeleteRepositories(t *testing.T) {
 		},
 	})
 
+	// the platform manifest of kept index (sha256:dd44...) must not be deleted
+	indexDigest := reg.Digest{Name: "sha256:2222222222222222222222222222222222222222222222222222222222222222", Tag: []string{"multi-arch"}, MediaType: reg.MediaTypeOCIIndex}
+	childDigests := []reg.Digest{
+		{Name: "sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11", ParentDigests: []string{indexDigest.Name}},
+		{Name: "sha256:bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22", ParentDigests: []string{indexDigest.Name}},
+		{Name: "sha256:dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44dd44", ParentDigests: []string{"sha256:4444444444444444444444444444444444444444444444444444444444444444"}},
+	}
+	multiArchRepos := []reg.Repository{{Name: "image-4", Digests: append(append([]reg.Digest{}, childDigests...), indexDigest)}}
+	multiArchDelete := func(digest reg.Digest) reg.Repository {
+		return reg.Repository{Name: "image-4", Digests: []reg.Digest{digest}}
+	}
+
+	signedRepos := referrerRepos()
+	reg.LinkReferrers(signedRepos[0].Digests)
+	signed := signedRepos[0].Digests
+	signedDelete := func(digest reg.Digest) reg.Repository {
+		return reg.Repository{Name: "image-5", Digests: []reg.Digest{digest}}
+	}
+
 	testCases := map[string]struct {
 		mockConfig   func(*gomock.Controller) *mc.MockIConfig
 		repositories []reg.Repository
 		expectErrMsg string
 	}{
+		"deleting the subject before it's referrers": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[4])).Times(1).Return(nil)
+				gomock.InOrder(
+					mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[0])).Times(1).Return(nil),
+					mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[1])).Times(1).Return(nil),
+					mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[2])).Times(1).Return(nil),
+				)
+				mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[3])).Times(1).Return(nil)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				return mockConfig
+			},
+			repositories: signedRepos,
+		},
+		"the referrers of subject in skip list are kept": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[4])).Times(1).Return(nil)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().SkipList().Times(1).Return(newSkipList(t, "image-5:v1.0.0"))
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				return mockConfig
+			},
+			repositories: signedRepos,
+		},
+		"not deleting the referrers if their subject is failed to be deleted": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[4])).Times(1).Return(nil)
+				mockReg.EXPECT().Delete(gomock.Any(), signedDelete(signed[0])).Times(1).Return(fmt.Errorf("failure"))
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				mockConfig.EXPECT().SkipDeletionErr().Times(4).Return(true)
+				return mockConfig
+			},
+			repositories: signedRepos,
+		},
+		"deleting the index before it's children and keeping the child of kept index": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				gomock.InOrder(
+					mockReg.EXPECT().Delete(gomock.Any(), multiArchDelete(indexDigest)).Times(1).Return(nil),
+					mockReg.EXPECT().Delete(gomock.Any(), multiArchDelete(childDigests[0])).Times(1).Return(nil),
+					mockReg.EXPECT().Delete(gomock.Any(), multiArchDelete(childDigests[1])).Times(1).Return(nil),
+				)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				return mockConfig
+			},
+			repositories: multiArchRepos,
+		},
+		"the children of index in skip list are kept": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(0)
+				mockConfig.EXPECT().SkipList().Times(1).Return(newSkipList(t, "image-4:multi-arch"))
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				return mockConfig
+			},
+			repositories: multiArchRepos,
+		},
+		"not deleting the children if their index is failed to be deleted": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Delete(gomock.Any(), multiArchDelete(indexDigest)).Times(1).Return(fmt.Errorf("failure"))
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				mockConfig.EXPECT().SkipDeletionErr().Times(3).Return(true)
+				return mockConfig
+			},
+			repositories: multiArchRepos,
+		},
 		"got an error while deleting image": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Delete(sampleRepos[0]).Times(1).Return(fmt.Errorf("failure"))
+				mockReg.EXPECT().Delete(gomock.Any(), sampleRepos[0]).Times(1).Return(fmt.Errorf("failure"))
 
 				mockConfig := mc.NewMockIConfig(ctrl)
 				mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
-				mockConfig.EXPECT().SkipList().Times(1).Return([]string{})
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
 				mockConfig.EXPECT().IsDryRun().Times(2).Return(false)
 				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
 				mockConfig.EXPECT().SkipDeletionErr().Times(1).Return(false)
@@ -259,11 +541,12 @@ func TestApp_DeleteRepositories(t *testing.T) {
 		"will not returning any error if skip-error provided": {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
-				mockReg.EXPECT().Delete(gomock.Any()).Times(2).Return(fmt.Errorf("failure"))
+				mockReg.EXPECT().Delete(gomock.Any(), gomock.Any()).Times(2).Return(fmt.Errorf("failure"))
 
 				mockConfig := mc.NewMockIConfig(ctrl)
 				mockConfig.EXPECT().ImageRegistry().Times(2).Return(mockReg)
-				mockConfig.EXPECT().SkipList().Return([]string{})
+				mockConfig.EXPECT().SkipList().Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Return(nil)
 				mockConfig.EXPECT().IsDryRun().Times(2).Return(false)
 				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
 				mockConfig.EXPECT().SkipDeletionErr().Times(2).Return(true)
@@ -275,12 +558,33 @@ func TestApp_DeleteRepositories(t *testing.T) {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockReg := mr.NewMockImageRegistry(ctrl)
 				expetedDeleteRepoImage1 := reg.Repository{Name: "image-1", Digests: []reg.Digest{deleteRepoDigest}}
-				mockReg.EXPECT().Delete(repoWithMoreDigest[1]).Times(1).Return(nil)
-				mockReg.EXPECT().Delete(expetedDeleteRepoImage1).Times(1).Return(nil)
+				mockReg.EXPECT().Delete(gomock.Any(), repoWithMoreDigest[1]).Times(1).Return(nil)
+				mockReg.EXPECT().Delete(gomock.Any(), expetedDeleteRepoImage1).Times(1).Return(nil)
 
 				mockConfig := mc.NewMockIConfig(ctrl)
 				mockConfig.EXPECT().ImageRegistry().Times(2).Return(mockReg)
-				mockConfig.EXPECT().SkipList().Times(1).Return([]string{"image-1:latest", "image-3:abc", "image-3:def"})
+				mockConfig.EXPECT().SkipList().Times(1).Return(newSkipList(t, "image-1:latest", "image-3:abc", "image-3:def"))
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+				mockConfig.EXPECT().IsDryRun().Times(2).Return(false)
+				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+				return mockConfig
+			},
+			repositories: repoWithMoreDigest,
+		},
+		"the digests that are used by kubernetes workloads are kept": {
+			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
+				mockReg := mr.NewMockImageRegistry(ctrl)
+				mockReg.EXPECT().Delete(gomock.Any(), sampleRepos[0]).Times(1).Return(nil)
+				mockReg.EXPECT().Delete(gomock.Any(), repoWithMoreDigest[2]).Times(1).Return(nil)
+
+				mockConfig := mc.NewMockIConfig(ctrl)
+				mockConfig.EXPECT().ImageRegistry().Times(2).Return(mockReg)
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				// the digest is matched even if it's pulled from the other host
+				mockConfig.EXPECT().RunningImages().Times(1).Return([]kube.Image{
+					kube.ParseImage("mirror.example.com/image-1@" + deleteRepoDigest.Name),
+					kube.ParseImage("image-2:xyz"),
+				})
 				mockConfig.EXPECT().IsDryRun().Times(2).Return(false)
 				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
 				return mockConfig
@@ -291,7 +595,8 @@ func TestApp_DeleteRepositories(t *testing.T) {
 			mockConfig: func(ctrl *gomock.Controller) *mc.MockIConfig {
 				mockConfig := mc.NewMockIConfig(ctrl)
 				mockConfig.EXPECT().ImageRegistry().Times(0)
-				mockConfig.EXPECT().SkipList().Times(1).Return([]string{})
+				mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+				mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
 				mockConfig.EXPECT().IsDryRun().Times(3).Return(true)
 				mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
 				return mockConfig
@@ -306,7 +611,7 @@ func TestApp_DeleteRepositories(t *testing.T) {
 			defer ctrl.Finish()
 
 			mockConfig := tc.mockConfig(ctrl)
-			err := app.New(mockConfig).DeleteRepositories(tc.repositories)
+			_, err := app.New(mockConfig).DeleteRepositories(context.Background(), tc.repositories)
 			if tc.expectErrMsg != "" {
 				assert.EqualError(t, err, tc.expectErrMsg)
 			} else {
@@ -315,3 +620,171 @@ func TestApp_DeleteRepositories(t *testing.T) {
 		})
 	}
 }
+
+func TestApp_ExcludeSkipList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockConfig := mc.NewMockIConfig(ctrl)
+	mockConfig.EXPECT().SkipList().Times(1).Return(newSkipList(t, "image-2:xyz"))
+	mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+	assert.Equal(t, []reg.Repository{sampleRepos[0]}, app.New(mockConfig).ExcludeSkipList(sampleRepos))
+
+	mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+
+	mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+	assert.Equal(t, sampleRepos, app.New(mockConfig).ExcludeSkipList(sampleRepos))
+
+	// the digest that is used by kubernetes workload
+	mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+	mockConfig.EXPECT().RunningImages().Times(1).Return([]kube.Image{kube.ParseImage("image-1@" + sampleRepos[0].Digests[0].Name)})
+	assert.Equal(t, []reg.Repository{sampleRepos[1]}, app.New(mockConfig).ExcludeSkipList(sampleRepos))
+
+	// the platform manifest is excluded since it's index is not listed
+	index := reg.Digest{Name: "sha256:2222222222222222222222222222222222222222222222222222222222222222", MediaType: reg.MediaTypeOCIIndex}
+	child := reg.Digest{Name: "sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11", ParentDigests: []string{index.Name}}
+	mockConfig.EXPECT().SkipList().Times(2).Return(skiplist.Matcher{})
+	mockConfig.EXPECT().RunningImages().Times(2).Return(nil)
+	assert.Equal(t, []reg.Repository{}, app.New(mockConfig).ExcludeSkipList([]reg.Repository{{Name: "image-4", Digests: []reg.Digest{child}}}))
+	multiArch := []reg.Repository{{Name: "image-4", Digests: []reg.Digest{child, index}}}
+	assert.Equal(t, multiArch, app.New(mockConfig).ExcludeSkipList(multiArch))
+}
+
+func TestApp_DeleteRepositoriesWithJournal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	failedDigest := reg.Digest{Name: "sha256:01551c49819f8bda0a8bdc6216e5793404b0adb4937d407e99a590c0c5cb8078"}
+	repos := []reg.Repository{
+		{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0], failedDigest}},
+	}
+
+	mockReg := mr.NewMockImageRegistry(ctrl)
+	gomock.InOrder(
+		mockReg.EXPECT().Delete(gomock.Any(), reg.Repository{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0]}}).Times(1).Return(nil),
+		mockReg.EXPECT().Delete(gomock.Any(), reg.Repository{Name: "image-1", Digests: []reg.Digest{failedDigest}}).Times(1).Return(fmt.Errorf("failure")),
+	)
+
+	mockConfig := mc.NewMockIConfig(ctrl)
+	mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+	mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+	mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+	mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+	mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+	mockConfig.EXPECT().SkipDeletionErr().Times(1).Return(true)
+
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := journal.Open(journalPath)
+	assert.NoError(t, err)
+	assert.NoError(t, j.Planned(repos))
+
+	report, err := app.New(mockConfig).WithJournal(j).DeleteRepositories(context.Background(), repos)
+	assert.NoError(t, err)
+	assert.Equal(t, []reg.Repository{{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0]}}}, report.Deleted)
+	assert.Equal(t, []reg.Repository{{Name: "image-1", Digests: []reg.Digest{failedDigest}}}, report.Failed)
+	assert.Empty(t, report.Pending)
+	assert.NoError(t, j.Close())
+
+	// only the failed one is left for resuming
+	remaining, err := journal.Load(journalPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []reg.Repository{{Name: "image-1", Digests: []reg.Digest{failedDigest}}}, remaining)
+}
+
+func TestApp_DeleteRepositoriesProtected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	keptDigest := reg.Digest{Name: "sha256:01551c49819f8bda0a8bdc6216e5793404b0adb4937d407e99a590c0c5cb8078", Tag: []string{"v1"}}
+	repos := []reg.Repository{
+		{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0], keptDigest}},
+		sampleRepos[1],
+	}
+
+	// all of digests are protected, so nothing is deleted
+	mockConfig := mc.NewMockIConfig(ctrl)
+	mockConfig.EXPECT().ImageRegistry().Times(0)
+	mockConfig.EXPECT().SkipList().Times(1).Return(newSkipList(t,
+		"# the digest reference and the pattern of tag",
+		"image-1@"+keptDigest.Name,
+		"",
+		"regex:image-[0-9]+:x.z",
+	))
+	mockConfig.EXPECT().RunningImages().Times(1).Return([]kube.Image{kube.ParseImage("image-1:release-abc-def")})
+	mockConfig.EXPECT().IsDryRun().Times(0)
+	mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+
+	report, err := app.New(mockConfig).DeleteRepositories(context.Background(), repos)
+	assert.NoError(t, err)
+	assert.Equal(t, []app.Protection{
+		{Repository: "image-1", Digest: keptDigest.Name, Rule: "skip list image-1@" + keptDigest.Name + " (line 2)"},
+		{Repository: "image-1", Digest: sampleRepos[0].Digests[0].Name, Rule: "kubernetes workload docker.io/library/image-1:release-abc-def"},
+		{Repository: "image-2", Digest: sampleRepos[1].Digests[0].Name, Rule: "skip list regex:image-[0-9]+:x.z (line 4)"},
+	}, report.Protected)
+	assert.Equal(t, 3, report.TotalProtected())
+	assert.Empty(t, report.Deleted)
+}
+
+func TestApp_DeleteRepositoriesLocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the platform manifests of the locked index are kept, only the unrelated digest is deleted
+	repo := multiArchRepos()[0]
+	repo.Digests[0].Locked = true
+	deletedDigest := reg.Digest{Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Tag: []string{"v3.0.0"}}
+	repo.Digests = append(repo.Digests[:2], deletedDigest)
+
+	mockReg := mr.NewMockImageRegistry(ctrl)
+	mockReg.EXPECT().Delete(gomock.Any(), reg.Repository{Name: repo.Name, Digests: []reg.Digest{deletedDigest}}).Times(1).Return(nil)
+
+	mockConfig := mc.NewMockIConfig(ctrl)
+	mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+	mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+	mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+	mockConfig.EXPECT().IsDryRun().Times(1).Return(false)
+	mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+
+	report, err := app.New(mockConfig).DeleteRepositories(context.Background(), []reg.Repository{repo})
+	assert.NoError(t, err)
+	assert.Equal(t, []app.Protection{{Repository: repo.Name, Digest: repo.Digests[0].Name, Rule: "locked in registry"}}, report.Protected)
+	assert.Equal(t, []reg.Repository{{Name: repo.Name, Digests: []reg.Digest{deletedDigest}}}, report.Deleted)
+}
+
+func TestApp_DeleteRepositoriesInterrupted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	secondDigest := reg.Digest{Name: "sha256:01551c49819f8bda0a8bdc6216e5793404b0adb4937d407e99a590c0c5cb8078"}
+	repos := []reg.Repository{
+		{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0], secondDigest}},
+		sampleRepos[1],
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockReg := mr.NewMockImageRegistry(ctrl)
+	// the signal is received in the middle of the first digest deletion
+	mockReg.EXPECT().Delete(gomock.Any(), reg.Repository{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0]}}).Times(1).
+		DoAndReturn(func(deleteCtx context.Context, _ reg.Repository) error {
+			cancel()
+			// the running deletion must not be canceled
+			assert.NoError(t, deleteCtx.Err())
+			return nil
+		})
+
+	mockConfig := mc.NewMockIConfig(ctrl)
+	mockConfig.EXPECT().ImageRegistry().Times(1).Return(mockReg)
+	mockConfig.EXPECT().SkipList().Times(1).Return(skiplist.Matcher{})
+	mockConfig.EXPECT().RunningImages().Times(1).Return(nil)
+	mockConfig.EXPECT().IsDryRun().Times(2).Return(false)
+	mockConfig.EXPECT().HTTPWorkerCount().Times(1).Return(1)
+
+	report, err := app.New(mockConfig).DeleteRepositories(ctx, repos)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []reg.Repository{{Name: "image-1", Digests: []reg.Digest{sampleRepos[0].Digests[0]}}}, report.Deleted)
+	assert.Empty(t, report.Failed)
+	assert.Equal(t, []reg.Repository{{Name: "image-1", Digests: []reg.Digest{secondDigest}}, sampleRepos[1]}, report.Pending)
+	assert.Equal(t, 2, report.TotalPending())
+}
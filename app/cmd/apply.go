@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/app"
+	"github.com/iomarmochtar/cir-rotator/app/plan"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+func ApplyAction() *cli.Command {
+	return &cli.Command{
+		Name:      "apply",
+		Usage:     "deleting the digests that are listed in plan file",
+		ArgsUsage: "<plan file>",
+		Flags: joinFlags(registryFlags, workerFlags, retryFlags, deletionFlags, kubeFlags, []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "max-age",
+				Usage: "refuse to apply the plan that is older than this duration, set 0 to disable it",
+				Value: 24 * time.Hour,
+			},
+			planKeyFlag,
+		}),
+		Action: func(ctx *cli.Context) error {
+			planPath := ctx.Args().First()
+			if planPath == "" {
+				return fmt.Errorf("plan file is required")
+			}
+
+			p, err := plan.Read(planPath)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := newConfig(ctx)
+			if err != nil {
+				return err
+			}
+
+			if err = cfg.Init(); err != nil {
+				return err
+			}
+
+			// verify it before doing anything to the registry, the registry type is determined by the host if it's not set
+			if err = p.Verify(cfg.Host(), cfg.Type(), ctx.String("plan-key"), ctx.Duration("max-age")); err != nil {
+				return err
+			}
+
+			if pd := cfg.HTTPWorkerCount(); pd <= 0 {
+				return fmt.Errorf("invalid value for worker count: %d, make sure it's more than equal to 1", pd)
+			}
+
+			// the registry may have changed since the plan is generated
+			current, err := cfg.ImageRegistry().Catalog(ctx.Context)
+			if err != nil {
+				return fmt.Errorf("error while listing the repositories for checking the plan: %w", err)
+			}
+			repositories, outdated := p.Refresh(current)
+			for _, o := range outdated {
+				log.Warn().Str("repo", o.Repository).Str("digest", o.Digest).Msgf("excluded from plan, %s", o.Reason)
+			}
+
+			_, err = deleteAndReport(ctx.Context, app.New(cfg), cfg, repositories)
+			return err
+		},
+	}
+}
@@ -0,0 +1,150 @@
+package cmd_test
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/app/cmd"
+	"github.com/iomarmochtar/cir-rotator/app/plan"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	cli "github.com/urfave/cli/v2"
+)
+
+func TestApplyAction(t *testing.T) {
+	testCases := map[string]struct {
+		planHost          func(host string) string
+		planType          string
+		currentTags       []string
+		writeKey          string
+		args              []string
+		beforeWrite       func(p *plan.Plan)
+		tamper            func(p *plan.Plan)
+		expectDeleteCalls int32
+		expectedErrMsg    string
+	}{
+		"deleting the digests in plan": {
+			expectDeleteCalls: 1,
+		},
+		"signed plan": {
+			writeKey:          "secret",
+			args:              []string{"--plan-key", "secret"},
+			expectDeleteCalls: 1,
+		},
+		"dry run will not deleting": {
+			args:              []string{"--dry-run"},
+			expectDeleteCalls: 0,
+		},
+		"plan for different registry type": {
+			planType:       "artifactregistry",
+			expectedErrMsg: "plan is generated for registry type artifactregistry, not for gcr",
+		},
+		"the tag has moved to the planned digest": {
+			currentTags:       []string{"latest"},
+			expectDeleteCalls: 0,
+		},
+		"plan for different host": {
+			planHost:       func(string) string { return "asia.gcr.io/other" },
+			expectedErrMsg: "plan is generated for host asia.gcr.io/other",
+		},
+		"stale plan": {
+			args: []string{"--max-age", "1h"},
+			beforeWrite: func(p *plan.Plan) {
+				p.GeneratedAt = p.GeneratedAt.Add(-2 * time.Hour)
+			},
+			expectedErrMsg: "plan is stale",
+		},
+		"tampered plan": {
+			tamper: func(p *plan.Plan) {
+				p.Repositories[0].Digests = append(p.Repositories[0].Digests, reg.Digest{Name: "sha256:other"})
+			},
+			expectedErrMsg: "plan hash mismatch, the plan file has been modified",
+		},
+		"plan file is not provided": {
+			expectedErrMsg: "plan file is required",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			digestName := "sha256:B0ac9df37ff356753cd20f4475d4b8d3a543b4d45db2390c0275be2ee7a09b2e"
+			var deleteCalls int32
+			ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodDelete {
+					atomic.AddInt32(&deleteCalls, 1)
+				}
+				w.WriteHeader(http.StatusOK)
+				// the current repositories in registry for checking the plan
+				switch r.URL.Path {
+				case "/v2/repo/tags/list":
+					_ = json.NewEncoder(w).Encode(reg.GCRTagsResponse{Child: []string{"image-1"}})
+				case "/v2/repo/image-1/tags/list":
+					_ = json.NewEncoder(w).Encode(reg.GCRTagsResponse{Manifest: map[string]reg.GCRDigest{
+						digestName: {ImageSizeBytes: "1024", Tag: tc.currentTags, TimeCreatedMs: "0", TimeUploadedMs: "0"},
+					}})
+				default:
+					_, _ = w.Write([]byte(`{}`))
+				}
+			}))
+			t.Cleanup(ts.Close)
+
+			host := strings.Replace(ts.URL, "https://", "", 1) + "/repo"
+			planHost := host
+			if tc.planHost != nil {
+				planHost = tc.planHost(host)
+			}
+
+			repos := []reg.Repository{
+				{
+					Name: host + "/image-1",
+					Digests: []reg.Digest{
+						{Name: digestName, ImageSizeBytes: 1024},
+					},
+				},
+			}
+			planPath := filepath.Join(t.TempDir(), "plan.json")
+			planType := "gcr"
+			if tc.planType != "" {
+				planType = tc.planType
+			}
+			p := plan.New(planHost, planType, nil, nil, repos)
+			if tc.beforeWrite != nil {
+				tc.beforeWrite(p)
+			}
+			assert.NoError(t, p.Write(planPath, tc.writeKey))
+			if tc.tamper != nil {
+				// modifying the contents but keep the original hash
+				tc.tamper(p)
+				data, err := json.Marshal(p)
+				assert.NoError(t, err)
+				assert.NoError(t, os.WriteFile(planPath, data, 0600))
+			}
+
+			cmdArgs := append([]string{"apply", "--host", host, "--type", "gcr", "--allow-insecure", "-u", "user", "-p", "secret"}, tc.args...)
+			if title != "plan file is not provided" {
+				cmdArgs = append(cmdArgs, planPath)
+			}
+			set := flag.NewFlagSet("test", 0)
+			app := &cli.App{Writer: io.Discard}
+			assert.NoError(t, set.Parse(cmdArgs))
+
+			cCtx := cli.NewContext(app, set, &cli.Context{App: app})
+			err := cmd.ApplyAction().Run(cCtx, cmdArgs...)
+			if tc.expectedErrMsg != "" {
+				assert.ErrorContains(t, err, tc.expectedErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectDeleteCalls, atomic.LoadInt32(&deleteCalls))
+		})
+	}
+}
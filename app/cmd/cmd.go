@@ -1,16 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/iomarmochtar/cir-rotator/app/config"
 
 	"github.com/iomarmochtar/cir-rotator/app"
 	"github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
 	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
 	"github.com/jedib0t/go-pretty/table"
 	"github.com/rs/zerolog"
@@ -29,7 +34,7 @@ var (
 )
 
 var (
-	commonFlags = []cli.Flag{
+	outputFlags = []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "output-table",
 			Usage: "show output as table to stdout",
@@ -38,6 +43,20 @@ var (
 			Name:  "output-json",
 			Usage: "dump result as json file",
 		},
+	}
+	// registryFlags are the flags for connecting to registry
+	registryFlags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Aliases: []string{"c"},
+			Usage:   "path of configuration file (yaml or toml), the flags that are set will override it's values",
+			EnvVars: []string{"CONFIG_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "profile name in configuration file, it can be omitted if there is only one profile or using the default one",
+			EnvVars: []string{"CONFIG_PROFILE"},
+		},
 		&cli.BoolFlag{
 			Name:    "allow-insecure",
 			Usage:   "allow insecure ssl verify",
@@ -56,11 +75,10 @@ var (
 			EnvVars: []string{"BASIC_AUTH_PWD"},
 		},
 		&cli.StringFlag{
-			Name:     "host",
-			Aliases:  []string{"ho"},
-			Usage:    "registry host",
-			Required: true,
-			EnvVars:  []string{"REGISTRY_HOST"},
+			Name:    "host",
+			Aliases: []string{"ho"},
+			Usage:   "registry host",
+			EnvVars: []string{"REGISTRY_HOST"},
 		},
 		&cli.StringFlag{
 			Name:    "type",
@@ -68,12 +86,19 @@ var (
 			Usage:   "registry type",
 			EnvVars: []string{"REGISTRY_TYPE"},
 		},
+		&cli.StringFlag{
+			Name:    "endpoint",
+			Usage:   "custom api endpoint for the registry that is using it's own api (ecr, artifactregistry, ghcr, gitlab, quay, dockerhub, nexus, artifactory) or the scheme and host of generic registry, eg: local stand-in for testing",
+			EnvVars: []string{"REGISTRY_ENDPOINT"},
+		},
 		&cli.StringFlag{
 			Name:    "service-account",
 			Aliases: []string{"f"},
 			Usage:   "service account file path, it cannot be combined if basic auth args are provided",
 			EnvVars: []string{"SA_FILE"},
 		},
+	}
+	filterFlags = []cli.Flag{
 		&cli.StringSliceFlag{
 			Name:    "exclude-filter",
 			Aliases: []string{"ef"},
@@ -84,14 +109,109 @@ var (
 			Aliases: []string{"if"},
 			Usage:   "only process the results of filter",
 		},
+	}
+	workerFlags = []cli.Flag{
 		&cli.IntFlag{
 			Name:  "worker-count",
-			Usage: "http client worker count",
+			Usage: "http client worker count, it's used for listing and deletion",
+			Value: 1,
+		},
+		&cli.Float64Flag{
+			Name:  "read-rps",
+			Usage: "max of read requests per second to the registry for all workers, unlimited if it's not set",
+		},
+		&cli.IntFlag{
+			Name:  "read-burst",
+			Usage: "max of read requests that can be sent at once",
+			Value: 1,
+		},
+		&cli.Float64Flag{
+			Name:  "delete-rps",
+			Usage: "max of delete requests per second to the registry for all workers, unlimited if it's not set",
+		},
+		&cli.IntFlag{
+			Name:  "delete-burst",
+			Usage: "max of delete requests that can be sent at once",
 			Value: 1,
 		},
 	}
+	// retryFlags are the retry policy of the failed registry calls
+	retryFlags = []cli.Flag{
+		&cli.IntFlag{
+			Name:  "retry-max",
+			Usage: "max attempts of registry call including the first one, set it to 1 for disabling retry",
+			Value: 3,
+		},
+		&cli.DurationFlag{
+			Name:  "retry-backoff",
+			Usage: "the first interval before retrying, it's doubled for each of the next attempt",
+			Value: time.Second,
+		},
+		&cli.DurationFlag{
+			Name:  "retry-max-backoff",
+			Usage: "the maximum interval between attempts, the one from Retry-After header is not limited",
+			Value: 30 * time.Second,
+		},
+		&cli.Float64Flag{
+			Name:  "retry-jitter",
+			Usage: "randomize the interval by this fraction (0 - 1)",
+			Value: 0.2,
+		},
+		&cli.IntSliceFlag{
+			Name:  "retry-status",
+			Usage: "status codes that will be retried",
+			Value: cli.NewIntSlice(http.DefaultRetryStatusCodes...),
+		},
+	}
+	// deletionFlags are the flags that control the deletion process
+	deletionFlags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "just log the action, will not deleting",
+			Value: false,
+		},
+		&cli.StringFlag{
+			Name:  "skip-list",
+			Usage: "path of file that contains skipping list, will be ignored if matched",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-error",
+			Usage: "if any error happen while deleting just ignore it",
+			Value: false,
+		},
+	}
+	// kubeFlags are the sources of images that are used by kubernetes workloads, their digests are protected from deletion
+	kubeFlags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "kube-images",
+			Usage: "path of kubectl json output (kubectl get pods,deployments,statefulsets,cronjobs -A -o json) or - for stdin, the digests of it's images will not be deleted",
+		},
+		&cli.StringFlag{
+			Name:  "kubeconfig",
+			Usage: "path of kubeconfig file for listing the images of workloads through kubernetes api, the digests of them will not be deleted",
+		},
+		&cli.StringFlag{
+			Name:  "kube-context",
+			Usage: "context in kubeconfig file, the current context is used if it's not set",
+		},
+	}
+	planKeyFlag = &cli.StringFlag{
+		Name:    "plan-key",
+		Usage:   "key for signing and verifying the plan file, if it's not set then only content hash (sha256) is used which can be recomputed by anyone, so it's only detecting the accidental modification",
+		EnvVars: []string{"PLAN_SIGNING_KEY"},
+	}
+	commonFlags = joinFlags(outputFlags, registryFlags, filterFlags, workerFlags, retryFlags)
 )
 
+// joinFlags combine the group of flags into a new slice
+func joinFlags(groups ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, group := range groups {
+		flags = append(flags, group...)
+	}
+	return flags
+}
+
 func printTable(repositories []reg.Repository) {
 	t := table.NewWriter()
 	t.SetOutputMirror(os.Stdout)
@@ -134,34 +254,74 @@ func dumpToJSON(repositories []reg.Repository, jsonPath string) error {
 }
 
 func doList(a *app.App, ctx *cli.Context) ([]reg.Repository, error) {
-	repositories, err := a.ListRepositories()
+	repositories, err := a.ListRepositories(ctx.Context)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = writeOutput(ctx, repositories); err != nil {
+		return nil, err
+	}
+
+	return repositories, nil
+}
+
+// writeOutput show the repositories as table and/or dump it to json file by the output flags
+func writeOutput(ctx *cli.Context, repositories []reg.Repository) error {
 	if ctx.Bool("output-table") {
 		printTable(repositories)
 	}
 
 	if outputJSON := ctx.String("output-json"); outputJSON != "" {
-		if err = dumpToJSON(repositories, outputJSON); err != nil {
-			return nil, err
+		if err := dumpToJSON(repositories, outputJSON); err != nil {
+			return err
 		}
 
 		log.Info().Msgf("json output result written to %s", outputJSON)
 	}
+	return nil
+}
 
-	return repositories, nil
+// deleteAndReport deleting the repositories then print the summary, it's printed even if the deletion is interrupted
+func deleteAndReport(ctx context.Context, a *app.App, cfg config.IConfig, repositories []reg.Repository) (*app.Report, error) {
+	report, err := a.DeleteRepositories(ctx, repositories)
+	if cfg.IsDryRun() {
+		return report, err
+	}
+	report.Log()
+	if errors.Is(err, context.Canceled) {
+		return report, fmt.Errorf("deletion is interrupted, %d digest(s) are not deleted: %w", report.TotalPending(), err)
+	}
+	return report, err
 }
 
-// initConfig create configuration instance from given cmd arguments
-func initConfig(ctx *cli.Context) (config.IConfig, error) {
+// handleSignal canceling the context when the process is interrupted or terminated,
+// the next signal will be handled by default behavior so it can be used for force stopping
+func handleSignal(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Warn().Str("signal", sig.String()).Msg("stopping, waiting for the running process to be finished. send the signal again for force stopping")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+// newConfig create configuration from given cmd arguments and the profile of configuration file if it's provided
+func newConfig(ctx *cli.Context) (*config.Config, error) {
 	cfg := &config.Config{
 		RegUsername:        ctx.String("basic-auth-user"),
 		RegPassword:        ctx.String("basic-auth-pwd"),
 		ServiceAccountPath: ctx.String("service-account"),
 		RegistryHost:       ctx.String("host"),
 		RegistryType:       ctx.String("type"),
+		Endpoint:           ctx.String("endpoint"),
 		SkipListPath:       ctx.String("skip-list"),
 		RepoListPath:       ctx.String("repo-list"),
 		DryRun:             ctx.Bool("dry-run"),
@@ -170,14 +330,88 @@ func initConfig(ctx *cli.Context) (config.IConfig, error) {
 		AllowInsecure:      ctx.Bool("allow-insecure"),
 		WorkerCount:        ctx.Int("worker-count"),
 		SkipErrDelete:      ctx.Bool("skip-error"),
+		RetryMaxAttempts:   ctx.Int("retry-max"),
+		RetryBackoff:       ctx.Duration("retry-backoff"),
+		RetryMaxBackoff:    ctx.Duration("retry-max-backoff"),
+		RetryJitter:        ctx.Float64("retry-jitter"),
+		RetryStatusCodes:   ctx.IntSlice("retry-status"),
+		ReadRPS:            ctx.Float64("read-rps"),
+		ReadBurst:          ctx.Int("read-burst"),
+		DeleteRPS:          ctx.Float64("delete-rps"),
+		DeleteBurst:        ctx.Int("delete-burst"),
+		KubeImagesPath:     ctx.String("kube-images"),
+		Kubeconfig:         ctx.String("kubeconfig"),
+		KubeContext:        ctx.String("kube-context"),
+	}
+
+	configPath := ctx.String("config")
+	if configPath == "" {
+		if ctx.String("profile") != "" {
+			return nil, fmt.Errorf("profile cannot be used without config file")
+		}
+		return cfg, nil
+	}
+
+	file, err := config.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := file.Profile(ctx.String("profile"))
+	if err != nil {
+		return nil, err
+	}
+	applyProfile(ctx, cfg, profile)
+	return cfg, nil
+}
+
+// applyProfile set the configuration by profile values, the flags that are set (including by it's env var) are not overridden
+func applyProfile(ctx *cli.Context, cfg *config.Config, p config.Profile) {
+	setString := func(flag, value string, dst *string) {
+		if value != "" && !ctx.IsSet(flag) {
+			*dst = value
+		}
+	}
+	setStrings := func(flag string, value []string, dst *[]string) {
+		if len(value) != 0 && !ctx.IsSet(flag) {
+			*dst = value
+		}
+	}
+	setBool := func(flag string, value *bool, dst *bool) {
+		if value != nil && !ctx.IsSet(flag) {
+			*dst = *value
+		}
+	}
+
+	setString("host", p.Host, &cfg.RegistryHost)
+	setString("type", p.Type, &cfg.RegistryType)
+	setString("endpoint", p.Endpoint, &cfg.Endpoint)
+	setString("basic-auth-user", p.BasicAuthUser, &cfg.RegUsername)
+	setString("basic-auth-pwd", p.BasicAuthPwd, &cfg.RegPassword)
+	setString("service-account", p.ServiceAccount, &cfg.ServiceAccountPath)
+	setString("skip-list", p.SkipList, &cfg.SkipListPath)
+	setStrings("include-filter", p.IncludeFilters, &cfg.IncludeFilters)
+	setStrings("exclude-filter", p.ExcludeFilters, &cfg.ExcludeFilters)
+	setBool("allow-insecure", p.AllowInsecure, &cfg.AllowInsecure)
+	setBool("dry-run", p.DryRun, &cfg.DryRun)
+	if p.WorkerCount != 0 && !ctx.IsSet("worker-count") {
+		cfg.WorkerCount = p.WorkerCount
 	}
-	if err := cfg.Init(); err != nil {
+}
+
+// initConfig create configuration instance from given cmd arguments
+func initConfig(ctx *cli.Context) (config.IConfig, error) {
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Init(); err != nil {
 		return nil, err
 	}
 	return cfg, nil
 }
 
 func New() cli.App {
+	var stopSignal context.CancelFunc
 	cli.VersionPrinter = func(ctx *cli.Context) {
 		_, _ = fmt.Fprintf(ctx.App.Writer, `{"version": "%s", "commit": "%s", "compile_time": "%v"}`,
 			ctx.App.Version, BuildHash, ctx.App.Compiled)
@@ -197,6 +431,8 @@ func New() cli.App {
 		Commands: []*cli.Command{
 			ListAction(),
 			DeleteAction(),
+			PlanAction(),
+			ApplyAction(),
 		},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
@@ -215,6 +451,14 @@ func New() cli.App {
 				log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 			}
 
+			// the context is inherited to the sub command
+			ctx.Context, stopSignal = handleSignal(ctx.Context)
+			return nil
+		},
+		After: func(ctx *cli.Context) error {
+			if stopSignal != nil {
+				stopSignal()
+			}
 			return nil
 		},
 	}
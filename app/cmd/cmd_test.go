@@ -11,7 +11,10 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/iomarmochtar/cir-rotator/app/cmd"
 	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
@@ -148,3 +151,28 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, cmd.Version, obj.Version)
 	assert.Equal(t, cmd.BuildHash, obj.Commit)
 }
+
+func TestNew_GracefulShutdown(t *testing.T) {
+	var deleteCount int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			// terminated in the middle of the first digest deletion (tag then digest)
+			if atomic.AddInt32(&deleteCount, 1) == 1 {
+				assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+				time.Sleep(100 * time.Millisecond)
+			}
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		_, _ = w.Write(readFixture("gcr/tag_list_no_child.json"))
+	}))
+	defer ts.Close()
+
+	host := fmt.Sprintf("%s/repo", strings.Replace(ts.URL, "https://", "", 1))
+	app := cmd.New()
+	err := app.Run([]string{"cir-rotator", "delete", "-u", "user", "-p", "secret", "--allow-insecure", "--type", "gcr", "--host", host})
+
+	assert.EqualError(t, err, "deletion is interrupted, 4 digest(s) are not deleted: context canceled")
+	// the running digest deletion is finished (it's tag and the digest itself)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&deleteCount))
+}
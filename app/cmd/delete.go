@@ -1,36 +1,45 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/iomarmochtar/cir-rotator/app"
+	"github.com/iomarmochtar/cir-rotator/app/config"
+	"github.com/iomarmochtar/cir-rotator/app/journal"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 )
 
 func DeleteAction() *cli.Command {
 	return &cli.Command{
 		Name: "delete",
-		Flags: append(commonFlags, []cli.Flag{
-			&cli.BoolFlag{
-				Name:  "dry-run",
-				Usage: "just log the action, will not deleting",
-				Value: false,
-			},
-			&cli.StringFlag{
-				Name:  "skip-list",
-				Usage: "path of file that contains skipping list, will be ignored if matched",
-			},
+		Flags: joinFlags(commonFlags, deletionFlags, kubeFlags, []cli.Flag{
+			policyFlag,
 			&cli.StringFlag{
 				Name:  "repo-list",
 				Usage: "path of file containing repositories that will be deleted, this can be generated from list action",
 			},
-			&cli.BoolFlag{
-				Name:  "skip-error",
-				Usage: "if any error happen while deleting just ignore it",
-				Value: false,
+			&cli.StringFlag{
+				Name:  "journal",
+				Usage: "path of file for recording each of digest deletion, it can be used for resuming the deletion",
 			},
-		}...),
+			&cli.StringFlag{
+				Name:  "resume",
+				Usage: "path of journal file from the previous deletion, the deleted digests will be skipped and the failed one will be retried",
+			},
+		}),
 		Action: func(ctx *cli.Context) error {
+			if ctx.String("policy") != "" {
+				return deletePolicy(ctx)
+			}
+
+			resumePath := ctx.String("resume")
+			if resumePath != "" && ctx.String("repo-list") != "" {
+				return fmt.Errorf("resume cannot be combined with repo-list")
+			}
+
 			cfg, err := initConfig(ctx)
 			if err != nil {
 				return err
@@ -41,16 +50,54 @@ func DeleteAction() *cli.Command {
 			}
 
 			app := app.New(cfg)
-			repositories, err := doList(app, ctx)
-			if err != nil {
+			var repositories []reg.Repository
+			if resumePath != "" {
+				// the repositories are taken from journal, so it's not necessary to list the catalog again
+				if repositories, err = journal.Load(resumePath); err != nil {
+					return err
+				}
+				log.Info().Int("total_repository", len(repositories)).Msgf("resuming deletion from journal %s", resumePath)
+			} else if repositories, err = doList(app, ctx); err != nil {
 				return err
 			}
 
-			if err = app.DeleteRepositories(repositories); err != nil {
-				return err
+			journalPath := ctx.String("journal")
+			if resumePath != "" {
+				journalPath = resumePath
+			}
+			if journalPath != "" && !cfg.IsDryRun() {
+				j, err := journal.Open(journalPath)
+				if err != nil {
+					return err
+				}
+				//nolint:errcheck
+				defer j.Close()
+
+				if resumePath == "" {
+					if err = j.Planned(repositories); err != nil {
+						return err
+					}
+				}
+				app.WithJournal(j)
 			}
 
-			return nil
+			_, err = deleteAndReport(ctx.Context, app, cfg, repositories)
+			return err
 		},
 	}
 }
+
+// deletePolicy deleting the repositories of each target in policy file
+func deletePolicy(ctx *cli.Context) error {
+	results, err := runPolicy(ctx, func(c context.Context, a *app.App, cfg config.IConfig) (result targetResult) {
+		if result.repositories, result.err = a.ListRepositories(c); result.err != nil {
+			return result
+		}
+		result.report, result.err = deleteAndReport(c, a, cfg, result.repositories)
+		return result
+	})
+	if outputErr := writeOutput(ctx, combineRepositories(results)); outputErr != nil {
+		return outputErr
+	}
+	return err
+}
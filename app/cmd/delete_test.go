@@ -3,16 +3,35 @@ package cmd_test
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/iomarmochtar/cir-rotator/app/cmd"
+	"github.com/iomarmochtar/cir-rotator/app/journal"
 	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
 )
 
 func TestDeleteAction(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	resumePath := filepath.Join(t.TempDir(), "resume.jsonl")
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	profile := "profiles:\n  dry:\n    basic_auth_user: user\n    basic_auth_pwd: secret\n    dry_run: true\n"
+	if err := os.WriteFile(configPath, []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kubeImagesPath := filepath.Join(t.TempDir(), "workloads.json")
+	pod := `{"kind": "Pod", "spec": {"containers": [{"image": "asia.gcr.io/repo/app@sha256:005ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"}]}}`
+	if err := os.WriteFile(kubeImagesPath, []byte(pod), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var deleteCount int32
 	deleteTestCases := h.CombineMaps(commonTestCases, map[string]caseParam{
 		"not providing any params": {
-			expectedErrMsg: `Required flag "host" not set`,
+			expectedErrMsg: "registry host is required",
 		},
 		"successfully deleting repositories": {
 			cmdArgs: []string{"-u", "secret", "-p", "souce"},
@@ -51,6 +70,127 @@ func TestDeleteAction(t *testing.T) {
 			},
 			expectedErrMsg: "Failed to compute blob liveness for manifest: 'latest'",
 		},
+		"the digest that is used by kubernetes workload is not deleted": {
+			cmdArgs: []string{"-u", "secret", "-p", "souce", "--kube-images", kubeImagesPath},
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				if r.Method == http.MethodDelete && (strings.HasSuffix(r.URL.Path, "sha256:005ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2") ||
+					strings.HasSuffix(r.URL.Path, "cec4a9d287db145235edcc7f765a150fbaa06202")) {
+					return fmt.Errorf("the protected digest is deleted")
+				}
+				data := readFixture("gcr/tag_list_no_child.json")
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write(data)
+				return err
+			},
+		},
+		"resume cannot be combined with repo list": {
+			cmdArgs:        []string{"-ho", "asia.gcr.io/somepath", "--resume", "/tmp/journal.jsonl", "--repo-list", "/tmp/repos.json"},
+			expectedErrMsg: "resume cannot be combined with repo-list",
+		},
+		"write the deletion journal": {
+			cmdArgs: []string{"-u", "secret", "-p", "souce", "--journal", journalPath},
+			beforeRunExec: func() error {
+				return os.RemoveAll(journalPath)
+			},
+			afterRunExec: func() error {
+				// all of deleted digests are recorded, so nothing left to be resumed
+				repos, err := journal.Load(journalPath)
+				if err != nil {
+					return err
+				}
+				if len(repos) != 0 {
+					return fmt.Errorf("expecting no repositories left but got %d", len(repos))
+				}
+				return os.Remove(journalPath)
+			},
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				data := readFixture("gcr/tag_list_no_child.json")
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write(data)
+				return err
+			},
+		},
+		"resume from journal without listing catalog": {
+			cmdArgs: []string{"-u", "secret", "-p", "souce", "--resume", resumePath},
+			beforeRunExec: func() error {
+				j, err := journal.Open(resumePath)
+				if err != nil {
+					return err
+				}
+				//nolint:errcheck
+				defer j.Close()
+				digests := []reg.Digest{{Name: "sha256:deleted"}, {Name: "sha256:failed"}}
+				if err = j.Planned([]reg.Repository{{Name: "some.where/repo/image", Digests: digests}}); err != nil {
+					return err
+				}
+				if err = j.Record("some.where/repo/image", digests[0], nil); err != nil {
+					return err
+				}
+				return j.Record("some.where/repo/image", digests[1], fmt.Errorf("service unavailable"))
+			},
+			afterRunExec: func() error {
+				repos, err := journal.Load(resumePath)
+				if err != nil {
+					return err
+				}
+				if len(repos) != 0 {
+					return fmt.Errorf("expecting no repositories left but got %d", len(repos))
+				}
+				return os.Remove(resumePath)
+			},
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				if r.Method != http.MethodDelete {
+					return fmt.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if strings.HasSuffix(r.URL.Path, "sha256:deleted") {
+					return fmt.Errorf("deleted digest is requested again")
+				}
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write([]byte(`{}`))
+				return err
+			},
+		},
+		"using the profile of config file": {
+			cmdArgs: []string{"--config", configPath, "--profile", "dry"},
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				// dry run is set in profile
+				if r.Method == http.MethodDelete {
+					return fmt.Errorf("will not deleting")
+				}
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write(readFixture("gcr/tag_list_no_child.json"))
+				return err
+			},
+		},
+		"the flag is overriding the profile": {
+			cmdArgs: []string{"--config", configPath, "--profile", "dry", "--dry-run=false"},
+			beforeRunExec: func() error {
+				atomic.StoreInt32(&deleteCount, 0)
+				return nil
+			},
+			afterRunExec: func() error {
+				if atomic.LoadInt32(&deleteCount) == 0 {
+					return fmt.Errorf("expecting the digests are deleted")
+				}
+				return nil
+			},
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				if r.Method == http.MethodDelete {
+					atomic.AddInt32(&deleteCount, 1)
+				}
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write(readFixture("gcr/tag_list_no_child.json"))
+				return err
+			},
+		},
+		"profile is not found": {
+			cmdArgs:        []string{"--config", configPath, "--profile", "prod"},
+			expectedErrMsg: "profile prod is not found, available profiles: dry",
+		},
+		"profile without config file": {
+			cmdArgs:        []string{"-ho", "asia.gcr.io/somepath", "--profile", "prod"},
+			expectedErrMsg: "profile cannot be used without config file",
+		},
 		"error if set worker count less than 1": {
 			cmdArgs:        []string{"-ho", "https://asia.gcr.io/somepath", "-u", "secret", "-p", "souce", "--worker-count", "0"},
 			expectedErrMsg: "invalid value for worker count: 0, make sure it's more than equal to 1",
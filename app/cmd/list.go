@@ -1,17 +1,23 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/iomarmochtar/cir-rotator/app"
+	"github.com/iomarmochtar/cir-rotator/app/config"
 	"github.com/urfave/cli/v2"
 )
 
 func ListAction() *cli.Command {
 	return &cli.Command{
 		Name:  "list",
-		Flags: commonFlags,
+		Flags: joinFlags(commonFlags, []cli.Flag{policyFlag}),
 		Action: func(ctx *cli.Context) error {
+			if ctx.String("policy") != "" {
+				return listPolicy(ctx)
+			}
+
 			cfg, err := initConfig(ctx)
 			if err != nil {
 				return err
@@ -32,3 +38,15 @@ func ListAction() *cli.Command {
 		},
 	}
 }
+
+// listPolicy listing the repositories of each target in policy file, the output is combined
+func listPolicy(ctx *cli.Context) error {
+	results, err := runPolicy(ctx, func(c context.Context, a *app.App, _ config.IConfig) (result targetResult) {
+		result.repositories, result.err = a.ListRepositories(c)
+		return result
+	})
+	if outputErr := writeOutput(ctx, combineRepositories(results)); outputErr != nil {
+		return outputErr
+	}
+	return err
+}
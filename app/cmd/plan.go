@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/iomarmochtar/cir-rotator/app"
+	"github.com/iomarmochtar/cir-rotator/app/plan"
+	"github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+func PlanAction() *cli.Command {
+	return &cli.Command{
+		Name:  "plan",
+		Usage: "write the list of digests that will be deleted as plan file, it can be executed later by apply action",
+		Flags: joinFlags(commonFlags, kubeFlags, []cli.Flag{
+			&cli.StringFlag{
+				Name:     "output-plan",
+				Usage:    "path of the plan file",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "skip-list",
+				Usage: "path of file that contains skipping list, the matched digests will not be included in the plan",
+			},
+			planKeyFlag,
+		}),
+		Action: func(ctx *cli.Context) error {
+			cfg, err := initConfig(ctx)
+			if err != nil {
+				return err
+			}
+
+			a := app.New(cfg)
+			repositories, err := doList(a, ctx)
+			if err != nil {
+				return err
+			}
+
+			p := plan.New(cfg.Host(), cfg.Type(), cfg.IncludeFilterList(), cfg.ExcludeFilterList(), a.ExcludeSkipList(repositories))
+			outputPlan := ctx.String("output-plan")
+			if err = p.Write(outputPlan, ctx.String("plan-key")); err != nil {
+				return fmt.Errorf("error while writing plan file: %w", err)
+			}
+
+			log.Info().Int("total_digest", p.TotalDigests).
+				Str("total_size", helpers.ByteCountIEC(p.TotalSize)).
+				Msgf("plan written to %s", outputPlan)
+			return nil
+		},
+	}
+}
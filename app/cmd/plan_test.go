@@ -0,0 +1,60 @@
+package cmd_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/app/cmd"
+	"github.com/iomarmochtar/cir-rotator/app/plan"
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+)
+
+func TestPlanAction(t *testing.T) {
+	planPath := "/tmp/cir_rotator_plan_test.json"
+	removePlan := func() error {
+		if h.FileExist(planPath) {
+			return os.Remove(planPath)
+		}
+		return nil
+	}
+
+	planTestCases := map[string]caseParam{
+		"not providing output plan": {
+			cmdArgs:        []string{"--host", "asia.gcr.io/parent"},
+			expectedErrMsg: `Required flag "output-plan" not set`,
+		},
+		"successfully writing plan": {
+			cmdArgs:       []string{"--output-plan", planPath, "-u", "secret", "-p", "souce", "--if", "ImageSize > SizeStr('500 MiB')"},
+			beforeRunExec: removePlan,
+			afterRunExec: func() error {
+				p, err := plan.Read(planPath)
+				if err != nil {
+					return err
+				}
+				if p.TotalDigests != 1 || p.IncludeFilters[0] != "ImageSize > SizeStr('500 MiB')" {
+					return fmt.Errorf("unexpected plan contents: %+v", p)
+				}
+				return removePlan()
+			},
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				data := readFixture("gcr/tag_list_no_child.json")
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write(data)
+				return err
+			},
+		},
+		"error while listing repository": {
+			cmdArgs:        []string{"--output-plan", planPath, "-u", "secret", "-p", "souce"},
+			expectedErrMsg: "invalid character 'o' looking for beginning of value",
+			mockImageReg: func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusOK)
+				_, err := w.Write([]byte("ok"))
+				return err
+			},
+		},
+	}
+
+	runCmdTestCases("plan", cmd.PlanAction(), planTestCases, t)
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alitto/pond"
+	"github.com/iomarmochtar/cir-rotator/app"
+	"github.com/iomarmochtar/cir-rotator/app/config"
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+var policyFlag = &cli.StringFlag{
+	Name:    "policy",
+	Usage:   "path of policy file (yaml or toml) that contains multiple registry targets, the flags that are set will be applied to all of them",
+	EnvVars: []string{"POLICY_FILE"},
+}
+
+// targetResult is the outcome of a target in policy
+type targetResult struct {
+	target       config.Target
+	repositories []reg.Repository
+	report       *app.Report
+	err          error
+}
+
+// targetRunner processing a target by it's initialized application
+type targetRunner func(ctx context.Context, a *app.App, cfg config.IConfig) targetResult
+
+// runPolicy processing all of targets in policy file then print the combined summary,
+// it's returning error if one of them is failed
+func runPolicy(ctx *cli.Context, run targetRunner) ([]targetResult, error) {
+	for _, flag := range []string{"host", "config", "profile", "repo-list", "journal", "resume"} {
+		if ctx.IsSet(flag) {
+			return nil, fmt.Errorf("policy cannot be combined with %s", flag)
+		}
+	}
+
+	// the standard input can be read once only, meanwhile the configuration is initialized for each of target
+	if ctx.String("kube-images") == kube.Stdin {
+		return nil, fmt.Errorf("policy cannot read the kubernetes objects from stdin, use a file for kube-images instead")
+	}
+
+	policy, err := config.ReadPolicy(ctx.String("policy"))
+	if err != nil {
+		return nil, err
+	}
+
+	parallel := policy.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+	results := make([]targetResult, len(policy.Targets))
+	pool := pond.New(parallel, len(policy.Targets))
+	for idx := range policy.Targets {
+		target := policy.Targets[idx]
+		pool.Submit(func() {
+			results[idx] = runTarget(ctx, target, run)
+		})
+	}
+	pool.StopAndWait()
+
+	logPolicySummary(results)
+
+	var failed []string
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result.target.Name)
+		}
+	}
+	if len(failed) != 0 {
+		return results, fmt.Errorf("%d of %d target(s) failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+func runTarget(ctx *cli.Context, target config.Target, run targetRunner) targetResult {
+	result := targetResult{target: target}
+	if err := ctx.Context.Err(); err != nil {
+		result.err = err
+		return result
+	}
+
+	cfg, err := newConfig(ctx)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	applyProfile(ctx, cfg, target.Profile)
+	if err = cfg.Init(); err != nil {
+		result.err = err
+		return result
+	}
+
+	if pd := cfg.HTTPWorkerCount(); pd <= 0 {
+		result.err = fmt.Errorf("invalid value for worker count: %d, make sure it's more than equal to 1", pd)
+		return result
+	}
+
+	log.Info().Str("target", target.Name).Str("host", cfg.Host()).Msg("processing target")
+	result = run(ctx.Context, app.New(cfg), cfg)
+	result.target = target
+	if result.err != nil {
+		log.Err(result.err).Str("target", target.Name).Msg("target is failed")
+	}
+	return result
+}
+
+func logPolicySummary(results []targetResult) {
+	var totalDigest int
+	for _, result := range results {
+		lg := log.Info()
+		if result.err != nil {
+			lg = log.Error().Err(result.err)
+		}
+		lg = lg.Str("target", result.target.Name).
+			Int("total_repository", len(result.repositories)).
+			Int("total_digest", app.CountDigests(result.repositories))
+		if result.report != nil {
+			lg = lg.Int("deleted_digest", result.report.TotalDeleted()).
+				Int("failed_digest", result.report.TotalFailed()).
+				Int("pending_digest", result.report.TotalPending()).
+				Int("protected_digest", result.report.TotalProtected())
+		}
+		status := "ok"
+		if result.err != nil {
+			status = "failed"
+		}
+		lg.Str("status", status).Msg("target summary")
+		totalDigest += app.CountDigests(result.repositories)
+	}
+	log.Info().Int("total_target", len(results)).Int("total_digest", totalDigest).Msg("policy summary")
+}
+
+// combineRepositories the repositories of all targets, the name is already prefixed by it's host
+func combineRepositories(results []targetResult) []reg.Repository {
+	var repositories []reg.Repository
+	for _, result := range results {
+		repositories = append(repositories, result.repositories...)
+	}
+	return repositories
+}
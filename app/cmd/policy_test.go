@@ -0,0 +1,127 @@
+package cmd_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/app/cmd"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPolicyTarget create a fake gcr registry then returning it's host, the broken one is always replying an error
+func newPolicyTarget(t *testing.T, broken bool, deleteCount *int32) string {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write(readFixture("gcr/error_delete_manifest.json"))
+			return
+		}
+		if r.Method == http.MethodDelete {
+			atomic.AddInt32(deleteCount, 1)
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		_, _ = w.Write(readFixture("gcr/tag_list_no_child.json"))
+	}))
+	t.Cleanup(ts.Close)
+	return fmt.Sprintf("%s/repo", strings.TrimPrefix(ts.URL, "https://"))
+}
+
+func writePolicy(t *testing.T, content string) string {
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(policyPath, []byte(content), 0600))
+	return policyPath
+}
+
+func TestPolicy(t *testing.T) {
+	var deleteCount int32
+	okHost := newPolicyTarget(t, false, &deleteCount)
+	okHost2 := newPolicyTarget(t, false, &deleteCount)
+	brokenHost := newPolicyTarget(t, true, &deleteCount)
+
+	target := func(name, host string) string {
+		return fmt.Sprintf("  - name: %s\n    host: %s\n    type: gcr\n    basic_auth_user: user\n    basic_auth_pwd: secret\n    allow_insecure: true\n", name, host)
+	}
+
+	testCases := map[string]struct {
+		args                []string
+		policy              string
+		expectedErrMsg      string
+		expectedRepos       []string
+		expectedDeleteCount int32
+	}{
+		"listing all of targets in parallel": {
+			args:          []string{"list"},
+			policy:        "parallel: 2\ntargets:\n" + target("first", okHost) + target("second", okHost2),
+			expectedRepos: []string{okHost, okHost2},
+		},
+		"the failed target is reported and the others are still processed": {
+			args:           []string{"list"},
+			policy:         "targets:\n" + target("first", okHost) + target("broken", brokenHost),
+			expectedErrMsg: "1 of 2 target(s) failed: broken",
+			expectedRepos:  []string{okHost},
+		},
+		"deleting all of targets": {
+			args:          []string{"delete"},
+			policy:        "targets:\n" + target("first", okHost) + target("second", okHost2),
+			expectedRepos: []string{okHost, okHost2},
+			// 5 digests with a tag for each of them
+			expectedDeleteCount: 20,
+		},
+		"the flag is applied to all of targets": {
+			args:          []string{"delete", "--dry-run"},
+			policy:        "targets:\n" + target("first", okHost) + target("second", okHost2),
+			expectedRepos: []string{okHost, okHost2},
+		},
+		"cannot be combined with host": {
+			args:           []string{"delete", "--host", okHost},
+			policy:         "targets:\n" + target("first", okHost),
+			expectedErrMsg: "policy cannot be combined with host",
+		},
+		"cannot read the kubernetes objects from stdin": {
+			args:           []string{"delete", "--kube-images", "-"},
+			policy:         "targets:\n" + target("first", okHost),
+			expectedErrMsg: "policy cannot read the kubernetes objects from stdin, use a file for kube-images instead",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			atomic.StoreInt32(&deleteCount, 0)
+			outputPath := filepath.Join(t.TempDir(), "output.json")
+			args := append([]string{"cir-rotator"}, tc.args...)
+			args = append(args, "--policy", writePolicy(t, tc.policy), "--output-json", outputPath)
+
+			app := cmd.New()
+			err := app.Run(args)
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedDeleteCount, atomic.LoadInt32(&deleteCount))
+			if tc.expectedRepos == nil {
+				return
+			}
+
+			// the output is combined
+			data, err := os.ReadFile(outputPath)
+			assert.NoError(t, err)
+			var repositories []reg.Repository
+			assert.NoError(t, json.Unmarshal(data, &repositories))
+			var names []string
+			for _, repo := range repositories {
+				names = append(names, repo.Name)
+			}
+			assert.Equal(t, tc.expectedRepos, names)
+		})
+	}
+}
@@ -1,24 +1,31 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	fl "github.com/iomarmochtar/cir-rotator/pkg/filter"
-	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
 	http "github.com/iomarmochtar/cir-rotator/pkg/http"
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
 	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/iomarmochtar/cir-rotator/pkg/skiplist"
 )
 
 //go:generate mockgen -destination mock_config/mock_config.go -source config.go IConfig
 type IConfig interface {
 	Username() string
 	Password() string
-	SkipList() []string
+	SkipList() skiplist.Matcher
+	RunningImages() []kube.Image
 	IsDryRun() bool
 	Host() string
+	Type() string
 	ImageRegistry() reg.ImageRegistry
+	IncludeFilterList() []string
+	ExcludeFilterList() []string
 	ExcludeEngine() fl.IFilterEngine
 	IncludeEngine() fl.IFilterEngine
 	HTTPClient() http.IHttpClient
@@ -34,6 +41,7 @@ type Config struct {
 	ServiceAccountPath string
 	RegistryHost       string
 	RegistryType       string
+	Endpoint           string
 	SkipListPath       string
 	RepoListPath       string
 	DryRun             bool
@@ -43,12 +51,25 @@ type Config struct {
 	JWExpirySecond     uint
 	WorkerCount        int
 	SkipErrDelete      bool
+	RetryMaxAttempts   int
+	RetryBackoff       time.Duration
+	RetryMaxBackoff    time.Duration
+	RetryJitter        float64
+	RetryStatusCodes   []int
+	ReadRPS            float64
+	ReadBurst          int
+	DeleteRPS          float64
+	DeleteBurst        int
+	KubeImagesPath     string
+	Kubeconfig         string
+	KubeContext        string
 
 	excludeEngine fl.IFilterEngine
 	includeEngine fl.IFilterEngine
 	imageReg      reg.ImageRegistry
 	httpClient    http.IHttpClient
-	skipList      []string
+	skipList      skiplist.Matcher
+	runningImages []kube.Image
 	repositories  []reg.Repository
 }
 
@@ -74,6 +95,11 @@ func (c *Config) Init() (err error) {
 		return err
 	}
 
+	// the images of kubernetes workloads that are protected from deletion
+	if err = c.initRunningImages(); err != nil {
+		return err
+	}
+
 	// setup filters (include & exclude)
 	if err = c.initFilters(); err != nil {
 		return err
@@ -87,10 +113,16 @@ func (c *Config) Init() (err error) {
 	return nil
 }
 
-func (c Config) SkipList() []string {
+// SkipList the rules of images that will not be deleted
+func (c Config) SkipList() skiplist.Matcher {
 	return c.skipList
 }
 
+// RunningImages the images that are used by kubernetes workloads, their digests will not be deleted
+func (c Config) RunningImages() []kube.Image {
+	return c.runningImages
+}
+
 func (c Config) RepositoryList() []reg.Repository {
 	return c.repositories
 }
@@ -119,6 +151,19 @@ func (c Config) Host() string {
 	return c.RegistryHost
 }
 
+// Type registry type, it's determined by the host if not set explicitly
+func (c Config) Type() string {
+	return c.RegistryType
+}
+
+func (c Config) IncludeFilterList() []string {
+	return c.IncludeFilters
+}
+
+func (c Config) ExcludeFilterList() []string {
+	return c.ExcludeFilters
+}
+
 func (c Config) HTTPClient() http.IHttpClient {
 	return c.httpClient
 }
@@ -156,7 +201,15 @@ func (c *Config) initImageReg() (err error) {
 		return fmt.Errorf("unknown image registry type %s", c.RegistryType)
 	}
 
-	if c.imageReg, err = imageRegFn(c.Host(), c.httpClient); err != nil {
+	if c.imageReg, err = imageRegFn(c.Host(), c.httpClient, reg.Option{
+		WorkerCount:   c.HTTPWorkerCount(),
+		Endpoint:      c.Endpoint,
+		Username:      c.Username(),
+		Password:      c.Password(),
+		Retry:         c.retryOption(),
+		RateLimit:     c.rateLimitOption(),
+		AllowInsecure: c.AllowInsecure,
+	}); err != nil {
 		return err
 	}
 	return nil
@@ -179,15 +232,68 @@ func (c *Config) initFilters() (err error) {
 
 func (c *Config) initSkipList() (err error) {
 	if c.SkipListPath != "" {
-		if c.skipList, err = h.ReadLines(c.SkipListPath); err != nil {
+		if c.skipList, err = skiplist.ReadFile(c.SkipListPath); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// initRunningImages reading the images from kubectl json output and/or listing them through kubernetes api
+func (c *Config) initRunningImages() error {
+	if c.KubeImagesPath != "" {
+		images, err := kube.ReadImages(c.KubeImagesPath)
+		if err != nil {
+			return err
+		}
+		c.runningImages = append(c.runningImages, images...)
+	}
+
+	if c.Kubeconfig != "" {
+		client, err := kube.NewClient(c.Kubeconfig, c.KubeContext)
+		if err != nil {
+			return err
+		}
+		images, err := client.Images(context.Background())
+		if err != nil {
+			return fmt.Errorf("error while listing the images of kubernetes workloads: %w", err)
+		}
+		c.runningImages = append(c.runningImages, images...)
+	}
+	return nil
+}
+
+func (c *Config) retryOption() http.RetryOption {
+	return http.RetryOption{
+		MaxAttempts: c.RetryMaxAttempts,
+		Backoff:     c.RetryBackoff,
+		MaxBackoff:  c.RetryMaxBackoff,
+		Jitter:      c.RetryJitter,
+		StatusCodes: c.RetryStatusCodes,
+	}
+}
+
+func (c *Config) rateLimitOption() http.RateLimitOption {
+	return http.RateLimitOption{
+		ReadRPS:     c.ReadRPS,
+		ReadBurst:   c.ReadBurst,
+		DeleteRPS:   c.DeleteRPS,
+		DeleteBurst: c.DeleteBurst,
+	}
+}
+
+// initHTTPClient the registry that is calling the api by it's own client is applying the retry, rate limit and insecure options by itself
 func (c *Config) initHTTPClient() (err error) {
-	hcOptions := http.Option{AllowInsecureSSL: c.AllowInsecure, WorkerCount: c.HTTPWorkerCount()}
+	if reg.OwnClientRegistries[c.RegistryType] {
+		return nil
+	}
+
+	hcOptions := http.Option{
+		AllowInsecureSSL: c.AllowInsecure,
+		WorkerCount:      c.HTTPWorkerCount(),
+		Retry:            c.retryOption(),
+		RateLimit:        c.rateLimitOption(),
+	}
 	// if username and password defined then will use BASIC auth method
 	if c.RegUsername != "" && c.RegPassword != "" {
 		hcOptions.BasicAuth = struct {
@@ -201,6 +307,10 @@ func (c *Config) initHTTPClient() (err error) {
 			return err
 		}
 		hcOptions.TokenSource = ts
+	} else if refreshToken := reg.RefreshTokenMapper[c.RegistryType]; refreshToken != nil {
+		if hcOptions.RefreshToken, err = refreshToken(c.AllowInsecure); err != nil {
+			return err
+		}
 	}
 	c.httpClient, err = http.New(hcOptions)
 	return err
@@ -86,6 +86,16 @@ func TestConfig_Init(t *testing.T) {
 				RegistryHost: "asia.gcr.io/parent",
 			},
 		},
+		"ecr is not using the http client": {
+			config: &c.Config{
+				RegistryHost: "123456789012.dkr.ecr.us-east-1.amazonaws.com/team-a",
+			},
+			afterExec: func(t *testing.T, c *c.Config) {
+				assert.Equal(t, "ecr", c.Type())
+				assert.Nil(t, c.HTTPClient())
+				assert.NotNil(t, c.ImageRegistry())
+			},
+		},
 		"an error while reading skip list file": {
 			config: &c.Config{
 				RegUsername:  "user",
@@ -102,6 +112,22 @@ func TestConfig_Init(t *testing.T) {
 				return nil
 			},
 		},
+		"invalid rule in skip list": {
+			config: &c.Config{
+				RegUsername:  "user",
+				RegPassword:  "secret",
+				RegistryHost: "asia.gcr.io/parent",
+			},
+			beforeExec: func(tc *tcArg) error {
+				path, err := dummyWriter("invalid-skip-list", []byte("# comment\nregex:repo1:(latest\n"), os.ModePerm)
+				if err != nil {
+					return err
+				}
+				tc.config.SkipListPath = path
+				tc.expectedErrMsg = fmt.Sprintf("error while parsing skip list %s: invalid rule at line 2: error parsing regexp: missing closing ): `repo1:(latest`", path)
+				return nil
+			},
+		},
 		"error while init include filter": {
 			config: &c.Config{
 				RegUsername:    "user",
@@ -187,8 +213,32 @@ func TestConfig_Init(t *testing.T) {
 				SkipListPath: "../../testdata/skip_list.txt",
 			},
 			afterExec: func(t *testing.T, c *c.Config) {
-				assert.Equal(t, []string{"asia.gcr.io/parent1/repo1:latest", "asia.gcr.io/parent1/repo2:release-abc"}, c.SkipList())
+				var rules []string
+				for _, rule := range c.SkipList().Rules() {
+					rules = append(rules, rule.Text)
+				}
+				assert.Equal(t, []string{"asia.gcr.io/parent1/repo1:latest", "asia.gcr.io/parent1/repo2:release-abc"}, rules)
+			},
+		},
+		"read the images of kubernetes workloads": {
+			config: &c.Config{
+				RegUsername:    "user",
+				RegPassword:    "secret",
+				RegistryHost:   "asia.gcr.io/parent",
+				KubeImagesPath: "../../testdata/kube/workloads.json",
+			},
+			afterExec: func(t *testing.T, c *c.Config) {
+				assert.Len(t, c.RunningImages(), 10)
+			},
+		},
+		"an error while reading kubeconfig file": {
+			config: &c.Config{
+				RegUsername:  "user",
+				RegPassword:  "secret",
+				RegistryHost: "asia.gcr.io/parent",
+				Kubeconfig:   "/tmp/not_found_kubeconfig",
 			},
+			expectedErrMsg: "error while reading kubeconfig file: open /tmp/not_found_kubeconfig: no such file or directory",
 		},
 		"http worker count": {
 			config: &c.Config{
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfile is used if the profile is not mentioned and the file has more than one profile
+const DefaultProfile = "default"
+
+// File is the configuration file that contains named profiles, the format is determined by it's extension (yaml or toml)
+type File struct {
+	Profiles map[string]Profile `yaml:"profiles" toml:"profiles"`
+}
+
+// Profile is the settings of a target, the empty one is not set so it will be taken from the cli flags
+type Profile struct {
+	Host           string   `yaml:"host" toml:"host"`
+	Type           string   `yaml:"type" toml:"type"`
+	Endpoint       string   `yaml:"endpoint" toml:"endpoint"`
+	BasicAuthUser  string   `yaml:"basic_auth_user" toml:"basic_auth_user"`
+	BasicAuthPwd   string   `yaml:"basic_auth_pwd" toml:"basic_auth_pwd"`
+	ServiceAccount string   `yaml:"service_account" toml:"service_account"`
+	AllowInsecure  *bool    `yaml:"allow_insecure" toml:"allow_insecure"`
+	IncludeFilters []string `yaml:"include_filters" toml:"include_filters"`
+	ExcludeFilters []string `yaml:"exclude_filters" toml:"exclude_filters"`
+	SkipList       string   `yaml:"skip_list" toml:"skip_list"`
+	WorkerCount    int      `yaml:"worker_count" toml:"worker_count"`
+	DryRun         *bool    `yaml:"dry_run" toml:"dry_run"`
+}
+
+// Policy is the list of registry targets that are processed in one run
+type Policy struct {
+	// Parallel total of targets that are processed at once, it's processed one by one if it's not set
+	Parallel int      `yaml:"parallel" toml:"parallel"`
+	Targets  []Target `yaml:"targets" toml:"targets"`
+}
+
+// Target is a registry in policy, the name is taken from the host if it's not set
+type Target struct {
+	Name    string `yaml:"name" toml:"name"`
+	Profile `yaml:",inline"`
+}
+
+// ReadFile parse the configuration file
+func ReadFile(path string) (*File, error) {
+	var file File
+	if err := decodeFile(path, "config", &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// ReadPolicy parse the policy file, it has the same format as configuration file.
+// the relative file paths of targets are resolved from the directory of policy file
+func ReadPolicy(path string) (*Policy, error) {
+	var policy Policy
+	if err := decodeFile(path, "policy", &policy); err != nil {
+		return nil, err
+	}
+	if len(policy.Targets) == 0 {
+		return nil, fmt.Errorf("no target found in policy file %s", path)
+	}
+
+	names := map[string]bool{}
+	for idx := range policy.Targets {
+		target := &policy.Targets[idx]
+		if target.Name == "" {
+			target.Name = target.Host
+		}
+		if target.Name == "" {
+			return nil, fmt.Errorf("target #%d in policy file must have name or host", idx+1)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("duplicate target %s in policy file", target.Name)
+		}
+		names[target.Name] = true
+		target.resolvePaths(filepath.Dir(path))
+	}
+	return &policy, nil
+}
+
+// resolvePaths joining the relative file paths with the directory, so they are not depending on the working directory
+func (p *Profile) resolvePaths(dir string) {
+	for _, filePath := range []*string{&p.SkipList, &p.ServiceAccount} {
+		if *filePath != "" && !filepath.IsAbs(*filePath) {
+			*filePath = filepath.Join(dir, *filePath)
+		}
+	}
+}
+
+// decodeFile unmarshal the yaml or toml file by it's extension, kind is the name of file in error message
+func decodeFile(path, kind string, obj any) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".yaml" && ext != ".yml" && ext != ".toml" {
+		return fmt.Errorf("unknown %s file format %s, it must be yaml or toml", kind, ext)
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("error while reading %s file: %w", kind, err)
+	}
+
+	if ext == ".toml" {
+		var meta toml.MetaData
+		if meta, err = toml.Decode(string(data), obj); err == nil && len(meta.Undecoded()) != 0 {
+			err = fmt.Errorf("unknown field %s", meta.Undecoded()[0])
+		}
+	} else {
+		// the typo in field name is reported instead of ignoring it silently
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(obj)
+	}
+	if err != nil {
+		return fmt.Errorf("error while parsing %s file %s: %w", kind, path, err)
+	}
+	return nil
+}
+
+// Profile returning the profile by name. if the name is empty then the only profile or the default one is used
+func (f File) Profile(name string) (Profile, error) {
+	if name == "" {
+		if len(f.Profiles) == 1 {
+			for _, profile := range f.Profiles {
+				return profile, nil
+			}
+		}
+		name = DefaultProfile
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return profile, fmt.Errorf("profile %s is not found, available profiles: %s", name, strings.Join(f.ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// ProfileNames the sorted names of profiles
+func (f File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,183 @@
+package config_test
+
+import (
+	"path"
+	"path/filepath"
+	"testing"
+
+	c "github.com/iomarmochtar/cir-rotator/app/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFile(t *testing.T) {
+	dryRun := true
+	allowInsecure := true
+	expectedProfiles := map[string]c.Profile{
+		"default": {
+			Host:           "asia.gcr.io/parent-repo",
+			ServiceAccount: "/secrets/sa.json",
+			WorkerCount:    4,
+		},
+		"staging": {
+			Host:           "registry.local:5000/team-a",
+			Type:           "generic",
+			BasicAuthUser:  "user",
+			BasicAuthPwd:   "secret",
+			AllowInsecure:  &allowInsecure,
+			DryRun:         &dryRun,
+			SkipList:       "skip_list.txt",
+			IncludeFilters: []string{"Now() - UploadedAt >= Duration('6M') and ImageSize >= SizeStr('100 MiB')"},
+			ExcludeFilters: []string{
+				"Repository matches '.*base-image$' and 'latest' in Tags",
+				"Repository matches '.*internal-tools.*'",
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		path             string
+		expectedProfiles map[string]c.Profile
+		expectedErrMsg   string
+	}{
+		"yaml file": {
+			path:             "profiles.yaml",
+			expectedProfiles: expectedProfiles,
+		},
+		"toml file": {
+			path:             "profiles.toml",
+			expectedProfiles: expectedProfiles,
+		},
+		"unknown field": {
+			path:           "unknown_field.yaml",
+			expectedErrMsg: "error while parsing config file ../../testdata/config/unknown_field.yaml: yaml: unmarshal errors:\n  line 3: field hots not found in type config.Profile",
+		},
+		"unknown format": {
+			path:           "profiles.json",
+			expectedErrMsg: "unknown config file format .json, it must be yaml or toml",
+		},
+		"file is not found": {
+			path:           "not_found.yaml",
+			expectedErrMsg: "error while reading config file: open ../../testdata/config/not_found.yaml: no such file or directory",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			file, err := c.ReadFile(path.Join("..", "..", "testdata", "config", tc.path))
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedProfiles, file.Profiles)
+		})
+	}
+}
+
+func TestFile_Profile(t *testing.T) {
+	testCases := map[string]struct {
+		path           string
+		name           string
+		expectedHost   string
+		expectedErrMsg string
+	}{
+		"by name": {
+			path:         "profiles.yaml",
+			name:         "staging",
+			expectedHost: "registry.local:5000/team-a",
+		},
+		"default profile is used if the name is not set": {
+			path:         "profiles.yaml",
+			expectedHost: "asia.gcr.io/parent-repo",
+		},
+		"the only profile is used if the name is not set": {
+			path:         "single_profile.toml",
+			expectedHost: "asia.gcr.io/parent-repo",
+		},
+		"profile is not found": {
+			path:           "profiles.toml",
+			name:           "prod",
+			expectedErrMsg: "profile prod is not found, available profiles: default, staging",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			file, err := c.ReadFile(path.Join("..", "..", "testdata", "config", tc.path))
+			assert.NoError(t, err)
+
+			profile, err := file.Profile(tc.name)
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedHost, profile.Host)
+		})
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	expectedPolicy := &c.Policy{
+		Parallel: 2,
+		Targets: []c.Target{
+			{
+				Name: "asia",
+				Profile: c.Profile{
+					Host:           "asia.gcr.io/parent-repo",
+					ServiceAccount: "/secrets/asia.json",
+					IncludeFilters: []string{"RankByUploaded > 10"},
+				},
+			},
+			{
+				// the name is taken from the host
+				Name: "europe-west1-docker.pkg.dev/project/repo",
+				Profile: c.Profile{
+					Host:           "europe-west1-docker.pkg.dev/project/repo",
+					ServiceAccount: "/secrets/europe.json",
+					// the relative path is resolved from the directory of policy file
+					SkipList: filepath.Join("..", "..", "testdata", "config", "skip_list.txt"),
+				},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		path           string
+		expectedPolicy *c.Policy
+		expectedErrMsg string
+	}{
+		"yaml file": {
+			path:           "policy.yaml",
+			expectedPolicy: expectedPolicy,
+		},
+		"toml file": {
+			path:           "policy.toml",
+			expectedPolicy: expectedPolicy,
+		},
+		"duplicate target": {
+			path:           "policy_duplicate.yaml",
+			expectedErrMsg: "duplicate target asia.gcr.io/parent-repo in policy file",
+		},
+		"no target": {
+			path:           "policy_empty.toml",
+			expectedErrMsg: "no target found in policy file ../../testdata/config/policy_empty.toml",
+		},
+		"unknown format": {
+			path:           "policy.ini",
+			expectedErrMsg: "unknown policy file format .ini, it must be yaml or toml",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			policy, err := c.ReadPolicy(path.Join("..", "..", "testdata", "config", tc.path))
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedPolicy, policy)
+		})
+	}
+}
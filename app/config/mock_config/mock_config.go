@@ -14,7 +14,9 @@ import (
 
 	filter "github.com/iomarmochtar/cir-rotator/pkg/filter"
 	http "github.com/iomarmochtar/cir-rotator/pkg/http"
+	kube "github.com/iomarmochtar/cir-rotator/pkg/kube"
 	registry "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	skiplist "github.com/iomarmochtar/cir-rotator/pkg/skiplist"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -56,6 +58,20 @@ func (mr *MockIConfigMockRecorder) ExcludeEngine() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExcludeEngine", reflect.TypeOf((*MockIConfig)(nil).ExcludeEngine))
 }
 
+// ExcludeFilterList mocks base method.
+func (m *MockIConfig) ExcludeFilterList() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExcludeFilterList")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// ExcludeFilterList indicates an expected call of ExcludeFilterList.
+func (mr *MockIConfigMockRecorder) ExcludeFilterList() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExcludeFilterList", reflect.TypeOf((*MockIConfig)(nil).ExcludeFilterList))
+}
+
 // HTTPClient mocks base method.
 func (m *MockIConfig) HTTPClient() http.IHttpClient {
 	m.ctrl.T.Helper()
@@ -126,6 +142,20 @@ func (mr *MockIConfigMockRecorder) IncludeEngine() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncludeEngine", reflect.TypeOf((*MockIConfig)(nil).IncludeEngine))
 }
 
+// IncludeFilterList mocks base method.
+func (m *MockIConfig) IncludeFilterList() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncludeFilterList")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// IncludeFilterList indicates an expected call of IncludeFilterList.
+func (mr *MockIConfigMockRecorder) IncludeFilterList() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncludeFilterList", reflect.TypeOf((*MockIConfig)(nil).IncludeFilterList))
+}
+
 // Init mocks base method.
 func (m *MockIConfig) Init() error {
 	m.ctrl.T.Helper()
@@ -182,6 +212,20 @@ func (mr *MockIConfigMockRecorder) RepositoryList() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RepositoryList", reflect.TypeOf((*MockIConfig)(nil).RepositoryList))
 }
 
+// RunningImages mocks base method.
+func (m *MockIConfig) RunningImages() []kube.Image {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunningImages")
+	ret0, _ := ret[0].([]kube.Image)
+	return ret0
+}
+
+// RunningImages indicates an expected call of RunningImages.
+func (mr *MockIConfigMockRecorder) RunningImages() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunningImages", reflect.TypeOf((*MockIConfig)(nil).RunningImages))
+}
+
 // SkipDeletionErr mocks base method.
 func (m *MockIConfig) SkipDeletionErr() bool {
 	m.ctrl.T.Helper()
@@ -197,10 +241,10 @@ func (mr *MockIConfigMockRecorder) SkipDeletionErr() *gomock.Call {
 }
 
 // SkipList mocks base method.
-func (m *MockIConfig) SkipList() []string {
+func (m *MockIConfig) SkipList() skiplist.Matcher {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SkipList")
-	ret0, _ := ret[0].([]string)
+	ret0, _ := ret[0].(skiplist.Matcher)
 	return ret0
 }
 
@@ -210,6 +254,20 @@ func (mr *MockIConfigMockRecorder) SkipList() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SkipList", reflect.TypeOf((*MockIConfig)(nil).SkipList))
 }
 
+// Type mocks base method.
+func (m *MockIConfig) Type() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Type")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Type indicates an expected call of Type.
+func (mr *MockIConfigMockRecorder) Type() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Type", reflect.TypeOf((*MockIConfig)(nil).Type))
+}
+
 // Username mocks base method.
 func (m *MockIConfig) Username() string {
 	m.ctrl.T.Helper()
@@ -0,0 +1,164 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+)
+
+const (
+	// KindRepository entry of the repository that is going to be deleted, it's written before the deletion begin
+	KindRepository = "repository"
+	// KindDigest entry of digest deletion outcome
+	KindDigest = "digest"
+
+	StatusDeleted = "deleted"
+	StatusFailed  = "failed"
+)
+
+// Entry is a line in journal file
+type Entry struct {
+	Kind       string          `json:"kind"`
+	Time       time.Time       `json:"time"`
+	Repository *reg.Repository `json:"repository,omitempty"`
+	RepoName   string          `json:"repo,omitempty"`
+	Digest     string          `json:"digest,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// Journal recording the deletion process as json lines, so it can be resumed if the process is stopped in the middle
+type Journal struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Open the journal file, the new entries will be appended if it's already exist
+func Open(path string) (*Journal, error) {
+	file, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening journal file: %w", err)
+	}
+	return &Journal{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Planned record the repositories that will be deleted
+func (j *Journal) Planned(repositories []reg.Repository) error {
+	for idr := range repositories {
+		if err := j.write(Entry{Kind: KindRepository, Repository: &repositories[idr]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record the outcome of digest deletion
+func (j *Journal) Record(repoName string, digest reg.Digest, deleteErr error) error {
+	entry := Entry{Kind: KindDigest, RepoName: repoName, Digest: digest.Name, Tags: digest.Tag, Status: StatusDeleted}
+	if deleteErr != nil {
+		entry.Status = StatusFailed
+		entry.Error = deleteErr.Error()
+	}
+	return j.write(entry)
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+func (j *Journal) write(entry Entry) error {
+	entry.Time = time.Now().UTC()
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.encoder.Encode(entry)
+}
+
+// Load read the journal file then returning the planned repositories without the digests that are already deleted,
+// the failed one will be returned so it can be retried
+func Load(path string) ([]reg.Repository, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading journal file: %w", err)
+	}
+	//nolint:errcheck
+	defer file.Close()
+
+	var planned []reg.Repository
+	plannedIndex := map[string]int{}
+	deleted := map[string]bool{}
+
+	scanner := bufio.NewScanner(file)
+	// the repository entry can be a long line if it has a lot of digests
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid journal entry at line %d: %w", line, err)
+		}
+
+		switch entry.Kind {
+		case KindRepository:
+			if entry.Repository == nil {
+				continue
+			}
+			if idx, ok := plannedIndex[entry.Repository.Name]; ok {
+				planned[idx].Digests = mergeDigests(planned[idx].Digests, entry.Repository.Digests)
+				continue
+			}
+			plannedIndex[entry.Repository.Name] = len(planned)
+			planned = append(planned, *entry.Repository)
+		case KindDigest:
+			key := digestKey(entry.RepoName, entry.Digest)
+			// only the latest outcome is counted
+			deleted[key] = entry.Status == StatusDeleted
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading journal file: %w", err)
+	}
+
+	result := []reg.Repository{}
+	for _, repo := range planned {
+		var digests []reg.Digest
+		for _, digest := range repo.Digests {
+			if !deleted[digestKey(repo.Name, digest.Name)] {
+				digests = append(digests, digest)
+			}
+		}
+		if len(digests) != 0 {
+			result = append(result, reg.Repository{Name: repo.Name, Digests: digests})
+		}
+	}
+	return result, nil
+}
+
+func mergeDigests(existing, additional []reg.Digest) []reg.Digest {
+	names := map[string]bool{}
+	for _, digest := range existing {
+		names[digest.Name] = true
+	}
+	for _, digest := range additional {
+		if !names[digest.Name] {
+			existing = append(existing, digest)
+		}
+	}
+	return existing
+}
+
+func digestKey(repoName, digest string) string {
+	return fmt.Sprintf("%s@%s", repoName, digest)
+}
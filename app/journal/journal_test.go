@@ -0,0 +1,94 @@
+package journal_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/app/journal"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	digest1 = reg.Digest{Name: "sha256:0000000000000000000000000000000000000000000000000000000000000001", Tag: []string{"v1"}}
+	digest2 = reg.Digest{Name: "sha256:0000000000000000000000000000000000000000000000000000000000000002", Tag: []string{"v2"}}
+	digest3 = reg.Digest{Name: "sha256:0000000000000000000000000000000000000000000000000000000000000003"}
+)
+
+func TestOpen(t *testing.T) {
+	j, err := journal.Open("/path/not/exists/journal.jsonl")
+	assert.Nil(t, j)
+	assert.EqualError(t, err, "error while opening journal file: open /path/not/exists/journal.jsonl: no such file or directory")
+}
+
+func TestLoad(t *testing.T) {
+	testCases := map[string]struct {
+		write        func(j *journal.Journal)
+		content      string
+		expectRepos  []reg.Repository
+		expectErrMsg string
+	}{
+		"skip the deleted digests and retry the failed one": {
+			write: func(j *journal.Journal) {
+				_ = j.Planned([]reg.Repository{
+					{Name: "image-1", Digests: []reg.Digest{digest1, digest2}},
+					{Name: "image-2", Digests: []reg.Digest{digest3}},
+				})
+				_ = j.Record("image-1", digest1, nil)
+				_ = j.Record("image-1", digest2, fmt.Errorf("service unavailable"))
+			},
+			expectRepos: []reg.Repository{
+				{Name: "image-1", Digests: []reg.Digest{digest2}},
+				{Name: "image-2", Digests: []reg.Digest{digest3}},
+			},
+		},
+		"failed digest that is succeeded in the next attempt": {
+			write: func(j *journal.Journal) {
+				_ = j.Planned([]reg.Repository{{Name: "image-1", Digests: []reg.Digest{digest1}}})
+				_ = j.Record("image-1", digest1, fmt.Errorf("service unavailable"))
+				_ = j.Record("image-1", digest1, nil)
+			},
+			expectRepos: []reg.Repository{},
+		},
+		"planned more than once will be merged": {
+			write: func(j *journal.Journal) {
+				_ = j.Planned([]reg.Repository{{Name: "image-1", Digests: []reg.Digest{digest1}}})
+				_ = j.Planned([]reg.Repository{{Name: "image-1", Digests: []reg.Digest{digest1, digest2}}})
+			},
+			expectRepos: []reg.Repository{
+				{Name: "image-1", Digests: []reg.Digest{digest1, digest2}},
+			},
+		},
+		"invalid entry": {
+			content:      "{\"kind\":\"repository\"}\n\nnot json\n",
+			expectErrMsg: "invalid journal entry at line 3: invalid character 'o' in literal null (expecting 'u')",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal.jsonl")
+			if tc.write != nil {
+				j, err := journal.Open(path)
+				assert.NoError(t, err)
+				tc.write(j)
+				assert.NoError(t, j.Close())
+			} else {
+				assert.NoError(t, os.WriteFile(path, []byte(tc.content), 0600))
+			}
+
+			repos, err := journal.Load(path)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectRepos, repos)
+			}
+		})
+	}
+
+	_, err := journal.Load("/path/not/exists.jsonl")
+	assert.EqualError(t, err, "error while reading journal file: open /path/not/exists.jsonl: no such file or directory")
+}
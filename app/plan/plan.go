@@ -0,0 +1,190 @@
+package plan
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+)
+
+const (
+	hashPrefixSHA256     = "sha256"
+	hashPrefixHMACSHA256 = "hmac-sha256"
+)
+
+// Plan is the reviewable list of digests that will be deleted, the hash is computed by all of it's contents
+// so any modification after it's generated can be detected
+type Plan struct {
+	Host           string           `json:"host"`
+	RegistryType   string           `json:"registry_type"`
+	IncludeFilters []string         `json:"include_filters"`
+	ExcludeFilters []string         `json:"exclude_filters"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+	TotalDigests   int              `json:"total_digests"`
+	TotalSize      uint             `json:"total_size"`
+	Repositories   []reg.Repository `json:"repositories"`
+	Hash           string           `json:"hash"`
+}
+
+func New(host, registryType string, includeFilters, excludeFilters []string, repositories []reg.Repository) *Plan {
+	p := &Plan{
+		Host:           host,
+		RegistryType:   registryType,
+		IncludeFilters: includeFilters,
+		ExcludeFilters: excludeFilters,
+		GeneratedAt:    time.Now().UTC(),
+		Repositories:   repositories,
+	}
+	for _, repo := range repositories {
+		for _, digest := range repo.Digests {
+			p.TotalDigests++
+			p.TotalSize += digest.ImageSizeBytes
+		}
+	}
+	return p
+}
+
+// Read load the plan file
+func Read(path string) (*Plan, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading plan file: %w", err)
+	}
+	var p Plan
+	if err = json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("unmarshaling plan file: %w", err)
+	}
+	return &p, nil
+}
+
+// Write sign the plan then dump it as json file
+func (p *Plan) Write(path, key string) (err error) {
+	if p.Hash, err = p.computeHash(key); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Outdated the digest in plan that is not the same anymore in the registry
+type Outdated struct {
+	Repository string
+	Digest     string
+	Reason     string
+}
+
+// Verify make sure the plan is not tampered, it's generated for the same host & registry type and it's not older than max age
+func (p Plan) Verify(host, registryType, key string, maxAge time.Duration) error {
+	prefix, _, _ := strings.Cut(p.Hash, ":")
+	if prefix == hashPrefixHMACSHA256 && key == "" {
+		return fmt.Errorf("plan is signed by key, the signing key is required")
+	}
+	if prefix != hashPrefixHMACSHA256 && key != "" {
+		return fmt.Errorf("plan is not signed by key")
+	}
+
+	expected, err := p.computeHash(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(p.Hash)) {
+		return fmt.Errorf("plan hash mismatch, the plan file has been modified")
+	}
+
+	if p.Host != host {
+		return fmt.Errorf("plan is generated for host %s, not for %s", p.Host, host)
+	}
+
+	if p.RegistryType != registryType {
+		return fmt.Errorf("plan is generated for registry type %s, not for %s", p.RegistryType, registryType)
+	}
+
+	if maxAge > 0 && time.Since(p.GeneratedAt) > maxAge {
+		return fmt.Errorf("plan is stale, it's generated at %s which is older than %s", p.GeneratedAt.Format(time.RFC3339), maxAge)
+	}
+	return nil
+}
+
+// Refresh comparing the digests in plan with the current repositories in registry, the digest that is gone or it's tags
+// have changed since the plan is generated will be excluded. the moved tag (eg: latest) is deleted by it's name in some
+// registries, so deleting it as planned may untag the newer image
+func (p Plan) Refresh(current []reg.Repository) ([]reg.Repository, []Outdated) {
+	currentDigests := map[string]reg.Digest{}
+	for _, repo := range current {
+		for _, digest := range repo.Digests {
+			currentDigests[repo.Name+"@"+digest.Name] = digest
+		}
+	}
+
+	var outdated []Outdated
+	repositories := make([]reg.Repository, 0, len(p.Repositories))
+	for _, repo := range p.Repositories {
+		refreshed := reg.Repository{Name: repo.Name}
+		for _, digest := range repo.Digests {
+			currentDigest, ok := currentDigests[repo.Name+"@"+digest.Name]
+			if !ok {
+				outdated = append(outdated, Outdated{Repository: repo.Name, Digest: digest.Name, Reason: "digest is not found"})
+				continue
+			}
+			if !sameTags(digest.Tag, currentDigest.Tag) {
+				outdated = append(outdated, Outdated{
+					Repository: repo.Name,
+					Digest:     digest.Name,
+					Reason:     fmt.Sprintf("tags have changed from %v to %v", digest.Tag, currentDigest.Tag),
+				})
+				continue
+			}
+			refreshed.Digests = append(refreshed.Digests, digest)
+		}
+		if len(refreshed.Digests) != 0 {
+			repositories = append(repositories, refreshed)
+		}
+	}
+	return repositories, outdated
+}
+
+// sameTags the tags are the same regardless of their order
+func sameTags(planned, current []string) bool {
+	if len(planned) != len(current) {
+		return false
+	}
+	a := append([]string{}, planned...)
+	b := append([]string{}, current...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// computeHash the hash of plan contents (without the hash itself), if the key is set then it's using hmac
+func (p Plan) computeHash(key string) (string, error) {
+	p.Hash = ""
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	if key == "" {
+		sum := sha256.Sum256(data)
+		return fmt.Sprintf("%s:%s", hashPrefixSHA256, hex.EncodeToString(sum[:])), nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	_, _ = mac.Write(data)
+	return fmt.Sprintf("%s:%s", hashPrefixHMACSHA256, hex.EncodeToString(mac.Sum(nil))), nil
+}
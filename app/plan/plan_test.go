@@ -0,0 +1,188 @@
+package plan_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/app/plan"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	sampleRepos = []reg.Repository{
+		{
+			Name: "asia.gcr.io/parent/image-1",
+			Digests: []reg.Digest{
+				{
+					Name:           "sha256:B0ac9df37ff356753cd20f4475d4b8d3a543b4d45db2390c0275be2ee7a09b2e",
+					ImageSizeBytes: 1024,
+					Tag:            []string{"latest"},
+					Created:        time.Date(2021, time.Month(2), 21, 1, 10, 30, 0, time.UTC),
+					Uploaded:       time.Date(2021, time.Month(2), 21, 1, 10, 30, 0, time.UTC),
+				},
+				{
+					Name:           "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2",
+					ImageSizeBytes: 2048,
+					Tag:            []string{"abc"},
+					Created:        time.Date(2022, time.Month(2), 21, 1, 10, 30, 0, time.UTC),
+					Uploaded:       time.Date(2022, time.Month(2), 21, 1, 10, 30, 0, time.UTC),
+				},
+			},
+		},
+	}
+)
+
+func TestNew(t *testing.T) {
+	p := plan.New("asia.gcr.io/parent", "gcr", []string{"true"}, nil, sampleRepos)
+	assert.Equal(t, 2, p.TotalDigests)
+	assert.Equal(t, uint(3072), p.TotalSize)
+	assert.Equal(t, "gcr", p.RegistryType)
+	assert.WithinDuration(t, time.Now(), p.GeneratedAt, time.Minute)
+}
+
+func TestRead(t *testing.T) {
+	_, err := plan.Read("/path/not/exists.json")
+	assert.EqualError(t, err, "error while reading plan file: open /path/not/exists.json: no such file or directory")
+
+	path := filepath.Join(t.TempDir(), "invalid.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`"dummy"`), 0600))
+	_, err = plan.Read(path)
+	assert.EqualError(t, err, "unmarshaling plan file: json: cannot unmarshal string into Go value of type plan.Plan")
+}
+
+func TestPlan_Verify(t *testing.T) {
+	host := "asia.gcr.io/parent"
+	testCases := map[string]struct {
+		writeKey     string
+		verifyKey    string
+		verifyHost   string
+		verifyType   string
+		maxAge       time.Duration
+		modify       func(p *plan.Plan)
+		expectErrMsg string
+	}{
+		"valid plan": {
+			verifyHost: host,
+			maxAge:     time.Hour,
+		},
+		"valid signed plan": {
+			writeKey:   "secret",
+			verifyKey:  "secret",
+			verifyHost: host,
+			maxAge:     time.Hour,
+		},
+		"the contents are modified": {
+			verifyHost: host,
+			modify: func(p *plan.Plan) {
+				p.Repositories[0].Digests[0].Name = "sha256:other"
+			},
+			expectErrMsg: "plan hash mismatch, the plan file has been modified",
+		},
+		"signed by different key": {
+			writeKey:     "secret",
+			verifyKey:    "other",
+			verifyHost:   host,
+			expectErrMsg: "plan hash mismatch, the plan file has been modified",
+		},
+		"signed plan without key": {
+			writeKey:     "secret",
+			verifyHost:   host,
+			expectErrMsg: "plan is signed by key, the signing key is required",
+		},
+		"key is provided but the plan is not signed": {
+			verifyKey:    "secret",
+			verifyHost:   host,
+			expectErrMsg: "plan is not signed by key",
+		},
+		"different host": {
+			verifyHost:   "asia.gcr.io/other",
+			expectErrMsg: "plan is generated for host asia.gcr.io/parent, not for asia.gcr.io/other",
+		},
+		"different registry type": {
+			verifyHost:   host,
+			verifyType:   "artifactregistry",
+			expectErrMsg: "plan is generated for registry type gcr, not for artifactregistry",
+		},
+		"stale plan": {
+			verifyHost: host,
+			maxAge:     time.Nanosecond,
+			modify: func(p *plan.Plan) {
+				time.Sleep(time.Millisecond)
+			},
+			expectErrMsg: "plan is stale",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plan.json")
+			repos := []reg.Repository{{Name: sampleRepos[0].Name, Digests: append([]reg.Digest{}, sampleRepos[0].Digests...)}}
+			assert.NoError(t, plan.New(host, "gcr", nil, nil, repos).Write(path, tc.writeKey))
+
+			p, err := plan.Read(path)
+			assert.NoError(t, err)
+			if tc.modify != nil {
+				tc.modify(p)
+			}
+
+			verifyType := "gcr"
+			if tc.verifyType != "" {
+				verifyType = tc.verifyType
+			}
+			err = p.Verify(tc.verifyHost, verifyType, tc.verifyKey, tc.maxAge)
+			if tc.expectErrMsg != "" {
+				assert.ErrorContains(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPlan_Refresh(t *testing.T) {
+	repoName := sampleRepos[0].Name
+	testCases := map[string]struct {
+		current          []reg.Repository
+		expectRepos      []reg.Repository
+		expectedOutdated []plan.Outdated
+	}{
+		"nothing has changed": {
+			current: []reg.Repository{{Name: repoName, Digests: []reg.Digest{
+				{Name: sampleRepos[0].Digests[1].Name, Tag: []string{"abc"}},
+				{Name: sampleRepos[0].Digests[0].Name, Tag: []string{"latest"}},
+			}}},
+			expectRepos: sampleRepos,
+		},
+		"the tag is moved to the other digest": {
+			current: []reg.Repository{{Name: repoName, Digests: []reg.Digest{
+				{Name: sampleRepos[0].Digests[0].Name},
+				{Name: sampleRepos[0].Digests[1].Name, Tag: []string{"abc"}},
+				{Name: "sha256:newer", Tag: []string{"latest"}},
+			}}},
+			expectRepos: []reg.Repository{{Name: repoName, Digests: sampleRepos[0].Digests[1:]}},
+			expectedOutdated: []plan.Outdated{
+				{Repository: repoName, Digest: sampleRepos[0].Digests[0].Name, Reason: "tags have changed from [latest] to []"},
+			},
+		},
+		"the digests are gone": {
+			current:     []reg.Repository{{Name: "asia.gcr.io/parent/image-2", Digests: sampleRepos[0].Digests}},
+			expectRepos: []reg.Repository{},
+			expectedOutdated: []plan.Outdated{
+				{Repository: repoName, Digest: sampleRepos[0].Digests[0].Name, Reason: "digest is not found"},
+				{Repository: repoName, Digest: sampleRepos[0].Digests[1].Name, Reason: "digest is not found"},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			p := plan.New("asia.gcr.io/parent", "gcr", nil, nil, sampleRepos)
+			repositories, outdated := p.Refresh(tc.current)
+			assert.Equal(t, tc.expectRepos, repositories)
+			assert.Equal(t, tc.expectedOutdated, outdated)
+		})
+	}
+}
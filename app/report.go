@@ -0,0 +1,127 @@
+package app
+
+import (
+	"sync"
+
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/rs/zerolog/log"
+)
+
+// Protection the digest that is not deleted and the rule that is protecting it (skip list, kubernetes workload or registry lock)
+type Protection struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest"`
+	Rule       string `json:"rule"`
+}
+
+// Report the outcome of deletion process, the digests that are not processed yet (eg: interrupted or stopped by an error)
+// are listed in pending
+type Report struct {
+	Deleted   []reg.Repository
+	Failed    []reg.Repository
+	Pending   []reg.Repository
+	Protected []Protection
+
+	mutex     sync.Mutex
+	processed map[string]bool
+}
+
+func newReport() *Report {
+	return &Report{processed: map[string]bool{}}
+}
+
+// record the outcome of digest deletion
+func (r *Report) record(repoName string, digest reg.Digest, deleteErr error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.processed[repoName+"@"+digest.Name] = true
+	if deleteErr != nil {
+		r.Failed = appendDigest(r.Failed, repoName, digest)
+		return
+	}
+	r.Deleted = appendDigest(r.Deleted, repoName, digest)
+}
+
+// protect recording the digests that are protected from deletion
+func (r *Report) protect(protections []Protection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Protected = append(r.Protected, protections...)
+}
+
+// finalize collecting the digests that are enqueued but not processed
+func (r *Report) finalize(enqueued []reg.Repository) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, repo := range enqueued {
+		for _, digest := range repo.Digests {
+			if !r.processed[repo.Name+"@"+digest.Name] {
+				r.Pending = appendDigest(r.Pending, repo.Name, digest)
+			}
+		}
+	}
+}
+
+// TotalDeleted total of digests that are deleted
+func (r *Report) TotalDeleted() int {
+	return CountDigests(r.Deleted)
+}
+
+// TotalFailed total of digests that are failed to be deleted
+func (r *Report) TotalFailed() int {
+	return CountDigests(r.Failed)
+}
+
+// TotalPending total of digests that are not processed
+func (r *Report) TotalPending() int {
+	return CountDigests(r.Pending)
+}
+
+// TotalProtected total of digests that are protected by skip list, kubernetes workloads or registry lock
+func (r *Report) TotalProtected() int {
+	return len(r.Protected)
+}
+
+// Log print the summary of deletion, each of pending repository is printed so it can be followed up
+func (r *Report) Log() {
+	for _, repo := range r.Pending {
+		log.Warn().Str("repo", repo.Name).
+			Int("total_digest", len(repo.Digests)).
+			Str("total_size", getDigestTotalSize(repo.Digests)).
+			Msg("not deleted")
+	}
+
+	log.Info().
+		Int("deleted_digest", r.TotalDeleted()).
+		Str("deleted_size", getReposTotalSize(r.Deleted)).
+		Int("failed_digest", r.TotalFailed()).
+		Int("pending_digest", r.TotalPending()).
+		Int("protected_digest", r.TotalProtected()).
+		Msg("deletion summary")
+}
+
+func appendDigest(repositories []reg.Repository, repoName string, digest reg.Digest) []reg.Repository {
+	for idr := range repositories {
+		if repositories[idr].Name == repoName {
+			repositories[idr].Digests = append(repositories[idr].Digests, digest)
+			return repositories
+		}
+	}
+	return append(repositories, reg.Repository{Name: repoName, Digests: []reg.Digest{digest}})
+}
+
+// CountDigests total of digests in the repositories
+func CountDigests(repositories []reg.Repository) (total int) {
+	for _, repo := range repositories {
+		total += len(repo.Digests)
+	}
+	return total
+}
+
+func getReposTotalSize(repositories []reg.Repository) string {
+	var digests []reg.Digest
+	for _, repo := range repositories {
+		digests = append(digests, repo.Digests...)
+	}
+	return getDigestTotalSize(digests)
+}
@@ -19,6 +19,32 @@ type Fields struct {
 	Tags       []string
 	CreatedAt  time.Time
 	UploadedAt time.Time
+	// PulledAt the last pull time, it's zero if the registry is not providing it or never pulled
+	PulledAt time.Time
+	// RankByUploaded position of digest in it's repository ordered by the newest uploaded time, started from 1.
+	// only the top level digests are ranked, it's 0 for the platform manifest and the referrer
+	RankByUploaded int
+	// RankByCreated position of digest in it's repository ordered by the newest created time, started from 1
+	RankByCreated int
+	// TotalDigests total of top level digests in the same repository
+	TotalDigests int
+	// MediaType the manifest media type, it's empty if the registry is not providing it
+	MediaType string
+	// IsIndex the digest is a manifest list or image index (multi-arch image)
+	IsIndex bool
+	// Platforms the platforms of image (os/arch[/variant]), for index it's the platforms of it's child
+	Platforms []string
+	// ParentDigests the digests of index that are referencing it (the platform manifest of multi-arch image)
+	ParentDigests []string
+	// Subject the digest that is referred by the artifact (signature, sbom, attestation, etc), it's empty for the image
+	// and the orphaned artifact
+	Subject string
+	// ArtifactType the type of artifact eg: cosign signature, it's empty for the image
+	ArtifactType string
+	// Referrers the digests of artifacts that are referring to it
+	Referrers []string
+	// HasSignature there is a signature (cosign or notation) in it's referrers
+	HasSignature bool
 }
 
 //go:generate mockgen -destination mock_filter/mock_filter.go -source filter.go IFilterEngine
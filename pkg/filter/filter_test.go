@@ -177,6 +177,70 @@ func TestNew_Process(t *testing.T) {
 			},
 			expectedResult: false,
 		},
+		"rank fields": {
+			filters: []string{
+				"RankByUploaded > 10 && RankByCreated <= TotalDigests",
+			},
+			fields: fl.Fields{
+				RankByUploaded: 11,
+				RankByCreated:  12,
+				TotalDigests:   15,
+			},
+			expectedResult: true,
+		},
+		"rank fields not match": {
+			filters: []string{
+				"RankByUploaded > 10",
+			},
+			fields: fl.Fields{
+				RankByUploaded: 10,
+				TotalDigests:   15,
+			},
+			expectedResult: false,
+		},
+		"multi-arch fields": {
+			filters: []string{
+				"IsIndex && 'linux/arm64' in Platforms",
+				"len(ParentDigests) == 0 && MediaType endsWith 'manifest.v1+json'",
+			},
+			fields: fl.Fields{
+				MediaType: "application/vnd.oci.image.index.v1+json",
+				IsIndex:   true,
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+			},
+			expectedResult: true,
+		},
+		"multi-arch fields not match": {
+			filters: []string{
+				"!IsIndex && len(ParentDigests) == 0",
+			},
+			fields: fl.Fields{
+				MediaType:     "application/vnd.oci.image.manifest.v1+json",
+				Platforms:     []string{"linux/amd64"},
+				ParentDigests: []string{"sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+			},
+			expectedResult: false,
+		},
+		"referrer fields": {
+			filters: []string{
+				"!HasSignature && len(Referrers) == 0 && Subject == ''",
+			},
+			fields: fl.Fields{
+				Referrers:    []string{"sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+				HasSignature: true,
+			},
+			expectedResult: false,
+		},
+		"referrer fields of artifact": {
+			filters: []string{
+				"Subject != '' && ArtifactType contains 'cosign'",
+			},
+			fields: fl.Fields{
+				Subject:      "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+				ArtifactType: "application/vnd.dev.cosign.artifact.sig.v1+json",
+			},
+			expectedResult: true,
+		},
 		"SizeStr wrong pattern": {
 			filters: []string{
 				"ImageSize < SizeStr('not valid')",
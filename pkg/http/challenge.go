@@ -0,0 +1,249 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultTokenExpiry as mentioned in token auth spec if the expires_in is not returned
+	defaultTokenExpiry = 60 * time.Second
+	// tokenExpiryLeeway refresh the token a bit earlier than it's expiry time to prevent the request is rejected in the middle
+	tokenExpiryLeeway = 5 * time.Second
+
+	scopeActionPull   = "pull"
+	scopeActionDelete = "delete"
+	scopeActionPush   = "push"
+)
+
+var (
+	reChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	reRepositoryPath = regexp.MustCompile(`^/v2/(.+?)/(manifests|blobs|tags|referrers)/`)
+)
+
+// challenge is the parsed WWW-Authenticate header
+type challenge struct {
+	scheme  string
+	realm   string
+	service string
+	scopes  []string
+}
+
+// tokenResponse response of token endpoint, some of registry implementation is returning access_token instead of token
+type tokenResponse struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+type cachedToken struct {
+	token     string
+	expiredAt time.Time
+	challenge challenge
+}
+
+// RefreshTokenSource returning the refresh token for the realm and service of bearer challenge,
+// it's used for requesting the token by refresh_token grant (oauth2) instead of basic auth. eg: acr's refresh token
+type RefreshTokenSource func(ctx context.Context, realm, service string) (string, error)
+
+// tokenCache holding the bearer tokens that are taken from the challenge flow, keyed by the scope of request.
+// the token of a key is requested by one worker at a time, the others are waiting and using the same token
+type tokenCache struct {
+	mutex        sync.Mutex
+	tokens       map[string]cachedToken
+	fetching     map[string]*sync.Mutex
+	username     string
+	password     string
+	refreshToken RefreshTokenSource
+	client       *req.Client
+}
+
+func newTokenCache(username, password string, refreshToken RefreshTokenSource, allowInsecureSSL bool) *tokenCache {
+	client := req.C()
+	if allowInsecureSSL {
+		client.EnableInsecureSkipVerify()
+	}
+	return &tokenCache{tokens: map[string]cachedToken{}, fetching: map[string]*sync.Mutex{}, username: username, password: password, refreshToken: refreshToken, client: client}
+}
+
+// parseChallenge parse WWW-Authenticate header, eg: Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"
+func parseChallenge(header string) (ch challenge, ok bool) {
+	header = strings.TrimSpace(header)
+	scheme, params, found := strings.Cut(header, " ")
+	if !found {
+		return ch, false
+	}
+	ch.scheme = strings.ToLower(scheme)
+	for _, matched := range reChallengeParam.FindAllStringSubmatch(params, -1) {
+		switch strings.ToLower(matched[1]) {
+		case "realm":
+			ch.realm = matched[2]
+		case "service":
+			ch.service = matched[2]
+		case "scope":
+			ch.scopes = append(ch.scopes, strings.Fields(matched[2])...)
+		}
+	}
+	return ch, ch.realm != ""
+}
+
+// scopeKey the key of cached token, it's by host, repository and the kind of action (pull vs delete)
+func scopeKey(r *req.Request) string {
+	action := scopeActionPush
+	switch r.Method {
+	case nethttp.MethodGet, nethttp.MethodHead:
+		action = scopeActionPull
+	case nethttp.MethodDelete:
+		action = scopeActionDelete
+	}
+
+	resource := r.URL.Path
+	if matched := reRepositoryPath.FindStringSubmatch(r.URL.Path); len(matched) == 3 {
+		resource = matched[1]
+	}
+	return fmt.Sprintf("%s:%s:%s", r.URL.Host, resource, action)
+}
+
+// get returning the cached token, the expired one will be refreshed by using the previous challenge
+func (t *tokenCache) get(ctx context.Context, key string) (string, error) {
+	t.mutex.Lock()
+	cached, ok := t.tokens[key]
+	t.mutex.Unlock()
+	if !ok {
+		return "", nil
+	}
+
+	if time.Now().Add(tokenExpiryLeeway).Before(cached.expiredAt) {
+		return cached.token, nil
+	}
+	log.Debug().Str("scope", key).Msg("token is expired, refreshing")
+	return t.fetch(ctx, key, cached.challenge, cached.token)
+}
+
+// keyMutex the lock of fetching the token for the key
+func (t *tokenCache) keyMutex(key string) *sync.Mutex {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	mutex, ok := t.fetching[key]
+	if !ok {
+		mutex = &sync.Mutex{}
+		t.fetching[key] = mutex
+	}
+	return mutex
+}
+
+// fetch request a new token to the realm by the challenge's service and scope then cache it. the stale token is the one
+// that is expired or rejected, if the other worker has fetched a new one for the key in the meantime then it's used instead
+func (t *tokenCache) fetch(ctx context.Context, key string, ch challenge, stale string) (string, error) {
+	keyMutex := t.keyMutex(key)
+	keyMutex.Lock()
+	defer keyMutex.Unlock()
+
+	t.mutex.Lock()
+	cached, ok := t.tokens[key]
+	t.mutex.Unlock()
+	if ok && cached.token != stale && time.Now().Add(tokenExpiryLeeway).Before(cached.expiredAt) {
+		return cached.token, nil
+	}
+
+	var body tokenResponse
+	log.Debug().Str("realm", ch.realm).Strs("scopes", ch.scopes).Msg("requesting token")
+	resp, err := t.requestToken(ctx, ch, &body)
+	if err != nil {
+		return "", err
+	}
+	if !resp.IsSuccessState() {
+		return "", fmt.Errorf("got status code %d while requesting token to %s", resp.StatusCode, ch.realm)
+	}
+
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("no token returned from %s", ch.realm)
+	}
+
+	expiry := defaultTokenExpiry
+	if body.ExpiresIn > 0 {
+		expiry = time.Duration(body.ExpiresIn) * time.Second
+	}
+	issuedAt := body.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = time.Now()
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.tokens[key] = cachedToken{token: token, expiredAt: issuedAt.Add(expiry), challenge: ch}
+	return token, nil
+}
+
+// requestToken by refresh token grant if it's set, otherwise by basic auth
+func (t *tokenCache) requestToken(ctx context.Context, ch challenge, body *tokenResponse) (*req.Response, error) {
+	request := t.client.R().SetContext(ctx).SetSuccessResult(body)
+	if t.refreshToken == nil {
+		request.SetBasicAuth(t.username, t.password)
+		if ch.service != "" {
+			request.SetQueryParam("service", ch.service)
+		}
+		for _, scope := range ch.scopes {
+			request.AddQueryParam("scope", scope)
+		}
+		return request.Get(ch.realm)
+	}
+
+	refreshToken, err := t.refreshToken(ctx, ch.realm, ch.service)
+	if err != nil {
+		return nil, errors.Wrap(err, "while getting refresh token")
+	}
+	form := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"service":       []string{ch.service},
+		"refresh_token": []string{refreshToken},
+		"scope":         ch.scopes,
+	}
+	return request.SetFormDataFromValues(form).Post(ch.realm)
+}
+
+// roundTripWrapper using the cached token if any, if the server is replying with bearer challenge
+// then do the token exchange and resend the request
+func (t *tokenCache) roundTripWrapper(rt req.RoundTripper) req.RoundTripFunc {
+	return func(r *req.Request) (resp *req.Response, err error) {
+		key := scopeKey(r)
+		token, err := t.get(r.Context(), key)
+		if err != nil {
+			return &req.Response{Request: r, Err: err}, err
+		}
+		if token != "" {
+			r.Headers.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+
+		resp, err = rt.RoundTrip(r)
+		if err != nil || resp.StatusCode != nethttp.StatusUnauthorized {
+			return resp, err
+		}
+
+		ch, ok := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+		if !ok || ch.scheme != "bearer" {
+			return resp, err
+		}
+
+		if token, err = t.fetch(r.Context(), key, ch, token); err != nil {
+			return &req.Response{Request: r, Err: err}, err
+		}
+		r.Headers.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		return rt.RoundTrip(r)
+	}
+}
@@ -0,0 +1,238 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTokenRegistry is a registry that requires bearer token which is exchanged by basic auth or refresh token in it's realm
+type fakeTokenRegistry struct {
+	mutex         sync.Mutex
+	expiresIn     int
+	tokenDelay    time.Duration
+	tokenRequests []string
+	server        *httptest.Server
+}
+
+func newFakeTokenRegistry(t *testing.T, expiresIn int) *fakeTokenRegistry {
+	f := &fakeTokenRegistry{expiresIn: expiresIn}
+	f.server = httptest.NewTLSServer(nethttp.HandlerFunc(f.handle))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeTokenRegistry) handle(w nethttp.ResponseWriter, r *nethttp.Request) {
+	if r.URL.Path == "/token" {
+		var scope string
+		if r.Method == nethttp.MethodPost {
+			_ = r.ParseForm()
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh-secret" || r.PostForm.Get("service") != "fake-registry" {
+				w.WriteHeader(nethttp.StatusUnauthorized)
+				return
+			}
+			scope = strings.Join(r.PostForm["scope"], " ")
+		} else {
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "user" || password != "secret" {
+				w.WriteHeader(nethttp.StatusUnauthorized)
+				return
+			}
+			scope = strings.Join(r.URL.Query()["scope"], " ")
+		}
+		f.mutex.Lock()
+		f.tokenRequests = append(f.tokenRequests, scope)
+		f.mutex.Unlock()
+		time.Sleep(f.tokenDelay)
+		_ = json.NewEncoder(w).Encode(map[string]any{"token": "token-for:" + scope, "expires_in": f.expiresIn})
+		return
+	}
+
+	action := "pull"
+	if r.Method == nethttp.MethodDelete {
+		action = "delete"
+	}
+	repo := strings.Split(strings.TrimPrefix(r.URL.Path, "/v2/"), "/manifests/")[0]
+	scope := fmt.Sprintf("repository:%s:%s", repo, action)
+	if r.Header.Get("Authorization") != "Bearer token-for:"+scope {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="%s"`, f.server.URL, scope))
+		w.WriteHeader(nethttp.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+		return
+	}
+	w.WriteHeader(nethttp.StatusOK)
+	_, _ = w.Write([]byte(`{"name":"` + repo + `"}`))
+}
+
+func TestClient_BearerChallenge(t *testing.T) {
+	testCases := map[string]struct {
+		username            string
+		refreshToken        string
+		expiresIn           int
+		requests            []http.Request
+		expectStatusCodes   []int
+		expectTokenRequests []string
+		expectErrMsg        string
+	}{
+		"token is cached per repository and action": {
+			username:  "user",
+			expiresIn: 300,
+			requests: []http.Request{
+				{Method: nethttp.MethodGet, URL: "/v2/team/app/manifests/latest"},
+				{Method: nethttp.MethodHead, URL: "/v2/team/app/manifests/v1"},
+				{Method: nethttp.MethodDelete, URL: "/v2/team/app/manifests/sha256:abc"},
+				{Method: nethttp.MethodDelete, URL: "/v2/team/app/manifests/sha256:def"},
+				{Method: nethttp.MethodGet, URL: "/v2/team/other/manifests/latest"},
+			},
+			expectStatusCodes: []int{200, 200, 200, 200, 200},
+			expectTokenRequests: []string{
+				"repository:team/app:pull",
+				"repository:team/app:delete",
+				"repository:team/other:pull",
+			},
+		},
+		"expired token will be refreshed": {
+			username:  "user",
+			expiresIn: 1,
+			requests: []http.Request{
+				{Method: nethttp.MethodGet, URL: "/v2/team/app/manifests/latest"},
+				{Method: nethttp.MethodGet, URL: "/v2/team/app/manifests/v1"},
+			},
+			expectStatusCodes: []int{200, 200},
+			expectTokenRequests: []string{
+				"repository:team/app:pull",
+				"repository:team/app:pull",
+			},
+		},
+		"token by refresh token grant": {
+			refreshToken: "refresh-secret",
+			expiresIn:    300,
+			requests: []http.Request{
+				{Method: nethttp.MethodGet, URL: "/v2/team/app/manifests/latest"},
+				{Method: nethttp.MethodDelete, URL: "/v2/team/app/manifests/sha256:abc"},
+			},
+			expectStatusCodes: []int{200, 200},
+			expectTokenRequests: []string{
+				"repository:team/app:pull",
+				"repository:team/app:delete",
+			},
+		},
+		"invalid refresh token": {
+			refreshToken: "expired",
+			expiresIn:    300,
+			requests: []http.Request{
+				{Method: nethttp.MethodGet, URL: "/v2/team/app/manifests/latest"},
+			},
+			expectErrMsg: "got status code 401 while requesting token to",
+		},
+		"wrong credential while requesting token": {
+			username:  "other",
+			expiresIn: 300,
+			requests: []http.Request{
+				{Method: nethttp.MethodGet, URL: "/v2/team/app/manifests/latest"},
+			},
+			expectErrMsg: "got status code 401 while requesting token to",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			registry := newFakeTokenRegistry(t, tc.expiresIn)
+			opt := http.Option{AllowInsecureSSL: true, WorkerCount: 2}
+			if tc.refreshToken != "" {
+				opt.RefreshToken = func(_ context.Context, realm, service string) (string, error) {
+					assert.Equal(t, registry.server.URL+"/token", realm)
+					return tc.refreshToken, nil
+				}
+			} else {
+				opt.BasicAuth.Username = tc.username
+				opt.BasicAuth.Password = "secret"
+			}
+			hc, err := http.New(opt)
+			assert.NoError(t, err)
+
+			var statusCodes []int
+			for _, r := range tc.requests {
+				r.URL = registry.server.URL + r.URL
+				var body map[string]any
+				resp, err := hc.Do(context.Background(), r, &body)
+				if tc.expectErrMsg != "" {
+					assert.ErrorContains(t, err, tc.expectErrMsg)
+					return
+				}
+				assert.NoError(t, err)
+				statusCodes = append(statusCodes, resp.StatusCode)
+			}
+			assert.Equal(t, tc.expectStatusCodes, statusCodes)
+			assert.Equal(t, tc.expectTokenRequests, registry.tokenRequests)
+		})
+	}
+}
+
+func TestClient_BearerChallengeParallel(t *testing.T) {
+	registry := newFakeTokenRegistry(t, 300)
+	// the token request is slow, so the other workers are getting the challenge while the token is being requested
+	registry.tokenDelay = 100 * time.Millisecond
+	opt := http.Option{AllowInsecureSSL: true, WorkerCount: 4}
+	opt.BasicAuth.Username = "user"
+	opt.BasicAuth.Password = "secret"
+	hc, err := http.New(opt)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		for _, method := range []string{nethttp.MethodGet, nethttp.MethodDelete} {
+			wg.Add(1)
+			go func(method string, i int) {
+				defer wg.Done()
+				var body map[string]any
+				r := http.Request{Method: method, URL: fmt.Sprintf("%s/v2/team/app/manifests/v%d", registry.server.URL, i)}
+				resp, err := hc.Do(context.Background(), r, &body)
+				if assert.NoError(t, err) {
+					assert.Equal(t, nethttp.StatusOK, resp.StatusCode)
+				}
+			}(method, i)
+		}
+	}
+	wg.Wait()
+
+	// the token is requested once for each scope
+	sort.Strings(registry.tokenRequests)
+	assert.Equal(t, []string{"repository:team/app:delete", "repository:team/app:pull"}, registry.tokenRequests)
+}
+
+func TestClient_BasicAuthWithoutChallenge(t *testing.T) {
+	ts := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "secret" {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"repositories":["app"]}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	opt := http.Option{AllowInsecureSSL: true}
+	opt.BasicAuth.Username = "user"
+	opt.BasicAuth.Password = "secret"
+	hc, err := http.New(opt)
+	assert.NoError(t, err)
+
+	var body struct {
+		Repositories []string `json:"repositories"`
+	}
+	resp, err := hc.Do(context.Background(), http.Request{Method: nethttp.MethodGet, URL: ts.URL + "/v2/_catalog"}, &body)
+	assert.NoError(t, err)
+	assert.Equal(t, nethttp.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"app"}, body.Repositories)
+}
@@ -1,7 +1,9 @@
 package http
 
 import (
+	"context"
 	"fmt"
+	nethttp "net/http"
 	"sync"
 
 	"github.com/imroc/req/v3"
@@ -11,8 +13,9 @@ import (
 
 //go:generate mockgen -destination mock_http/mock_http.go -source http.go IHttpClient
 type IHttpClient interface {
-	GetMarshalReturnObj(url string, obj any) error
-	DeleteMarshalReturnObj(url string, obj any) error
+	GetMarshalReturnObj(ctx context.Context, url string, obj any) error
+	DeleteMarshalReturnObj(ctx context.Context, url string, obj any) error
+	Do(ctx context.Context, r Request, obj any) (*Response, error)
 }
 
 type Option struct {
@@ -21,14 +24,33 @@ type Option struct {
 		Password string
 	}
 	TokenSource      oauth2.TokenSource
+	RefreshToken     RefreshTokenSource
 	AllowInsecureSSL bool
 	WorkerCount      int
+	Retry            RetryOption
+	RateLimit        RateLimitOption
+}
+
+// Request is the parameters of a custom http call
+type Request struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	// Body is sent as it is if it's a string (set the Content-Type header), otherwise it's sent as json
+	Body any
+}
+
+// Response is the part of http response that is exposed to the caller, the body itself is unmarshaled to the given object
+type Response struct {
+	StatusCode int
+	Header     nethttp.Header
 }
 
 type Client struct {
 	reqIndex      int
 	reqIndexMutex sync.Mutex
-	reqs          []*req.Request
+	clients       []*req.Client
+	retry         RetryOption
 }
 
 func New(o Option) (IHttpClient, error) {
@@ -36,14 +58,19 @@ func New(o Option) (IHttpClient, error) {
 	if workerCount <= 0 {
 		workerCount = 1
 	}
-	client := &Client{reqs: make([]*req.Request, workerCount), reqIndex: 0, reqIndexMutex: sync.Mutex{}}
+	client := &Client{clients: make([]*req.Client, workerCount), reqIndex: 0, reqIndexMutex: sync.Mutex{}, retry: o.Retry}
+	// the token from challenge flow is shared among the workers
+	tokens := newTokenCache(o.BasicAuth.Username, o.BasicAuth.Password, o.RefreshToken, o.AllowInsecureSSL)
+	// so the rate limit is applied to the total requests of all workers
+	limits := newRateLimits(o.RateLimit)
 	for i := 0; i < workerCount; i++ {
 		log.Debug().Int("worker_index", i).Msg("initialize http worker")
-		httpClient := req.C()
+		httpClient := req.C().SetCommonHeader("Content-Type", "application/json")
 		if o.AllowInsecureSSL {
 			httpClient.EnableInsecureSkipVerify()
 		}
-		request := httpClient.R().SetHeader("Content-Type", "application/json")
+		o.Retry.apply(httpClient)
+		httpClient.WrapRoundTripFunc(limits.roundTripWrapper)
 		//nolint:gocritic
 		if o.TokenSource != nil {
 			// injecting authorization header
@@ -59,35 +86,44 @@ func New(o Option) (IHttpClient, error) {
 				}
 			})
 		} else if o.BasicAuth.Username != "" && o.BasicAuth.Password != "" {
-			request.SetBasicAuth(o.BasicAuth.Username, o.BasicAuth.Password)
+			// basic auth is sent directly, if the registry is replying with bearer challenge then it will be exchanged as token
+			httpClient.SetCommonBasicAuth(o.BasicAuth.Username, o.BasicAuth.Password).
+				WrapRoundTripFunc(tokens.roundTripWrapper)
+		} else if o.RefreshToken != nil {
+			// the refresh token is exchanged as token only if the registry is replying with bearer challenge
+			httpClient.WrapRoundTripFunc(tokens.roundTripWrapper)
 		} else {
 			return nil, fmt.Errorf("you must set oauth token or basic auth params (username & password)")
 		}
-		client.reqs[i] = request
+		client.clients[i] = httpClient
 	}
 
 	return client, nil
 }
 
-// request get current worker
-func (h *Client) request() *req.Request {
+// request create a new request from current worker, the request will be canceled if the context is done
+func (h *Client) request(ctx context.Context) *req.Request {
 	h.reqIndexMutex.Lock()
 	defer h.reqIndexMutex.Unlock()
-	req := h.reqs[h.reqIndex]
-	if h.reqIndex+1 == len(h.reqs) {
+	client := h.clients[h.reqIndex]
+	if h.reqIndex+1 == len(h.clients) {
 		h.reqIndex = 0
 	} else {
 		h.reqIndex++
 	}
-	return req
+	return client.R().SetContext(ctx)
 }
 
-func (h *Client) GetMarshalReturnObj(url string, obj any) error {
-	response, err := h.request().Get(url)
+func (h *Client) GetMarshalReturnObj(ctx context.Context, url string, obj any) error {
+	response, err := h.request(ctx).Get(url)
 	if err != nil {
 		return err
 	}
 
+	if err = h.checkRetryableStatus(response); err != nil {
+		return err
+	}
+
 	if err = response.UnmarshalJson(obj); err != nil {
 		return err
 	}
@@ -95,15 +131,53 @@ func (h *Client) GetMarshalReturnObj(url string, obj any) error {
 	return nil
 }
 
-func (h *Client) DeleteMarshalReturnObj(url string, obj any) error {
-	response, err := h.request().Delete(url)
+func (h *Client) DeleteMarshalReturnObj(ctx context.Context, url string, obj any) error {
+	response, err := h.request(ctx).Delete(url)
 	if err != nil {
 		return err
 	}
 
+	if err = h.checkRetryableStatus(response); err != nil {
+		return err
+	}
+
 	if err = response.UnmarshalJson(obj); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Do send request by the given method and headers, the response body will be unmarshaled to obj
+// only if it's set and the body is not empty (eg: HEAD request or 202 response from deletion)
+func (h *Client) Do(ctx context.Context, r Request, obj any) (*Response, error) {
+	request := h.request(ctx).SetHeaders(r.Headers)
+	switch body := r.Body.(type) {
+	case nil:
+	case string:
+		request.SetBodyString(body)
+	default:
+		request.SetBodyJsonMarshal(body)
+	}
+	response, err := request.Send(r.Method, r.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj != nil && len(response.Bytes()) != 0 {
+		if err = response.UnmarshalJson(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Response{StatusCode: response.StatusCode, Header: response.Header}, nil
+}
+
+// checkRetryableStatus returning error if the response is still in retryable status after all of attempts,
+// so the transient error will not be unmarshaled as a valid response
+func (h *Client) checkRetryableStatus(response *req.Response) error {
+	if h.retry.isRetryableStatus(response.StatusCode) {
+		return StatusError{StatusCode: response.StatusCode, Method: response.Request.Method, URL: response.Request.RawURL}
+	}
+	return nil
+}
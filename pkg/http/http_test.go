@@ -0,0 +1,75 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Do(t *testing.T) {
+	ts := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"method":       r.Method,
+			"header":       r.Header.Get("X-Custom"),
+			"content_type": r.Header.Get("Content-Type"),
+			"body":         string(body),
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	testCases := map[string]struct {
+		request      http.Request
+		expectResult map[string]any
+	}{
+		"with json body": {
+			request: http.Request{Method: nethttp.MethodPost, URL: ts.URL, Headers: map[string]string{"X-Custom": "yes"}, Body: map[string]string{"username": "user"}},
+			expectResult: map[string]any{
+				"method":       "POST",
+				"header":       "yes",
+				"content_type": "application/json; charset=utf-8",
+				"body":         `{"username":"user"}`,
+			},
+		},
+		"with text body": {
+			request: http.Request{Method: nethttp.MethodPost, URL: ts.URL, Headers: map[string]string{"Content-Type": "text/plain"}, Body: `items.find({"repo":"docker-local"})`},
+			expectResult: map[string]any{
+				"method":       "POST",
+				"header":       "",
+				"content_type": "text/plain",
+				"body":         `items.find({"repo":"docker-local"})`,
+			},
+		},
+		"without body": {
+			request: http.Request{Method: nethttp.MethodGet, URL: ts.URL},
+			expectResult: map[string]any{
+				"method":       "GET",
+				"header":       "",
+				"content_type": "application/json",
+				"body":         "",
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			opt := http.Option{AllowInsecureSSL: true}
+			opt.BasicAuth.Username = "user"
+			opt.BasicAuth.Password = "secret"
+			hc, err := http.New(opt)
+			assert.NoError(t, err)
+
+			var result map[string]any
+			resp, err := hc.Do(context.Background(), tc.request, &result)
+			assert.NoError(t, err)
+			assert.Equal(t, nethttp.StatusOK, resp.StatusCode)
+			assert.Equal(t, tc.expectResult, result)
+		})
+	}
+}
@@ -10,8 +10,10 @@
 package mock_http
 
 import (
+	context "context"
 	reflect "reflect"
 
+	http "github.com/iomarmochtar/cir-rotator/pkg/http"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -40,29 +42,44 @@ func (m *MockIHttpClient) EXPECT() *MockIHttpClientMockRecorder {
 }
 
 // DeleteMarshalReturnObj mocks base method.
-func (m *MockIHttpClient) DeleteMarshalReturnObj(url string, obj any) error {
+func (m *MockIHttpClient) DeleteMarshalReturnObj(ctx context.Context, url string, obj any) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteMarshalReturnObj", url, obj)
+	ret := m.ctrl.Call(m, "DeleteMarshalReturnObj", ctx, url, obj)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteMarshalReturnObj indicates an expected call of DeleteMarshalReturnObj.
-func (mr *MockIHttpClientMockRecorder) DeleteMarshalReturnObj(url, obj any) *gomock.Call {
+func (mr *MockIHttpClientMockRecorder) DeleteMarshalReturnObj(ctx, url, obj any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMarshalReturnObj", reflect.TypeOf((*MockIHttpClient)(nil).DeleteMarshalReturnObj), url, obj)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMarshalReturnObj", reflect.TypeOf((*MockIHttpClient)(nil).DeleteMarshalReturnObj), ctx, url, obj)
+}
+
+// Do mocks base method.
+func (m *MockIHttpClient) Do(ctx context.Context, r http.Request, obj any) (*http.Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Do", ctx, r, obj)
+	ret0, _ := ret[0].(*http.Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Do indicates an expected call of Do.
+func (mr *MockIHttpClientMockRecorder) Do(ctx, r, obj any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Do", reflect.TypeOf((*MockIHttpClient)(nil).Do), ctx, r, obj)
 }
 
 // GetMarshalReturnObj mocks base method.
-func (m *MockIHttpClient) GetMarshalReturnObj(url string, obj any) error {
+func (m *MockIHttpClient) GetMarshalReturnObj(ctx context.Context, url string, obj any) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetMarshalReturnObj", url, obj)
+	ret := m.ctrl.Call(m, "GetMarshalReturnObj", ctx, url, obj)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // GetMarshalReturnObj indicates an expected call of GetMarshalReturnObj.
-func (mr *MockIHttpClientMockRecorder) GetMarshalReturnObj(url, obj any) *gomock.Call {
+func (mr *MockIHttpClientMockRecorder) GetMarshalReturnObj(ctx, url, obj any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMarshalReturnObj", reflect.TypeOf((*MockIHttpClient)(nil).GetMarshalReturnObj), url, obj)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMarshalReturnObj", reflect.TypeOf((*MockIHttpClient)(nil).GetMarshalReturnObj), ctx, url, obj)
 }
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"context"
+	nethttp "net/http"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+)
+
+// RateLimitOption limiting the requests per second to the registry, the limit is shared among all of workers.
+// it's unlimited if the requests per second is not set
+type RateLimitOption struct {
+	// ReadRPS requests per second for non deletion calls (eg: listing catalog, tags, manifests)
+	ReadRPS float64
+	// ReadBurst max of read requests that can be sent at once, it's at least 1
+	ReadBurst int
+	// DeleteRPS requests per second for deletion calls
+	DeleteRPS float64
+	// DeleteBurst max of delete requests that can be sent at once, it's at least 1
+	DeleteBurst int
+}
+
+// rateLimiter is a token bucket, the bucket is full at the beginning and refilled by rate per second
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve take a token and returning the waiting time until the token is available
+func (l *rateLimiter) reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returning the token that has been reserved
+func (l *rateLimiter) cancel() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.tokens++
+}
+
+// Wait blocking until a token is available or the context is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	wait := l.reserve()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// rateLimits the limiters for each kind of request
+type rateLimits struct {
+	read   *rateLimiter
+	delete *rateLimiter
+}
+
+func newRateLimits(o RateLimitOption) *rateLimits {
+	return &rateLimits{
+		read:   newRateLimiter(o.ReadRPS, o.ReadBurst),
+		delete: newRateLimiter(o.DeleteRPS, o.DeleteBurst),
+	}
+}
+
+func (r *rateLimits) limiter(method string) *rateLimiter {
+	if method == nethttp.MethodDelete {
+		return r.delete
+	}
+	return r.read
+}
+
+// roundTripWrapper waiting for the token before sending the request, each of retry attempt is counted as well
+func (r *rateLimits) roundTripWrapper(rt req.RoundTripper) req.RoundTripFunc {
+	return func(rq *req.Request) (*req.Response, error) {
+		if limiter := r.limiter(rq.Method); limiter != nil {
+			if err := limiter.Wait(rq.Context()); err != nil {
+				return &req.Response{Request: rq, Err: err}, err
+			}
+		}
+		return rt.RoundTrip(rq)
+	}
+}
+
+// Doer is sending the http request, eg: *net/http.Client
+type Doer interface {
+	Do(r *nethttp.Request) (*nethttp.Response, error)
+}
+
+type rateLimitedDoer struct {
+	limits *rateLimits
+	doer   Doer
+}
+
+// NewRateLimitedDoer applying the rate limit to the client that is not created by New (eg: aws sdk's http client),
+// each of retry attempt is counted as well since it's sent through the client again
+func NewRateLimitedDoer(o RateLimitOption, doer Doer) Doer {
+	return &rateLimitedDoer{limits: newRateLimits(o), doer: doer}
+}
+
+func (d *rateLimitedDoer) Do(r *nethttp.Request) (*nethttp.Response, error) {
+	if limiter := d.limits.limiter(r.Method); limiter != nil {
+		if err := limiter.Wait(r.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return d.doer.Do(r)
+}
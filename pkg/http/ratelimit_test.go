@@ -0,0 +1,153 @@
+package http_test
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_RateLimit(t *testing.T) {
+	testCases := map[string]struct {
+		rateLimit     http.RateLimitOption
+		workerCount   int
+		gets          int
+		deletes       int
+		expectMinTime time.Duration
+		expectMaxTime time.Duration
+	}{
+		"reads are limited": {
+			rateLimit: http.RateLimitOption{ReadRPS: 20, ReadBurst: 1},
+			gets:      5,
+			// the first one is taken from the bucket, the rest are waiting 50ms for each
+			expectMinTime: 200 * time.Millisecond,
+		},
+		"the limit is shared among workers": {
+			rateLimit:     http.RateLimitOption{ReadRPS: 20, ReadBurst: 2},
+			workerCount:   3,
+			gets:          6,
+			expectMinTime: 200 * time.Millisecond,
+		},
+		"deletes are not limited by the read limit": {
+			rateLimit:     http.RateLimitOption{ReadRPS: 1, ReadBurst: 1},
+			gets:          1,
+			deletes:       5,
+			expectMaxTime: 500 * time.Millisecond,
+		},
+		"deletes are limited separately": {
+			rateLimit:     http.RateLimitOption{ReadRPS: 1, ReadBurst: 5, DeleteRPS: 10, DeleteBurst: 2},
+			gets:          5,
+			deletes:       4,
+			expectMinTime: 200 * time.Millisecond,
+			expectMaxTime: time.Second,
+		},
+		"unlimited by default": {
+			gets:          20,
+			deletes:       20,
+			expectMaxTime: 500 * time.Millisecond,
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			opt := http.Option{RateLimit: tc.rateLimit, WorkerCount: tc.workerCount}
+			opt.BasicAuth.Username = "user"
+			opt.BasicAuth.Password = "secret"
+			client, err := http.New(opt)
+			assert.NoError(t, err)
+
+			var wg sync.WaitGroup
+			begin := time.Now()
+			send := func(fn func(context.Context, string, any) error) {
+				defer wg.Done()
+				var result map[string]any
+				assert.NoError(t, fn(context.Background(), server.URL+"/v2/app/manifests/latest", &result))
+			}
+			for i := 0; i < tc.gets; i++ {
+				wg.Add(1)
+				go send(client.GetMarshalReturnObj)
+			}
+			for i := 0; i < tc.deletes; i++ {
+				wg.Add(1)
+				go send(client.DeleteMarshalReturnObj)
+			}
+			wg.Wait()
+			elapsed := time.Since(begin)
+
+			if tc.expectMinTime != 0 {
+				assert.GreaterOrEqual(t, elapsed, tc.expectMinTime)
+			}
+			if tc.expectMaxTime != 0 {
+				assert.Less(t, elapsed, tc.expectMaxTime)
+			}
+		})
+	}
+}
+
+func TestClient_RateLimitCanceled(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	opt := http.Option{RateLimit: http.RateLimitOption{ReadRPS: 0.1, ReadBurst: 1}}
+	opt.BasicAuth.Username = "user"
+	opt.BasicAuth.Password = "secret"
+	client, err := http.New(opt)
+	assert.NoError(t, err)
+
+	var result map[string]any
+	url := server.URL + "/v2/app/manifests/latest"
+	assert.NoError(t, client.GetMarshalReturnObj(context.Background(), url, &result))
+
+	// the next token is available in 10 seconds, it must not wait for it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	err = client.GetMarshalReturnObj(ctx, url, &result)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(begin), time.Second)
+}
+
+func TestNewRateLimitedDoer(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	doer := http.NewRateLimitedDoer(http.RateLimitOption{ReadRPS: 20, ReadBurst: 1, DeleteRPS: 1, DeleteBurst: 1}, server.Client())
+	begin := time.Now()
+	// the first one is taken from the bucket, the rest are waiting 50ms for each
+	for i := 0; i < 5; i++ {
+		r, err := nethttp.NewRequestWithContext(context.Background(), nethttp.MethodPost, server.URL, nil)
+		assert.NoError(t, err)
+		resp, err := doer.Do(r)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.GreaterOrEqual(t, time.Since(begin), 200*time.Millisecond)
+
+	// the delete is limited separately
+	r, err := nethttp.NewRequestWithContext(context.Background(), nethttp.MethodDelete, server.URL, nil)
+	assert.NoError(t, err)
+	resp, err := doer.Do(r)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r, err = nethttp.NewRequestWithContext(ctx, nethttp.MethodDelete, server.URL, nil)
+	assert.NoError(t, err)
+	_, err = doer.Do(r)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// DefaultRetryStatusCodes the transient status codes that are worth to retry
+	DefaultRetryStatusCodes = []int{
+		nethttp.StatusTooManyRequests,
+		nethttp.StatusInternalServerError,
+		nethttp.StatusBadGateway,
+		nethttp.StatusServiceUnavailable,
+		nethttp.StatusGatewayTimeout,
+	}
+)
+
+// RetryOption policy for retrying the failed request, it's disabled if max attempts is less than 2
+type RetryOption struct {
+	// MaxAttempts total of attempts including the first request
+	MaxAttempts int
+	// Backoff the first interval before retrying, it will be doubled for each of the next attempt
+	Backoff time.Duration
+	// MaxBackoff the limit of the interval, not applied for the one that is set by Retry-After header
+	MaxBackoff time.Duration
+	// Jitter randomize the interval by this fraction (0 - 1) to prevent the workers are retrying at the same time
+	Jitter float64
+	// StatusCodes that will be retried, DefaultRetryStatusCodes is used if it's empty
+	StatusCodes []int
+}
+
+// StatusError returned when the response status code is still in retryable one after all of attempts
+type StatusError struct {
+	StatusCode int
+	Method     string
+	URL        string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("got status code %d for %s %s", e.StatusCode, e.Method, e.URL)
+}
+
+func (o RetryOption) enabled() bool {
+	return o.MaxAttempts > 1
+}
+
+// RetryStatusCodes the status codes that will be retried
+func (o RetryOption) RetryStatusCodes() []int {
+	if len(o.StatusCodes) == 0 {
+		return DefaultRetryStatusCodes
+	}
+	return o.StatusCodes
+}
+
+// isRetryableStatus the status code is classified as the one that will be retried
+func (o RetryOption) isRetryableStatus(statusCode int) bool {
+	for _, code := range o.RetryStatusCodes() {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// interval the waiting time before the next attempt, Retry-After header from the server has higher priority
+func (o RetryOption) interval(resp *req.Response, attempt int) time.Duration {
+	if resp != nil && resp.Response != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return retryAfter
+		}
+	}
+	return o.Delay(attempt)
+}
+
+// Delay the exponential backoff with jitter before the attempt (started from 1) is retried
+func (o RetryOption) Delay(attempt int) time.Duration {
+	backoff := float64(o.Backoff) * math.Pow(2, float64(attempt-1))
+	if o.MaxBackoff > 0 && backoff > float64(o.MaxBackoff) {
+		backoff = float64(o.MaxBackoff)
+	}
+	if o.Jitter > 0 {
+		//nolint:gosec
+		backoff += backoff * o.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// apply set the retry policy to the client
+func (o RetryOption) apply(client *req.Client) {
+	if !o.enabled() {
+		return
+	}
+	client.SetCommonRetryCount(o.MaxAttempts - 1).
+		SetCommonRetryInterval(o.interval).
+		SetCommonRetryCondition(func(resp *req.Response, err error) bool {
+			// nothing to retry if the request is canceled
+			if resp.Request.Context().Err() != nil {
+				return false
+			}
+			if err != nil {
+				return true
+			}
+			return o.isRetryableStatus(resp.StatusCode)
+		}).
+		SetCommonRetryHook(func(resp *req.Response, err error) {
+			lg := log.Warn().Int("attempt", resp.Request.RetryAttempt).Str("url", resp.Request.RawURL)
+			if err != nil {
+				lg.Err(err).Msg("retrying request")
+				return
+			}
+			lg.Int("status_code", resp.StatusCode).Msg("retrying request")
+		})
+}
+
+// parseRetryAfter the value can be in seconds or http date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := nethttp.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
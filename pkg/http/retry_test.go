@@ -0,0 +1,141 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer replying with the given status codes in order, the last one will be used for the rest of requests
+type flakyServer struct {
+	mutex      sync.Mutex
+	statuses   []int
+	retryAfter string
+	requests   []time.Time
+	server     *httptest.Server
+}
+
+func newFlakyServer(t *testing.T, retryAfter string, statuses ...int) *flakyServer {
+	f := &flakyServer{statuses: statuses, retryAfter: retryAfter}
+	f.server = httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		f.mutex.Lock()
+		idx := len(f.requests)
+		f.requests = append(f.requests, time.Now())
+		f.mutex.Unlock()
+		if idx >= len(f.statuses) {
+			idx = len(f.statuses) - 1
+		}
+
+		status := f.statuses[idx]
+		if status != nethttp.StatusOK && f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.WriteHeader(status)
+		if status == nethttp.StatusOK {
+			_, _ = w.Write([]byte(`{"name":"app"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errors":[{"code":"UNAVAILABLE","message":"try again later"}]}`))
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func TestClient_Retry(t *testing.T) {
+	testCases := map[string]struct {
+		retry          http.RetryOption
+		retryAfter     string
+		statuses       []int
+		delete         bool
+		expectRequests int
+		expectMinDelay time.Duration
+		expectName     string
+		expectErr      *http.StatusError
+	}{
+		"retry the transient error until succeed": {
+			retry:          http.RetryOption{MaxAttempts: 3, Backoff: 10 * time.Millisecond},
+			statuses:       []int{nethttp.StatusServiceUnavailable, nethttp.StatusBadGateway, nethttp.StatusOK},
+			expectRequests: 3,
+			// 10ms + 20ms
+			expectMinDelay: 30 * time.Millisecond,
+			expectName:     "app",
+		},
+		"backoff is limited by max backoff": {
+			retry:          http.RetryOption{MaxAttempts: 4, Backoff: 10 * time.Millisecond, MaxBackoff: 15 * time.Millisecond},
+			statuses:       []int{nethttp.StatusInternalServerError, nethttp.StatusInternalServerError, nethttp.StatusInternalServerError, nethttp.StatusOK},
+			expectRequests: 4,
+			expectMinDelay: 40 * time.Millisecond,
+			expectName:     "app",
+		},
+		"honoring retry after header": {
+			retry:          http.RetryOption{MaxAttempts: 2, Backoff: time.Millisecond},
+			retryAfter:     "1",
+			statuses:       []int{nethttp.StatusTooManyRequests, nethttp.StatusOK},
+			delete:         true,
+			expectRequests: 2,
+			expectMinDelay: time.Second,
+			expectName:     "app",
+		},
+		"returning error after all of attempts are failed": {
+			retry:          http.RetryOption{MaxAttempts: 3, Backoff: time.Millisecond},
+			statuses:       []int{nethttp.StatusTooManyRequests},
+			delete:         true,
+			expectRequests: 3,
+			expectErr:      &http.StatusError{StatusCode: nethttp.StatusTooManyRequests, Method: nethttp.MethodDelete},
+		},
+		// the body is unmarshaled as is, so the caller can inspect the errors field
+		"not retrying the status that is not listed": {
+			retry:          http.RetryOption{MaxAttempts: 3, Backoff: time.Millisecond, StatusCodes: []int{nethttp.StatusTooManyRequests}},
+			statuses:       []int{nethttp.StatusServiceUnavailable, nethttp.StatusOK},
+			expectRequests: 1,
+		},
+		"retry is disabled but the transient error is still returned": {
+			statuses:       []int{nethttp.StatusServiceUnavailable, nethttp.StatusOK},
+			expectRequests: 1,
+			expectErr:      &http.StatusError{StatusCode: nethttp.StatusServiceUnavailable, Method: nethttp.MethodGet},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			server := newFlakyServer(t, tc.retryAfter, tc.statuses...)
+			opt := http.Option{Retry: tc.retry}
+			opt.BasicAuth.Username = "user"
+			opt.BasicAuth.Password = "secret"
+			client, err := http.New(opt)
+			assert.NoError(t, err)
+
+			var result struct {
+				Name string `json:"name"`
+			}
+			url := server.server.URL + "/v2/app/manifests/latest"
+			if tc.delete {
+				err = client.DeleteMarshalReturnObj(context.Background(), url, &result)
+			} else {
+				err = client.GetMarshalReturnObj(context.Background(), url, &result)
+			}
+
+			assert.Len(t, server.requests, tc.expectRequests)
+			if tc.expectErr != nil {
+				var statusErr http.StatusError
+				assert.True(t, errors.As(err, &statusErr))
+				assert.Equal(t, tc.expectErr.StatusCode, statusErr.StatusCode)
+				assert.Equal(t, tc.expectErr.Method, statusErr.Method)
+				assert.Equal(t, url, statusErr.URL)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectName, result.Name)
+			if tc.expectMinDelay != 0 {
+				assert.GreaterOrEqual(t, server.requests[len(server.requests)-1].Sub(server.requests[0]), tc.expectMinDelay)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package kube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// listLimit the total of objects in each page of list api
+	listLimit      = 500
+	requestTimeout = 30 * time.Second
+)
+
+// ResourcePaths the api of objects that are listed in all namespaces, it's the same as
+// kubectl get pods,deployments,statefulsets,cronjobs -A
+var ResourcePaths = []string{
+	"/api/v1/pods",
+	"/apis/apps/v1/deployments",
+	"/apis/apps/v1/statefulsets",
+	"/apis/batch/v1/cronjobs",
+}
+
+// kubeconfig the parts of kubeconfig file that are used for connecting to the api server
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Username              string    `yaml:"username"`
+			Password              string    `yaml:"password"`
+			Exec                  yaml.Node `yaml:"exec"`
+			AuthProvider          yaml.Node `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// Client listing the images of workloads through kubernetes api
+type Client struct {
+	server   string
+	token    string
+	username string
+	password string
+	hc       *nethttp.Client
+}
+
+// NewClient create the api client by the context of kubeconfig file, the current context is used if it's not set.
+// the authentication by token, client certificate and basic auth are supported, the exec and auth provider plugins are not
+func NewClient(kubeconfigPath, contextName string) (*Client, error) {
+	data, err := os.ReadFile(filepath.Clean(kubeconfigPath))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading kubeconfig file: %w", err)
+	}
+	var kc kubeconfig
+	if err = yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("error while parsing kubeconfig file %s: %w", kubeconfigPath, err)
+	}
+	// the relative paths in kubeconfig are relative to it's directory
+	baseDir := filepath.Dir(kubeconfigPath)
+
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, ctx := range kc.Contexts {
+		if ctx.Name == contextName {
+			clusterName, userName, found = ctx.Context.Cluster, ctx.Context.User, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %s is not found in kubeconfig file %s", contextName, kubeconfigPath)
+	}
+
+	c := &Client{}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	found = false
+	for _, cluster := range kc.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		found = true
+		c.server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		//nolint:gosec
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+		caData, err := readData(baseDir, cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading certificate authority of cluster %s: %w", clusterName, err)
+		}
+		if len(caData) != 0 {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caData) {
+				return nil, fmt.Errorf("invalid certificate authority of cluster %s", clusterName)
+			}
+		}
+		break
+	}
+	if !found || c.server == "" {
+		return nil, fmt.Errorf("server of cluster %s is not found in kubeconfig file %s", clusterName, kubeconfigPath)
+	}
+
+	for _, user := range kc.Users {
+		if user.Name != userName {
+			continue
+		}
+		if !user.User.Exec.IsZero() || !user.User.AuthProvider.IsZero() {
+			return nil, fmt.Errorf("the credential plugin of user %s is not supported, use the output of kubectl instead", userName)
+		}
+		c.username, c.password = user.User.Username, user.User.Password
+		c.token = user.User.Token
+		if c.token == "" && user.User.TokenFile != "" {
+			token, err := os.ReadFile(resolvePath(baseDir, user.User.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("error while reading token file of user %s: %w", userName, err)
+			}
+			c.token = strings.TrimSpace(string(token))
+		}
+
+		certData, err := readData(baseDir, user.User.ClientCertificateData, user.User.ClientCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading client certificate of user %s: %w", userName, err)
+		}
+		keyData, err := readData(baseDir, user.User.ClientKeyData, user.User.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading client key of user %s: %w", userName, err)
+		}
+		if len(certData) != 0 {
+			cert, err := tls.X509KeyPair(certData, keyData)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of user %s: %w", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		break
+	}
+
+	c.hc = &nethttp.Client{
+		Timeout:   requestTimeout,
+		Transport: &nethttp.Transport{Proxy: nethttp.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	return c, nil
+}
+
+// Images listing the images of workloads in all namespaces
+func (c Client) Images(ctx context.Context) ([]Image, error) {
+	var refs []string
+	for _, path := range ResourcePaths {
+		continueToken := ""
+		for {
+			obj, next, err := c.list(ctx, path, continueToken)
+			if err != nil {
+				return nil, fmt.Errorf("while listing %s: %w", path, err)
+			}
+			refs = obj.collect(refs)
+			if next == "" {
+				break
+			}
+			continueToken = next
+		}
+	}
+	images := toImages(refs)
+	log.Debug().Str("server", c.server).Int("total_image", len(images)).Msg("images of kubernetes workloads are listed")
+	return images, nil
+}
+
+// list fetching a page of list api, it's returning the continue token of the next page
+func (c Client) list(ctx context.Context, path, continueToken string) (object, string, error) {
+	query := url.Values{"limit": {fmt.Sprint(listLimit)}}
+	if continueToken != "" {
+		query.Set("continue", continueToken)
+	}
+	var obj object
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return obj, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return obj, "", err
+	}
+	//nolint:errcheck
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return obj, "", err
+	}
+	if resp.StatusCode >= nethttp.StatusBadRequest {
+		var status struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(body, &status)
+		return obj, "", fmt.Errorf("got status code %d: %s", resp.StatusCode, status.Message)
+	}
+
+	if err = json.Unmarshal(body, &obj); err != nil {
+		return obj, "", err
+	}
+	return obj, obj.Metadata.Continue, nil
+}
+
+// readData the base64 encoded data of kubeconfig field, it's read from the file if the data is not set
+func readData(baseDir, data, path string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	return os.ReadFile(resolvePath(baseDir, path))
+}
+
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(baseDir, path)
+}
@@ -0,0 +1,171 @@
+package kube_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeToken = "secret-token"
+
+// fakeAPIServer serving the workloads of testdata/kube/workloads.json by their kind, the pods are listed in 2 pages
+func fakeAPIServer(t *testing.T) *httptest.Server {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "kube", "workloads.json"))
+	assert.NoError(t, err)
+	var list struct {
+		Items []map[string]any `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &list))
+	byKind := map[string][]map[string]any{}
+	for _, item := range list.Items {
+		kind := item["kind"].(string)
+		// the items of list api are not mentioning the kind
+		delete(item, "kind")
+		byKind[kind] = append(byKind[kind], item)
+	}
+
+	pages := map[string]map[string]any{
+		"/api/v1/pods":                 {"metadata": map[string]string{"continue": "page-2"}, "items": byKind["Pod"]},
+		"/api/v1/pods?continue=page-2": {"metadata": map[string]string{}, "items": []any{}},
+		"/apis/apps/v1/deployments":    {"items": byKind["Deployment"]},
+		"/apis/apps/v1/statefulsets":   {"items": byKind["StatefulSet"]},
+		"/apis/batch/v1/cronjobs":      {"items": byKind["CronJob"]},
+	}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"kind": "Status", "message": "Unauthorized"}`))
+			return
+		}
+		assert.Equal(t, "500", r.URL.Query().Get("limit"))
+		key := r.URL.Path
+		if token := r.URL.Query().Get("continue"); token != "" {
+			key += "?continue=" + token
+		}
+		page, ok := pages[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+}
+
+// writeKubeconfig writing kubeconfig file that has the context of fake api server, the users are token and exec plugin
+func writeKubeconfig(t *testing.T, server *httptest.Server) string {
+	caData := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: fake
+clusters:
+- name: fake
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+contexts:
+- name: fake
+  context:
+    cluster: fake
+    user: token
+- name: wrong-token
+  context:
+    cluster: fake
+    user: wrong-token
+- name: gke
+  context:
+    cluster: fake
+    user: gke
+- name: no-cluster
+  context:
+    cluster: not-exists
+    user: token
+users:
+- name: token
+  user:
+    tokenFile: token
+- name: wrong-token
+  user:
+    token: wrong
+- name: gke
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: gke-gcloud-auth-plugin
+`, server.URL, caData)
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte(fakeToken+"\n"), 0o600))
+	path := filepath.Join(dir, "config")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestClient_Images(t *testing.T) {
+	server := fakeAPIServer(t)
+	defer server.Close()
+	kubeconfigPath := writeKubeconfig(t, server)
+
+	testCases := map[string]struct {
+		context           string
+		kubeconfigPath    string
+		expectedResult    []kube.Image
+		expectedErrMsg    string
+		expectedNewErrMsg string
+	}{
+		"listing the images of workloads by current context": {
+			expectedResult: workloadImages,
+		},
+		"got an error response from api server": {
+			context:        "wrong-token",
+			expectedErrMsg: "while listing /api/v1/pods: got status code 401: Unauthorized",
+		},
+		"credential plugin is not supported": {
+			context:           "gke",
+			expectedNewErrMsg: "the credential plugin of user gke is not supported, use the output of kubectl instead",
+		},
+		"context is not found": {
+			context:           "not-exists",
+			expectedNewErrMsg: fmt.Sprintf("context not-exists is not found in kubeconfig file %s", kubeconfigPath),
+		},
+		"cluster is not found": {
+			context:           "no-cluster",
+			expectedNewErrMsg: fmt.Sprintf("server of cluster not-exists is not found in kubeconfig file %s", kubeconfigPath),
+		},
+		"kubeconfig file is not found": {
+			kubeconfigPath:    "/tmp/not_found_kubeconfig",
+			expectedNewErrMsg: "error while reading kubeconfig file: open /tmp/not_found_kubeconfig: no such file or directory",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			path := kubeconfigPath
+			if tc.kubeconfigPath != "" {
+				path = tc.kubeconfigPath
+			}
+			client, err := kube.NewClient(path, tc.context)
+			if tc.expectedNewErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedNewErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+
+			images, err := client.Images(context.Background())
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, images)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package kube
+
+import (
+	"strings"
+)
+
+const (
+	dockerHubDomain = "docker.io"
+	// dockerHubNamespace the namespace of official images in docker hub, eg: nginx is docker.io/library/nginx
+	dockerHubNamespace = "library"
+)
+
+// dockerHubAliases the other hosts of docker hub that are normalized to docker.io
+var dockerHubAliases = []string{"index.docker.io", "registry-1.docker.io", "registry.hub.docker.com"}
+
+// Image the image reference that is used by container, the repository is normalized to it's full name
+type Image struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+}
+
+// String the image reference, the digest is preferred since it's the one that is pulled
+func (i Image) String() string {
+	if i.Digest != "" {
+		return i.Repository + "@" + i.Digest
+	}
+	if i.Tag != "" {
+		return i.Repository + ":" + i.Tag
+	}
+	return i.Repository
+}
+
+// ParseImage parse the image reference (eg: nginx:1.25, ghcr.io/org/app@sha256:<hex>), the image without tag and digest
+// is using latest tag as the container runtime does
+func ParseImage(ref string) Image {
+	var image Image
+	name := ref
+	if idx := strings.Index(name, "@"); idx != -1 {
+		name, image.Digest = name[:idx], name[idx+1:]
+	}
+	// the colon after the last slash is the tag separator, the one before it is the port of host
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name, image.Tag = name[:idx], name[idx+1:]
+	}
+	if image.Tag == "" && image.Digest == "" {
+		image.Tag = "latest"
+	}
+	image.Repository = NormalizeRepository(name)
+	return image
+}
+
+// NormalizeRepository the full name of repository as the container runtime resolves it, the host is docker.io
+// if it's not mentioned and the official image is under library namespace
+func NormalizeRepository(name string) string {
+	name = strings.TrimSuffix(name, "/")
+	domain, remainder, found := strings.Cut(name, "/")
+	if !found || (!strings.ContainsAny(domain, ".:") && domain != "localhost") {
+		domain, remainder = dockerHubDomain, name
+	}
+	for _, alias := range dockerHubAliases {
+		if domain == alias {
+			domain = dockerHubDomain
+		}
+	}
+	if domain == dockerHubDomain && !strings.Contains(remainder, "/") {
+		remainder = dockerHubNamespace + "/" + remainder
+	}
+	return domain + "/" + remainder
+}
+
+// Index the lookup of images by their digest and tag
+type Index struct {
+	digests map[string]Image
+	tags    map[string]Image
+}
+
+// NewIndex indexing the images for matching them with the digests of registry
+func NewIndex(images []Image) Index {
+	idx := Index{digests: map[string]Image{}, tags: map[string]Image{}}
+	for _, image := range images {
+		if image.Digest != "" {
+			idx.digests[image.Digest] = image
+		}
+		if image.Tag != "" {
+			idx.tags[image.Repository+":"+image.Tag] = image
+		}
+	}
+	return idx
+}
+
+// IsEmpty there is no image in index
+func (i Index) IsEmpty() bool {
+	return len(i.digests) == 0 && len(i.tags) == 0
+}
+
+// Match returning the image that is using the digest of repository. the digest is matched in any repository since it's
+// the same content (eg: pulled through a mirror), the tag is matched in the same repository only
+func (i Index) Match(repository, digest string, tags []string) (Image, bool) {
+	if image, ok := i.digests[digest]; ok {
+		return image, true
+	}
+	if len(tags) == 0 || len(i.tags) == 0 {
+		return Image{}, false
+	}
+	repository = NormalizeRepository(repository)
+	for _, tag := range tags {
+		if image, ok := i.tags[repository+":"+tag]; ok {
+			return image, true
+		}
+	}
+	return Image{}, false
+}
@@ -0,0 +1,96 @@
+package kube_test
+
+import (
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseImage(t *testing.T) {
+	testCases := map[string]struct {
+		ref            string
+		expectedResult kube.Image
+	}{
+		"official image without tag": {
+			ref:            "nginx",
+			expectedResult: kube.Image{Repository: "docker.io/library/nginx", Tag: "latest"},
+		},
+		"docker hub image of organization": {
+			ref:            "index.docker.io/bitnami/redis:7.2",
+			expectedResult: kube.Image{Repository: "docker.io/bitnami/redis", Tag: "7.2"},
+		},
+		"host with port": {
+			ref:            "registry.example.com:5000/team-a/app:v1",
+			expectedResult: kube.Image{Repository: "registry.example.com:5000/team-a/app", Tag: "v1"},
+		},
+		"host with port and without tag": {
+			ref:            "localhost:5000/app",
+			expectedResult: kube.Image{Repository: "localhost:5000/app", Tag: "latest"},
+		},
+		"digest": {
+			ref: "ghcr.io/org/app@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			expectedResult: kube.Image{
+				Repository: "ghcr.io/org/app",
+				Digest:     "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			},
+		},
+		"tag and digest": {
+			ref: "localhost/app:v1@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			expectedResult: kube.Image{
+				Repository: "localhost/app",
+				Tag:        "v1",
+				Digest:     "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			assert.Equal(t, tc.expectedResult, kube.ParseImage(tc.ref))
+		})
+	}
+}
+
+func TestIndex_Match(t *testing.T) {
+	index := kube.NewIndex([]kube.Image{
+		kube.ParseImage("nginx:1.25"),
+		kube.ParseImage("mirror.example.com/team-a/app@sha256:1111111111111111111111111111111111111111111111111111111111111111"),
+	})
+	assert.False(t, index.IsEmpty())
+	assert.True(t, kube.NewIndex(nil).IsEmpty())
+
+	testCases := map[string]struct {
+		repository  string
+		digest      string
+		tags        []string
+		expectMatch string
+	}{
+		"matched by digest in the other repository": {
+			repository:  "registry.example.com/team-a/app",
+			digest:      "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			expectMatch: "mirror.example.com/team-a/app@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		},
+		"matched by tag of normalized repository": {
+			repository:  "docker.io/library/nginx",
+			digest:      "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:        []string{"latest", "1.25"},
+			expectMatch: "docker.io/library/nginx:1.25",
+		},
+		"the same tag in the other repository": {
+			repository: "registry.example.com/nginx",
+			digest:     "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:       []string{"1.25"},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			image, matched := index.Match(tc.repository, tc.digest, tc.tags)
+			assert.Equal(t, tc.expectMatch != "", matched)
+			if matched {
+				assert.Equal(t, tc.expectMatch, image.String())
+			}
+		})
+	}
+}
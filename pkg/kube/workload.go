@@ -0,0 +1,148 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdin the path for reading the kubectl output from standard input
+const Stdin = "-"
+
+type container struct {
+	Image string `json:"image"`
+}
+
+type containerStatus struct {
+	Image string `json:"image"`
+	// ImageID the resolved image of running container, eg: docker-pullable://nginx@sha256:<hex>
+	ImageID string `json:"imageID"`
+}
+
+type podSpec struct {
+	Containers          []container `json:"containers"`
+	InitContainers      []container `json:"initContainers"`
+	EphemeralContainers []container `json:"ephemeralContainers"`
+}
+
+type podTemplate struct {
+	Spec podSpec `json:"spec"`
+}
+
+// object the kubernetes object or list of them, only the parts that contain images are parsed.
+// the kind is not used since the items of list from api are not mentioning it
+type object struct {
+	Metadata struct {
+		// Continue the token for fetching the next page of list
+		Continue string `json:"continue"`
+	} `json:"metadata"`
+	Items []object `json:"items"`
+	Spec  struct {
+		podSpec
+		// Template the pod template of deployment, statefulset, daemonset, job, etc
+		Template *podTemplate `json:"template"`
+		// JobTemplate the job template of cronjob
+		JobTemplate *struct {
+			Spec struct {
+				Template podTemplate `json:"template"`
+			} `json:"spec"`
+		} `json:"jobTemplate"`
+	} `json:"spec"`
+	Status struct {
+		ContainerStatuses          []containerStatus `json:"containerStatuses"`
+		InitContainerStatuses      []containerStatus `json:"initContainerStatuses"`
+		EphemeralContainerStatuses []containerStatus `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+// collect adding the images of object and it's items
+func (o object) collect(refs []string) []string {
+	for _, item := range o.Items {
+		refs = item.collect(refs)
+	}
+
+	specs := []podSpec{o.Spec.podSpec}
+	if o.Spec.Template != nil {
+		specs = append(specs, o.Spec.Template.Spec)
+	}
+	if o.Spec.JobTemplate != nil {
+		specs = append(specs, o.Spec.JobTemplate.Spec.Template.Spec)
+	}
+	for _, spec := range specs {
+		for _, containers := range [][]container{spec.Containers, spec.InitContainers, spec.EphemeralContainers} {
+			for _, c := range containers {
+				refs = append(refs, c.Image)
+			}
+		}
+	}
+
+	for _, statuses := range [][]containerStatus{o.Status.ContainerStatuses, o.Status.InitContainerStatuses, o.Status.EphemeralContainerStatuses} {
+		for _, status := range statuses {
+			refs = append(refs, status.Image, imageIDRef(status.ImageID))
+		}
+	}
+	return refs
+}
+
+// imageIDRef the image reference of container status image id. the one without repository (image config id) is ignored
+// since it's not a manifest digest
+func imageIDRef(imageID string) string {
+	if _, ref, found := strings.Cut(imageID, "://"); found {
+		imageID = ref
+	}
+	if !strings.Contains(imageID, "@") {
+		return ""
+	}
+	return imageID
+}
+
+// ParseImages parse the images of kubectl json output (eg: kubectl get pods,deployments -A -o json) or the list from api,
+// the images of containers, init containers and ephemeral containers including the resolved one in pod status are taken
+func ParseImages(data []byte) ([]Image, error) {
+	var obj object
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return toImages(obj.collect(nil)), nil
+}
+
+// ReadImages parse the images of kubectl json output file, it's read from standard input if the path is "-"
+func ReadImages(path string) ([]Image, error) {
+	var data []byte
+	var err error
+	if path == Stdin {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filepath.Clean(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading kubernetes objects file: %w", err)
+	}
+
+	images, err := ParseImages(data)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing kubernetes objects %s: %w", path, err)
+	}
+	return images, nil
+}
+
+// toImages parse the unique image references
+func toImages(refs []string) []Image {
+	seen := map[Image]bool{}
+	images := []Image{}
+	for _, ref := range refs {
+		if ref == "" {
+			continue
+		}
+		image := ParseImage(ref)
+		if seen[image] {
+			continue
+		}
+		seen[image] = true
+		images = append(images, image)
+	}
+	return images
+}
@@ -0,0 +1,56 @@
+package kube_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/kube"
+	"github.com/stretchr/testify/assert"
+)
+
+// workloadImages the images of testdata/kube/workloads.json
+var workloadImages = []kube.Image{
+	{Repository: "registry.example.com/team-a/web", Tag: "v1.0.0"},
+	{Repository: "docker.io/library/nginx", Tag: "latest"},
+	{Repository: "registry.example.com/team-a/migrate", Tag: "v2"},
+	{Repository: "docker.io/library/busybox", Tag: "1.36"},
+	{Repository: "registry.example.com/team-a/web", Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+	{Repository: "registry.example.com/team-a/migrate", Digest: "sha256:3333333333333333333333333333333333333333333333333333333333333333"},
+	{Repository: "registry.example.com:5000/team-a/worker", Digest: "sha256:2222222222222222222222222222222222222222222222222222222222222222"},
+	{Repository: "registry.example.com/team-b/db", Tag: "15"},
+	{Repository: "registry.example.com/team-b/db-init", Tag: "v1", Digest: "sha256:5555555555555555555555555555555555555555555555555555555555555555"},
+	{Repository: "registry.example.com/team-b/report", Tag: "nightly"},
+}
+
+func TestReadImages(t *testing.T) {
+	testCases := map[string]struct {
+		path           string
+		expectedResult []kube.Image
+		expectedErrMsg string
+	}{
+		"images of workloads": {
+			path:           filepath.Join("..", "..", "testdata", "kube", "workloads.json"),
+			expectedResult: workloadImages,
+		},
+		"file is not found": {
+			path:           filepath.Join("..", "..", "testdata", "kube", "not_found.json"),
+			expectedErrMsg: "error while reading kubernetes objects file: open ../../testdata/kube/not_found.json: no such file or directory",
+		},
+		"not a json file": {
+			path:           filepath.Join("..", "..", "testdata", "skip_list.txt"),
+			expectedErrMsg: "error while parsing kubernetes objects ../../testdata/skip_list.txt: invalid character 'a' looking for beginning of value",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			images, err := kube.ReadImages(tc.path)
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedResult, images)
+		})
+	}
+}
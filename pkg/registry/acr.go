@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/imroc/req/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	// acrAADScope the scope of azure ad token that can be exchanged as acr refresh token
+	acrAADScope    = "https://containerregistry.azure.net/.default"
+	acrPageSize    = 100
+	acrExchangeURI = "/oauth2/exchange"
+)
+
+type ACRChangeableAttributes struct {
+	// DeleteEnabled is nil if it's not returned, it's deletable by default
+	DeleteEnabled *bool `json:"deleteEnabled"`
+}
+
+type ACRManifest struct {
+	Digest               string                  `json:"digest"`
+	ImageSize            uint                    `json:"imageSize"`
+	CreatedTime          time.Time               `json:"createdTime"`
+	LastUpdateTime       time.Time               `json:"lastUpdateTime"`
+	MediaType            string                  `json:"mediaType"`
+	Tags                 []string                `json:"tags"`
+	ChangeableAttributes ACRChangeableAttributes `json:"changeableAttributes"`
+}
+
+type ACRManifestsResponse struct {
+	Registry  string        `json:"registry"`
+	ImageName string        `json:"imageName"`
+	Manifests []ACRManifest `json:"manifests"`
+	ErrorsField
+}
+
+type acrRefreshTokenResponse struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// ACR is the azure container registry that using it's metadata api (/acr/v1) for listing the manifests,
+// the locked manifest (deleteEnabled is false) is listed as locked since it cannot be deleted
+type ACR struct {
+	host   string
+	prefix string
+	hc     http.IHttpClient
+}
+
+// NewACR the host can be followed by path for limiting the repositories, eg: myregistry.azurecr.io/team-a
+func NewACR(host string, hc http.IHttpClient, _ Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 2)
+	a := &ACR{host: hostSplt[0], hc: hc}
+	if len(hostSplt) == 2 {
+		a.prefix = strings.Trim(hostSplt[1], "/")
+	}
+	return a, nil
+}
+
+// Catalog list repositories then it's manifests through acr's metadata api
+func (a ACR) Catalog(ctx context.Context) ([]Repository, error) {
+	var repoNames []string
+	err := a.paginate(ctx, a.url("_catalog"), func() errorResponse { return &CatalogResponse{} }, func(obj errorResponse) {
+		repoNames = append(repoNames, obj.(*CatalogResponse).Repositories...)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing repositories")
+	}
+
+	var repositories []Repository
+	for _, repoName := range repoNames {
+		if a.prefix != "" && repoName != a.prefix && !strings.HasPrefix(repoName, a.prefix+"/") {
+			continue
+		}
+
+		log.Debug().Str("repo", repoName).Msg("processing")
+		digests, err := a.digests(ctx, repoName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", repoName).Msg("not found any digests found, skipping")
+			continue
+		}
+		repositories = append(repositories, Repository{Name: h.SlashJoin(a.host, repoName), Digests: digests})
+	}
+	return repositories, nil
+}
+
+// Delete by manifest digest, the tags that are referring to it will be gone as well
+func (a ACR) Delete(ctx context.Context, repository Repository) (err error) {
+	shortRepoName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/", a.host))
+	for idr := range repository.Digests {
+		digestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", a.host, shortRepoName, repository.Digests[idr].Name)
+		log.Debug().Str("url", digestURL).Msg("deleting digest")
+		if _, err = callAPI(ctx, a.hc, http.Request{Method: nethttp.MethodDelete, URL: digestURL}, &ErrorsField{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digests mapping the manifests of repository, the uploaded time is the last time of manifest is pushed.
+// the platform manifests of index are listed as the untagged manifests, so they are linked to the index
+func (a ACR) digests(ctx context.Context, repoName string) ([]Digest, error) {
+	var digests []Digest
+	err := a.paginate(ctx, a.url(repoName, "_manifests"), func() errorResponse { return &ACRManifestsResponse{} }, func(obj errorResponse) {
+		for _, manifest := range obj.(*ACRManifestsResponse).Manifests {
+			// the locked index is kept in the list, so it's platform manifests are linked to it and they are not deleted as well
+			deleteEnabled := manifest.ChangeableAttributes.DeleteEnabled
+			digests = append(digests, Digest{
+				Name:           manifest.Digest,
+				ImageSizeBytes: manifest.ImageSize,
+				Tag:            manifest.Tags,
+				Created:        manifest.CreatedTime,
+				Uploaded:       manifest.LastUpdateTime,
+				MediaType:      manifest.MediaType,
+				Locked:         deleteEnabled != nil && !*deleteEnabled,
+			})
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing manifests of %s", repoName)
+	}
+
+	err = linkIndexManifests(digests, func(name string) (*ManifestResponse, error) {
+		return fetchManifest(ctx, a.hc, fmt.Sprintf("https://%s/v2/%s/manifests/%s", a.host, repoName, name), name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// paginate fetching the url and following the next page through Link header
+func (a ACR) paginate(ctx context.Context, pageURL string, newObj func() errorResponse, collect func(obj errorResponse)) error {
+	pageURL = fmt.Sprintf("%s?n=%d", pageURL, acrPageSize)
+	for pageURL != "" {
+		obj := newObj()
+		resp, err := callAPI(ctx, a.hc, http.Request{Method: nethttp.MethodGet, URL: pageURL}, obj)
+		if err != nil {
+			return err
+		}
+		collect(obj)
+		pageURL = nextLink(fmt.Sprintf("https://%s", a.host), resp)
+	}
+	return nil
+}
+
+func (a ACR) url(paths ...string) string {
+	return fmt.Sprintf("https://%s/acr/v1/%s", a.host, h.SlashJoin(paths...))
+}
+
+// acrRefreshToken exchanging the azure ad token as acr refresh token, it's exchanged again only if the azure ad token is renewed
+type acrRefreshToken struct {
+	mutex        sync.Mutex
+	cred         azcore.TokenCredential
+	client       *req.Client
+	aadToken     string
+	refreshToken string
+}
+
+// NewACRRefreshTokenSource the refresh token source by the given azure credential
+func NewACRRefreshTokenSource(cred azcore.TokenCredential, allowInsecureSSL bool) http.RefreshTokenSource {
+	client := req.C()
+	if allowInsecureSSL {
+		client.EnableInsecureSkipVerify()
+	}
+	source := &acrRefreshToken{cred: cred, client: client}
+	return source.get
+}
+
+// acrRefreshTokenSource using the default azure credential chain (env vars, workload identity, managed identity, azure cli, etc)
+func acrRefreshTokenSource(allowInsecureSSL bool) (http.RefreshTokenSource, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "while initiating azure credential")
+	}
+	return NewACRRefreshTokenSource(cred, allowInsecureSSL), nil
+}
+
+func (a *acrRefreshToken) get(ctx context.Context, realm, service string) (string, error) {
+	aadToken, err := a.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{acrAADScope}})
+	if err != nil {
+		return "", errors.Wrap(err, "while getting azure ad token")
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.refreshToken != "" && a.aadToken == aadToken.Token {
+		return a.refreshToken, nil
+	}
+
+	// the exchange endpoint is in the same host of token realm, eg: https://myregistry.azurecr.io/oauth2/token
+	exchangeURL, err := url.Parse(realm)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid realm %s", realm)
+	}
+	exchangeURL.Path = acrExchangeURI
+	exchangeURL.RawQuery = ""
+
+	var body acrRefreshTokenResponse
+	log.Debug().Str("url", exchangeURL.String()).Msg("exchanging azure ad token as refresh token")
+	resp, err := a.client.R().SetContext(ctx).SetSuccessResult(&body).SetFormData(map[string]string{
+		"grant_type":   "access_token",
+		"service":      service,
+		"access_token": aadToken.Token,
+	}).Post(exchangeURL.String())
+	if err != nil {
+		return "", err
+	}
+	if !resp.IsSuccessState() {
+		return "", fmt.Errorf("got status code %d while exchanging refresh token to %s", resp.StatusCode, exchangeURL)
+	}
+	if body.RefreshToken == "" {
+		return "", fmt.Errorf("no refresh token returned from %s", exchangeURL)
+	}
+
+	a.aadToken = aadToken.Token
+	a.refreshToken = body.RefreshToken
+	return a.refreshToken, nil
+}
@@ -0,0 +1,305 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	acrHost     = "myregistry.azurecr.io"
+	acrAPIURL   = fmt.Sprintf("https://%s/acr/v1", acrHost)
+	acrPageSize = "?n=100"
+)
+
+func TestACR_Catalog(t *testing.T) {
+	expectedDigests := []reg.Digest{
+		{
+			Name:           "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			ImageSizeBytes: 3861526,
+			Tag:            []string{"latest", "v1.0.0"},
+			Created:        time.Date(2023, time.March, 10, 8, 0, 0, 123456700, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 12, 9, 30, 0, 123456700, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+		},
+		// the second one is locked
+		{
+			Name:           "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			ImageSizeBytes: 7723052,
+			Tag:            []string{"release-2023.02"},
+			Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+			Locked:         true,
+		},
+		{
+			Name:           "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+			ImageSizeBytes: 1048576,
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+		},
+	}
+
+	successRoutes := func() map[string]mockRoute {
+		return map[string]mockRoute{
+			"GET " + hl.SlashJoin(acrAPIURL, "_catalog"+acrPageSize): jsonRoute(
+				reg.CatalogResponse{Repositories: []string{"team-a/app"}},
+				map[string]string{"Link": `</acr/v1/_catalog?last=team-a%2Fapp&n=100&orderby=>; rel="next"`},
+			),
+			"GET " + hl.SlashJoin(acrAPIURL, "_catalog?last=team-a%2Fapp&n=100&orderby="): jsonRoute(
+				reg.CatalogResponse{Repositories: []string{"team-a/locked", "team-b/web"}}, nil,
+			),
+			"GET " + hl.SlashJoin(acrAPIURL, "team-a/app", "_manifests"+acrPageSize): fixtureRoute(
+				"acr/manifests.json", nethttp.StatusOK, nil,
+			),
+			"GET " + hl.SlashJoin(acrAPIURL, "team-a/locked", "_manifests"+acrPageSize): jsonRoute(
+				reg.ACRManifestsResponse{Manifests: []reg.ACRManifest{
+					{Digest: "sha256:4444", ChangeableAttributes: reg.ACRChangeableAttributes{DeleteEnabled: new(bool)}},
+				}}, nil,
+			),
+			"GET " + hl.SlashJoin(acrAPIURL, "team-b/web", "_manifests"+acrPageSize): fixtureRoute(
+				"acr/manifests.json", nethttp.StatusOK, nil,
+			),
+		}
+	}
+	multiArchRoutes := func(indexAttrs reg.ACRChangeableAttributes) func() map[string]mockRoute {
+		return func() map[string]mockRoute {
+			return map[string]mockRoute{
+				"GET " + hl.SlashJoin(acrAPIURL, "_catalog"+acrPageSize): jsonRoute(reg.CatalogResponse{Repositories: []string{"team-c/multi-arch"}}, nil),
+				"GET " + hl.SlashJoin(acrAPIURL, "team-c/multi-arch", "_manifests"+acrPageSize): jsonRoute(
+					reg.ACRManifestsResponse{Manifests: []reg.ACRManifest{
+						{Digest: multiArchIndex, Tags: []string{"stable"}, MediaType: reg.MediaTypeOCIIndex, ChangeableAttributes: indexAttrs},
+						{Digest: multiArchChildren[0], MediaType: reg.MediaTypeOCIManifest},
+						{Digest: multiArchChildren[1], MediaType: reg.MediaTypeOCIManifest},
+					}}, nil,
+				),
+				"GET " + fmt.Sprintf("https://%s/v2/team-c/multi-arch/manifests/%s", acrHost, multiArchIndex): fixtureRoute(
+					"generic/index.json", nethttp.StatusOK, nil,
+				),
+			}
+		}
+	}
+
+	testCases := map[string]struct {
+		host               string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"unauthorized while listing repositories": {
+			host: acrHost,
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(acrAPIURL, "_catalog"+acrPageSize): fixtureRoute("acr/error_unauthorized.json", nethttp.StatusUnauthorized, nil),
+				}
+			},
+			expectErrMsg: "while listing repositories: [UNAUTHORIZED] [authentication required, visit https://aka.ms/acr/authorization for more information.]",
+		},
+		"listing the locked manifests and following pagination": {
+			host:   acrHost,
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "myregistry.azurecr.io/team-a/app", Digests: expectedDigests},
+				{Name: "myregistry.azurecr.io/team-a/locked", Digests: []reg.Digest{{Name: "sha256:4444", Locked: true}}},
+				{Name: "myregistry.azurecr.io/team-b/web", Digests: expectedDigests},
+			},
+		},
+		"only repositories under the prefix": {
+			host:   hl.SlashJoin(acrHost, "team-b"),
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "myregistry.azurecr.io/team-b/web", Digests: expectedDigests},
+			},
+		},
+		"linking the platform manifests to their index": {
+			host:   acrHost,
+			routes: multiArchRoutes(reg.ACRChangeableAttributes{}),
+			expectRepositories: []reg.Repository{
+				{Name: "myregistry.azurecr.io/team-c/multi-arch", Digests: []reg.Digest{
+					{Name: multiArchIndex, Tag: []string{"stable"}, MediaType: reg.MediaTypeOCIIndex, Platforms: []string{"linux/amd64", "linux/arm64"}},
+					{Name: multiArchChildren[0], MediaType: reg.MediaTypeOCIManifest, Platforms: []string{"linux/amd64"}, ParentDigests: []string{multiArchIndex}},
+					{Name: multiArchChildren[1], MediaType: reg.MediaTypeOCIManifest, Platforms: []string{"linux/arm64"}, ParentDigests: []string{multiArchIndex}},
+				}},
+			},
+		},
+		"the unlocked platform manifests are linked to the locked index": {
+			host:   acrHost,
+			routes: multiArchRoutes(reg.ACRChangeableAttributes{DeleteEnabled: new(bool)}),
+			expectRepositories: []reg.Repository{
+				{Name: "myregistry.azurecr.io/team-c/multi-arch", Digests: []reg.Digest{
+					{Name: multiArchIndex, Tag: []string{"stable"}, MediaType: reg.MediaTypeOCIIndex, Platforms: []string{"linux/amd64", "linux/arm64"}, Locked: true},
+					{Name: multiArchChildren[0], MediaType: reg.MediaTypeOCIManifest, Platforms: []string{"linux/amd64"}, ParentDigests: []string{multiArchIndex}},
+					{Name: multiArchChildren[1], MediaType: reg.MediaTypeOCIManifest, Platforms: []string{"linux/arm64"}, ParentDigests: []string{multiArchIndex}},
+				}},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			acr, err := reg.NewACR(tc.host, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := acr.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestACR_Delete(t *testing.T) {
+	sampleRepo := reg.Repository{
+		Name: "myregistry.azurecr.io/team-a/app",
+		Digests: []reg.Digest{
+			{Name: "sha256:1111111111111111111111111111111111111111111111111111111111111111", Tag: []string{"latest"}},
+			{Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333"},
+		},
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/team-a/app/manifests", acrHost)
+
+	testCases := map[string]struct {
+		mockHTTPClient func(*mh.MockIHttpClient)
+		expectErrMsg   string
+	}{
+		"deleting by digest": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusAccepted}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[1].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusAccepted}, nil),
+				)
+			},
+		},
+		"manifest is locked": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+					Times(1).DoAndReturn(func(_ context.Context, r http.Request, errFields *reg.ErrorsField) (*http.Response, error) {
+					errFields.Errors = []reg.ErrorField{{Code: "DENIED", Message: "The operation is disallowed on this registry, repository or manifest."}}
+					return &http.Response{StatusCode: nethttp.StatusMethodNotAllowed}, nil
+				})
+			},
+			expectErrMsg: "[DENIED] [The operation is disallowed on this registry, repository or manifest.]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			tc.mockHTTPClient(mHc)
+
+			acr, err := reg.NewACR(acrHost, mHc, reg.Option{})
+			assert.NoError(t, err)
+
+			err = acr.Delete(context.Background(), sampleRepo)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// fakeAzureCredential returning the azure ad token by order of calls
+type fakeAzureCredential struct {
+	tokens []string
+	calls  int
+}
+
+func (f *fakeAzureCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if len(opts.Scopes) != 1 || opts.Scopes[0] != "https://containerregistry.azure.net/.default" {
+		return azcore.AccessToken{}, fmt.Errorf("unexpected scopes %v", opts.Scopes)
+	}
+	token := f.tokens[min(f.calls, len(f.tokens)-1)]
+	f.calls++
+	return azcore.AccessToken{Token: token, ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+func TestACRRefreshTokenSource(t *testing.T) {
+	var exchanges []string
+	ts := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_ = r.ParseForm()
+		if r.URL.Path != "/oauth2/exchange" || r.PostForm.Get("grant_type") != "access_token" || r.PostForm.Get("service") != acrHost {
+			w.WriteHeader(nethttp.StatusBadRequest)
+			return
+		}
+		aadToken := r.PostForm.Get("access_token")
+		if aadToken == "aad-invalid" {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		exchanges = append(exchanges, aadToken)
+		_ = json.NewEncoder(w).Encode(map[string]string{"refresh_token": "refresh-for:" + aadToken})
+	}))
+	t.Cleanup(ts.Close)
+	realm := ts.URL + "/oauth2/token"
+
+	testCases := map[string]struct {
+		aadTokens           []string
+		calls               int
+		expectRefreshTokens []string
+		expectExchanges     []string
+		expectErrMsg        string
+	}{
+		"refresh token is reused until the azure ad token is renewed": {
+			aadTokens:           []string{"aad-1", "aad-1", "aad-2"},
+			calls:               3,
+			expectRefreshTokens: []string{"refresh-for:aad-1", "refresh-for:aad-1", "refresh-for:aad-2"},
+			expectExchanges:     []string{"aad-1", "aad-2"},
+		},
+		"azure ad token is rejected": {
+			aadTokens:    []string{"aad-invalid"},
+			calls:        1,
+			expectErrMsg: fmt.Sprintf("got status code 401 while exchanging refresh token to %s/oauth2/exchange", ts.URL),
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			exchanges = nil
+			source := reg.NewACRRefreshTokenSource(&fakeAzureCredential{tokens: tc.aadTokens}, true)
+
+			var refreshTokens []string
+			for i := 0; i < tc.calls; i++ {
+				refreshToken, err := source(context.Background(), realm, acrHost)
+				if tc.expectErrMsg != "" {
+					assert.EqualError(t, err, tc.expectErrMsg)
+					return
+				}
+				assert.NoError(t, err)
+				refreshTokens = append(refreshTokens, refreshToken)
+			}
+			assert.Equal(t, tc.expectRefreshTokens, refreshTokens)
+			assert.Equal(t, tc.expectExchanges, exchanges)
+		})
+	}
+}
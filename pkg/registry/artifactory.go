@@ -0,0 +1,251 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	artifactoryManifest     = "manifest.json"
+	artifactoryListManifest = "list.manifest.json"
+	artifactoryPageSize     = 1000
+	// artifactoryDigestFolder the prefix of folder that is storing the manifest by it's digest (sha256__<hex>)
+	artifactoryDigestFolder = "sha256__"
+)
+
+type ArtifactoryErrorItem struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// ArtifactoryError is the error response of artifactory rest api
+type ArtifactoryError struct {
+	Errors []ArtifactoryErrorItem `json:"errors"`
+}
+
+func (e ArtifactoryError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("[%d] [%s]", e.Errors[0].Status, e.Errors[0].Message)
+}
+
+type ArtifactoryStat struct {
+	Downloaded time.Time `json:"downloaded"`
+	Downloads  uint      `json:"downloads"`
+}
+
+type ArtifactoryItem struct {
+	Repo     string            `json:"repo"`
+	Path     string            `json:"path"`
+	Name     string            `json:"name"`
+	Size     uint              `json:"size"`
+	SHA256   string            `json:"sha256"`
+	Created  time.Time         `json:"created"`
+	Modified time.Time         `json:"modified"`
+	Stats    []ArtifactoryStat `json:"stats"`
+}
+
+type ArtifactoryAQLResponse struct {
+	Results []ArtifactoryItem `json:"results"`
+	ArtifactoryError
+}
+
+// Artifactory is querying the files of docker repository by AQL, each of tag is a folder (<image>/<tag>) that contains
+// the manifest and the layers of image
+type Artifactory struct {
+	host       string
+	repository string
+	prefix     string
+	apiURL     string
+	hc         http.IHttpClient
+}
+
+// NewArtifactory the host must be followed by the docker repository key and it can be followed by image prefix,
+// eg: artifactory.company.com/docker-local or artifactory.company.com/docker-local/team-a
+func NewArtifactory(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 3)
+	if len(hostSplt) == 1 {
+		return nil, fmt.Errorf("you must specified repository key after artifactory host, eg: artifactory.company.com/docker-local")
+	}
+
+	a := &Artifactory{
+		host:       hostSplt[0],
+		repository: hostSplt[1],
+		apiURL:     fmt.Sprintf("https://%s/artifactory", hostSplt[0]),
+		hc:         hc,
+	}
+	if len(hostSplt) == 3 {
+		a.prefix = strings.Trim(hostSplt[2], "/")
+	}
+	if opt.Endpoint != "" {
+		a.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	return a, nil
+}
+
+// Catalog list all files of repository then the tag folders that are containing manifest are grouped by it's image and digest,
+// the image size is the total size of files in tag folder and the platform folders of manifest list
+func (a *Artifactory) Catalog(ctx context.Context) ([]Repository, error) {
+	items, err := a.items(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "while querying items")
+	}
+
+	folderSizes := map[string]uint{}
+	var manifests []ArtifactoryItem
+	for _, item := range items {
+		folderSizes[item.Path] += item.Size
+		if item.Name == artifactoryManifest || item.Name == artifactoryListManifest {
+			manifests = append(manifests, item)
+		}
+	}
+
+	var repositories []Repository
+	repoIndex := map[string]int{}
+	for _, manifest := range manifests {
+		imageName, tag := path.Split(manifest.Path)
+		imageName = strings.TrimSuffix(imageName, "/")
+		// the platform manifests of a manifest list are stored in the folder that is named by it's digest
+		if imageName == "" || strings.HasPrefix(tag, artifactoryDigestFolder) {
+			continue
+		}
+		if a.prefix != "" && imageName != a.prefix && !strings.HasPrefix(imageName, a.prefix+"/") {
+			continue
+		}
+
+		idx, ok := repoIndex[imageName]
+		if !ok {
+			log.Debug().Str("repo", imageName).Msg("processing")
+			idx = len(repositories)
+			repoIndex[imageName] = idx
+			repositories = append(repositories, Repository{Name: h.SlashJoin(a.host, a.repository, imageName)})
+		}
+		if repositories[idx].Digests, err = a.addDigest(ctx, repositories[idx].Digests, imageName, tag, manifest, folderSizes); err != nil {
+			return nil, err
+		}
+	}
+	return repositories, nil
+}
+
+// addDigest the created and uploaded time is the earliest of the tags and the pulled time is the latest download of them
+func (a *Artifactory) addDigest(ctx context.Context, digests []Digest, imageName, tag string, manifest ArtifactoryItem, folderSizes map[string]uint) ([]Digest, error) {
+	name := fmt.Sprintf("sha256:%s", manifest.SHA256)
+	created := manifest.Created.UTC()
+	var pulled time.Time
+	for _, stat := range manifest.Stats {
+		if stat.Downloaded.After(pulled) {
+			pulled = stat.Downloaded.UTC()
+		}
+	}
+
+	for idx := range digests {
+		if digests[idx].Name != name {
+			continue
+		}
+		digests[idx].Tag = append(digests[idx].Tag, tag)
+		if created.Before(digests[idx].Uploaded) {
+			digests[idx].Created = created
+			digests[idx].Uploaded = created
+		}
+		if pulled.After(digests[idx].PulledAt()) {
+			digests[idx].Pulled = pulledTime(pulled)
+		}
+		return digests, nil
+	}
+
+	size, err := a.size(ctx, imageName, manifest, folderSizes)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(digests, Digest{
+		Name:           name,
+		ImageSizeBytes: size,
+		Tag:            []string{tag},
+		Created:        created,
+		Uploaded:       created,
+		Pulled:         pulledTime(pulled),
+	}), nil
+}
+
+// size the total size of files in tag folder, the manifest list is only stored with it's tag folder so the size of
+// it's platform folders (sha256__<hex>) are added by fetching the content of manifest list
+func (a *Artifactory) size(ctx context.Context, imageName string, manifest ArtifactoryItem, folderSizes map[string]uint) (uint, error) {
+	size := folderSizes[manifest.Path]
+	if manifest.Name != artifactoryListManifest {
+		return size, nil
+	}
+
+	listURL := h.SlashJoin(a.apiURL, a.repository, manifest.Path, manifest.Name)
+	list, err := fetchManifest(ctx, a.hc, listURL, fmt.Sprintf("sha256:%s", manifest.SHA256))
+	if err != nil {
+		return 0, err
+	}
+	for _, child := range list.Manifests {
+		folder := artifactoryDigestFolder + strings.TrimPrefix(child.Digest, "sha256:")
+		size += folderSizes[path.Join(imageName, folder)]
+	}
+	return size, nil
+}
+
+// Delete by deleting the folder of all tags of the digest
+func (a *Artifactory) Delete(ctx context.Context, repository Repository) error {
+	imageName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/%s/", a.host, a.repository))
+	for _, digest := range repository.Digests {
+		if len(digest.Tag) == 0 {
+			return fmt.Errorf("untagged digest %s@%s cannot be deleted through artifactory api", repository.Name, digest.Name)
+		}
+
+		for _, tag := range digest.Tag {
+			tagURL := h.SlashJoin(a.apiURL, a.repository, imageName, url.PathEscape(tag))
+			log.Debug().Str("url", tagURL).Msg("deleting tag folder")
+			if err := deleteTag(ctx, a.hc, http.Request{Method: nethttp.MethodDelete, URL: tagURL}, &ArtifactoryError{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// items querying all files of the repository by AQL, it's sorted by the path so the result is stable across the pages
+func (a *Artifactory) items(ctx context.Context) ([]ArtifactoryItem, error) {
+	criteria := fmt.Sprintf(`{"repo":%q,"type":"file"}`, a.repository)
+	if a.prefix != "" {
+		criteria = fmt.Sprintf(`{"repo":%q,"type":"file","path":{"$match":%q}}`, a.repository, a.prefix+"/*")
+	}
+
+	var items []ArtifactoryItem
+	for offset := 0; ; offset += artifactoryPageSize {
+		query := fmt.Sprintf(
+			`items.find(%s).include("repo","path","name","size","sha256","created","modified","stat.downloaded","stat.downloads").sort({"$asc":["path","name"]}).offset(%d).limit(%d)`,
+			criteria, offset, artifactoryPageSize,
+		)
+		var obj ArtifactoryAQLResponse
+		r := http.Request{
+			Method:  nethttp.MethodPost,
+			URL:     h.SlashJoin(a.apiURL, "api/search/aql"),
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			Body:    query,
+		}
+		if _, err := callAPI(ctx, a.hc, r, &obj); err != nil {
+			return nil, err
+		}
+		items = append(items, obj.Results...)
+
+		if len(obj.Results) < artifactoryPageSize {
+			return items, nil
+		}
+	}
+}
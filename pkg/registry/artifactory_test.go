@@ -0,0 +1,175 @@
+package registry_test
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	artifactoryAPIURL  = "https://artifactory.company.io/artifactory"
+	artifactoryAQLURL  = hl.SlashJoin(artifactoryAPIURL, "api/search/aql")
+	artifactoryInclude = `.include("repo","path","name","size","sha256","created","modified","stat.downloaded","stat.downloads").sort({"$asc":["path","name"]}).offset(0).limit(1000)`
+	artifactoryDigests = []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+)
+
+// aqlRoute only replying the fixture if the query is matched
+func aqlRoute(query, loc string, statusCode int) mockRoute {
+	return func(r http.Request, obj any) (*http.Response, error) {
+		if r.Headers["Content-Type"] != "text/plain" || r.Body != query {
+			return nil, fmt.Errorf("unexpected aql query %v", r.Body)
+		}
+		return fixtureRoute(loc, statusCode, nil)(r, obj)
+	}
+}
+
+func TestArtifactory_Catalog(t *testing.T) {
+	appDigests := []reg.Digest{
+		// the size is the total files of tag folder, the earliest created and the latest download of the tags
+		{
+			Name:           artifactoryDigests[0],
+			ImageSizeBytes: 1083 + 1472 + 2811478,
+			Tag:            []string{"1.0.0", "latest"},
+			Created:        time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Pulled:         pulledAt(time.Date(2023, time.April, 1, 10, 0, 0, 0, time.UTC)),
+		},
+		// the size of manifest list is including it's platform folders that are listed in the repository
+		{
+			Name:           artifactoryDigests[1],
+			ImageSizeBytes: 743 + 1083,
+			Tag:            []string{"multi-arch"},
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+		},
+	}
+
+	testCases := map[string]struct {
+		host               string
+		route              mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"unauthorized": {
+			host:         "artifactory.company.io/docker-local",
+			route:        aqlRoute(`items.find({"repo":"docker-local","type":"file"})`+artifactoryInclude, "artifactory/error_unauthorized.json", nethttp.StatusUnauthorized),
+			expectErrMsg: "while querying items: [401] [Bad credentials]",
+		},
+		"grouping the tag folders and skipping the platform manifest": {
+			host:  "artifactory.company.io/docker-local",
+			route: aqlRoute(`items.find({"repo":"docker-local","type":"file"})`+artifactoryInclude, "artifactory/aql.json", nethttp.StatusOK),
+			expectRepositories: []reg.Repository{
+				{Name: "artifactory.company.io/docker-local/team-a/app", Digests: appDigests},
+				{Name: "artifactory.company.io/docker-local/team-b/web", Digests: []reg.Digest{{
+					Name:           artifactoryDigests[0],
+					ImageSizeBytes: 1083,
+					Tag:            []string{"v1"},
+					Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+					Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+				}}},
+			},
+		},
+		"only images under the prefix": {
+			host:  "artifactory.company.io/docker-local/team-a",
+			route: aqlRoute(`items.find({"repo":"docker-local","type":"file","path":{"$match":"team-a/*"}})`+artifactoryInclude, "artifactory/aql.json", nethttp.StatusOK),
+			expectRepositories: []reg.Repository{
+				{Name: "artifactory.company.io/docker-local/team-a/app", Digests: appDigests},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, map[string]mockRoute{
+				"POST " + artifactoryAQLURL: tc.route,
+				"GET " + hl.SlashJoin(artifactoryAPIURL, "docker-local/team-a/app/multi-arch/list.manifest.json"): fixtureRoute("generic/index.json", nethttp.StatusOK, nil),
+			})
+
+			artifactory, err := reg.NewArtifactory(tc.host, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := artifactory.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestArtifactory_Delete(t *testing.T) {
+	imageURL := hl.SlashJoin(artifactoryAPIURL, "docker-local/team-a/app")
+
+	testCases := map[string]struct {
+		digests        []reg.Digest
+		mockHTTPClient func(*mh.MockIHttpClient)
+		expectErrMsg   string
+	}{
+		"deleting folder of all tags": {
+			digests: []reg.Digest{{Name: artifactoryDigests[0], Tag: []string{"1.0.0", "latest"}}, {Name: artifactoryDigests[1], Tag: []string{"multi-arch"}}},
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(imageURL, "1.0.0")}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusNoContent}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(imageURL, "latest")}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusNoContent}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(imageURL, "multi-arch")}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusNoContent}, nil),
+				)
+			},
+		},
+		"tag folder is already deleted": {
+			digests: []reg.Digest{{Name: artifactoryDigests[0], Tag: []string{"latest"}}},
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, _ http.Request, obj *reg.ArtifactoryError) (*http.Response, error) {
+					obj.Errors = []reg.ArtifactoryErrorItem{{Status: 404, Message: "Could not locate artifact 'docker-local:team-a/app/latest'."}}
+					return &http.Response{StatusCode: nethttp.StatusNotFound}, nil
+				})
+			},
+		},
+		"untagged digest": {
+			digests:        []reg.Digest{{Name: artifactoryDigests[0]}},
+			mockHTTPClient: func(_ *mh.MockIHttpClient) {},
+			expectErrMsg:   "untagged digest artifactory.company.io/docker-local/team-a/app@sha256:1111111111111111111111111111111111111111111111111111111111111111 cannot be deleted through artifactory api",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			tc.mockHTTPClient(mHc)
+
+			artifactory, err := reg.NewArtifactory("artifactory.company.io/docker-local", mHc, reg.Option{})
+			assert.NoError(t, err)
+
+			err = artifactory.Delete(context.Background(), reg.Repository{Name: "artifactory.company.io/docker-local/team-a/app", Digests: tc.digests})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	ArtifactRegistryAPIURL   = "https://artifactregistry.googleapis.com/v1"
+	artifactRegistryPageSize = 1000
+	artifactRegistryHostTail = "-docker.pkg.dev"
+)
+
+type GoogleErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// GoogleError is the error response of google cloud apis
+type GoogleError struct {
+	Detail *GoogleErrorDetail `json:"error"`
+}
+
+func (e GoogleError) Err() error {
+	if e.Detail == nil {
+		return nil
+	}
+	return fmt.Errorf("[%s] [%s]", e.Detail.Status, e.Detail.Message)
+}
+
+type ArtifactRegistryDockerImage struct {
+	Name           string    `json:"name"`
+	URI            string    `json:"uri"`
+	Tags           []string  `json:"tags"`
+	ImageSizeBytes uint      `json:"imageSizeBytes,string"`
+	MediaType      string    `json:"mediaType"`
+	BuildTime      time.Time `json:"buildTime"`
+	UploadTime     time.Time `json:"uploadTime"`
+	UpdateTime     time.Time `json:"updateTime"`
+}
+
+type ArtifactRegistryDockerImagesResponse struct {
+	DockerImages  []ArtifactRegistryDockerImage `json:"dockerImages"`
+	NextPageToken string                        `json:"nextPageToken"`
+	GoogleError
+}
+
+// ArtifactRegistry is using the artifact registry rest api instead of the gcr compatible tags/list endpoint,
+// each of docker image in the api is a digest of the repository
+type ArtifactRegistry struct {
+	host       string
+	project    string
+	location   string
+	repository string
+	prefix     string
+	apiURL     string
+	hc         http.IHttpClient
+}
+
+// NewArtifactRegistry the host must be followed by project and repository, then it can be followed by image prefix,
+// eg: asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo or asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo/team-a
+func NewArtifactRegistry(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 4)
+	if len(hostSplt) < 3 {
+		return nil, fmt.Errorf("you must specified project and repository after registry host, eg: asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo")
+	}
+	if !strings.HasSuffix(hostSplt[0], artifactRegistryHostTail) {
+		return nil, fmt.Errorf("unknown location of artifact registry host %s, it must be <location>%s", hostSplt[0], artifactRegistryHostTail)
+	}
+
+	a := &ArtifactRegistry{
+		host:       hostSplt[0],
+		project:    hostSplt[1],
+		location:   strings.TrimSuffix(hostSplt[0], artifactRegistryHostTail),
+		repository: hostSplt[2],
+		apiURL:     ArtifactRegistryAPIURL,
+		hc:         hc,
+	}
+	if len(hostSplt) == 4 {
+		a.prefix = strings.Trim(hostSplt[3], "/")
+	}
+	if opt.Endpoint != "" {
+		a.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	return a, nil
+}
+
+// Catalog list all docker images of the repository then they are grouped by the image name,
+// the created time is taken from the build time (or upload time if it's not available). the manifest of index is fetched
+// from the registry api for linking it's platform manifests
+func (a *ArtifactRegistry) Catalog(ctx context.Context) ([]Repository, error) {
+	images, err := a.dockerImages(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing docker images")
+	}
+
+	var repositories []Repository
+	repoIndex := map[string]int{}
+	repoPrefix := h.SlashJoin(a.host, a.project, a.repository) + "/"
+	for _, image := range images {
+		uriSplt := strings.SplitN(image.URI, "@", 2)
+		if len(uriSplt) != 2 || !strings.HasPrefix(uriSplt[0], repoPrefix) {
+			log.Debug().Str("uri", image.URI).Msg("unknown docker image uri, skipping")
+			continue
+		}
+		imageName := strings.TrimPrefix(uriSplt[0], repoPrefix)
+		if a.prefix != "" && imageName != a.prefix && !strings.HasPrefix(imageName, a.prefix+"/") {
+			continue
+		}
+
+		idx, ok := repoIndex[imageName]
+		if !ok {
+			log.Debug().Str("repo", imageName).Msg("processing")
+			idx = len(repositories)
+			repoIndex[imageName] = idx
+			repositories = append(repositories, Repository{Name: uriSplt[0]})
+		}
+
+		created := image.BuildTime
+		if created.IsZero() {
+			created = image.UploadTime
+		}
+		repositories[idx].Digests = append(repositories[idx].Digests, Digest{
+			Name:           uriSplt[1],
+			ImageSizeBytes: image.ImageSizeBytes,
+			Tag:            image.Tags,
+			Created:        created.UTC(),
+			Uploaded:       image.UploadTime.UTC(),
+			MediaType:      image.MediaType,
+		})
+	}
+
+	// the platform manifests of index are listed as the untagged docker images
+	for idx := range repositories {
+		imagePath := strings.TrimPrefix(repositories[idx].Name, a.host+"/")
+		err = linkIndexManifests(repositories[idx].Digests, func(name string) (*ManifestResponse, error) {
+			return fetchManifest(ctx, a.hc, fmt.Sprintf("https://%s/v2/%s/manifests/%s", a.host, imagePath, name), name)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return repositories, nil
+}
+
+// Delete by deleting the package version (digest), the tags that are attached to it are deleted as well
+func (a *ArtifactRegistry) Delete(ctx context.Context, repository Repository) error {
+	imageName := strings.TrimPrefix(repository.Name, h.SlashJoin(a.host, a.project, a.repository)+"/")
+	for _, digest := range repository.Digests {
+		versionURL := fmt.Sprintf("%s?force=true", a.url("packages", url.PathEscape(imageName), "versions", digest.Name))
+		log.Debug().Str("url", versionURL).Msg("deleting version")
+		if _, err := callAPI(ctx, a.hc, http.Request{Method: nethttp.MethodDelete, URL: versionURL}, &GoogleError{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dockerImages list all docker images of the repository by following the next page token
+func (a *ArtifactRegistry) dockerImages(ctx context.Context) ([]ArtifactRegistryDockerImage, error) {
+	var images []ArtifactRegistryDockerImage
+	query := url.Values{"pageSize": {fmt.Sprint(artifactRegistryPageSize)}}
+	for {
+		var obj ArtifactRegistryDockerImagesResponse
+		if _, err := callAPI(ctx, a.hc, http.Request{Method: nethttp.MethodGet, URL: fmt.Sprintf("%s?%s", a.url("dockerImages"), query.Encode())}, &obj); err != nil {
+			return nil, err
+		}
+		images = append(images, obj.DockerImages...)
+
+		if obj.NextPageToken == "" {
+			return images, nil
+		}
+		query.Set("pageToken", obj.NextPageToken)
+	}
+}
+
+func (a *ArtifactRegistry) url(paths ...string) string {
+	return h.SlashJoin(append([]string{a.apiURL, "projects", a.project, "locations", a.location, "repositories", a.repository}, paths...)...)
+}
+
+// artifactRegistryOauthSource the artifact registry api requires the cloud platform scope
+func artifactRegistryOauthSource(saFilePath string) (oauth2.TokenSource, error) {
+	return googleOauthSource(saFilePath, "https://www.googleapis.com/auth/cloud-platform")
+}
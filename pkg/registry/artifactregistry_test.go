@@ -0,0 +1,228 @@
+package registry_test
+
+import (
+	"context"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	garRepoURL    = hl.SlashJoin(reg.ArtifactRegistryAPIURL, "projects/gcp-proj/locations/asia-southeast2/repositories/parent-repo")
+	garImagesURL  = hl.SlashJoin(garRepoURL, "dockerImages") + "?pageSize=1000"
+	garRepoPrefix = "asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo"
+	garDigests    = []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+)
+
+func TestNewArtifactRegistry(t *testing.T) {
+	testCases := map[string]struct {
+		host         string
+		expectErrMsg string
+	}{
+		"valid host": {
+			host: "asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo/team-a",
+		},
+		"without repository": {
+			host:         "asia-southeast2-docker.pkg.dev/gcp-proj",
+			expectErrMsg: "you must specified project and repository after registry host, eg: asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo",
+		},
+		"not a docker repository host": {
+			host:         "asia-southeast2-python.pkg.dev/gcp-proj/parent-repo",
+			expectErrMsg: "unknown location of artifact registry host asia-southeast2-python.pkg.dev, it must be <location>-docker.pkg.dev",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			_, err := reg.NewArtifactRegistry(tc.host, nil, reg.Option{})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestArtifactRegistry_Catalog(t *testing.T) {
+	appDigests := []reg.Digest{
+		// created is the build time
+		{
+			Name:           garDigests[0],
+			ImageSizeBytes: 3861526,
+			Tag:            []string{"latest", "v1.0.0"},
+			Created:        time.Date(2023, time.March, 10, 7, 15, 30, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 10, 8, 0, 0, 123456000, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+		},
+		// untagged index that has no build time
+		{
+			Name:           garDigests[1],
+			ImageSizeBytes: 1048576,
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeOCIIndex,
+			Platforms:      []string{"linux/amd64", "linux/arm64"},
+		},
+		// the platform image of index is linked by the index manifest in registry
+		{
+			Name:           multiArchChildren[0],
+			ImageSizeBytes: 1048576,
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeOCIManifest,
+			Platforms:      []string{"linux/amd64"},
+			ParentDigests:  []string{garDigests[1]},
+		},
+	}
+
+	successRoutes := func() map[string]mockRoute {
+		return map[string]mockRoute{
+			"GET " + garImagesURL: fixtureRoute("artifactregistry/docker_images.json", nethttp.StatusOK, nil),
+			"GET " + garImagesURL + "&pageToken=CiBwYWdlLTI": jsonRoute(reg.ArtifactRegistryDockerImagesResponse{
+				DockerImages: []reg.ArtifactRegistryDockerImage{{
+					URI:            garRepoPrefix + "/team-b/web@" + garDigests[0],
+					Tags:           []string{"v1"},
+					ImageSizeBytes: 3861526,
+					UploadTime:     time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+				}},
+			}, nil),
+			"GET " + hl.SlashJoin("https://asia-southeast2-docker.pkg.dev/v2/gcp-proj/parent-repo/team-a/app/manifests", garDigests[1]): fixtureRoute(
+				"generic/index.json", nethttp.StatusOK, nil,
+			),
+		}
+	}
+
+	testCases := map[string]struct {
+		host               string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"permission denied": {
+			host: garRepoPrefix,
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + garImagesURL: fixtureRoute("artifactregistry/error_permission.json", nethttp.StatusForbidden, nil),
+				}
+			},
+			expectErrMsg: "while listing docker images: [PERMISSION_DENIED] [Permission 'artifactregistry.repositories.listArtifacts' denied on resource '//artifactregistry.googleapis.com/projects/gcp-proj/locations/asia-southeast2/repositories/parent-repo' (or it may not exist).]",
+		},
+		"following the next page token": {
+			host:   garRepoPrefix,
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: garRepoPrefix + "/team-a/app", Digests: appDigests},
+				{Name: garRepoPrefix + "/team-b/web", Digests: []reg.Digest{{
+					Name:           garDigests[0],
+					ImageSizeBytes: 3861526,
+					Tag:            []string{"v1"},
+					Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+					Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+				}}},
+			},
+		},
+		"only images under the prefix": {
+			host:   garRepoPrefix + "/team-a",
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: garRepoPrefix + "/team-a/app", Digests: appDigests},
+			},
+		},
+		"failed while fetching index manifest": {
+			host: garRepoPrefix + "/team-a",
+			routes: func() map[string]mockRoute {
+				routes := successRoutes()
+				routes["GET "+hl.SlashJoin("https://asia-southeast2-docker.pkg.dev/v2/gcp-proj/parent-repo/team-a/app/manifests", garDigests[1])] = fixtureRoute(
+					"generic/error_manifest_unknown.json", nethttp.StatusNotFound, nil,
+				)
+				return routes
+			},
+			expectErrMsg: "while fetching manifest sha256:2222222222222222222222222222222222222222222222222222222222222222: [MANIFEST_UNKNOWN] [manifest unknown]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			gar, err := reg.NewArtifactRegistry(tc.host, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := gar.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestArtifactRegistry_Delete(t *testing.T) {
+	versionsURL := hl.SlashJoin(garRepoURL, "packages/team-a%2Fapp/versions")
+
+	testCases := map[string]struct {
+		mockHTTPClient func(*mh.MockIHttpClient)
+		expectErrMsg   string
+	}{
+		"deleting the versions by force": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(versionsURL, garDigests[0]) + "?force=true"}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusOK}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(versionsURL, garDigests[1]) + "?force=true"}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusOK}, nil),
+				)
+			},
+		},
+		"version is not found": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, _ http.Request, obj *reg.GoogleError) (*http.Response, error) {
+					obj.Detail = &reg.GoogleErrorDetail{Code: 404, Message: "Requested entity was not found.", Status: "NOT_FOUND"}
+					return &http.Response{StatusCode: nethttp.StatusNotFound}, nil
+				})
+			},
+			expectErrMsg: "[NOT_FOUND] [Requested entity was not found.]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			tc.mockHTTPClient(mHc)
+
+			gar, err := reg.NewArtifactRegistry(garRepoPrefix, mHc, reg.Option{})
+			assert.NoError(t, err)
+
+			err = gar.Delete(context.Background(), reg.Repository{
+				Name:    garRepoPrefix + "/team-a/app",
+				Digests: []reg.Digest{{Name: garDigests[0], Tag: []string{"latest"}}, {Name: garDigests[1]}},
+			})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	DockerHubAPIURL   = "https://hub.docker.com/v2"
+	dockerHubHost     = "docker.io"
+	dockerHubPageSize = 100
+)
+
+// DockerHubError is the error response of docker hub api, some endpoints are replying by detail and the others by message
+type DockerHubError struct {
+	Detail  string `json:"detail"`
+	Message string `json:"message"`
+}
+
+func (e DockerHubError) Err() error {
+	if e.Detail != "" {
+		return fmt.Errorf("[%s]", e.Detail)
+	}
+	if e.Message != "" {
+		return fmt.Errorf("[%s]", e.Message)
+	}
+	return nil
+}
+
+type DockerHubLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type DockerHubLoginResponse struct {
+	Token string `json:"token"`
+	DockerHubError
+}
+
+type DockerHubRepository struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type DockerHubRepositoriesResponse struct {
+	Next    string                `json:"next"`
+	Results []DockerHubRepository `json:"results"`
+	DockerHubError
+}
+
+type DockerHubImage struct {
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant"`
+	Digest       string    `json:"digest"`
+	Size         uint      `json:"size"`
+	LastPushed   time.Time `json:"last_pushed"`
+	LastPulled   time.Time `json:"last_pulled"`
+}
+
+type DockerHubTag struct {
+	Name          string           `json:"name"`
+	Digest        string           `json:"digest"`
+	MediaType     string           `json:"media_type"`
+	FullSize      uint             `json:"full_size"`
+	LastUpdated   time.Time        `json:"last_updated"`
+	TagLastPushed time.Time        `json:"tag_last_pushed"`
+	TagLastPulled time.Time        `json:"tag_last_pulled"`
+	Images        []DockerHubImage `json:"images"`
+}
+
+type DockerHubTagsResponse struct {
+	Next    string         `json:"next"`
+	Results []DockerHubTag `json:"results"`
+	DockerHubError
+}
+
+// DockerHub is using the hub api for listing the tags since it's providing the push and pull time,
+// the api is authenticated by jwt that is taken by logging in with the username and password (or personal access token).
+// the jwt is short-lived, so it's logged in again if the api is replying unauthorized
+type DockerHub struct {
+	host      string
+	namespace string
+	prefix    string
+	apiURL    string
+	username  string
+	password  string
+	hc        http.IHttpClient
+
+	mutex sync.Mutex
+	token string
+}
+
+// NewDockerHub the host must be followed by namespace (organization or user) and it can be followed by repository prefix,
+// eg: docker.io/my-org or docker.io/my-org/team-a
+func NewDockerHub(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 3)
+	if len(hostSplt) == 1 {
+		return nil, fmt.Errorf("you must specified namespace after registry host, eg: docker.io/my-org")
+	}
+
+	d := &DockerHub{
+		host:      dockerHubHost,
+		namespace: hostSplt[1],
+		apiURL:    DockerHubAPIURL,
+		username:  opt.Username,
+		password:  opt.Password,
+		hc:        hc,
+	}
+	if len(hostSplt) == 3 {
+		d.prefix = strings.Trim(hostSplt[2], "/")
+	}
+	if opt.Endpoint != "" {
+		d.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	return d, nil
+}
+
+// Catalog list all repositories of the namespace then it's tags, the tags are grouped by the digest
+func (d *DockerHub) Catalog(ctx context.Context) ([]Repository, error) {
+	if _, err := d.getToken(ctx, ""); err != nil {
+		return nil, err
+	}
+
+	var repoNames []string
+	pageURL := fmt.Sprintf("%s?page_size=%d", d.url(), dockerHubPageSize)
+	for pageURL != "" {
+		var obj DockerHubRepositoriesResponse
+		if err := dockerHubCall(ctx, d, http.Request{Method: nethttp.MethodGet, URL: pageURL}, &obj); err != nil {
+			return nil, errors.Wrap(err, "while listing repositories")
+		}
+		for _, repo := range obj.Results {
+			repoNames = append(repoNames, repo.Name)
+		}
+		pageURL = obj.Next
+	}
+
+	var repositories []Repository
+	for _, repoName := range repoNames {
+		if d.prefix != "" && repoName != d.prefix && !strings.HasPrefix(repoName, d.prefix+"/") {
+			continue
+		}
+
+		log.Debug().Str("repo", repoName).Msg("processing")
+		digests, err := d.digests(ctx, repoName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", repoName).Msg("not found any digests found, skipping")
+			continue
+		}
+		repositories = append(repositories, Repository{Name: h.SlashJoin(d.host, d.namespace, repoName), Digests: digests})
+	}
+	return repositories, nil
+}
+
+// digests the uploaded time is the earliest push of the tags and the pulled time is the latest pull of them,
+// for multi platform image the size is the sum of it's platform images
+func (d *DockerHub) digests(ctx context.Context, repoName string) ([]Digest, error) {
+	var tags []DockerHubTag
+	pageURL := fmt.Sprintf("%s?page_size=%d", d.url(repoName, "tags"), dockerHubPageSize)
+	for pageURL != "" {
+		var obj DockerHubTagsResponse
+		if err := dockerHubCall(ctx, d, http.Request{Method: nethttp.MethodGet, URL: pageURL}, &obj); err != nil {
+			return nil, errors.Wrapf(err, "while listing tags of %s", repoName)
+		}
+		tags = append(tags, obj.Results...)
+		pageURL = obj.Next
+	}
+
+	var digests []Digest
+	digestIndex := map[string]int{}
+	for _, tag := range tags {
+		name := tag.Digest
+		if name == "" && len(tag.Images) == 1 {
+			name = tag.Images[0].Digest
+		}
+		if name == "" {
+			log.Debug().Str("repo", repoName).Str("tag", tag.Name).Msg("no digest for tag, skipping")
+			continue
+		}
+
+		if idx, ok := digestIndex[name]; ok {
+			digests[idx].Tag = append(digests[idx].Tag, tag.Name)
+			if tag.TagLastPushed.Before(digests[idx].Uploaded) {
+				digests[idx].Created = tag.TagLastPushed
+				digests[idx].Uploaded = tag.TagLastPushed
+			}
+			if tag.TagLastPulled.After(digests[idx].PulledAt()) {
+				digests[idx].Pulled = pulledTime(tag.TagLastPulled)
+			}
+			continue
+		}
+
+		size := tag.FullSize
+		if len(tag.Images) > 1 {
+			size = 0
+			for _, image := range tag.Images {
+				size += image.Size
+			}
+		}
+		var platforms []string
+		for _, image := range tag.Images {
+			if platform := (Platform{Architecture: image.Architecture, OS: image.OS, Variant: image.Variant}).String(); platform != "" {
+				platforms = append(platforms, platform)
+			}
+		}
+
+		digestIndex[name] = len(digests)
+		digests = append(digests, Digest{
+			Name:           name,
+			ImageSizeBytes: size,
+			Tag:            []string{tag.Name},
+			Created:        tag.TagLastPushed,
+			Uploaded:       tag.TagLastPushed,
+			Pulled:         pulledTime(tag.TagLastPulled),
+			MediaType:      tag.MediaType,
+			Platforms:      platforms,
+		})
+	}
+	return digests, nil
+}
+
+// Delete by deleting all tags of the digest through hub api
+func (d *DockerHub) Delete(ctx context.Context, repository Repository) error {
+	if _, err := d.getToken(ctx, ""); err != nil {
+		return err
+	}
+
+	repoName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/%s/", d.host, d.namespace))
+	for _, digest := range repository.Digests {
+		if len(digest.Tag) == 0 {
+			return fmt.Errorf("untagged digest %s@%s cannot be deleted through docker hub api", repository.Name, digest.Name)
+		}
+
+		for _, tag := range digest.Tag {
+			tagURL := d.url(repoName, "tags", url.PathEscape(tag))
+			log.Debug().Str("url", tagURL).Msg("deleting tag")
+			var obj DockerHubError
+			r := http.Request{Method: nethttp.MethodDelete, URL: tagURL}
+			resp, err := dockerHubDo(ctx, d, r, &obj)
+			if err != nil {
+				return err
+			}
+			if err = checkDeletedTag(resp, r, &obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getToken the jwt that is logged in once and shared for the next calls. it's logged in again if the given expired one is
+// still the current jwt, so the concurrent calls that are unauthorized by the same jwt are logging in once
+func (d *DockerHub) getToken(ctx context.Context, expired string) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.token != "" && d.token != expired {
+		return d.token, nil
+	}
+
+	var obj DockerHubLoginResponse
+	loginReq := http.Request{
+		Method: nethttp.MethodPost,
+		URL:    fmt.Sprintf("%s/users/login", d.apiURL),
+		Body:   DockerHubLoginRequest{Username: d.username, Password: d.password},
+	}
+	if _, err := callAPI(ctx, d.hc, loginReq, &obj); err != nil {
+		return "", errors.Wrap(err, "while logging in to docker hub")
+	}
+	if obj.Token == "" {
+		return "", fmt.Errorf("no token returned from docker hub login")
+	}
+	d.token = obj.Token
+	return d.token, nil
+}
+
+// dockerHubDo calling the hub api by the jwt, the request is repeated once by the new jwt if it's replied unauthorized.
+// the response object is reset before it's repeated, so the error of the expired jwt is not left in it
+func dockerHubDo[T any, PT interface {
+	*T
+	errorResponse
+}](ctx context.Context, d *DockerHub, r http.Request, obj PT) (*http.Response, error) {
+	token, err := d.getToken(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		r.Headers = map[string]string{"Authorization": fmt.Sprintf("Bearer %s", token)}
+		resp, err := d.hc.Do(ctx, r, obj)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != nethttp.StatusUnauthorized || attempt != 0 {
+			return resp, nil
+		}
+
+		log.Debug().Str("url", r.URL).Msg("docker hub jwt is expired, logging in again")
+		if token, err = d.getToken(ctx, token); err != nil {
+			return nil, err
+		}
+		*obj = *new(T)
+	}
+}
+
+// dockerHubCall the same as callAPI for the hub api that is authenticated by jwt
+func dockerHubCall[T any, PT interface {
+	*T
+	errorResponse
+}](ctx context.Context, d *DockerHub, r http.Request, obj PT) error {
+	resp, err := dockerHubDo(ctx, d, r, obj)
+	if err != nil {
+		return err
+	}
+	_, err = checkResponse(resp, r, obj)
+	return err
+}
+
+func (d *DockerHub) url(paths ...string) string {
+	return h.SlashJoin(append([]string{d.apiURL, "namespaces", d.namespace, "repositories"}, paths...)...)
+}
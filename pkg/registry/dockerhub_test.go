@@ -0,0 +1,279 @@
+package registry_test
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	hubLoginURL = hl.SlashJoin(reg.DockerHubAPIURL, "users/login")
+	hubRepoURL  = hl.SlashJoin(reg.DockerHubAPIURL, "namespaces/my-org/repositories")
+	hubPageSize = "?page_size=100"
+	hubDigests  = []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+)
+
+// hubLoginRoute replying the jwt only if the credential is valid
+func hubLoginRoute(r http.Request, obj any) (*http.Response, error) {
+	if r.Body != (reg.DockerHubLoginRequest{Username: "my-org", Password: "dckr_pat_secret"}) {
+		return fixtureRoute("dockerhub/error_login.json", nethttp.StatusUnauthorized, nil)(r, obj)
+	}
+	return jsonRoute(reg.DockerHubLoginResponse{Token: "hub-jwt"}, nil)(r, obj)
+}
+
+// hubAuthorized only replying the route if it's called by the jwt from login
+func hubAuthorized(route mockRoute) mockRoute {
+	return func(r http.Request, obj any) (*http.Response, error) {
+		if r.Headers["Authorization"] != "Bearer hub-jwt" {
+			return nil, fmt.Errorf("unauthorized request %s %s", r.Method, r.URL)
+		}
+		return route(r, obj)
+	}
+}
+
+func TestDockerHub_Catalog(t *testing.T) {
+	expectedDigests := []reg.Digest{
+		// the size is the sum of platform images, the uploaded is the earliest push and pulled is the latest pull of the tags
+		{
+			Name:           hubDigests[0],
+			ImageSizeBytes: 3861526 + 3700000,
+			Tag:            []string{"latest", "v1.0.0"},
+			Created:        time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Pulled:         pulledAt(time.Date(2023, time.April, 1, 10, 0, 0, 0, time.UTC)),
+			MediaType:      reg.MediaTypeOCIIndex,
+			Platforms:      []string{"linux/amd64", "linux/arm64/v8"},
+		},
+		{
+			Name:           hubDigests[1],
+			ImageSizeBytes: 1048576,
+			Tag:            []string{"v0.9.0"},
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+			Platforms:      []string{"linux/amd64"},
+		},
+	}
+
+	successRoutes := map[string]mockRoute{
+		"POST " + hubLoginURL: hubLoginRoute,
+		"GET " + hubRepoURL + hubPageSize: hubAuthorized(jsonRoute(reg.DockerHubRepositoriesResponse{
+			Next:    hubRepoURL + "?page=2&page_size=100",
+			Results: []reg.DockerHubRepository{{Name: "app", Namespace: "my-org"}},
+		}, nil)),
+		"GET " + hubRepoURL + "?page=2&page_size=100": hubAuthorized(jsonRoute(reg.DockerHubRepositoriesResponse{
+			Results: []reg.DockerHubRepository{{Name: "web", Namespace: "my-org"}, {Name: "empty", Namespace: "my-org"}},
+		}, nil)),
+		"GET " + hl.SlashJoin(hubRepoURL, "app/tags") + hubPageSize: hubAuthorized(fixtureRoute("dockerhub/tags.json", nethttp.StatusOK, nil)),
+		"GET " + hl.SlashJoin(hubRepoURL, "web/tags") + hubPageSize: hubAuthorized(jsonRoute(reg.DockerHubTagsResponse{
+			Next: hl.SlashJoin(hubRepoURL, "web/tags") + "?page=2&page_size=100",
+		}, nil)),
+		"GET " + hl.SlashJoin(hubRepoURL, "web/tags") + "?page=2&page_size=100": hubAuthorized(fixtureRoute("dockerhub/tags.json", nethttp.StatusOK, nil)),
+		"GET " + hl.SlashJoin(hubRepoURL, "empty/tags") + hubPageSize:           hubAuthorized(jsonRoute(reg.DockerHubTagsResponse{}, nil)),
+	}
+
+	testCases := map[string]struct {
+		host               string
+		password           string
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"failed to login": {
+			host:         "docker.io/my-org",
+			password:     "wrong",
+			expectErrMsg: "while logging in to docker hub: [Incorrect authentication credentials.]",
+		},
+		"following pagination of repositories and tags": {
+			host:     "docker.io/my-org",
+			password: "dckr_pat_secret",
+			expectRepositories: []reg.Repository{
+				{Name: "docker.io/my-org/app", Digests: expectedDigests},
+				{Name: "docker.io/my-org/web", Digests: expectedDigests},
+			},
+		},
+		"only repositories under the prefix": {
+			host:     "docker.io/my-org/web",
+			password: "dckr_pat_secret",
+			expectRepositories: []reg.Repository{
+				{Name: "docker.io/my-org/web", Digests: expectedDigests},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, successRoutes)
+
+			hub, err := reg.NewDockerHub(tc.host, mHc, reg.Option{Username: "my-org", Password: tc.password})
+			assert.NoError(t, err)
+			repositories, err := hub.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDockerHub_Delete(t *testing.T) {
+	tagsURL := hl.SlashJoin(hubRepoURL, "app/tags")
+
+	testCases := map[string]struct {
+		digests      []reg.Digest
+		tagRoute     func(deleted *[]string) mockRoute
+		expectErrMsg string
+		expectDelete []string
+	}{
+		"deleting all tags of digest": {
+			digests: []reg.Digest{{Name: hubDigests[0], Tag: []string{"latest", "v1.0.0"}}, {Name: hubDigests[1], Tag: []string{"v0.9.0"}}},
+			tagRoute: func(deleted *[]string) mockRoute {
+				return hubAuthorized(func(r http.Request, _ any) (*http.Response, error) {
+					*deleted = append(*deleted, r.URL)
+					return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+				})
+			},
+			expectDelete: []string{hl.SlashJoin(tagsURL, "latest"), hl.SlashJoin(tagsURL, "v1.0.0"), hl.SlashJoin(tagsURL, "v0.9.0")},
+		},
+		"the tag is already deleted by the previous run": {
+			digests: []reg.Digest{{Name: hubDigests[0], Tag: []string{"latest", "v1.0.0"}}},
+			tagRoute: func(deleted *[]string) mockRoute {
+				return hubAuthorized(func(r http.Request, obj any) (*http.Response, error) {
+					if strings.HasSuffix(r.URL, "/latest") {
+						obj.(*reg.DockerHubError).Message = "tag not found"
+						return &http.Response{StatusCode: nethttp.StatusNotFound}, nil
+					}
+					*deleted = append(*deleted, r.URL)
+					return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+				})
+			},
+			expectDelete: []string{hl.SlashJoin(tagsURL, "v1.0.0")},
+		},
+		"deletion is forbidden": {
+			digests: []reg.Digest{{Name: hubDigests[0], Tag: []string{"latest"}}},
+			tagRoute: func(_ *[]string) mockRoute {
+				return hubAuthorized(func(_ http.Request, obj any) (*http.Response, error) {
+					obj.(*reg.DockerHubError).Message = "access to the resource is forbidden with personal access token"
+					return &http.Response{StatusCode: nethttp.StatusForbidden}, nil
+				})
+			},
+			expectErrMsg: "[access to the resource is forbidden with personal access token]",
+		},
+		"the jwt is expired in the middle of deletion": {
+			digests: []reg.Digest{{Name: hubDigests[0], Tag: []string{"latest", "v1.0.0"}}},
+			tagRoute: func(deleted *[]string) mockRoute {
+				return func(r http.Request, obj any) (*http.Response, error) {
+					// the first jwt is expired after the first tag is deleted
+					if r.Headers["Authorization"] == "Bearer hub-jwt" && len(*deleted) != 0 {
+						obj.(*reg.DockerHubError).Detail = "Token is expired"
+						return &http.Response{StatusCode: nethttp.StatusUnauthorized}, nil
+					}
+					*deleted = append(*deleted, r.URL+" "+r.Headers["Authorization"])
+					return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+				}
+			},
+			expectDelete: []string{hl.SlashJoin(tagsURL, "latest") + " Bearer hub-jwt", hl.SlashJoin(tagsURL, "v1.0.0") + " Bearer hub-jwt-2"},
+		},
+		"untagged digest": {
+			digests:      []reg.Digest{{Name: hubDigests[0]}},
+			expectErrMsg: "untagged digest docker.io/my-org/app@sha256:1111111111111111111111111111111111111111111111111111111111111111 cannot be deleted through docker hub api",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var deleted []string
+			// the next login is replying the new jwt
+			logins := 0
+			routes := map[string]mockRoute{"POST " + hubLoginURL: func(r http.Request, obj any) (*http.Response, error) {
+				if logins++; logins > 1 {
+					return jsonRoute(reg.DockerHubLoginResponse{Token: fmt.Sprintf("hub-jwt-%d", logins)}, nil)(r, obj)
+				}
+				return hubLoginRoute(r, obj)
+			}}
+			if tc.tagRoute != nil {
+				for _, tag := range []string{"latest", "v1.0.0", "v0.9.0"} {
+					routes["DELETE "+hl.SlashJoin(tagsURL, tag)] = tc.tagRoute(&deleted)
+				}
+			}
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, routes)
+
+			hub, err := reg.NewDockerHub("docker.io/my-org", mHc, reg.Option{Username: "my-org", Password: "dckr_pat_secret"})
+			assert.NoError(t, err)
+
+			err = hub.Delete(context.Background(), reg.Repository{Name: "docker.io/my-org/app", Digests: tc.digests})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectDelete, deleted)
+			}
+		})
+	}
+}
+
+func TestDockerHub_DeleteParallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the first jwt is expired, all of the workers that are unauthorized by it are sharing the next login
+	var logins, deleted int32
+	tagsURL := hl.SlashJoin(hubRepoURL, "app/tags")
+	routes := map[string]mockRoute{"POST " + hubLoginURL: func(r http.Request, obj any) (*http.Response, error) {
+		return jsonRoute(reg.DockerHubLoginResponse{Token: fmt.Sprintf("hub-jwt-%d", atomic.AddInt32(&logins, 1))}, nil)(r, obj)
+	}}
+	tags := []string{"v1", "v2", "v3", "v4", "v5", "v6", "v7", "v8"}
+	for _, tag := range tags {
+		routes["DELETE "+hl.SlashJoin(tagsURL, tag)] = func(r http.Request, _ any) (*http.Response, error) {
+			if r.Headers["Authorization"] == "Bearer hub-jwt-1" {
+				return &http.Response{StatusCode: nethttp.StatusUnauthorized}, nil
+			}
+			atomic.AddInt32(&deleted, 1)
+			return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+		}
+	}
+	mHc := mh.NewMockIHttpClient(ctrl)
+	mockRoutes(mHc, routes)
+
+	hub, err := reg.NewDockerHub("docker.io/my-org", mHc, reg.Option{Username: "my-org", Password: "dckr_pat_secret"})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for idx, tag := range tags {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			digest := reg.Digest{Name: fmt.Sprintf("sha256:%064d", idx), Tag: []string{tag}}
+			assert.NoError(t, hub.Delete(context.Background(), reg.Repository{Name: "docker.io/my-org/app", Digests: []reg.Digest{digest}}))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&logins))
+	assert.Equal(t, int32(len(tags)), atomic.LoadInt32(&deleted))
+}
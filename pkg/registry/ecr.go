@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+// ecrMaxDeleteImages max of image ids in one BatchDeleteImage call
+const ecrMaxDeleteImages = 100
+
+// reEcrHost capturing the registry (account) id and region, eg: 123456789012.dkr.ecr.us-east-1.amazonaws.com
+var reEcrHost = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ECR is the image registry that using amazon ecr api, the requests are signed by SigV4 with the credential
+// from environment variables or shared config (~/.aws), so the http client is not used. the retry, rate limit
+// and insecure options are applied to the aws sdk's client instead
+type ECR struct {
+	host       string
+	registryID string
+	prefix     string
+	client     *ecr.Client
+}
+
+// NewECR the host can be followed by path for limiting the repositories, eg: 123456789012.dkr.ecr.us-east-1.amazonaws.com/team-a.
+// the api endpoint can be changed by option, eg: for testing against a local stand-in
+func NewECR(host string, _ http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 2)
+	matched := reEcrHost.FindStringSubmatch(hostSplt[0])
+	if len(matched) != 3 {
+		return nil, fmt.Errorf("invalid ecr host %s, eg: 123456789012.dkr.ecr.us-east-1.amazonaws.com", hostSplt[0])
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(matched[2]), config.WithRetryer(ecrRetryer(opt.Retry)))
+	if err != nil {
+		return nil, errors.Wrap(err, "while loading aws config")
+	}
+	awsCfg.HTTPClient = ecrHTTPClient(awsCfg.HTTPClient, opt)
+
+	e := &ECR{host: hostSplt[0], registryID: matched[1]}
+	if len(hostSplt) == 2 {
+		e.prefix = strings.Trim(hostSplt[1], "/")
+	}
+	e.client = ecr.NewFromConfig(awsCfg, func(o *ecr.Options) {
+		if opt.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opt.Endpoint)
+		}
+	})
+	return e, nil
+}
+
+// ecrHTTPClient applying the insecure and rate limit options to the aws sdk's http client,
+// it's wrapped after the config is loaded so the ca bundle from aws config is kept
+func ecrHTTPClient(client aws.HTTPClient, opt Option) aws.HTTPClient {
+	if buildable, ok := client.(*awshttp.BuildableClient); ok && opt.AllowInsecure {
+		client = buildable.WithTransportOptions(func(tr *nethttp.Transport) {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+			//nolint:gosec
+			tr.TLSClientConfig.InsecureSkipVerify = true
+		})
+	}
+	return http.NewRateLimitedDoer(opt.RateLimit, client)
+}
+
+// ecrRetryer the aws sdk's standard retryer with the retry options, the retry status codes are added
+// to the errors that are retried by aws sdk (eg: throttling)
+func ecrRetryer(o http.RetryOption) func() aws.Retryer {
+	return func() aws.Retryer {
+		return retry.NewStandard(func(so *retry.StandardOptions) {
+			so.MaxAttempts = max(o.MaxAttempts, 1)
+			if o.MaxBackoff > 0 {
+				so.MaxBackoff = o.MaxBackoff
+			}
+			so.Backoff = retry.BackoffDelayerFunc(func(attempt int, _ error) (time.Duration, error) {
+				return o.Delay(attempt), nil
+			})
+			codes := map[int]struct{}{}
+			for _, code := range o.RetryStatusCodes() {
+				codes[code] = struct{}{}
+			}
+			so.Retryables = append(so.Retryables, retry.RetryableHTTPStatusCode{Codes: codes})
+		})
+	}
+}
+
+// Catalog list repositories through DescribeRepositories then it's images through DescribeImages,
+// the manifest of index is fetched through BatchGetImage for linking it's platform manifests
+func (e ECR) Catalog(ctx context.Context) ([]Repository, error) {
+	var repoNames []string
+	repoPaginator := ecr.NewDescribeRepositoriesPaginator(e.client, &ecr.DescribeRepositoriesInput{RegistryId: aws.String(e.registryID)})
+	for repoPaginator.HasMorePages() {
+		page, err := repoPaginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "while describing repositories")
+		}
+		for _, repo := range page.Repositories {
+			repoNames = append(repoNames, aws.ToString(repo.RepositoryName))
+		}
+	}
+
+	var repositories []Repository
+	for _, repoName := range repoNames {
+		if e.prefix != "" && repoName != e.prefix && !strings.HasPrefix(repoName, e.prefix+"/") {
+			continue
+		}
+
+		log.Debug().Str("repo", repoName).Msg("processing")
+		digests, err := e.digests(ctx, repoName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", repoName).Msg("not found any digests found, skipping")
+			continue
+		}
+		repositories = append(repositories, Repository{Name: h.SlashJoin(e.host, repoName), Digests: digests})
+	}
+	return repositories, nil
+}
+
+// Delete by image digest in batch, the tags that are referring to it will be gone as well
+func (e ECR) Delete(ctx context.Context, repository Repository) error {
+	repoName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/", e.host))
+	for start := 0; start < len(repository.Digests); start += ecrMaxDeleteImages {
+		end := min(start+ecrMaxDeleteImages, len(repository.Digests))
+		imageIDs := make([]types.ImageIdentifier, 0, end-start)
+		for _, digest := range repository.Digests[start:end] {
+			imageIDs = append(imageIDs, types.ImageIdentifier{ImageDigest: aws.String(digest.Name)})
+		}
+
+		log.Debug().Str("repo", repoName).Int("total_digest", len(imageIDs)).Msg("deleting images")
+		output, err := e.client.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{
+			RegistryId:     aws.String(e.registryID),
+			RepositoryName: aws.String(repoName),
+			ImageIds:       imageIDs,
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(output.Failures) != 0 {
+			failure := output.Failures[0]
+			return fmt.Errorf("[%s] [%s]", failure.FailureCode, aws.ToString(failure.FailureReason))
+		}
+	}
+	return nil
+}
+
+// digests mapping the image details, there is no created time in ecr so it's following the pushed time
+func (e ECR) digests(ctx context.Context, repoName string) ([]Digest, error) {
+	var digests []Digest
+	imagePaginator := ecr.NewDescribeImagesPaginator(e.client, &ecr.DescribeImagesInput{
+		RegistryId:     aws.String(e.registryID),
+		RepositoryName: aws.String(repoName),
+	})
+	for imagePaginator.HasMorePages() {
+		page, err := imagePaginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while describing images of %s", repoName)
+		}
+
+		for _, image := range page.ImageDetails {
+			pushedAt := aws.ToTime(image.ImagePushedAt)
+			digests = append(digests, Digest{
+				Name:           aws.ToString(image.ImageDigest),
+				ImageSizeBytes: uint(aws.ToInt64(image.ImageSizeInBytes)), //nolint:gosec
+				Tag:            image.ImageTags,
+				Created:        pushedAt,
+				Uploaded:       pushedAt,
+				Pulled:         pulledTime(aws.ToTime(image.LastRecordedPullTime)),
+				MediaType:      aws.ToString(image.ImageManifestMediaType),
+			})
+		}
+	}
+
+	// the platform manifests of index are described as the untagged images
+	err := linkIndexManifests(digests, func(name string) (*ManifestResponse, error) {
+		return e.manifest(ctx, repoName, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// manifest fetching the manifest content of index through BatchGetImage
+func (e ECR) manifest(ctx context.Context, repoName, name string) (*ManifestResponse, error) {
+	output, err := e.client.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+		RegistryId:         aws.String(e.registryID),
+		RepositoryName:     aws.String(repoName),
+		ImageIds:           []types.ImageIdentifier{{ImageDigest: aws.String(name)}},
+		AcceptedMediaTypes: []string{MediaTypeDockerManifestList, MediaTypeOCIIndex},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching manifest %s", name)
+	}
+
+	if len(output.Failures) != 0 {
+		failure := output.Failures[0]
+		return nil, fmt.Errorf("while fetching manifest %s: [%s] [%s]", name, failure.FailureCode, aws.ToString(failure.FailureReason))
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("while fetching manifest %s: not found", name)
+	}
+
+	var manifest ManifestResponse
+	if err = json.Unmarshal([]byte(aws.ToString(output.Images[0].ImageManifest)), &manifest); err != nil {
+		return nil, errors.Wrapf(err, "while parsing manifest %s", name)
+	}
+	return &manifest, nil
+}
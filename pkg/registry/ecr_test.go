@@ -0,0 +1,305 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ecrHost         = "123456789012.dkr.ecr.us-east-1.amazonaws.com"
+	ecrTargetPrefix = "AmazonEC2ContainerRegistry_V20150921."
+)
+
+type ecrCall struct {
+	Operation      string
+	RegistryID     string `json:"registryId"`
+	RepositoryName string `json:"repositoryName"`
+	NextToken      string `json:"nextToken"`
+	ImageIds       []struct {
+		ImageDigest string `json:"imageDigest"`
+	} `json:"imageIds"`
+	AcceptedMediaTypes []string `json:"acceptedMediaTypes"`
+}
+
+// ecrStandIn is the local ecr api, handler is replying by operation name and the decoded request body
+type ecrStandIn struct {
+	mutex sync.Mutex
+	calls []ecrCall
+}
+
+func newEcrStandIn(t *testing.T, handler func(call ecrCall) (int, []byte)) (*ecrStandIn, string) {
+	return newEcrStandInServer(t, false, handler)
+}
+
+// newEcrStandInServer the local ecr api is served over tls with self signed certificate if it's set
+func newEcrStandInServer(t *testing.T, useTLS bool, handler func(call ecrCall) (int, []byte)) (*ecrStandIn, string) {
+	// the credential is taken from env, make sure the one in host is not used
+	awsDir := t.TempDir()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(awsDir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(awsDir, "credentials"))
+	t.Setenv("AWS_MAX_ATTEMPTS", "1")
+
+	standIn := &ecrStandIn{}
+	ts := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") || !strings.Contains(auth, "/us-east-1/ecr/aws4_request") {
+			w.WriteHeader(nethttp.StatusForbidden)
+			_, _ = w.Write([]byte(`{"__type":"UnrecognizedClientException","message":"invalid signature"}`))
+			return
+		}
+
+		var call ecrCall
+		_ = json.NewDecoder(r.Body).Decode(&call)
+		call.Operation = strings.TrimPrefix(r.Header.Get("X-Amz-Target"), ecrTargetPrefix)
+		standIn.mutex.Lock()
+		standIn.calls = append(standIn.calls, call)
+		standIn.mutex.Unlock()
+
+		statusCode, body := handler(call)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(body)
+	}))
+	if useTLS {
+		ts.StartTLS()
+	} else {
+		ts.Start()
+	}
+	t.Cleanup(ts.Close)
+	return standIn, ts.URL
+}
+
+func TestNewECR(t *testing.T) {
+	_, err := reg.NewECR("registry.local/team-a", nil, reg.Option{})
+	assert.EqualError(t, err, "invalid ecr host registry.local, eg: 123456789012.dkr.ecr.us-east-1.amazonaws.com")
+}
+
+func TestECR_Catalog(t *testing.T) {
+	expectedDigests := []reg.Digest{
+		{
+			Name:           "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			ImageSizeBytes: 3861526,
+			Tag:            []string{"latest", "v1.0.0"},
+			Created:        time.Date(2023, time.March, 10, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 10, 8, 0, 0, 0, time.UTC),
+			Pulled:         pulledAt(time.Date(2023, time.April, 1, 10, 30, 0, 500000000, time.UTC)),
+			MediaType:      reg.MediaTypeDockerManifest,
+		},
+		{
+			Name:           "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			ImageSizeBytes: 7723052,
+			Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeOCIIndex,
+			Platforms:      []string{"linux/amd64", "linux/arm64"},
+		},
+		// the platform image is linked by the index manifest
+		{
+			Name:           multiArchChildren[0],
+			ImageSizeBytes: 3861526,
+			Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeOCIManifest,
+			Platforms:      []string{"linux/amd64"},
+			ParentDigests:  []string{multiArchIndex},
+		},
+	}
+	indexImages, _ := json.Marshal(map[string]any{
+		"images": []map[string]any{{
+			"imageId":                map[string]string{"imageDigest": multiArchIndex},
+			"imageManifest":          string(readFixture("generic/index.json")),
+			"imageManifestMediaType": reg.MediaTypeOCIIndex,
+		}},
+	})
+
+	handler := func(call ecrCall) (int, []byte) {
+		switch {
+		case call.Operation == "DescribeRepositories" && call.NextToken == "":
+			return nethttp.StatusOK, []byte(`{"repositories":[{"repositoryName":"team-a/app"}],"nextToken":"page-2"}`)
+		case call.Operation == "DescribeRepositories" && call.NextToken == "page-2":
+			return nethttp.StatusOK, []byte(`{"repositories":[{"repositoryName":"team-a/empty"},{"repositoryName":"team-b/web"},{"repositoryName":"team-x/app"}]}`)
+		case call.Operation == "DescribeImages" && call.RepositoryName == "team-a/empty":
+			return nethttp.StatusOK, []byte(`{"imageDetails":[]}`)
+		case call.Operation == "DescribeImages" && call.RepositoryName == "team-x/app":
+			return nethttp.StatusBadRequest, readFixture("ecr/error_repository_not_found.json")
+		case call.Operation == "DescribeImages":
+			return nethttp.StatusOK, readFixture("ecr/describe_images.json")
+		case call.Operation == "BatchGetImage" && len(call.ImageIds) == 1 && call.ImageIds[0].ImageDigest == multiArchIndex:
+			return nethttp.StatusOK, indexImages
+		}
+		return nethttp.StatusBadRequest, []byte(`{"__type":"InvalidParameterException","message":"unexpected call"}`)
+	}
+
+	testCases := map[string]struct {
+		host               string
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"only repositories under the prefix": {
+			host: ecrHost + "/team-a",
+			expectRepositories: []reg.Repository{
+				{Name: ecrHost + "/team-a/app", Digests: expectedDigests},
+			},
+		},
+		"following pagination and error in describing images": {
+			host:         ecrHost,
+			expectErrMsg: "while describing images of team-x/app: operation error ECR: DescribeImages",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			standIn, endpoint := newEcrStandIn(t, handler)
+			ecr, err := reg.NewECR(tc.host, nil, reg.Option{Endpoint: endpoint})
+			assert.NoError(t, err)
+
+			repositories, err := ecr.Catalog(context.Background())
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.ErrorContains(t, err, tc.expectErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			for _, call := range standIn.calls {
+				assert.Equal(t, "123456789012", call.RegistryID)
+				if call.Operation == "BatchGetImage" {
+					assert.Equal(t, []string{reg.MediaTypeDockerManifestList, reg.MediaTypeOCIIndex}, call.AcceptedMediaTypes)
+				}
+			}
+		})
+	}
+}
+
+func TestECR_ClientOption(t *testing.T) {
+	testCases := map[string]struct {
+		useTLS        bool
+		option        reg.Option
+		failureStatus int
+		failures      int
+		expectErrMsg  string
+		expectCalls   int
+	}{
+		"retrying the transient error": {
+			option:      reg.Option{Retry: http.RetryOption{MaxAttempts: 3, Backoff: time.Millisecond}},
+			failures:    2,
+			expectCalls: 3,
+		},
+		"retry is disabled": {
+			option:       reg.Option{Retry: http.RetryOption{MaxAttempts: 1, Backoff: time.Millisecond}},
+			failures:     1,
+			expectErrMsg: "StatusCode: 429",
+			expectCalls:  1,
+		},
+		"the status code is retried additionally": {
+			option:        reg.Option{Retry: http.RetryOption{MaxAttempts: 3, Backoff: time.Millisecond, StatusCodes: []int{nethttp.StatusConflict}}},
+			failureStatus: nethttp.StatusConflict,
+			failures:      1,
+			expectCalls:   2,
+		},
+		"the status code that is not retried": {
+			option:        reg.Option{Retry: http.RetryOption{MaxAttempts: 3, Backoff: time.Millisecond}},
+			failureStatus: nethttp.StatusConflict,
+			failures:      1,
+			expectErrMsg:  "StatusCode: 409",
+			expectCalls:   1,
+		},
+		"allowing insecure tls": {
+			useTLS:      true,
+			option:      reg.Option{AllowInsecure: true},
+			expectCalls: 1,
+		},
+		"the self signed certificate is rejected": {
+			useTLS:       true,
+			expectErrMsg: "certificate",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			failures := tc.failures
+			standIn, endpoint := newEcrStandInServer(t, tc.useTLS, func(call ecrCall) (int, []byte) {
+				if failures > 0 {
+					failures--
+					if tc.failureStatus == nethttp.StatusConflict {
+						return tc.failureStatus, []byte(`{"__type":"RepositoryPolicyNotFoundException","message":"conflict"}`)
+					}
+					return nethttp.StatusTooManyRequests, []byte(`{"__type":"LimitExceededException","message":"slow down"}`)
+				}
+				return nethttp.StatusOK, []byte(`{"repositories":[]}`)
+			})
+			tc.option.Endpoint = endpoint
+			ecr, err := reg.NewECR(ecrHost, nil, tc.option)
+			assert.NoError(t, err)
+
+			_, err = ecr.Catalog(context.Background())
+			if tc.expectErrMsg != "" {
+				assert.ErrorContains(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, standIn.calls, tc.expectCalls)
+		})
+	}
+}
+
+func TestECR_Delete(t *testing.T) {
+	var digests []reg.Digest
+	for i := 0; i < 150; i++ {
+		digests = append(digests, reg.Digest{Name: fmt.Sprintf("sha256:%064d", i)})
+	}
+	sampleRepo := reg.Repository{Name: ecrHost + "/team-a/app", Digests: digests}
+
+	testCases := map[string]struct {
+		failure          string
+		expectErrMsg     string
+		expectBatchSizes []int
+	}{
+		"deleting in batch of 100": {
+			expectBatchSizes: []int{100, 50},
+		},
+		"image is failed to be deleted": {
+			failure:          `{"failureCode":"ImageReferencedByManifestList","failureReason":"Requested image referenced by manifest list","imageId":{"imageDigest":"sha256:0"}}`,
+			expectErrMsg:     "[ImageReferencedByManifestList] [Requested image referenced by manifest list]",
+			expectBatchSizes: []int{100},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			standIn, endpoint := newEcrStandIn(t, func(call ecrCall) (int, []byte) {
+				return nethttp.StatusOK, []byte(fmt.Sprintf(`{"imageIds":[],"failures":[%s]}`, tc.failure))
+			})
+			ecr, err := reg.NewECR(ecrHost, nil, reg.Option{Endpoint: endpoint})
+			assert.NoError(t, err)
+
+			err = ecr.Delete(context.Background(), sampleRepo)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			var batchSizes []int
+			for _, call := range standIn.calls {
+				assert.Equal(t, "BatchDeleteImage", call.Operation)
+				assert.Equal(t, "team-a/app", call.RepositoryName)
+				batchSizes = append(batchSizes, len(call.ImageIds))
+			}
+			assert.Equal(t, tc.expectBatchSizes, batchSizes)
+		})
+	}
+}
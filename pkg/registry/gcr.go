@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -36,54 +38,116 @@ type GCRDigest struct {
 }
 
 type GCR struct {
-	host         string
-	project      string
-	hc           http.IHttpClient
-	repositories []Repository
+	host        string
+	project     string
+	hc          http.IHttpClient
+	workerCount int
 }
 
-func NewGCR(host string, hc http.IHttpClient) (ImageRegistry, error) {
+func NewGCR(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
 	hostSplt := strings.Split(host, "/")
 	if len(hostSplt) == 1 {
 		return nil, fmt.Errorf("you must specified parent repository after registry host, eg; asia.gcr.io/parent_repo")
 	}
-	return &GCR{host: hostSplt[0], project: strings.Join(hostSplt[1:], "/"), hc: hc}, nil
+	workerCount := opt.WorkerCount
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+	return &GCR{host: hostSplt[0], project: strings.Join(hostSplt[1:], "/"), hc: hc, workerCount: workerCount}, nil
+}
+
+// gcrNode is the result of a repository and it's children, the result is flattened after all of them are fetched
+// so the order is the same as walking it one by one (children first then the parent)
+type gcrNode struct {
+	repository *Repository
+	children   []*gcrNode
+}
+
+func (n *gcrNode) flatten(repositories []Repository) []Repository {
+	for _, child := range n.children {
+		repositories = child.flatten(repositories)
+	}
+	if n.repository != nil {
+		repositories = append(repositories, *n.repository)
+	}
+	return repositories
+}
+
+// gcrCrawler fetching the child repositories concurrently, the concurrent requests are limited by worker count
+type gcrCrawler struct {
+	gcr    GCR
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+	slots  chan struct{}
+}
+
+func (c *gcrCrawler) crawl(repository string, node *gcrNode) {
+	defer c.wg.Done()
+	if c.ctx.Err() != nil {
+		return
+	}
+	select {
+	case c.slots <- struct{}{}:
+	case <-c.ctx.Done():
+		return
+	}
+	repo, children, err := c.gcr.tagList(c.ctx, repository)
+	<-c.slots
+	if err != nil {
+		// stop the others, the first error is the one that is returned
+		c.cancel(err)
+		return
+	}
+
+	node.repository = repo
+	node.children = make([]*gcrNode, len(children))
+	for idx, child := range children {
+		node.children[idx] = &gcrNode{}
+		c.wg.Add(1)
+		go c.crawl(fmt.Sprintf("%s/%s", repository, child), node.children[idx])
+	}
 }
 
 // Catalog list of repositorry, recursively follow child attribute
-// instead using image registry API spec so we can only use less permission in service account
-func (g GCR) Catalog() ([]Repository, error) {
-	if err := g.tagList(g.project); err != nil {
+// instead using image registry API spec so we can only use less permission in service account.
+// the children are fetched in parallel by the worker count
+func (g GCR) Catalog(ctx context.Context) ([]Repository, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	crawler := &gcrCrawler{gcr: g, ctx: ctx, cancel: cancel, slots: make(chan struct{}, g.workerCount)}
+	root := &gcrNode{}
+	crawler.wg.Add(1)
+	go crawler.crawl(g.project, root)
+	crawler.wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
 		return nil, err
 	}
-	return g.repositories, nil
+	return root.flatten(nil), nil
 }
 
-func (g *GCR) tagList(repository string) (err error) {
+// tagList fetching the digests of repository and it's child names, the repository is nil if it has no digest
+func (g GCR) tagList(ctx context.Context, repository string) (*Repository, []string, error) {
 	url := fmt.Sprintf("https://%s/v2/%s/tags/list", g.host, repository)
 	var jsonBody GCRTagsResponse
-	if err = g.hc.GetMarshalReturnObj(url, &jsonBody); err != nil {
-		return err
+	if err := g.hc.GetMarshalReturnObj(ctx, url, &jsonBody); err != nil {
+		return nil, nil, err
 	}
 
-	if len(jsonBody.Errors) > 0 {
-		return fmt.Errorf("[%s] [%s]", jsonBody.Errors[0].Code, jsonBody.Errors[0].Message)
+	if err := jsonBody.Err(); err != nil {
+		return nil, nil, err
 	}
 
 	log.Debug().Str("repo", repository).Msg("processing")
 	if len(jsonBody.Child) != 0 {
 		log.Debug().Msgf("detected %d child(s) in repository %s", len(jsonBody.Child), repository)
-		for _, child := range jsonBody.Child {
-			nextRepo := fmt.Sprintf("%s/%s", repository, child)
-			if err = g.tagList(nextRepo); err != nil {
-				return err
-			}
-		}
 	}
 	// ignore if it's doesn't has any manifest attached
 	if len(jsonBody.Manifest) == 0 {
 		log.Debug().Str("repo", repository).Msg("not found any digests found, skipping")
-		return nil
+		return nil, jsonBody.Child, nil
 	}
 
 	//nolint:prealloc
@@ -91,17 +155,17 @@ func (g *GCR) tagList(repository string) (err error) {
 	for name, gdigest := range jsonBody.Manifest {
 		sizeByte, err := strconv.ParseUint(gdigest.ImageSizeBytes, 10, 64)
 		if err != nil {
-			return errors.Wrap(err, "while converting image size")
+			return nil, nil, errors.Wrap(err, "while converting image size")
 		}
 
 		timeCreated, err := h.ConvertTimeStrToUnix(gdigest.TimeCreatedMs)
 		if err != nil {
-			return errors.Wrap(err, "while parse created time")
+			return nil, nil, errors.Wrap(err, "while parse created time")
 		}
 
 		timeUploaded, err := h.ConvertTimeStrToUnix(gdigest.TimeUploadedMs)
 		if err != nil {
-			return errors.Wrap(err, "while parse uploaded time")
+			return nil, nil, errors.Wrap(err, "while parse uploaded time")
 		}
 
 		digest = append(digest, Digest{
@@ -110,15 +174,28 @@ func (g *GCR) tagList(repository string) (err error) {
 			Tag:            gdigest.Tag,
 			Created:        timeCreated,
 			Uploaded:       timeUploaded,
+			MediaType:      gdigest.MediaType,
 		})
 	}
+	// the manifest is a map, so it's sorted for having the same result for each listing
+	sort.Slice(digest, func(i, j int) bool { return digest[i].Name < digest[j].Name })
+
+	// the platform manifests of index are listed as the untagged digests, so they are linked to the index
+	err := linkIndexManifests(digest, func(name string) (*ManifestResponse, error) { return g.manifest(ctx, repository, name) })
+	if err != nil {
+		return nil, nil, err
+	}
 	// name will be combination between host and repo path
 	normalizedRepoName := fmt.Sprintf("%s/%s", g.host, repository)
-	g.repositories = append(g.repositories, Repository{Name: normalizedRepoName, Digests: digest})
-	return nil
+	return &Repository{Name: normalizedRepoName, Digests: digest}, jsonBody.Child, nil
 }
 
-func (g GCR) Delete(repository Repository) (err error) {
+// manifest fetching the manifest content of digest, the tags list is only providing it's media type
+func (g GCR) manifest(ctx context.Context, repository, name string) (*ManifestResponse, error) {
+	return fetchManifest(ctx, g.hc, fmt.Sprintf("https://%s/v2/%s/manifests/%s", g.host, repository, name), name)
+}
+
+func (g GCR) Delete(ctx context.Context, repository Repository) (err error) {
 	shortRepoName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/", g.host))
 	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests", g.host, shortRepoName)
 	for idr := range repository.Digests {
@@ -127,14 +204,14 @@ func (g GCR) Delete(repository Repository) (err error) {
 		for idt := range digest.Tag {
 			tagURL := fmt.Sprintf("%s/%s", manifestURL, digest.Tag[idt])
 			log.Debug().Str("url", tagURL).Msg("deleting tag")
-			if err = deleteImage(g.hc, tagURL); err != nil {
+			if err = deleteImageTag(ctx, g.hc, tagURL); err != nil {
 				return err
 			}
 		}
 
 		digestURL := fmt.Sprintf("%s/%s", manifestURL, digest.Name)
 		log.Debug().Str("url", digestURL).Msg("deleting digest")
-		if err = deleteImage(g.hc, digestURL); err != nil {
+		if err = deleteImage(ctx, g.hc, digestURL); err != nil {
 			return err
 		}
 	}
@@ -145,7 +222,11 @@ func (g GCR) Delete(repository Repository) (err error) {
 
 // gcrOauthSource generate token source from google library, supporting DAC (default application credential)
 func gcrOauthSource(saFilePath string) (oauth2.TokenSource, error) {
-	scope := "https://www.googleapis.com/auth/devstorage.read_write"
+	return googleOauthSource(saFilePath, "https://www.googleapis.com/auth/devstorage.read_write")
+}
+
+// googleOauthSource the token source is taken from service account file if it's set, otherwise from DAC
+func googleOauthSource(saFilePath, scope string) (oauth2.TokenSource, error) {
 	if saFilePath != "" {
 		saData, err := os.ReadFile(filepath.Clean(saFilePath))
 		if err != nil {
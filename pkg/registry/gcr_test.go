@@ -1,10 +1,14 @@
 package registry_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	nethttp "net/http"
 	"os"
 	"path"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,6 +35,11 @@ func readFixture(loc string) []byte {
 	return data
 }
 
+// pulledAt the pull time of digest
+func pulledAt(t time.Time) *time.Time {
+	return &t
+}
+
 func readGCRResponseFixture[t any](loc string) t {
 	var obj t
 	data := readFixture(hl.SlashJoin("gcr", loc))
@@ -61,7 +70,7 @@ func TestNewGCR(t *testing.T) {
 
 	for title, tc := range testCases {
 		t.Run(title, func(t *testing.T) {
-			imgReg, err := reg.NewGCR(tc.host, nil)
+			imgReg, err := reg.NewGCR(tc.host, nil, reg.Option{})
 			if tc.expectedErr {
 				assert.Nil(t, imgReg)
 				assert.Error(t, err)
@@ -79,6 +88,7 @@ func TestGCR_Catalog(t *testing.T) {
 	parentTagListURL := hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, "tags", "list")
 	paretRepoResp := readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_parent.json")
 	sub1RepoResp := readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_sub1.json")
+	indexDigest := "sha256:2222222222222222222222222222222222222222222222222222222222222222"
 
 	testCases := map[string]struct {
 		mockHTTPClient     func(*mh.MockIHttpClient)
@@ -87,14 +97,14 @@ func TestGCR_Catalog(t *testing.T) {
 	}{
 		"error while get tags from parent repository": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).Return(fmt.Errorf("an error while fetching catalog"))
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).Return(fmt.Errorf("an error while fetching catalog"))
 			},
 			expectErrMsg:       "an error while fetching catalog",
 			expectRepositories: nil,
 		},
 		"error in response body": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					jbody.Errors = []reg.ErrorField{
 						{
 							Code:    "UNKNOWN",
@@ -110,20 +120,20 @@ func TestGCR_Catalog(t *testing.T) {
 		"got an error while access child repo": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
 				childRepo := "child1"
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					jbody.Child = []string{childRepo}
 					return nil
 				})
 
 				childURL := hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, childRepo, "tags", "list")
-				m.EXPECT().GetMarshalReturnObj(childURL, gomock.Any()).Times(1).Return(fmt.Errorf("an error in accessing child repo"))
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), childURL, gomock.Any()).Times(1).Return(fmt.Errorf("an error in accessing child repo"))
 			},
 			expectRepositories: nil,
 			expectErrMsg:       "an error in accessing child repo",
 		},
 		"got invalid created timestamp": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_parent_invalid_created_time.json")
 					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_parent_invalid_created_time.json")
 					return nil
@@ -134,7 +144,7 @@ func TestGCR_Catalog(t *testing.T) {
 		},
 		"got invalid uploaded timestamp": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_parent_invalid_uploaded_time.json")
 					return nil
 				})
@@ -144,7 +154,7 @@ func TestGCR_Catalog(t *testing.T) {
 		},
 		"invalid image size byte": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_parent_invalid_byte_size.json")
 					return nil
 				})
@@ -156,27 +166,27 @@ func TestGCR_Catalog(t *testing.T) {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
 				childRepo1 := "sub1"
 				childRepo2 := "sub2"
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = paretRepoResp
 					jbody.Child = []string{childRepo1, childRepo2}
 					return nil
 				})
 
 				childURL := hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, childRepo1, "tags", "list")
-				m.EXPECT().GetMarshalReturnObj(childURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), childURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = sub1RepoResp
 					return nil
 				})
 
 				// no digest but has several child
 				childURL2 := hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, childRepo2)
-				m.EXPECT().GetMarshalReturnObj(hl.SlashJoin(childURL2, "tags", "list"), gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), hl.SlashJoin(childURL2, "tags", "list"), gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("empty_repo_with_child.json")
 					return nil
 				})
 
-				m.EXPECT().GetMarshalReturnObj(hl.SlashJoin(childURL2, "cronjob-image", "tags", "list"), gomock.Any()).Times(1).Return(nil)
-				m.EXPECT().GetMarshalReturnObj(hl.SlashJoin(childURL2, "job-script", "tags", "list"), gomock.Any()).Times(1).Return(nil)
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), hl.SlashJoin(childURL2, "cronjob-image", "tags", "list"), gomock.Any()).Times(1).Return(nil)
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), hl.SlashJoin(childURL2, "job-script", "tags", "list"), gomock.Any()).Times(1).Return(nil)
 			},
 			expectRepositories: []reg.Repository{
 				{
@@ -188,6 +198,7 @@ func TestGCR_Catalog(t *testing.T) {
 							Tag:            []string{"latest", "abc"},
 							Created:        time.Unix(1585800237411/1000, 0).UTC(),
 							Uploaded:       time.Unix(1585800278141/1000, 0).UTC(),
+							MediaType:      reg.MediaTypeDockerManifest,
 						},
 					},
 				},
@@ -200,47 +211,184 @@ func TestGCR_Catalog(t *testing.T) {
 							Tag:            []string{"latest", "release-20210624-150000"},
 							Created:        time.Unix(1624518709150/1000, 0).UTC(),
 							Uploaded:       time.Unix(1624518770462/1000, 0).UTC(),
+							MediaType:      reg.MediaTypeDockerManifest,
+						},
+					},
+				},
+			},
+		},
+		"linking the platform manifests to it's index": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
+					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_multi_arch.json")
+					return nil
+				})
+				mockRoutes(m, map[string]mockRoute{
+					"GET " + hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, "manifests", indexDigest): fixtureRoute("generic/index.json", nethttp.StatusOK, nil),
+				})
+			},
+			expectRepositories: []reg.Repository{
+				{
+					Name: "asia.gcr.io/parent",
+					Digests: []reg.Digest{
+						{
+							Name:      indexDigest,
+							Tag:       []string{"latest"},
+							Created:   time.Unix(1678432530000/1000, 0).UTC(),
+							Uploaded:  time.Unix(1678435200000/1000, 0).UTC(),
+							MediaType: reg.MediaTypeOCIIndex,
+							Platforms: []string{"linux/amd64", "linux/arm64"},
+						},
+						{
+							Name:           "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+							ImageSizeBytes: 3861526,
+							Tag:            []string{},
+							Created:        time.Unix(1675238400000/1000, 0).UTC(),
+							Uploaded:       time.Unix(1675238400000/1000, 0).UTC(),
+							MediaType:      reg.MediaTypeDockerManifest,
+						},
+						{
+							Name:           "sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11",
+							ImageSizeBytes: 3861526,
+							Tag:            []string{},
+							Created:        time.Unix(1678432530000/1000, 0).UTC(),
+							Uploaded:       time.Unix(1678435200000/1000, 0).UTC(),
+							MediaType:      reg.MediaTypeOCIManifest,
+							Platforms:      []string{"linux/amd64"},
+							ParentDigests:  []string{indexDigest},
+						},
+						{
+							Name:           "sha256:bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22",
+							ImageSizeBytes: 3861526,
+							Tag:            []string{},
+							Created:        time.Unix(1678432530000/1000, 0).UTC(),
+							Uploaded:       time.Unix(1678435200000/1000, 0).UTC(),
+							MediaType:      reg.MediaTypeOCIManifest,
+							Platforms:      []string{"linux/arm64"},
+							ParentDigests:  []string{indexDigest},
 						},
 					},
 				},
 			},
 		},
+		"got an error while fetching the index manifest": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
+					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("tag_list_multi_arch.json")
+					return nil
+				})
+				mockRoutes(m, map[string]mockRoute{
+					"GET " + hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, "manifests", indexDigest): fixtureRoute("generic/error_manifest_unknown.json", nethttp.StatusNotFound, nil),
+				})
+			},
+			expectErrMsg: "while fetching manifest " + indexDigest + ": [MANIFEST_UNKNOWN] [manifest unknown]",
+		},
 		"empty repository will not be marked as result": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
-				m.EXPECT().GetMarshalReturnObj(parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(url string, jbody *reg.GCRTagsResponse) error {
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), parentTagListURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
 					*jbody = readGCRResponseFixture[reg.GCRTagsResponse]("empty_repo_with_child.json")
 					return nil
 				})
 
 				child1Url := hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, "cronjob-image", "tags", "list")
-				m.EXPECT().GetMarshalReturnObj(child1Url, gomock.Any()).Times(1).Return(nil)
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), child1Url, gomock.Any()).Times(1).Return(nil)
 
 				child2Url := hl.SlashJoin(gcrHostHTTPS, "v2", parentRepo, "job-script", "tags", "list")
-				m.EXPECT().GetMarshalReturnObj(child2Url, gomock.Any()).Times(1).Return(nil)
+				m.EXPECT().GetMarshalReturnObj(gomock.Any(), child2Url, gomock.Any()).Times(1).Return(nil)
 			},
 			expectRepositories: nil,
 		},
 	}
 
 	for title, tc := range testCases {
-		t.Run(title, func(t *testing.T) {
-			ctrl := gomock.NewController(t)
-			defer ctrl.Finish()
+		// the result must be the same for sequential and parallel listing
+		for _, workerCount := range []int{1, 4} {
+			t.Run(fmt.Sprintf("%s with %d worker(s)", title, workerCount), func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
 
-			mHc := mh.NewMockIHttpClient(ctrl)
-			tc.mockHTTPClient(mHc)
+				mHc := mh.NewMockIHttpClient(ctrl)
+				tc.mockHTTPClient(mHc)
 
-			gcr, _ := reg.NewGCR(hostWithParentRepo, mHc)
-			repositories, err := gcr.Catalog()
+				gcr, _ := reg.NewGCR(hostWithParentRepo, mHc, reg.Option{WorkerCount: workerCount})
+				repositories, err := gcr.Catalog(context.Background())
 
-			assert.Equal(t, tc.expectRepositories, repositories)
-			if tc.expectErrMsg != "" {
-				assert.EqualError(t, err, tc.expectErrMsg)
-			} else {
-				assert.NoError(t, err)
+				assert.Equal(t, tc.expectRepositories, repositories)
+				if tc.expectErrMsg != "" {
+					assert.EqualError(t, err, tc.expectErrMsg)
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		}
+	}
+}
+
+func TestGCR_CatalogParallel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// parent has 3 children and each of them has 3 grandchildren, every repository has a digest
+	var running, maxRunning int32
+	mHc := mh.NewMockIHttpClient(ctrl)
+	mHc.EXPECT().GetMarshalReturnObj(gomock.Any(), gomock.Any(), gomock.Any()).Times(13).
+		DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				prev := atomic.LoadInt32(&maxRunning)
+				if current <= prev || atomic.CompareAndSwapInt32(&maxRunning, prev, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			repo := strings.TrimSuffix(strings.TrimPrefix(url, gcrHostHTTPS+"/v2/"), "/tags/list")
+			if strings.Count(repo, "/") < 2 {
+				jbody.Child = []string{"c", "a", "b"}
+			}
+			jbody.Manifest = map[string]reg.GCRDigest{
+				"sha256:" + repo: {ImageSizeBytes: "10", TimeCreatedMs: "1585800237411", TimeUploadedMs: "1585800278141"},
 			}
+			return nil
 		})
+
+	gcr, _ := reg.NewGCR(hl.SlashJoin(gcrHost, "parent"), mHc, reg.Option{WorkerCount: 3})
+	repositories, err := gcr.Catalog(context.Background())
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(3))
+	assert.Greater(t, atomic.LoadInt32(&maxRunning), int32(1))
+
+	// following the children order then the parent
+	var names []string
+	for _, repo := range repositories {
+		names = append(names, strings.TrimPrefix(repo.Name, gcrHost+"/"))
 	}
+	assert.Equal(t, []string{
+		"parent/c/c", "parent/c/a", "parent/c/b", "parent/c",
+		"parent/a/c", "parent/a/a", "parent/a/b", "parent/a",
+		"parent/b/c", "parent/b/a", "parent/b/b", "parent/b",
+		"parent",
+	}, names)
+}
+
+func TestGCR_CatalogCanceled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mHc := mh.NewMockIHttpClient(ctrl)
+	mHc.EXPECT().GetMarshalReturnObj(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).
+		DoAndReturn(func(_ context.Context, url string, jbody *reg.GCRTagsResponse) error {
+			jbody.Child = []string{"a", "b"}
+			cancel()
+			return nil
+		})
+
+	gcr, _ := reg.NewGCR(hl.SlashJoin(gcrHost, "parent"), mHc, reg.Option{WorkerCount: 1})
+	repositories, err := gcr.Catalog(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, repositories)
 }
 
 func TestGCR_Delete(t *testing.T) {
@@ -267,9 +415,9 @@ func TestGCR_Delete(t *testing.T) {
 				manifestURL := hl.SlashJoin(gcrHostHTTPS, "v2", "parent", "sub1", "manifests")
 				// expecting call in order since the tags will be deleted first before it's digest
 				gomock.InOrder(
-					m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).Return(nil),
-					m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(1).Return(nil),
-					m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(1).Return(nil),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).Return(nil),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(1).Return(nil),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(1).Return(nil),
 				)
 			},
 			repository: sampleRepo,
@@ -277,9 +425,9 @@ func TestGCR_Delete(t *testing.T) {
 		"an error while deleting tag": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
 				manifestURL := hl.SlashJoin(gcrHostHTTPS, "v2", "parent", "sub1", "manifests")
-				m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).Return(fmt.Errorf("an error while deleting tag"))
-				m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(0)
-				m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(0)
+				m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).Return(fmt.Errorf("an error while deleting tag"))
+				m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(0)
+				m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(0)
 			},
 			repository:   sampleRepo,
 			expectErrMsg: "an error while deleting tag",
@@ -288,18 +436,32 @@ func TestGCR_Delete(t *testing.T) {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
 				manifestURL := hl.SlashJoin(gcrHostHTTPS, "v2", "parent", "sub1", "manifests")
 				gomock.InOrder(
-					m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).Return(nil),
-					m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(1).Return(nil),
-					m.EXPECT().DeleteMarshalReturnObj(hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(1).Return(fmt.Errorf("an error in manifest deletion")),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).Return(nil),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(1).Return(nil),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(1).Return(fmt.Errorf("an error in manifest deletion")),
 				)
 			},
 			repository:   sampleRepo,
 			expectErrMsg: "an error in manifest deletion",
 		},
+		"the tag is already deleted by the previous run": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				manifestURL := hl.SlashJoin(gcrHostHTTPS, "v2", "parent", "sub1", "manifests")
+				gomock.InOrder(
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "latest"), gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, errFields *reg.ErrorsField) error {
+						errFields.Errors = []reg.ErrorField{{Code: "MANIFEST_UNKNOWN", Message: "Failed to fetch \"latest\""}}
+						return nil
+					}),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "abc"), gomock.Any()).Times(1).Return(nil),
+					m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), hl.SlashJoin(manifestURL, "sha256:C05ce64163cd2327d364933df75aa4850af425b6cbaec2f6af3b31e5246be0e2"), gomock.Any()).Times(1).Return(nil),
+				)
+			},
+			repository: sampleRepo,
+		},
 		"error from delete response": {
 			mockHTTPClient: func(m *mh.MockIHttpClient) {
 				latestTagURL := hl.SlashJoin(gcrHostHTTPS, "v2", "parent", "sub1", "manifests", "latest")
-				m.EXPECT().DeleteMarshalReturnObj(latestTagURL, gomock.Any()).Times(1).DoAndReturn(func(url string, errFields *reg.ErrorsField) error {
+				m.EXPECT().DeleteMarshalReturnObj(gomock.Any(), latestTagURL, gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, url string, errFields *reg.ErrorsField) error {
 					*errFields = readGCRResponseFixture[reg.ErrorsField]("error_delete_manifest.json")
 					return nil
 				})
@@ -317,10 +479,10 @@ func TestGCR_Delete(t *testing.T) {
 			mHc := mh.NewMockIHttpClient(ctrl)
 			tc.mockHTTPClient(mHc)
 
-			gcr, err := reg.NewGCR(hl.SlashJoin(gcrHost, "parent"), mHc)
+			gcr, err := reg.NewGCR(hl.SlashJoin(gcrHost, "parent"), mHc, reg.Option{})
 			assert.NoError(t, err)
 
-			err = gcr.Delete(tc.repository)
+			err = gcr.Delete(context.Background(), tc.repository)
 			if tc.expectErrMsg != "" {
 				assert.EqualError(t, err, tc.expectErrMsg)
 			} else {
@@ -0,0 +1,520 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+
+	headerContentDigest = "Docker-Content-Digest"
+	headerLink          = "Link"
+)
+
+var (
+	reLinkNext      = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+	referrersAccept = map[string]string{"Accept": MediaTypeOCIIndex}
+	manifestAccept  = map[string]string{
+		"Accept": strings.Join([]string{
+			MediaTypeDockerManifest, MediaTypeDockerManifestList, MediaTypeOCIManifest, MediaTypeOCIIndex,
+		}, ", "),
+	}
+)
+
+type errorResponse interface {
+	Err() error
+}
+
+type CatalogResponse struct {
+	Repositories []string `json:"repositories"`
+	ErrorsField
+}
+
+type TagListResponse struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+	ErrorsField
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String formatted as os/arch[/variant], it's empty if the platform is unknown
+func (p Platform) String() string {
+	if p.OS == "" || p.Architecture == "" {
+		return ""
+	}
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         uint              `json:"size"`
+	Platform     *Platform         `json:"platform,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+type ManifestResponse struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	ArtifactType  string       `json:"artifactType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+	Manifests     []Descriptor `json:"manifests"`
+	Subject       *Descriptor  `json:"subject"`
+	ErrorsField
+}
+
+// IsIndex the manifest is either docker's manifest list or oci image index
+func (m ManifestResponse) IsIndex() bool {
+	return m.MediaType == MediaTypeDockerManifestList || m.MediaType == MediaTypeOCIIndex || len(m.Manifests) != 0
+}
+
+// GetArtifactType the artifact type of manifest, it's following the config media type if it's not set for the artifact
+// that has subject. the ordinary image has no artifact type
+func (m ManifestResponse) GetArtifactType() string {
+	if m.ArtifactType != "" || m.Subject == nil {
+		return m.ArtifactType
+	}
+	return m.Config.MediaType
+}
+
+// Platforms of the child manifests that are known
+func (m ManifestResponse) Platforms() []string {
+	var platforms []string
+	for _, child := range m.Manifests {
+		if child.Platform == nil {
+			continue
+		}
+		if platform := child.Platform.String(); platform != "" {
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+type ImageConfigResponse struct {
+	Created      time.Time `json:"created"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Variant      string    `json:"variant"`
+	ErrorsField
+}
+
+// Platforms the platform of image in list, it's empty if it's unknown
+func (c ImageConfigResponse) Platforms() []string {
+	if platform := (Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant}).String(); platform != "" {
+		return []string{platform}
+	}
+	return nil
+}
+
+// Generic is the image registry that only relies on the distribution spec (docker registry v2 API),
+// so it can be used for any of spec compliant registry such as registry:2, zot, etc.
+type Generic struct {
+	host   string
+	prefix string
+	// baseURL the scheme and host of registry api, it's https by default
+	baseURL string
+	hc      http.IHttpClient
+}
+
+// NewGeneric the host can be followed by path for limiting the repositories in catalog, eg: registry.local:5000/team-a.
+// the endpoint option is replacing the scheme and host of registry api, eg: http://registry.local:5000 for plain http registry
+func NewGeneric(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(host, "/", 2)
+	g := &Generic{host: hostSplt[0], baseURL: fmt.Sprintf("https://%s", hostSplt[0]), hc: hc}
+	if len(hostSplt) == 2 {
+		g.prefix = strings.Trim(hostSplt[1], "/")
+	}
+	if opt.Endpoint != "" {
+		g.baseURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	return g, nil
+}
+
+// Catalog list of repository through _catalog endpoint then resolving each of it's tags to the digest
+func (g Generic) Catalog(ctx context.Context) ([]Repository, error) {
+	var repoNames []string
+	err := g.paginate(ctx, g.url("_catalog"), func() errorResponse { return &CatalogResponse{} }, func(obj errorResponse) {
+		repoNames = append(repoNames, obj.(*CatalogResponse).Repositories...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:prealloc
+	var repositories []Repository
+	for _, repoName := range repoNames {
+		if g.prefix != "" && repoName != g.prefix && !strings.HasPrefix(repoName, g.prefix+"/") {
+			continue
+		}
+
+		log.Debug().Str("repo", repoName).Msg("processing")
+		digests, err := g.digests(ctx, repoName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", repoName).Msg("not found any digests found, skipping")
+			continue
+		}
+		repositories = append(repositories, Repository{Name: h.SlashJoin(g.host, repoName), Digests: digests})
+	}
+	return repositories, nil
+}
+
+// Delete by manifest digest, the tags that are referring to it will be gone as well
+func (g Generic) Delete(ctx context.Context, repository Repository) (err error) {
+	shortRepoName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/", g.host))
+	for idr := range repository.Digests {
+		digestURL := g.url(shortRepoName, "manifests", repository.Digests[idr].Name)
+		log.Debug().Str("url", digestURL).Msg("deleting digest")
+		if _, err = g.call(ctx, nethttp.MethodDelete, digestURL, nil, &ErrorsField{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// digests resolving all tags in repository to it's digest, the tags that are pointing to the same digest will be grouped.
+// the platform manifest that is tagged on it's own is linked to the listed index that is referencing it
+func (g Generic) digests(ctx context.Context, repoName string) ([]Digest, error) {
+	var tags []string
+	err := g.paginate(ctx, g.url(repoName, "tags", "list"), func() errorResponse { return &TagListResponse{} }, func(obj errorResponse) {
+		tags = append(tags, obj.(*TagListResponse).Tags...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []Digest
+	digestIndex := map[string]int{}
+	children := map[string][]Descriptor{}
+	for _, tag := range tags {
+		resp, err := g.call(ctx, nethttp.MethodHead, g.url(repoName, "manifests", tag), manifestAccept, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while resolving tag %s:%s", repoName, tag)
+		}
+
+		name := resp.Header.Get(headerContentDigest)
+		if name == "" {
+			return nil, fmt.Errorf("no digest returned for tag %s:%s", repoName, tag)
+		}
+
+		if idx, ok := digestIndex[name]; ok {
+			digests[idx].Tag = append(digests[idx].Tag, tag)
+			continue
+		}
+
+		digest, manifests, err := g.describe(ctx, repoName, name)
+		if err != nil {
+			return nil, err
+		}
+		children[name] = manifests
+		digest.Tag = []string{tag}
+		digestIndex[name] = len(digests)
+		digests = append(digests, digest)
+	}
+
+	// the referrers are mostly untagged, so they are discovered by their subject. the discovered referrer is queried as well
+	// since it can be referred by the other artifact (eg: signature of sbom)
+	for idx := 0; idx < len(digests); idx++ {
+		referrers, supported, err := g.referrers(ctx, repoName, digests[idx].Name)
+		if err != nil {
+			return nil, err
+		}
+		if !supported {
+			log.Debug().Str("repo", repoName).Msg("referrers api is not supported, following the tag schema")
+			break
+		}
+
+		for _, referrer := range referrers {
+			if _, ok := digestIndex[referrer.Digest]; ok {
+				continue
+			}
+			digest, manifests, err := g.describe(ctx, repoName, referrer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			children[referrer.Digest] = manifests
+			digest.Subject = digests[idx].Name
+			if digest.ArtifactType == "" {
+				digest.ArtifactType = referrer.ArtifactType
+			}
+			if digest.Created.IsZero() {
+				digest.Created = referrerCreated(referrer.Annotations)
+				digest.Uploaded = digest.Created
+			}
+			digestIndex[referrer.Digest] = len(digests)
+			digests = append(digests, digest)
+		}
+	}
+	linkIndexChildren(digests, children)
+	return digests, nil
+}
+
+// referrers listing the artifacts that are referring to the digest through oci referrers api,
+// it's not supported by the registry if it's replying not found
+func (g Generic) referrers(ctx context.Context, repoName, name string) ([]Descriptor, bool, error) {
+	var referrers []Descriptor
+	pageURL := g.url(repoName, "referrers", name)
+	for pageURL != "" {
+		var index ManifestResponse
+		resp, err := g.hc.Do(ctx, http.Request{Method: nethttp.MethodGet, URL: pageURL, Headers: referrersAccept}, &index)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "while listing referrers of %s", name)
+		}
+		if resp.StatusCode == nethttp.StatusNotFound {
+			return nil, false, nil
+		}
+		if err = index.Err(); err != nil {
+			return nil, false, errors.Wrapf(err, "while listing referrers of %s", name)
+		}
+		if resp.StatusCode >= nethttp.StatusBadRequest {
+			return nil, false, fmt.Errorf("got status code %d while listing referrers of %s", resp.StatusCode, name)
+		}
+		referrers = append(referrers, index.Manifests...)
+		pageURL = nextLink(g.baseURL, resp)
+	}
+	return referrers, true, nil
+}
+
+// describe fill the digest size and created time from it's manifest and config blob,
+// for index the size is the sum of it's child and the created time is the latest one of them.
+// the uploaded time is not available in distribution spec so it's following the created time. the child manifests of index are returned
+// for linking them to the index
+func (g Generic) describe(ctx context.Context, repoName, name string) (digest Digest, children []Descriptor, err error) {
+	var manifest ManifestResponse
+	resp, err := g.call(ctx, nethttp.MethodGet, g.url(repoName, "manifests", name), manifestAccept, &manifest)
+	if err != nil {
+		return digest, nil, errors.Wrapf(err, "while fetching manifest %s", name)
+	}
+
+	digest.Name = name
+	digest.MediaType = manifest.MediaType
+	// the media type is optional in oci manifest, so it's taken from the response
+	if digest.MediaType == "" {
+		digest.MediaType = resp.Header.Get("Content-Type")
+	}
+	digest.ArtifactType = manifest.GetArtifactType()
+	if manifest.Subject != nil {
+		digest.Subject = manifest.Subject.Digest
+	}
+	if manifest.IsIndex() {
+		digest.Platforms = manifest.Platforms()
+		for _, child := range manifest.Manifests {
+			childDigest, _, err := g.describe(ctx, repoName, child.Digest)
+			if err != nil {
+				return digest, nil, err
+			}
+			digest.ImageSizeBytes += childDigest.ImageSizeBytes
+			if childDigest.Created.After(digest.Created) {
+				digest.Created = childDigest.Created
+			}
+		}
+		digest.Uploaded = digest.Created
+		return digest, manifest.Manifests, nil
+	}
+
+	digest.ImageSizeBytes = manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		digest.ImageSizeBytes += layer.Size
+	}
+
+	var config ImageConfigResponse
+	if _, err = g.call(ctx, nethttp.MethodGet, g.url(repoName, "blobs", manifest.Config.Digest), nil, &config); err != nil {
+		return digest, nil, errors.Wrapf(err, "while fetching config blob %s", manifest.Config.Digest)
+	}
+	digest.Created = config.Created
+	digest.Uploaded = config.Created
+	digest.Platforms = config.Platforms()
+	return digest, nil, nil
+}
+
+// size only calculating the image size from it's manifest, it's for the registry that the dates are taken from other api
+func (g Generic) size(ctx context.Context, repoName, name string) (uint, error) {
+	size, _, err := g.sizedManifest(ctx, repoName, name)
+	return size, err
+}
+
+// sizedManifest the image size with it's manifest, it's for the registry that is not providing the media type in it's api
+func (g Generic) sizedManifest(ctx context.Context, repoName, name string) (uint, *ManifestResponse, error) {
+	manifestURL := func(reference string) string { return g.url(repoName, "manifests", reference) }
+	manifest, err := fetchManifest(ctx, g.hc, manifestURL(name), name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	size, err := manifestTotalSize(ctx, g.hc, manifestURL, manifest)
+	if err != nil {
+		return 0, nil, err
+	}
+	return size, manifest, nil
+}
+
+// manifestSize the size of image is the sum of it's config and layers, for index it's the sum of it's child.
+// the manifest url is built by the reference (tag or digest) since the child is fetched by it's digest
+func manifestSize(ctx context.Context, hc http.IHttpClient, manifestURL func(reference string) string, reference string) (uint, error) {
+	manifest, err := fetchManifest(ctx, hc, manifestURL(reference), reference)
+	if err != nil {
+		return 0, err
+	}
+	return manifestTotalSize(ctx, hc, manifestURL, manifest)
+}
+
+// manifestTotalSize the size of manifest that is already fetched, the children of index are fetched for their size
+func manifestTotalSize(ctx context.Context, hc http.IHttpClient, manifestURL func(reference string) string, manifest *ManifestResponse) (uint, error) {
+	if manifest.IsIndex() {
+		var total uint
+		for _, child := range manifest.Manifests {
+			childSize, err := manifestSize(ctx, hc, manifestURL, child.Digest)
+			if err != nil {
+				return 0, err
+			}
+			total += childSize
+		}
+		return total, nil
+	}
+
+	total := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		total += layer.Size
+	}
+	return total, nil
+}
+
+// linkIndexManifests fetching the manifest of each index then it's children are linked, it's for the registry that is
+// listing the platform manifests as their own (untagged) digests but the list is not providing the children of index
+func linkIndexManifests(digests []Digest, fetch func(name string) (*ManifestResponse, error)) error {
+	children := map[string][]Descriptor{}
+	for idx := range digests {
+		if !digests[idx].IsIndex() {
+			continue
+		}
+		manifest, err := fetch(digests[idx].Name)
+		if err != nil {
+			return err
+		}
+		digests[idx].Platforms = manifest.Platforms()
+		children[digests[idx].Name] = manifest.Manifests
+	}
+	linkIndexChildren(digests, children)
+	return nil
+}
+
+// fetchManifest fetching the manifest content of reference (tag or digest) from registry api
+func fetchManifest(ctx context.Context, hc http.IHttpClient, manifestURL, reference string) (*ManifestResponse, error) {
+	var manifest ManifestResponse
+	if _, err := callAPI(ctx, hc, http.Request{Method: nethttp.MethodGet, URL: manifestURL, Headers: manifestAccept}, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "while fetching manifest %s", reference)
+	}
+	return &manifest, nil
+}
+
+// linkIndexChildren filling the parent digests of the child manifests that are listed in the same repository,
+// the children are the manifests of each index by it's name. the platform of child is taken from the index if it's unknown
+func linkIndexChildren(digests []Digest, children map[string][]Descriptor) {
+	digestIndex := map[string]int{}
+	for idx := range digests {
+		digestIndex[digests[idx].Name] = idx
+	}
+
+	for _, parent := range digests {
+		for _, child := range children[parent.Name] {
+			idx, ok := digestIndex[child.Digest]
+			if !ok {
+				continue
+			}
+			digests[idx].ParentDigests = append(digests[idx].ParentDigests, parent.Name)
+			if len(digests[idx].Platforms) == 0 && child.Platform != nil && child.Platform.String() != "" {
+				digests[idx].Platforms = []string{child.Platform.String()}
+			}
+		}
+	}
+}
+
+// paginate fetching the url and following the next page through Link header,
+// newObj is creating the response placeholder and collect will be called for each of page
+func (g Generic) paginate(ctx context.Context, url string, newObj func() errorResponse, collect func(obj errorResponse)) error {
+	for url != "" {
+		obj := newObj()
+		resp, err := g.call(ctx, nethttp.MethodGet, url, nil, obj)
+		if err != nil {
+			return err
+		}
+		collect(obj)
+
+		url = nextLink(g.baseURL, resp)
+	}
+	return nil
+}
+
+// call the registry api then check for the error in response body and status code
+func (g Generic) call(ctx context.Context, method, url string, headers map[string]string, obj errorResponse) (*http.Response, error) {
+	return callAPI(ctx, g.hc, http.Request{Method: method, URL: url, Headers: headers}, obj)
+}
+
+func (g Generic) url(paths ...string) string {
+	return fmt.Sprintf("%s/v2/%s", g.baseURL, h.SlashJoin(paths...))
+}
+
+// nextLink the url of next page from Link header, it's empty if there is no more page.
+// the relative path is following the base url (scheme and host) of the api
+func nextLink(baseURL string, resp *http.Response) string {
+	matched := reLinkNext.FindStringSubmatch(resp.Header.Get(headerLink))
+	if len(matched) != 2 {
+		return ""
+	}
+	// mostly it's returning the relative path
+	if strings.HasPrefix(matched[1], "/") {
+		return baseURL + matched[1]
+	}
+	return matched[1]
+}
+
+// callAPI send the request then check for the error in response body and status code
+func callAPI(ctx context.Context, hc http.IHttpClient, r http.Request, obj errorResponse) (*http.Response, error) {
+	resp, err := hc.Do(ctx, r, obj)
+	if err != nil {
+		return nil, err
+	}
+	return checkResponse(resp, r, obj)
+}
+
+// checkResponse returning the error in response body or the error status code of request
+func checkResponse(resp *http.Response, r http.Request, obj errorResponse) (*http.Response, error) {
+	if obj != nil {
+		if err := obj.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode >= nethttp.StatusBadRequest {
+		return nil, fmt.Errorf("got status code %d for %s %s", resp.StatusCode, r.Method, r.URL)
+	}
+	return resp, nil
+}
@@ -0,0 +1,508 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	genericHost      = "registry.local:5000"
+	genericHostHTTPS = fmt.Sprintf("https://%s", genericHost)
+	genericV2URL     = hl.SlashJoin(genericHostHTTPS, "v2")
+
+	// multiArchIndex the digest of index that is referring to the multiArchChildren (testdata/generic/index.json),
+	// it's for the registry that is listing the platform manifests as their own digests
+	multiArchIndex    = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	multiArchChildren = []string{
+		"sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11",
+		"sha256:bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22",
+	}
+)
+
+type mockRoute func(r http.Request, obj any) (*http.Response, error)
+
+// mockRoutes register the http client mock that replying request based on it's method and url
+func mockRoutes(m *mh.MockIHttpClient, routes map[string]mockRoute) {
+	m.EXPECT().Do(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(_ context.Context, r http.Request, obj any) (*http.Response, error) {
+		route, ok := routes[fmt.Sprintf("%s %s", r.Method, r.URL)]
+		if !ok {
+			return nil, fmt.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		return route(r, obj)
+	})
+}
+
+// fixtureRoute replying with the content of fixture file and additional headers
+func fixtureRoute(loc string, statusCode int, headers map[string]string) mockRoute {
+	return func(r http.Request, obj any) (*http.Response, error) {
+		if obj != nil && loc != "" {
+			if err := json.Unmarshal(readFixture(loc), obj); err != nil {
+				panic(errors.Wrapf(err, "while reading fixture file %s", loc))
+			}
+		}
+		resp := &http.Response{StatusCode: statusCode, Header: nethttp.Header{}}
+		for k, v := range headers {
+			resp.Header.Set(k, v)
+		}
+		return resp, nil
+	}
+}
+
+// jsonRoute replying with the given object as response body
+func jsonRoute(body any, headers map[string]string) mockRoute {
+	return func(r http.Request, obj any) (*http.Response, error) {
+		data, _ := json.Marshal(body)
+		if err := json.Unmarshal(data, obj); err != nil {
+			panic(err)
+		}
+		resp := &http.Response{StatusCode: nethttp.StatusOK, Header: nethttp.Header{}}
+		for k, v := range headers {
+			resp.Header.Set(k, v)
+		}
+		return resp, nil
+	}
+}
+
+// manifestRoutes replying the image manifest for sha256:1111... and the index for sha256:2222... that has 2 platform images,
+// it's for the registry that is calculating the size from registry manifest
+func manifestRoutes(v2URL, repoName string) map[string]mockRoute {
+	routes := map[string]mockRoute{
+		"GET " + hl.SlashJoin(v2URL, repoName, "manifests", "sha256:1111111111111111111111111111111111111111111111111111111111111111"): fixtureRoute("generic/manifest.json", nethttp.StatusOK, nil),
+		"GET " + hl.SlashJoin(v2URL, repoName, "manifests", "sha256:2222222222222222222222222222222222222222222222222222222222222222"): fixtureRoute("generic/index.json", nethttp.StatusOK, nil),
+	}
+	for _, child := range []string{
+		"sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11",
+		"sha256:bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22",
+	} {
+		routes["GET "+hl.SlashJoin(v2URL, repoName, "manifests", child)] = fixtureRoute("generic/manifest.json", nethttp.StatusOK, nil)
+	}
+	return routes
+}
+
+func TestGeneric_Catalog(t *testing.T) {
+	imageDigest := "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	indexDigest := "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	configDigest := "sha256:2b9b7a1e2f5e1e0a2c7cf3b2a0a4c6e9e9a17a3a4ad7c3f3c7b8f1e9b0b1c2d3"
+	childDigests := []string{
+		"sha256:aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11aa11",
+		"sha256:bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22bb22",
+	}
+	created := time.Date(2023, time.March, 10, 7, 15, 30, 123456789, time.UTC)
+	imageSize := uint(1472 + 2811478 + 1048576)
+
+	successRoutes := func() map[string]mockRoute {
+		return map[string]mockRoute{
+			"GET " + hl.SlashJoin(genericV2URL, "_catalog"): jsonRoute(
+				reg.CatalogResponse{Repositories: []string{"team-a/app"}},
+				map[string]string{"Link": `</v2/_catalog?last=team-a%2Fapp&n=100>; rel="next"`},
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "_catalog?last=team-a%2Fapp&n=100"): jsonRoute(
+				reg.CatalogResponse{Repositories: []string{"team-a/multi-arch", "team-b/other"}}, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/app", "tags", "list"): jsonRoute(
+				reg.TagListResponse{Name: "team-a/app", Tags: []string{"latest", "v1.0.0"}}, nil,
+			),
+			"HEAD " + hl.SlashJoin(genericV2URL, "team-a/app", "manifests", "latest"): fixtureRoute(
+				"", nethttp.StatusOK, map[string]string{"Docker-Content-Digest": imageDigest},
+			),
+			"HEAD " + hl.SlashJoin(genericV2URL, "team-a/app", "manifests", "v1.0.0"): fixtureRoute(
+				"", nethttp.StatusOK, map[string]string{"Docker-Content-Digest": imageDigest},
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/app", "manifests", imageDigest): fixtureRoute(
+				"generic/manifest.json", nethttp.StatusOK, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/app", "blobs", configDigest): fixtureRoute(
+				"generic/config.json", nethttp.StatusOK, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "tags", "list"): jsonRoute(
+				reg.TagListResponse{Name: "team-a/multi-arch", Tags: []string{"stable"}}, nil,
+			),
+			"HEAD " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "manifests", "stable"): fixtureRoute(
+				"", nethttp.StatusOK, map[string]string{"Docker-Content-Digest": indexDigest},
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "manifests", indexDigest): fixtureRoute(
+				"generic/index.json", nethttp.StatusOK, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "manifests", childDigests[0]): fixtureRoute(
+				"generic/manifest.json", nethttp.StatusOK, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "manifests", childDigests[1]): fixtureRoute(
+				"generic/manifest.json", nethttp.StatusOK, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "blobs", configDigest): fixtureRoute(
+				"generic/config.json", nethttp.StatusOK, nil,
+			),
+			// referrers api is not supported
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/app", "referrers", imageDigest): fixtureRoute(
+				"", nethttp.StatusNotFound, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-a/multi-arch", "referrers", indexDigest): fixtureRoute(
+				"", nethttp.StatusNotFound, nil,
+			),
+			"GET " + hl.SlashJoin(genericV2URL, "team-b/other", "tags", "list"): jsonRoute(
+				reg.TagListResponse{Name: "team-b/other", Tags: []string{}}, nil,
+			),
+		}
+	}
+
+	testCases := map[string]struct {
+		host               string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"error while fetching catalog": {
+			host: genericHost,
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(genericV2URL, "_catalog"): func(r http.Request, obj any) (*http.Response, error) {
+						return nil, fmt.Errorf("connection refused")
+					},
+				}
+			},
+			expectErrMsg: "connection refused",
+		},
+		"error in catalog response body": {
+			host: genericHost,
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(genericV2URL, "_catalog"): jsonRoute(
+						reg.CatalogResponse{ErrorsField: reg.ErrorsField{Errors: []reg.ErrorField{{Code: "UNAUTHORIZED", Message: "authentication required"}}}}, nil,
+					),
+				}
+			},
+			expectErrMsg: "[UNAUTHORIZED] [authentication required]",
+		},
+		"tag is not returning digest header": {
+			host: genericHost,
+			routes: func() map[string]mockRoute {
+				routes := successRoutes()
+				routes["HEAD "+hl.SlashJoin(genericV2URL, "team-a/app", "manifests", "latest")] = fixtureRoute("", nethttp.StatusOK, nil)
+				return routes
+			},
+			expectErrMsg: "no digest returned for tag team-a/app:latest",
+		},
+		"unknown manifest when resolving tag": {
+			host: genericHost,
+			routes: func() map[string]mockRoute {
+				routes := successRoutes()
+				routes["HEAD "+hl.SlashJoin(genericV2URL, "team-a/app", "manifests", "latest")] = fixtureRoute("", nethttp.StatusNotFound, nil)
+				return routes
+			},
+			expectErrMsg: fmt.Sprintf("while resolving tag team-a/app:latest: got status code 404 for HEAD %s", hl.SlashJoin(genericV2URL, "team-a/app", "manifests", "latest")),
+		},
+		"error in manifest response": {
+			host: genericHost,
+			routes: func() map[string]mockRoute {
+				routes := successRoutes()
+				routes["GET "+hl.SlashJoin(genericV2URL, "team-a/app", "manifests", imageDigest)] = fixtureRoute("generic/error_manifest_unknown.json", nethttp.StatusNotFound, nil)
+				return routes
+			},
+			expectErrMsg: fmt.Sprintf("while fetching manifest %s: [MANIFEST_UNKNOWN] [manifest unknown]", imageDigest),
+		},
+		"grouping tags by digest, following pagination and sum up the index child": {
+			host:   genericHost,
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{
+					Name: "registry.local:5000/team-a/app",
+					Digests: []reg.Digest{
+						{
+							Name:           imageDigest,
+							ImageSizeBytes: imageSize,
+							Tag:            []string{"latest", "v1.0.0"},
+							Created:        created,
+							Uploaded:       created,
+							MediaType:      reg.MediaTypeDockerManifest,
+							Platforms:      []string{"linux/amd64"},
+						},
+					},
+				},
+				{
+					Name: "registry.local:5000/team-a/multi-arch",
+					Digests: []reg.Digest{
+						{
+							Name:           indexDigest,
+							ImageSizeBytes: imageSize * 2,
+							Tag:            []string{"stable"},
+							Created:        created,
+							Uploaded:       created,
+							MediaType:      reg.MediaTypeOCIIndex,
+							Platforms:      []string{"linux/amd64", "linux/arm64"},
+						},
+					},
+				},
+			},
+		},
+		"linking the platform manifest that is tagged on it's own": {
+			host: hl.SlashJoin(genericHost, "team-a", "multi-arch"),
+			routes: func() map[string]mockRoute {
+				routes := successRoutes()
+				routes["GET "+hl.SlashJoin(genericV2URL, "team-a/multi-arch", "tags", "list")] = jsonRoute(
+					reg.TagListResponse{Name: "team-a/multi-arch", Tags: []string{"stable", "stable-amd64"}}, nil,
+				)
+				routes["HEAD "+hl.SlashJoin(genericV2URL, "team-a/multi-arch", "manifests", "stable-amd64")] = fixtureRoute(
+					"", nethttp.StatusOK, map[string]string{"Docker-Content-Digest": childDigests[0]},
+				)
+				return routes
+			},
+			expectRepositories: []reg.Repository{
+				{
+					Name: "registry.local:5000/team-a/multi-arch",
+					Digests: []reg.Digest{
+						{
+							Name:           indexDigest,
+							ImageSizeBytes: imageSize * 2,
+							Tag:            []string{"stable"},
+							Created:        created,
+							Uploaded:       created,
+							MediaType:      reg.MediaTypeOCIIndex,
+							Platforms:      []string{"linux/amd64", "linux/arm64"},
+						},
+						{
+							Name:           childDigests[0],
+							ImageSizeBytes: imageSize,
+							Tag:            []string{"stable-amd64"},
+							Created:        created,
+							Uploaded:       created,
+							MediaType:      reg.MediaTypeDockerManifest,
+							Platforms:      []string{"linux/amd64"},
+							ParentDigests:  []string{indexDigest},
+						},
+					},
+				},
+			},
+		},
+		"only repositories under the prefix": {
+			host:   hl.SlashJoin(genericHost, "team-a", "app"),
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{
+					Name: "registry.local:5000/team-a/app",
+					Digests: []reg.Digest{
+						{
+							Name:           imageDigest,
+							ImageSizeBytes: imageSize,
+							Tag:            []string{"latest", "v1.0.0"},
+							Created:        created,
+							Uploaded:       created,
+							MediaType:      reg.MediaTypeDockerManifest,
+							Platforms:      []string{"linux/amd64"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			generic, err := reg.NewGeneric(tc.host, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := generic.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGeneric_CatalogReferrers(t *testing.T) {
+	imageDigest := "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	sbomDigest := "sha256:5555555555555555555555555555555555555555555555555555555555555555"
+	signatureDigest := "sha256:6666666666666666666666666666666666666666666666666666666666666666"
+	configDigest := "sha256:2b9b7a1e2f5e1e0a2c7cf3b2a0a4c6e9e9a17a3a4ad7c3f3c7b8f1e9b0b1c2d3"
+	emptyConfigDigest := "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+	created := time.Date(2023, time.March, 10, 7, 15, 30, 123456789, time.UTC)
+	repoURL := hl.SlashJoin(genericV2URL, "team-a/app")
+
+	routes := func() map[string]mockRoute {
+		return map[string]mockRoute{
+			"GET " + hl.SlashJoin(genericV2URL, "_catalog"): jsonRoute(reg.CatalogResponse{Repositories: []string{"team-a/app"}}, nil),
+			"GET " + hl.SlashJoin(repoURL, "tags", "list"):  jsonRoute(reg.TagListResponse{Name: "team-a/app", Tags: []string{"latest"}}, nil),
+			"HEAD " + hl.SlashJoin(repoURL, "manifests", "latest"): fixtureRoute(
+				"", nethttp.StatusOK, map[string]string{"Docker-Content-Digest": imageDigest},
+			),
+			"GET " + hl.SlashJoin(repoURL, "manifests", imageDigest):     fixtureRoute("generic/manifest.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(repoURL, "blobs", configDigest):        fixtureRoute("generic/config.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(repoURL, "manifests", sbomDigest):      fixtureRoute("generic/artifact_sbom.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(repoURL, "manifests", signatureDigest): fixtureRoute("generic/artifact_signature.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(repoURL, "blobs", emptyConfigDigest):   fixtureRoute("", nethttp.StatusOK, nil),
+			// the sbom is signed
+			"GET " + hl.SlashJoin(repoURL, "referrers", imageDigest):     fixtureRoute("generic/referrers.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(repoURL, "referrers", sbomDigest):      fixtureRoute("generic/referrers_sbom.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(repoURL, "referrers", signatureDigest): fixtureRoute("generic/referrers_empty.json", nethttp.StatusOK, nil),
+		}
+	}
+
+	testCases := map[string]struct {
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"discovering the referrers of digest and it's referrers": {
+			routes: routes,
+			expectRepositories: []reg.Repository{{
+				Name: "registry.local:5000/team-a/app",
+				Digests: []reg.Digest{
+					{
+						Name:           imageDigest,
+						ImageSizeBytes: 1472 + 2811478 + 1048576,
+						Tag:            []string{"latest"},
+						Created:        created,
+						Uploaded:       created,
+						MediaType:      reg.MediaTypeDockerManifest,
+						Platforms:      []string{"linux/amd64"},
+					},
+					{
+						Name:           sbomDigest,
+						ImageSizeBytes: 2 + 24576,
+						Created:        time.Date(2023, time.March, 11, 9, 0, 0, 0, time.UTC),
+						Uploaded:       time.Date(2023, time.March, 11, 9, 0, 0, 0, time.UTC),
+						MediaType:      reg.MediaTypeOCIManifest,
+						Subject:        imageDigest,
+						ArtifactType:   "application/spdx+json",
+					},
+					{
+						Name:           signatureDigest,
+						ImageSizeBytes: 2 + 2048,
+						Created:        time.Date(2023, time.March, 11, 9, 5, 0, 0, time.UTC),
+						Uploaded:       time.Date(2023, time.March, 11, 9, 5, 0, 0, time.UTC),
+						MediaType:      reg.MediaTypeOCIManifest,
+						Subject:        sbomDigest,
+						ArtifactType:   reg.ArtifactTypeNotarySignature,
+					},
+				},
+			}},
+		},
+		"error while listing the referrers": {
+			routes: func() map[string]mockRoute {
+				r := routes()
+				r["GET "+hl.SlashJoin(repoURL, "referrers", sbomDigest)] = fixtureRoute("generic/error_manifest_unknown.json", nethttp.StatusBadRequest, nil)
+				return r
+			},
+			expectErrMsg: "while listing referrers of " + sbomDigest + ": [MANIFEST_UNKNOWN] [manifest unknown]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			generic, err := reg.NewGeneric(genericHost, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := generic.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGeneric_Delete(t *testing.T) {
+	sampleRepo := reg.Repository{
+		Name: "registry.local:5000/team-a/app",
+		Digests: []reg.Digest{
+			{Name: "sha256:1111111111111111111111111111111111111111111111111111111111111111", Tag: []string{"latest"}},
+			{Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Tag: []string{"v0.9.0"}},
+		},
+	}
+	manifestURL := hl.SlashJoin(genericV2URL, "team-a/app", "manifests")
+
+	testCases := map[string]struct {
+		endpoint       string
+		mockHTTPClient func(*mh.MockIHttpClient)
+		expectErrMsg   string
+	}{
+		"deleting by digest only": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusAccepted}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[1].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusAccepted}, nil),
+				)
+			},
+		},
+		"plain http registry by endpoint": {
+			endpoint: "http://registry.local:5000/",
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				httpManifestURL := "http://registry.local:5000/v2/team-a/app/manifests"
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(httpManifestURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusAccepted}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(httpManifestURL, sampleRepo.Digests[1].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusAccepted}, nil),
+				)
+			},
+		},
+		"deletion is not enabled in registry": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+					Times(1).DoAndReturn(func(_ context.Context, r http.Request, errFields *reg.ErrorsField) (*http.Response, error) {
+					errFields.Errors = []reg.ErrorField{{Code: "UNSUPPORTED", Message: "The operation is unsupported."}}
+					return &http.Response{StatusCode: nethttp.StatusMethodNotAllowed}, nil
+				})
+			},
+			expectErrMsg: "[UNSUPPORTED] [The operation is unsupported.]",
+		},
+		"unexpected status code": {
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(manifestURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+					Times(1).Return(&http.Response{StatusCode: nethttp.StatusForbidden}, nil)
+			},
+			expectErrMsg: fmt.Sprintf("got status code 403 for DELETE %s", hl.SlashJoin(manifestURL, sampleRepo.Digests[0].Name)),
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			tc.mockHTTPClient(mHc)
+
+			generic, err := reg.NewGeneric(genericHost, mHc, reg.Option{Endpoint: tc.endpoint})
+			assert.NoError(t, err)
+
+			err = generic.Delete(context.Background(), sampleRepo)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	GitHubAPIURL    = "https://api.github.com"
+	githubPageSize  = 100
+	githubOwnerOrg  = "Organization"
+	githubAPIAccept = "application/vnd.github+json"
+)
+
+var githubHeaders = map[string]string{
+	"Accept":               githubAPIAccept,
+	"X-GitHub-Api-Version": "2022-11-28",
+}
+
+// GitHubError is the error response of github api
+type GitHubError struct {
+	Message string `json:"message"`
+}
+
+func (e GitHubError) Err() error {
+	if e.Message == "" {
+		return nil
+	}
+	return fmt.Errorf("[%s]", e.Message)
+}
+
+// GitHubListResponse the list endpoints are replying json array, but the error is replied as object
+type GitHubListResponse[T any] struct {
+	Items []T
+	GitHubError
+}
+
+func (r *GitHubListResponse[T]) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &r.Items)
+	}
+	return json.Unmarshal(data, &r.GitHubError)
+}
+
+type GitHubOwner struct {
+	Login string `json:"login"`
+	Type  string `json:"type"`
+	GitHubError
+}
+
+type GitHubPackage struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type GitHubPackageVersion struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Metadata  struct {
+		Container struct {
+			Tags []string `json:"tags"`
+		} `json:"container"`
+	} `json:"metadata"`
+}
+
+// GHCR is the github container registry, the registry api is not providing the dates so the metadata is taken from
+// github packages api and the size is calculated from the manifest in registry
+type GHCR struct {
+	host     string
+	owner    string
+	prefix   string
+	apiURL   string
+	hc       http.IHttpClient
+	registry Generic
+
+	mutex     sync.Mutex
+	ownerPath string
+	// versionIDs the package version ids by their digest for each package, it's filled by catalog or the first deletion
+	// of the package so the versions are not listed again for each of digest
+	versionIDs map[string]map[string]int64
+}
+
+// NewGHCR the owner (user or organization) must be set after the host, it can be followed by package name prefix,
+// eg: ghcr.io/my-org or ghcr.io/my-org/team-a. the api url can be changed by option for github enterprise server
+func NewGHCR(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 3)
+	if len(hostSplt) == 1 {
+		return nil, fmt.Errorf("you must specified owner (user or organization) after registry host, eg: ghcr.io/my-org")
+	}
+
+	g := &GHCR{
+		host:       hostSplt[0],
+		owner:      hostSplt[1],
+		apiURL:     GitHubAPIURL,
+		hc:         hc,
+		registry:   Generic{host: hostSplt[0], baseURL: fmt.Sprintf("https://%s", hostSplt[0]), hc: hc},
+		versionIDs: map[string]map[string]int64{},
+	}
+	if len(hostSplt) == 3 {
+		g.prefix = strings.Trim(hostSplt[2], "/")
+	}
+	if opt.Endpoint != "" {
+		g.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	return g, nil
+}
+
+// Catalog list container packages of the owner then it's versions, the size and media type are taken from registry manifest
+func (g *GHCR) Catalog(ctx context.Context) ([]Repository, error) {
+	ownerPath, err := g.getOwnerPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []GitHubPackage
+	err = githubPaginate(ctx, g, g.url(ownerPath, "packages")+"?package_type=container", func(items []GitHubPackage) {
+		packages = append(packages, items...)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing packages")
+	}
+
+	var repositories []Repository
+	for _, pkg := range packages {
+		if g.prefix != "" && pkg.Name != g.prefix && !strings.HasPrefix(pkg.Name, g.prefix+"/") {
+			continue
+		}
+
+		log.Debug().Str("package", pkg.Name).Msg("processing")
+		versions, err := g.versions(ctx, ownerPath, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		g.cacheVersionIDs(pkg.Name, versions)
+
+		digests := make([]Digest, 0, len(versions))
+		// the platform manifests of index are listed as the untagged versions
+		children := map[string][]Descriptor{}
+		for _, version := range versions {
+			size, manifest, err := g.registry.sizedManifest(ctx, h.SlashJoin(g.owner, pkg.Name), version.Name)
+			if err != nil {
+				return nil, err
+			}
+			digest := Digest{
+				Name:           version.Name,
+				ImageSizeBytes: size,
+				Tag:            version.Metadata.Container.Tags,
+				Created:        version.CreatedAt,
+				Uploaded:       version.UpdatedAt,
+				MediaType:      manifest.MediaType,
+			}
+			if manifest.IsIndex() {
+				digest.Platforms = manifest.Platforms()
+				children[version.Name] = manifest.Manifests
+			}
+			digests = append(digests, digest)
+		}
+		linkIndexChildren(digests, children)
+
+		if len(digests) == 0 {
+			log.Debug().Str("package", pkg.Name).Msg("not found any digests found, skipping")
+			continue
+		}
+		repositories = append(repositories, Repository{Name: h.SlashJoin(g.host, g.owner, pkg.Name), Digests: digests})
+	}
+	return repositories, nil
+}
+
+// Delete by package version id, the id is resolved from the digest by the package versions that are listed in catalog
+// or listed once in the first deletion of the package
+func (g *GHCR) Delete(ctx context.Context, repository Repository) error {
+	ownerPath, err := g.getOwnerPath(ctx)
+	if err != nil {
+		return err
+	}
+
+	pkgName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/%s/", g.host, g.owner))
+	versionIDs, err := g.getVersionIDs(ctx, ownerPath, pkgName)
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range repository.Digests {
+		versionID, ok := versionIDs[digest.Name]
+		if !ok {
+			return fmt.Errorf("no package version found for %s@%s", repository.Name, digest.Name)
+		}
+
+		versionURL := g.url(ownerPath, "packages", "container", url.PathEscape(pkgName), "versions", fmt.Sprint(versionID))
+		log.Debug().Str("url", versionURL).Msg("deleting package version")
+		if _, err = callAPI(ctx, g.hc, http.Request{Method: nethttp.MethodDelete, URL: versionURL, Headers: githubHeaders}, &GitHubError{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getOwnerPath the api path of owner's packages is different for organization and user
+func (g *GHCR) getOwnerPath(ctx context.Context) (string, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.ownerPath != "" {
+		return g.ownerPath, nil
+	}
+
+	var owner GitHubOwner
+	if _, err := callAPI(ctx, g.hc, http.Request{Method: nethttp.MethodGet, URL: g.url("users", g.owner), Headers: githubHeaders}, &owner); err != nil {
+		return "", errors.Wrapf(err, "while fetching owner %s", g.owner)
+	}
+
+	g.ownerPath = h.SlashJoin("users", g.owner)
+	if owner.Type == githubOwnerOrg {
+		g.ownerPath = h.SlashJoin("orgs", g.owner)
+	}
+	return g.ownerPath, nil
+}
+
+// getVersionIDs the version ids of package by their digest, the versions are listed if it's not cached yet
+func (g *GHCR) getVersionIDs(ctx context.Context, ownerPath, pkgName string) (map[string]int64, error) {
+	g.mutex.Lock()
+	versionIDs, ok := g.versionIDs[pkgName]
+	g.mutex.Unlock()
+	if ok {
+		return versionIDs, nil
+	}
+
+	versions, err := g.versions(ctx, ownerPath, pkgName)
+	if err != nil {
+		return nil, err
+	}
+	return g.cacheVersionIDs(pkgName, versions), nil
+}
+
+func (g *GHCR) cacheVersionIDs(pkgName string, versions []GitHubPackageVersion) map[string]int64 {
+	versionIDs := make(map[string]int64, len(versions))
+	for _, version := range versions {
+		versionIDs[version.Name] = version.ID
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.versionIDs[pkgName] = versionIDs
+	return versionIDs
+}
+
+func (g *GHCR) versions(ctx context.Context, ownerPath, pkgName string) ([]GitHubPackageVersion, error) {
+	var versions []GitHubPackageVersion
+	versionsURL := g.url(ownerPath, "packages", "container", url.PathEscape(pkgName), "versions")
+	err := githubPaginate(ctx, g, versionsURL, func(items []GitHubPackageVersion) {
+		versions = append(versions, items...)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing versions of package %s", pkgName)
+	}
+	return versions, nil
+}
+
+func (g *GHCR) url(paths ...string) string {
+	return fmt.Sprintf("%s/%s", g.apiURL, h.SlashJoin(paths...))
+}
+
+// githubPaginate fetching all pages of list endpoint by following the Link header
+func githubPaginate[T any](ctx context.Context, g *GHCR, pageURL string, collect func(items []T)) error {
+	sep := "?"
+	if strings.Contains(pageURL, "?") {
+		sep = "&"
+	}
+	pageURL = fmt.Sprintf("%s%sper_page=%d", pageURL, sep, githubPageSize)
+	for pageURL != "" {
+		var obj GitHubListResponse[T]
+		resp, err := callAPI(ctx, g.hc, http.Request{Method: nethttp.MethodGet, URL: pageURL, Headers: githubHeaders}, &obj)
+		if err != nil {
+			return err
+		}
+		collect(obj.Items)
+		pageURL = nextLink(fmt.Sprintf("https://%s", g.host), resp)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package registry_test
+
+import (
+	"context"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ghcrV2URL         = "https://ghcr.io/v2"
+	ghcrOrgAPIURL     = hl.SlashJoin(reg.GitHubAPIURL, "orgs/my-org")
+	ghcrPackagesQuery = "?package_type=container&per_page=100"
+	ghcrVersionsQuery = "?per_page=100"
+	ghcrImageDigest   = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	ghcrIndexDigest   = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func TestGHCR_Catalog(t *testing.T) {
+	imageSize := uint(1472 + 2811478 + 1048576)
+	expectedDigests := []reg.Digest{
+		{
+			Name:           ghcrImageDigest,
+			ImageSizeBytes: imageSize,
+			Tag:            []string{"latest", "v1.0.0"},
+			Created:        time.Date(2023, time.March, 10, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 12, 9, 30, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+		},
+		// the index size is the sum of it's platform images
+		{
+			Name:           ghcrIndexDigest,
+			ImageSizeBytes: imageSize * 2,
+			Tag:            []string{},
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeOCIIndex,
+			Platforms:      []string{"linux/amd64", "linux/arm64"},
+		},
+		// the platform image of index is listed as untagged version
+		{
+			Name:           multiArchChildren[0],
+			ImageSizeBytes: imageSize,
+			Tag:            []string{},
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			MediaType:      reg.MediaTypeDockerManifest,
+			Platforms:      []string{"linux/amd64"},
+			ParentDigests:  []string{ghcrIndexDigest},
+		},
+	}
+
+	orgRoutes := func() map[string]mockRoute {
+		routes := map[string]mockRoute{
+			"GET " + hl.SlashJoin(reg.GitHubAPIURL, "users/my-org"): jsonRoute(reg.GitHubOwner{Login: "my-org", Type: "Organization"}, nil),
+			"GET " + hl.SlashJoin(ghcrOrgAPIURL, "packages"+ghcrPackagesQuery): jsonRoute(
+				[]reg.GitHubPackage{{ID: 1, Name: "team-a/app"}},
+				map[string]string{"Link": `<https://api.github.com/organizations/1234/packages?package_type=container&per_page=100&page=2>; rel="next", <https://api.github.com/organizations/1234/packages?package_type=container&per_page=100&page=2>; rel="last"`},
+			),
+			"GET https://api.github.com/organizations/1234/packages?package_type=container&per_page=100&page=2": jsonRoute(
+				[]reg.GitHubPackage{{ID: 2, Name: "team-b/web"}}, nil,
+			),
+			"GET " + hl.SlashJoin(ghcrOrgAPIURL, "packages/container/team-a%2Fapp/versions"+ghcrVersionsQuery): fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(ghcrOrgAPIURL, "packages/container/team-b%2Fweb/versions"+ghcrVersionsQuery): fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+		}
+		for _, repoName := range []string{"my-org/team-a/app", "my-org/team-b/web"} {
+			for k, v := range manifestRoutes(ghcrV2URL, repoName) {
+				routes[k] = v
+			}
+		}
+		return routes
+	}
+
+	testCases := map[string]struct {
+		host               string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"owner is not found": {
+			host: "ghcr.io/ghost",
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(reg.GitHubAPIURL, "users/ghost"): fixtureRoute("ghcr/error_not_found.json", nethttp.StatusNotFound, nil),
+				}
+			},
+			expectErrMsg: "while fetching owner ghost: [Not Found]",
+		},
+		"organization packages and following pagination": {
+			host:   "ghcr.io/my-org",
+			routes: orgRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "ghcr.io/my-org/team-a/app", Digests: expectedDigests},
+				{Name: "ghcr.io/my-org/team-b/web", Digests: expectedDigests},
+			},
+		},
+		"only packages under the prefix": {
+			host:   "ghcr.io/my-org/team-b",
+			routes: orgRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "ghcr.io/my-org/team-b/web", Digests: expectedDigests},
+			},
+		},
+		"user packages": {
+			host: "ghcr.io/someone",
+			routes: func() map[string]mockRoute {
+				userAPIURL := hl.SlashJoin(reg.GitHubAPIURL, "users/someone")
+				routes := map[string]mockRoute{
+					"GET " + userAPIURL: jsonRoute(reg.GitHubOwner{Login: "someone", Type: "User"}, nil),
+					"GET " + hl.SlashJoin(userAPIURL, "packages"+ghcrPackagesQuery): jsonRoute(
+						[]reg.GitHubPackage{{ID: 1, Name: "app"}}, nil,
+					),
+					"GET " + hl.SlashJoin(userAPIURL, "packages/container/app/versions"+ghcrVersionsQuery): fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+				}
+				for k, v := range manifestRoutes(ghcrV2URL, "someone/app") {
+					routes[k] = v
+				}
+				return routes
+			},
+			expectRepositories: []reg.Repository{
+				{Name: "ghcr.io/someone/app", Digests: expectedDigests},
+			},
+		},
+		"failed while fetching manifest": {
+			host: "ghcr.io/my-org/team-a",
+			routes: func() map[string]mockRoute {
+				routes := orgRoutes()
+				routes["GET "+hl.SlashJoin(ghcrV2URL, "my-org/team-a/app/manifests", ghcrImageDigest)] = fixtureRoute(
+					"generic/error_manifest_unknown.json", nethttp.StatusNotFound, nil,
+				)
+				return routes
+			},
+			expectErrMsg: "while fetching manifest sha256:1111111111111111111111111111111111111111111111111111111111111111: [MANIFEST_UNKNOWN] [manifest unknown]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			ghcr, err := reg.NewGHCR(tc.host, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := ghcr.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGHCR_Delete(t *testing.T) {
+	versionsURL := hl.SlashJoin(ghcrOrgAPIURL, "packages/container/team-a%2Fapp/versions")
+
+	testCases := map[string]struct {
+		endpoint     string
+		digests      []reg.Digest
+		routes       func(deleted *[]string) map[string]mockRoute
+		expectErrMsg string
+		expectDelete []string
+	}{
+		"deleting by package version id": {
+			digests: []reg.Digest{{Name: ghcrImageDigest}, {Name: ghcrIndexDigest}},
+			routes: func(deleted *[]string) map[string]mockRoute {
+				deleteRoute := func(r http.Request, _ any) (*http.Response, error) {
+					*deleted = append(*deleted, r.URL)
+					return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+				}
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(reg.GitHubAPIURL, "users/my-org"): jsonRoute(reg.GitHubOwner{Login: "my-org", Type: "Organization"}, nil),
+					"GET " + versionsURL + ghcrVersionsQuery:                fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+					"DELETE " + hl.SlashJoin(versionsURL, "101"):            deleteRoute,
+					"DELETE " + hl.SlashJoin(versionsURL, "102"):            deleteRoute,
+				}
+			},
+			expectDelete: []string{hl.SlashJoin(versionsURL, "101"), hl.SlashJoin(versionsURL, "102")},
+		},
+		"custom api endpoint": {
+			endpoint: "https://github.company.io/api/v3/",
+			digests:  []reg.Digest{{Name: ghcrImageDigest}},
+			routes: func(deleted *[]string) map[string]mockRoute {
+				enterpriseVersionsURL := "https://github.company.io/api/v3/orgs/my-org/packages/container/team-a%2Fapp/versions"
+				return map[string]mockRoute{
+					"GET https://github.company.io/api/v3/users/my-org": jsonRoute(reg.GitHubOwner{Login: "my-org", Type: "Organization"}, nil),
+					"GET " + enterpriseVersionsURL + ghcrVersionsQuery:  fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+					"DELETE " + hl.SlashJoin(enterpriseVersionsURL, "101"): func(r http.Request, _ any) (*http.Response, error) {
+						*deleted = append(*deleted, r.URL)
+						return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+					},
+				}
+			},
+			expectDelete: []string{"https://github.company.io/api/v3/orgs/my-org/packages/container/team-a%2Fapp/versions/101"},
+		},
+		"digest is not found in package versions": {
+			digests: []reg.Digest{{Name: "sha256:3333"}},
+			routes: func(_ *[]string) map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(reg.GitHubAPIURL, "users/my-org"): jsonRoute(reg.GitHubOwner{Login: "my-org", Type: "Organization"}, nil),
+					"GET " + versionsURL + ghcrVersionsQuery:                fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+				}
+			},
+			expectErrMsg: "no package version found for ghcr.io/my-org/team-a/app@sha256:3333",
+		},
+		"deletion is rejected": {
+			digests: []reg.Digest{{Name: ghcrImageDigest}},
+			routes: func(_ *[]string) map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(reg.GitHubAPIURL, "users/my-org"): jsonRoute(reg.GitHubOwner{Login: "my-org", Type: "Organization"}, nil),
+					"GET " + versionsURL + ghcrVersionsQuery:                fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil),
+					"DELETE " + hl.SlashJoin(versionsURL, "101"): func(_ http.Request, obj any) (*http.Response, error) {
+						obj.(*reg.GitHubError).Message = "Publicly visible package versions with more than 5000 downloads cannot be deleted."
+						return &http.Response{StatusCode: nethttp.StatusBadRequest}, nil
+					},
+				}
+			},
+			expectErrMsg: "[Publicly visible package versions with more than 5000 downloads cannot be deleted.]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var deleted []string
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes(&deleted))
+
+			ghcr, err := reg.NewGHCR("ghcr.io/my-org", mHc, reg.Option{Endpoint: tc.endpoint})
+			assert.NoError(t, err)
+
+			err = ghcr.Delete(context.Background(), reg.Repository{Name: "ghcr.io/my-org/team-a/app", Digests: tc.digests})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectDelete, deleted)
+			}
+		})
+	}
+}
+
+func TestNewGHCR(t *testing.T) {
+	_, err := reg.NewGHCR("ghcr.io", nil, reg.Option{})
+	assert.EqualError(t, err, "you must specified owner (user or organization) after registry host, eg: ghcr.io/my-org")
+}
+
+func TestGHCR_DeleteListingVersionsOnce(t *testing.T) {
+	versionsURL := hl.SlashJoin(ghcrOrgAPIURL, "packages/container/team-a%2Fapp/versions")
+	testCases := map[string]struct {
+		catalog            bool
+		expectVersionsList int
+	}{
+		"versions are listed in the first deletion": {
+			expectVersionsList: 1,
+		},
+		"versions are taken from catalog": {
+			catalog:            true,
+			expectVersionsList: 1,
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var versionsList int
+			var deleted []string
+			deleteRoute := func(r http.Request, _ any) (*http.Response, error) {
+				deleted = append(deleted, r.URL)
+				return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+			}
+			routes := map[string]mockRoute{
+				"GET " + hl.SlashJoin(reg.GitHubAPIURL, "users/my-org"):            jsonRoute(reg.GitHubOwner{Login: "my-org", Type: "Organization"}, nil),
+				"GET " + hl.SlashJoin(ghcrOrgAPIURL, "packages"+ghcrPackagesQuery): jsonRoute([]reg.GitHubPackage{{ID: 1, Name: "team-a/app"}}, nil),
+				"GET " + versionsURL + ghcrVersionsQuery: func(r http.Request, obj any) (*http.Response, error) {
+					versionsList++
+					return fixtureRoute("ghcr/versions.json", nethttp.StatusOK, nil)(r, obj)
+				},
+				"DELETE " + hl.SlashJoin(versionsURL, "101"): deleteRoute,
+				"DELETE " + hl.SlashJoin(versionsURL, "102"): deleteRoute,
+			}
+			for k, v := range manifestRoutes(ghcrV2URL, "my-org/team-a/app") {
+				routes[k] = v
+			}
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, routes)
+
+			ghcr, err := reg.NewGHCR("ghcr.io/my-org", mHc, reg.Option{})
+			assert.NoError(t, err)
+			if tc.catalog {
+				_, err = ghcr.Catalog(context.Background())
+				assert.NoError(t, err)
+			}
+
+			// the digests are deleted one by one
+			for _, digest := range []string{ghcrImageDigest, ghcrIndexDigest} {
+				err = ghcr.Delete(context.Background(), reg.Repository{Name: "ghcr.io/my-org/team-a/app", Digests: []reg.Digest{{Name: digest}}})
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectVersionsList, versionsList)
+			assert.Equal(t, []string{hl.SlashJoin(versionsURL, "101"), hl.SlashJoin(versionsURL, "102")}, deleted)
+		})
+	}
+}
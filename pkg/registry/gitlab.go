@@ -0,0 +1,434 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	gitlabPageSize = 100
+	// gitlabJobTokenUser the username of ci job token as it's used in docker login, eg: docker login -u gitlab-ci-token -p $CI_JOB_TOKEN
+	gitlabJobTokenUser = "gitlab-ci-token"
+)
+
+// GitLabError is the error response of gitlab api, the message can be a string or validation errors object
+type GitLabError struct {
+	Message   any    `json:"message"`
+	ErrorCode string `json:"error"`
+}
+
+func (e GitLabError) Err() error {
+	if e.Message != nil {
+		return fmt.Errorf("[%v]", e.Message)
+	}
+	if e.ErrorCode != "" {
+		return fmt.Errorf("[%s]", e.ErrorCode)
+	}
+	return nil
+}
+
+// GitLabListResponse the list endpoints are replying json array, but the error is replied as object
+type GitLabListResponse[T any] struct {
+	Items []T
+	GitLabError
+}
+
+func (r *GitLabListResponse[T]) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &r.Items)
+	}
+	return json.Unmarshal(data, &r.GitLabError)
+}
+
+type GitLabNamespace struct {
+	ID       int64  `json:"id"`
+	FullPath string `json:"full_path"`
+	GitLabError
+}
+
+type GitLabProject struct {
+	ID                int64  `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	GitLabError
+}
+
+type GitLabRegistryRepository struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	ProjectID int64  `json:"project_id"`
+	Location  string `json:"location"`
+}
+
+type GitLabTag struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"path"`
+	Location  string    `json:"location"`
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+	TotalSize uint      `json:"total_size"`
+	GitLabError
+}
+
+// gitlabRepositoryRef the ids of registry repository that are required by the tag delete api
+type gitlabRepositoryRef struct {
+	projectID    int64
+	repositoryID int64
+}
+
+// GitLab is the gitlab container registry that using it's rest api (/api/v4), the repositories are listed from
+// the projects of group and it's subgroups recursively or from a single project
+type GitLab struct {
+	host        string
+	path        string
+	apiURL      string
+	headers     map[string]string
+	hc          http.IHttpClient
+	workerCount int
+
+	mutex sync.Mutex
+	refs  map[string]gitlabRepositoryRef
+}
+
+// NewGitLab the host is the gitlab instance (not the registry host) followed by group or project path,
+// eg: gitlab.company.io/my-group or gitlab.company.io/my-group/my-project. the password is personal access token
+// or the ci job token if the username is gitlab-ci-token
+func NewGitLab(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 2)
+	if len(hostSplt) == 1 {
+		return nil, fmt.Errorf("you must specified group or project path after gitlab host, eg: gitlab.company.io/my-group")
+	}
+	workerCount := opt.WorkerCount
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+
+	g := &GitLab{
+		host:        hostSplt[0],
+		path:        strings.Trim(hostSplt[1], "/"),
+		apiURL:      fmt.Sprintf("https://%s/api/v4", hostSplt[0]),
+		headers:     map[string]string{},
+		hc:          hc,
+		workerCount: workerCount,
+		refs:        map[string]gitlabRepositoryRef{},
+	}
+	if opt.Endpoint != "" {
+		g.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	if opt.Username == gitlabJobTokenUser {
+		g.headers["JOB-TOKEN"] = opt.Password
+	} else if opt.Password != "" {
+		g.headers["PRIVATE-TOKEN"] = opt.Password
+	}
+	return g, nil
+}
+
+// gitlabNode is the result of a group or project, the result is flattened after all of them are fetched
+// so the order is the same as walking it one by one (the projects first then the subgroups)
+type gitlabNode struct {
+	repositories []Repository
+	children     []*gitlabNode
+}
+
+func (n *gitlabNode) flatten(repositories []Repository) []Repository {
+	repositories = append(repositories, n.repositories...)
+	for _, child := range n.children {
+		repositories = child.flatten(repositories)
+	}
+	return repositories
+}
+
+// gitlabCrawler fetching the projects and subgroups concurrently, the concurrent requests are limited by worker count
+type gitlabCrawler struct {
+	gitlab *GitLab
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+	slots  chan struct{}
+}
+
+// run the fetch function in a worker slot, the others are stopped if it's failed
+func (c *gitlabCrawler) run(fetch func() error) bool {
+	if c.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case c.slots <- struct{}{}:
+	case <-c.ctx.Done():
+		return false
+	}
+	err := fetch()
+	<-c.slots
+	if err != nil {
+		// stop the others, the first error is the one that is returned
+		c.cancel(err)
+		return false
+	}
+	return true
+}
+
+func (c *gitlabCrawler) crawlGroup(groupID int64, node *gitlabNode) {
+	defer c.wg.Done()
+	var projects []GitLabProject
+	var subgroups []GitLabNamespace
+	ok := c.run(func() (err error) {
+		if projects, err = gitlabList[GitLabProject](c.ctx, c.gitlab, c.gitlab.url("groups", fmt.Sprint(groupID), "projects")); err != nil {
+			return errors.Wrapf(err, "while listing projects of group %d", groupID)
+		}
+		if subgroups, err = gitlabList[GitLabNamespace](c.ctx, c.gitlab, c.gitlab.url("groups", fmt.Sprint(groupID), "subgroups")); err != nil {
+			return errors.Wrapf(err, "while listing subgroups of group %d", groupID)
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+
+	node.children = make([]*gitlabNode, len(projects)+len(subgroups))
+	for idx, project := range projects {
+		node.children[idx] = &gitlabNode{}
+		c.wg.Add(1)
+		go c.crawlProject(project, node.children[idx])
+	}
+	for idx, subgroup := range subgroups {
+		node.children[len(projects)+idx] = &gitlabNode{}
+		c.wg.Add(1)
+		go c.crawlGroup(subgroup.ID, node.children[len(projects)+idx])
+	}
+}
+
+func (c *gitlabCrawler) crawlProject(project GitLabProject, node *gitlabNode) {
+	defer c.wg.Done()
+	c.run(func() (err error) {
+		node.repositories, err = c.gitlab.projectRepositories(c.ctx, project)
+		return err
+	})
+}
+
+// Catalog list the registry repositories of project or all of projects in the group and it's subgroups,
+// they are fetched in parallel by the worker count
+func (g *GitLab) Catalog(ctx context.Context) ([]Repository, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var group GitLabNamespace
+	isGroup, err := g.call(ctx, nethttp.MethodGet, g.url("groups", url.PathEscape(g.path)), &group)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching group %s", g.path)
+	}
+
+	// it's not a group, so it must be a project
+	var project GitLabProject
+	if !isGroup {
+		found, err := g.call(ctx, nethttp.MethodGet, g.url("projects", url.PathEscape(g.path)), &project)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while fetching project %s", g.path)
+		}
+		if !found {
+			return nil, fmt.Errorf("no group or project found for %s", g.path)
+		}
+	}
+
+	crawler := &gitlabCrawler{gitlab: g, ctx: ctx, cancel: cancel, slots: make(chan struct{}, g.workerCount)}
+	root := &gitlabNode{}
+	crawler.wg.Add(1)
+	if isGroup {
+		go crawler.crawlGroup(group.ID, root)
+	} else {
+		go crawler.crawlProject(project, root)
+	}
+	crawler.wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	return root.flatten(nil), nil
+}
+
+// projectRepositories fetching the registry repositories of the project then resolving it's tags detail,
+// the tags that are pointing to the same digest will be grouped
+func (g *GitLab) projectRepositories(ctx context.Context, project GitLabProject) ([]Repository, error) {
+	log.Debug().Str("project", project.PathWithNamespace).Msg("processing")
+	registryRepositories, err := gitlabList[GitLabRegistryRepository](ctx, g, g.url("projects", fmt.Sprint(project.ID), "registry", "repositories"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing registry repositories of %s", project.PathWithNamespace)
+	}
+
+	var repositories []Repository
+	for _, registryRepository := range registryRepositories {
+		digests, err := g.digests(ctx, registryRepository)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", registryRepository.Path).Msg("not found any digests found, skipping")
+			continue
+		}
+		g.setRef(registryRepository)
+		repositories = append(repositories, Repository{Name: registryRepository.Location, Digests: digests})
+	}
+	return repositories, nil
+}
+
+func (g *GitLab) digests(ctx context.Context, registryRepository GitLabRegistryRepository) ([]Digest, error) {
+	tagsURL := g.tagsURL(registryRepository.ProjectID, registryRepository.ID)
+	tags, err := gitlabList[GitLabTag](ctx, g, tagsURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing tags of %s", registryRepository.Path)
+	}
+
+	var digests []Digest
+	digestIndex := map[string]int{}
+	for _, tag := range tags {
+		var detail GitLabTag
+		if _, err = callAPI(ctx, g.hc, http.Request{Method: nethttp.MethodGet, URL: h.SlashJoin(tagsURL, url.PathEscape(tag.Name)), Headers: g.headers}, &detail); err != nil {
+			return nil, errors.Wrapf(err, "while fetching tag %s:%s", registryRepository.Path, tag.Name)
+		}
+
+		if idx, ok := digestIndex[detail.Digest]; ok {
+			digests[idx].Tag = append(digests[idx].Tag, tag.Name)
+			continue
+		}
+		digestIndex[detail.Digest] = len(digests)
+		// the created time is taken from image config, there is no pushed time in gitlab's api
+		digests = append(digests, Digest{
+			Name:           detail.Digest,
+			ImageSizeBytes: detail.TotalSize,
+			Tag:            []string{tag.Name},
+			Created:        detail.CreatedAt,
+			Uploaded:       detail.CreatedAt,
+		})
+	}
+	return digests, nil
+}
+
+// Delete through the tag delete api since there is no api for deleting by digest, so the digest must be tagged
+func (g *GitLab) Delete(ctx context.Context, repository Repository) error {
+	ref, err := g.resolveRef(ctx, repository.Name)
+	if err != nil {
+		return err
+	}
+
+	tagsURL := g.tagsURL(ref.projectID, ref.repositoryID)
+	for _, digest := range repository.Digests {
+		if len(digest.Tag) == 0 {
+			return fmt.Errorf("untagged digest %s@%s cannot be deleted through gitlab api", repository.Name, digest.Name)
+		}
+
+		for _, tag := range digest.Tag {
+			tagURL := h.SlashJoin(tagsURL, url.PathEscape(tag))
+			log.Debug().Str("url", tagURL).Msg("deleting tag")
+			found, err := g.call(ctx, nethttp.MethodDelete, tagURL, &GitLabError{})
+			if err != nil {
+				return err
+			}
+			// the other tags of the same manifest can be gone along with the deleted one
+			if !found {
+				log.Debug().Str("url", tagURL).Msg("tag is already deleted")
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef the project and registry repository ids of the repository's location, it's taken from the cache that is
+// filled by catalog, otherwise it's looking for the project by trimming the path from the longest one
+// (eg: my-group/my-project/web, my-group/my-project) since the image name can be nested in the project
+func (g *GitLab) resolveRef(ctx context.Context, location string) (gitlabRepositoryRef, error) {
+	g.mutex.Lock()
+	ref, ok := g.refs[location]
+	g.mutex.Unlock()
+	if ok {
+		return ref, nil
+	}
+
+	_, repoPath, _ := strings.Cut(location, "/")
+	segments := strings.Split(repoPath, "/")
+	for idx := len(segments); idx >= 2; idx-- {
+		projectPath := strings.Join(segments[:idx], "/")
+		var project GitLabProject
+		found, err := g.call(ctx, nethttp.MethodGet, g.url("projects", url.PathEscape(projectPath)), &project)
+		if err != nil {
+			return ref, errors.Wrapf(err, "while fetching project %s", projectPath)
+		}
+		if !found {
+			continue
+		}
+
+		registryRepositories, err := gitlabList[GitLabRegistryRepository](ctx, g, g.url("projects", fmt.Sprint(project.ID), "registry", "repositories"))
+		if err != nil {
+			return ref, errors.Wrapf(err, "while listing registry repositories of %s", projectPath)
+		}
+		for _, registryRepository := range registryRepositories {
+			if registryRepository.Location == location {
+				return g.setRef(registryRepository), nil
+			}
+		}
+		break
+	}
+	return ref, fmt.Errorf("no gitlab registry repository found for %s", location)
+}
+
+func (g *GitLab) setRef(registryRepository GitLabRegistryRepository) gitlabRepositoryRef {
+	ref := gitlabRepositoryRef{projectID: registryRepository.ProjectID, repositoryID: registryRepository.ID}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.refs[registryRepository.Location] = ref
+	return ref
+}
+
+// call the gitlab api by the token headers, found is false if it's replied with not found status
+func (g *GitLab) call(ctx context.Context, method, objURL string, obj errorResponse) (found bool, err error) {
+	resp, err := g.hc.Do(ctx, http.Request{Method: method, URL: objURL, Headers: g.headers}, obj)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == nethttp.StatusNotFound {
+		return false, nil
+	}
+	if err = obj.Err(); err != nil {
+		return false, err
+	}
+	if resp.StatusCode >= nethttp.StatusBadRequest {
+		return false, fmt.Errorf("got status code %d for %s %s", resp.StatusCode, method, objURL)
+	}
+	return true, nil
+}
+
+func (g *GitLab) tagsURL(projectID, repositoryID int64) string {
+	return g.url("projects", fmt.Sprint(projectID), "registry", "repositories", fmt.Sprint(repositoryID), "tags")
+}
+
+func (g *GitLab) url(paths ...string) string {
+	return fmt.Sprintf("%s/%s", g.apiURL, h.SlashJoin(paths...))
+}
+
+// gitlabList fetching all pages of list endpoint by following the Link header
+func gitlabList[T any](ctx context.Context, g *GitLab, listURL string) ([]T, error) {
+	var items []T
+	pageURL := fmt.Sprintf("%s?per_page=%d", listURL, gitlabPageSize)
+	for pageURL != "" {
+		var obj GitLabListResponse[T]
+		resp, err := callAPI(ctx, g.hc, http.Request{Method: nethttp.MethodGet, URL: pageURL, Headers: g.headers}, &obj)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, obj.Items...)
+		pageURL = nextLink(fmt.Sprintf("https://%s", g.host), resp)
+	}
+	return items, nil
+}
@@ -0,0 +1,302 @@
+package registry_test
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	gitlabHost     = "gitlab.company.io"
+	gitlabAPIURL   = fmt.Sprintf("https://%s/api/v4", gitlabHost)
+	gitlabPageSize = "?per_page=100"
+	gitlabDigests  = []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+)
+
+func gitlabURL(paths ...string) string {
+	return hl.SlashJoin(append([]string{gitlabAPIURL}, paths...)...)
+}
+
+func TestGitLab_Catalog(t *testing.T) {
+	appRepo := reg.Repository{
+		Name: "registry.company.io/my-group/app",
+		Digests: []reg.Digest{
+			{
+				Name:           gitlabDigests[0],
+				ImageSizeBytes: 3861526,
+				Tag:            []string{"latest", "v1.0.0"},
+				Created:        time.Date(2023, time.March, 10, 8, 0, 0, 0, time.UTC),
+				Uploaded:       time.Date(2023, time.March, 10, 8, 0, 0, 0, time.UTC),
+			},
+			{
+				Name:           gitlabDigests[1],
+				ImageSizeBytes: 1048576,
+				Tag:            []string{"v0.9.0"},
+				Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+				Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	apiRepo := reg.Repository{
+		Name: "registry.company.io/my-group/sub/api",
+		Digests: []reg.Digest{
+			{
+				Name:           gitlabDigests[1],
+				ImageSizeBytes: 1048576,
+				Tag:            []string{"v1"},
+				Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+				Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	oldTag := reg.GitLabTag{Digest: gitlabDigests[1], CreatedAt: time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC), TotalSize: 1048576}
+
+	projectRoutes := func() map[string]mockRoute {
+		appTagsURL := gitlabURL("projects/100/registry/repositories/1/tags")
+		return map[string]mockRoute{
+			"GET " + gitlabURL("projects/100/registry/repositories"+gitlabPageSize): fixtureRoute("gitlab/registry_repositories.json", nethttp.StatusOK, nil),
+			"GET " + appTagsURL + gitlabPageSize: jsonRoute(
+				[]reg.GitLabTag{{Name: "latest"}, {Name: "v1.0.0"}, {Name: "v0.9.0"}}, nil,
+			),
+			"GET " + hl.SlashJoin(appTagsURL, "latest"): fixtureRoute("gitlab/tag_latest.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(appTagsURL, "v1.0.0"): fixtureRoute("gitlab/tag_latest.json", nethttp.StatusOK, nil),
+			"GET " + hl.SlashJoin(appTagsURL, "v0.9.0"): jsonRoute(oldTag, nil),
+			// the worker repository has no tag
+			"GET " + gitlabURL("projects/100/registry/repositories/2/tags"+gitlabPageSize): jsonRoute([]reg.GitLabTag{}, nil),
+		}
+	}
+	groupRoutes := func() map[string]mockRoute {
+		routes := projectRoutes()
+		extra := map[string]mockRoute{
+			"GET " + gitlabURL("groups/my-group"): jsonRoute(reg.GitLabNamespace{ID: 10, FullPath: "my-group"}, nil),
+			"GET " + gitlabURL("groups/10/projects"+gitlabPageSize): jsonRoute(
+				[]reg.GitLabProject{{ID: 100, PathWithNamespace: "my-group/app"}},
+				map[string]string{"Link": fmt.Sprintf(`<%s>; rel="next"`, gitlabURL("groups/10/projects?page=2&per_page=100"))},
+			),
+			"GET " + gitlabURL("groups/10/projects?page=2&per_page=100"): jsonRoute(
+				[]reg.GitLabProject{{ID: 101, PathWithNamespace: "my-group/web"}}, nil,
+			),
+			"GET " + gitlabURL("groups/10/subgroups"+gitlabPageSize): jsonRoute(
+				[]reg.GitLabNamespace{{ID: 11, FullPath: "my-group/sub"}}, nil,
+			),
+			"GET " + gitlabURL("projects/101/registry/repositories"+gitlabPageSize): jsonRoute([]reg.GitLabRegistryRepository{}, nil),
+			"GET " + gitlabURL("groups/11/projects"+gitlabPageSize): jsonRoute(
+				[]reg.GitLabProject{{ID: 110, PathWithNamespace: "my-group/sub/api"}}, nil,
+			),
+			"GET " + gitlabURL("groups/11/subgroups"+gitlabPageSize): jsonRoute([]reg.GitLabNamespace{}, nil),
+			"GET " + gitlabURL("projects/110/registry/repositories"+gitlabPageSize): jsonRoute(
+				[]reg.GitLabRegistryRepository{{ID: 3, Path: "my-group/sub/api", ProjectID: 110, Location: "registry.company.io/my-group/sub/api"}}, nil,
+			),
+			"GET " + gitlabURL("projects/110/registry/repositories/3/tags"+gitlabPageSize): jsonRoute([]reg.GitLabTag{{Name: "v1"}}, nil),
+			"GET " + gitlabURL("projects/110/registry/repositories/3/tags/v1"):             jsonRoute(oldTag, nil),
+		}
+		for k, v := range extra {
+			routes[k] = v
+		}
+		return routes
+	}
+
+	testCases := map[string]struct {
+		host               string
+		workerCount        int
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"recursively walk the projects and subgroups": {
+			host:               hl.SlashJoin(gitlabHost, "my-group"),
+			workerCount:        3,
+			routes:             groupRoutes,
+			expectRepositories: []reg.Repository{appRepo, apiRepo},
+		},
+		"single project": {
+			host: hl.SlashJoin(gitlabHost, "my-group/app"),
+			routes: func() map[string]mockRoute {
+				routes := projectRoutes()
+				routes["GET "+gitlabURL("groups/my-group%2Fapp")] = fixtureRoute("gitlab/error_not_found.json", nethttp.StatusNotFound, nil)
+				routes["GET "+gitlabURL("projects/my-group%2Fapp")] = jsonRoute(reg.GitLabProject{ID: 100, PathWithNamespace: "my-group/app"}, nil)
+				return routes
+			},
+			expectRepositories: []reg.Repository{appRepo},
+		},
+		"group or project is not found": {
+			host: hl.SlashJoin(gitlabHost, "unknown"),
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + gitlabURL("groups/unknown"):   fixtureRoute("gitlab/error_not_found.json", nethttp.StatusNotFound, nil),
+					"GET " + gitlabURL("projects/unknown"): fixtureRoute("gitlab/error_not_found.json", nethttp.StatusNotFound, nil),
+				}
+			},
+			expectErrMsg: "no group or project found for unknown",
+		},
+		"forbidden to access the tag detail": {
+			host:        hl.SlashJoin(gitlabHost, "my-group"),
+			workerCount: 1,
+			routes: func() map[string]mockRoute {
+				routes := groupRoutes()
+				routes["GET "+gitlabURL("projects/110/registry/repositories/3/tags/v1")] = jsonRoute(reg.GitLabError{Message: "403 Forbidden"}, nil)
+				return routes
+			},
+			expectErrMsg: "while fetching tag my-group/sub/api:v1: [403 Forbidden]",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			gitlab, err := reg.NewGitLab(tc.host, mHc, reg.Option{WorkerCount: tc.workerCount, Username: "me", Password: "glpat-secret"})
+			assert.NoError(t, err)
+			repositories, err := gitlab.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGitLab_Delete(t *testing.T) {
+	workerTagsURL := gitlabURL("projects/100/registry/repositories/2/tags")
+	// the project of nested image is resolved by trimming it's path
+	resolveRoutes := map[string]mockRoute{
+		"GET " + gitlabURL("projects/my-group%2Fapp%2Fworker"):                  fixtureRoute("gitlab/error_not_found.json", nethttp.StatusNotFound, nil),
+		"GET " + gitlabURL("projects/my-group%2Fapp"):                           jsonRoute(reg.GitLabProject{ID: 100, PathWithNamespace: "my-group/app"}, nil),
+		"GET " + gitlabURL("projects/100/registry/repositories"+gitlabPageSize): fixtureRoute("gitlab/registry_repositories.json", nethttp.StatusOK, nil),
+	}
+
+	testCases := map[string]struct {
+		repository   reg.Repository
+		username     string
+		tagRoute     func(deleted *[]string) mockRoute
+		expectErrMsg string
+		expectDelete []string
+	}{
+		"deleting all tags of digest by job token": {
+			repository: reg.Repository{
+				Name:    "registry.company.io/my-group/app/worker",
+				Digests: []reg.Digest{{Name: gitlabDigests[0], Tag: []string{"latest", "v1.0.0"}}, {Name: gitlabDigests[1], Tag: []string{"v0.9.0"}}},
+			},
+			username: "gitlab-ci-token",
+			tagRoute: func(deleted *[]string) mockRoute {
+				return func(r http.Request, _ any) (*http.Response, error) {
+					if r.Headers["JOB-TOKEN"] != "glpat-secret" {
+						return &http.Response{StatusCode: nethttp.StatusUnauthorized}, nil
+					}
+					*deleted = append(*deleted, r.URL)
+					return &http.Response{StatusCode: nethttp.StatusOK}, nil
+				}
+			},
+			expectDelete: []string{hl.SlashJoin(workerTagsURL, "latest"), hl.SlashJoin(workerTagsURL, "v1.0.0"), hl.SlashJoin(workerTagsURL, "v0.9.0")},
+		},
+		"the tag is already deleted along with the other tag": {
+			repository: reg.Repository{
+				Name:    "registry.company.io/my-group/app/worker",
+				Digests: []reg.Digest{{Name: gitlabDigests[0], Tag: []string{"latest", "v1.0.0"}}},
+			},
+			tagRoute: func(deleted *[]string) mockRoute {
+				return func(r http.Request, obj any) (*http.Response, error) {
+					if r.Headers["PRIVATE-TOKEN"] != "glpat-secret" {
+						return &http.Response{StatusCode: nethttp.StatusUnauthorized}, nil
+					}
+					if len(*deleted) != 0 {
+						obj.(*reg.GitLabError).Message = "404 Tag Not Found"
+						return &http.Response{StatusCode: nethttp.StatusNotFound}, nil
+					}
+					*deleted = append(*deleted, r.URL)
+					return &http.Response{StatusCode: nethttp.StatusOK}, nil
+				}
+			},
+			expectDelete: []string{hl.SlashJoin(workerTagsURL, "latest")},
+		},
+		"deletion is rejected": {
+			repository: reg.Repository{
+				Name:    "registry.company.io/my-group/app/worker",
+				Digests: []reg.Digest{{Name: gitlabDigests[0], Tag: []string{"latest"}}},
+			},
+			tagRoute: func(_ *[]string) mockRoute {
+				return func(_ http.Request, obj any) (*http.Response, error) {
+					obj.(*reg.GitLabError).Message = "403 Forbidden"
+					return &http.Response{StatusCode: nethttp.StatusForbidden}, nil
+				}
+			},
+			expectErrMsg: "[403 Forbidden]",
+		},
+		"untagged digest": {
+			repository: reg.Repository{
+				Name:    "registry.company.io/my-group/app/worker",
+				Digests: []reg.Digest{{Name: gitlabDigests[0]}},
+			},
+			expectErrMsg: "untagged digest registry.company.io/my-group/app/worker@sha256:1111111111111111111111111111111111111111111111111111111111111111 cannot be deleted through gitlab api",
+		},
+		"registry repository is not found": {
+			repository: reg.Repository{
+				Name:    "registry.company.io/my-group/other",
+				Digests: []reg.Digest{{Name: gitlabDigests[0], Tag: []string{"latest"}}},
+			},
+			expectErrMsg: "no gitlab registry repository found for registry.company.io/my-group/other",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var deleted []string
+			routes := map[string]mockRoute{
+				"GET " + gitlabURL("projects/my-group%2Fother"): fixtureRoute("gitlab/error_not_found.json", nethttp.StatusNotFound, nil),
+			}
+			for k, v := range resolveRoutes {
+				routes[k] = v
+			}
+			if tc.tagRoute != nil {
+				for _, tag := range []string{"latest", "v1.0.0", "v0.9.0"} {
+					routes["DELETE "+hl.SlashJoin(workerTagsURL, tag)] = tc.tagRoute(&deleted)
+				}
+			}
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, routes)
+
+			username := tc.username
+			if username == "" {
+				username = "me"
+			}
+			gitlab, err := reg.NewGitLab(hl.SlashJoin(gitlabHost, "my-group"), mHc, reg.Option{Username: username, Password: "glpat-secret"})
+			assert.NoError(t, err)
+
+			err = gitlab.Delete(context.Background(), tc.repository)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectDelete, deleted)
+			}
+		})
+	}
+}
+
+func TestNewGitLab(t *testing.T) {
+	_, err := reg.NewGitLab(gitlabHost, nil, reg.Option{})
+	assert.EqualError(t, err, "you must specified group or project path after gitlab host, eg: gitlab.company.io/my-group")
+}
@@ -0,0 +1,237 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const harborPageSize = 100
+
+type HarborProject struct {
+	ProjectID int    `json:"project_id"`
+	Name      string `json:"name"`
+}
+
+type HarborRepository struct {
+	Name          string `json:"name"`
+	ArtifactCount int    `json:"artifact_count"`
+}
+
+type HarborTag struct {
+	Name string `json:"name"`
+}
+
+type HarborReference struct {
+	ChildDigest string    `json:"child_digest"`
+	Platform    *Platform `json:"platform"`
+}
+
+type HarborArtifact struct {
+	Digest            string            `json:"digest"`
+	MediaType         string            `json:"media_type"`
+	ManifestMediaType string            `json:"manifest_media_type"`
+	Size              uint              `json:"size"`
+	PushTime          time.Time         `json:"push_time"`
+	PullTime          time.Time         `json:"pull_time"`
+	Tags              []HarborTag       `json:"tags"`
+	References        []HarborReference `json:"references"`
+	ExtraAttrs        struct {
+		Created time.Time `json:"created"`
+		Platform
+	} `json:"extra_attrs"`
+}
+
+// HarborListResponse the list endpoints are replying json array, but the error is replied as object
+type HarborListResponse[T any] struct {
+	Items []T
+	ErrorsField
+}
+
+func (r *HarborListResponse[T]) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &r.Items)
+	}
+	return json.Unmarshal(data, &r.ErrorsField)
+}
+
+// Harbor is the image registry that using harbor's rest api (/api/v2.0), it's providing the push and pull time
+// which are not available in distribution spec
+type Harbor struct {
+	host    string
+	project string
+	prefix  string
+	hc      http.IHttpClient
+}
+
+// NewHarbor the host can be followed by project and repository path for limiting the catalog,
+// eg: harbor.local/library or harbor.local/library/team-a
+func NewHarbor(host string, hc http.IHttpClient, _ Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 3)
+	hr := &Harbor{host: hostSplt[0], hc: hc}
+	if len(hostSplt) >= 2 {
+		hr.project = hostSplt[1]
+	}
+	if len(hostSplt) == 3 {
+		hr.prefix = strings.Trim(hostSplt[2], "/")
+	}
+	return hr, nil
+}
+
+// Catalog list repositories of all projects (or the one in host) then fetching it's artifacts
+func (hr Harbor) Catalog(ctx context.Context) ([]Repository, error) {
+	projects := []string{hr.project}
+	if hr.project == "" {
+		var err error
+		if projects, err = hr.projects(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var repositories []Repository
+	for _, project := range projects {
+		var repos []HarborRepository
+		err := harborPaginate(ctx, hr, hr.url("projects", project, "repositories"), func(items []HarborRepository) {
+			repos = append(repos, items...)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "while listing repositories of project %s", project)
+		}
+
+		for _, repo := range repos {
+			// the repository name is already prefixed by project name
+			shortName := strings.TrimPrefix(repo.Name, project+"/")
+			if hr.prefix != "" && shortName != hr.prefix && !strings.HasPrefix(shortName, hr.prefix+"/") {
+				continue
+			}
+
+			log.Debug().Str("repo", repo.Name).Msg("processing")
+			digests, err := hr.digests(ctx, project, shortName)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(digests) == 0 {
+				log.Debug().Str("repo", repo.Name).Msg("not found any digests found, skipping")
+				continue
+			}
+			repositories = append(repositories, Repository{Name: h.SlashJoin(hr.host, repo.Name), Digests: digests})
+		}
+	}
+	return repositories, nil
+}
+
+// Delete by artifact digest, the tags that are attached to it will be gone as well
+func (hr Harbor) Delete(ctx context.Context, repository Repository) (err error) {
+	fullName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/", hr.host))
+	nameSplt := strings.SplitN(fullName, "/", 2)
+	if len(nameSplt) != 2 {
+		return fmt.Errorf("invalid harbor repository %s, it must be prefixed by project", repository.Name)
+	}
+
+	for idr := range repository.Digests {
+		artifactURL := hr.artifactsURL(nameSplt[0], nameSplt[1], repository.Digests[idr].Name)
+		log.Debug().Str("url", artifactURL).Msg("deleting artifact")
+		if _, err = callAPI(ctx, hr.hc, http.Request{Method: nethttp.MethodDelete, URL: artifactURL}, &ErrorsField{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hr Harbor) projects(ctx context.Context) ([]string, error) {
+	var projects []string
+	err := harborPaginate(ctx, hr, hr.url("projects"), func(items []HarborProject) {
+		for _, project := range items {
+			projects = append(projects, project.Name)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing projects")
+	}
+	return projects, nil
+}
+
+// digests mapping the artifacts of repository, the created time is taken from image config if it's available.
+// the platform manifest is listed only if it's tagged on it's own, it's linked to the index by the references of index
+func (hr Harbor) digests(ctx context.Context, project, repoName string) ([]Digest, error) {
+	var digests []Digest
+	children := map[string][]Descriptor{}
+	err := harborPaginate(ctx, hr, hr.artifactsURL(project, repoName, "")+"?with_tag=true", func(items []HarborArtifact) {
+		for _, artifact := range items {
+			digest := Digest{
+				Name:           artifact.Digest,
+				ImageSizeBytes: artifact.Size,
+				Created:        artifact.ExtraAttrs.Created,
+				Uploaded:       artifact.PushTime,
+				Pulled:         pulledTime(artifact.PullTime),
+				MediaType:      artifact.ManifestMediaType,
+			}
+			if digest.Created.IsZero() {
+				digest.Created = artifact.PushTime
+			}
+			// the platform of index is taken from it's references
+			if platform := artifact.ExtraAttrs.String(); platform != "" {
+				digest.Platforms = []string{platform}
+			}
+			for _, reference := range artifact.References {
+				if reference.Platform != nil && reference.Platform.String() != "" {
+					digest.Platforms = append(digest.Platforms, reference.Platform.String())
+				}
+				children[artifact.Digest] = append(children[artifact.Digest], Descriptor{Digest: reference.ChildDigest, Platform: reference.Platform})
+			}
+			for _, tag := range artifact.Tags {
+				digest.Tag = append(digest.Tag, tag.Name)
+			}
+			digests = append(digests, digest)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "while listing artifacts of %s/%s", project, repoName)
+	}
+	linkIndexChildren(digests, children)
+	return digests, nil
+}
+
+// harborPaginate fetching all pages of list endpoint by following the Link header
+func harborPaginate[T any](ctx context.Context, hr Harbor, pageURL string, collect func(items []T)) error {
+	sep := "?"
+	if strings.Contains(pageURL, "?") {
+		sep = "&"
+	}
+	pageURL = fmt.Sprintf("%s%spage_size=%d", pageURL, sep, harborPageSize)
+	for pageURL != "" {
+		var obj HarborListResponse[T]
+		resp, err := callAPI(ctx, hr.hc, http.Request{Method: nethttp.MethodGet, URL: pageURL}, &obj)
+		if err != nil {
+			return err
+		}
+		collect(obj.Items)
+		pageURL = nextLink(fmt.Sprintf("https://%s", hr.host), resp)
+	}
+	return nil
+}
+
+// artifactsURL the repository name in path must be encoded twice, eg: team-a/app become team-a%252Fapp
+func (hr Harbor) artifactsURL(project, repoName, digest string) string {
+	artifactURL := hr.url("projects", project, "repositories", url.PathEscape(url.PathEscape(repoName)), "artifacts")
+	if digest != "" {
+		artifactURL = h.SlashJoin(artifactURL, digest)
+	}
+	return artifactURL
+}
+
+func (hr Harbor) url(paths ...string) string {
+	return fmt.Sprintf("https://%s/api/v2.0/%s", hr.host, h.SlashJoin(paths...))
+}
@@ -0,0 +1,230 @@
+package registry_test
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	harborHost   = "harbor.local"
+	harborAPIURL = fmt.Sprintf("https://%s/api/v2.0", harborHost)
+)
+
+func TestHarbor_Catalog(t *testing.T) {
+	artifactsURL := func(project, repo string) string {
+		return hl.SlashJoin(harborAPIURL, "projects", project, "repositories", repo, "artifacts?with_tag=true&page_size=100")
+	}
+	imageDigest := reg.Digest{
+		Name:           "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		ImageSizeBytes: 3861526,
+		Tag:            []string{"latest", "v1.0.0"},
+		Created:        time.Date(2023, time.March, 10, 7, 15, 30, 123456789, time.UTC),
+		Uploaded:       time.Date(2023, time.March, 10, 8, 0, 0, 0, time.UTC),
+		Pulled:         pulledAt(time.Date(2023, time.April, 1, 10, 30, 0, 0, time.UTC)),
+		MediaType:      reg.MediaTypeDockerManifest,
+		Platforms:      []string{"linux/amd64"},
+	}
+	// no image config attributes and never pulled, the platforms are taken from it's references
+	indexDigest := reg.Digest{
+		Name:           "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		ImageSizeBytes: 7723052,
+		Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+		Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+		MediaType:      reg.MediaTypeOCIIndex,
+		Platforms:      []string{"linux/amd64", "linux/arm64/v8"},
+	}
+
+	successRoutes := func() map[string]mockRoute {
+		return map[string]mockRoute{
+			"GET " + hl.SlashJoin(harborAPIURL, "projects?page_size=100"): jsonRoute(
+				[]reg.HarborProject{{ProjectID: 1, Name: "library"}},
+				map[string]string{"Link": `</api/v2.0/projects?page=2&page_size=100>; rel="next"`},
+			),
+			"GET " + hl.SlashJoin(harborAPIURL, "projects?page=2&page_size=100"): jsonRoute(
+				[]reg.HarborProject{{ProjectID: 2, Name: "team-a"}}, nil,
+			),
+			"GET " + hl.SlashJoin(harborAPIURL, "projects", "library", "repositories?page_size=100"): jsonRoute(
+				[]reg.HarborRepository{{Name: "library/nginx", ArtifactCount: 0}}, nil,
+			),
+			"GET " + hl.SlashJoin(harborAPIURL, "projects", "team-a", "repositories?page_size=100"): jsonRoute(
+				[]reg.HarborRepository{{Name: "team-a/backend/api", ArtifactCount: 2}, {Name: "team-a/frontend", ArtifactCount: 2}}, nil,
+			),
+			"GET " + artifactsURL("library", "nginx"): jsonRoute([]reg.HarborArtifact{}, nil),
+			"GET " + artifactsURL("team-a", "backend%252Fapi"): fixtureRoute(
+				"harbor/artifacts.json", nethttp.StatusOK, nil,
+			),
+			"GET " + artifactsURL("team-a", "frontend"): fixtureRoute(
+				"harbor/artifacts.json", nethttp.StatusOK, nil,
+			),
+		}
+	}
+
+	testCases := map[string]struct {
+		host               string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"error while listing projects": {
+			host: harborHost,
+			routes: func() map[string]mockRoute {
+				routes := successRoutes()
+				routes["GET "+hl.SlashJoin(harborAPIURL, "projects?page_size=100")] = fixtureRoute("", nethttp.StatusUnauthorized, nil)
+				return routes
+			},
+			expectErrMsg: fmt.Sprintf("while listing projects: got status code 401 for GET %s", hl.SlashJoin(harborAPIURL, "projects?page_size=100")),
+		},
+		"project is not found": {
+			host: hl.SlashJoin(harborHost, "team-x"),
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + hl.SlashJoin(harborAPIURL, "projects", "team-x", "repositories?page_size=100"): fixtureRoute(
+						"harbor/error_not_found.json", nethttp.StatusNotFound, nil,
+					),
+				}
+			},
+			expectErrMsg: "while listing repositories of project team-x: [NOT_FOUND] [project team-x not found]",
+		},
+		"all projects and following pagination": {
+			host:   harborHost,
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "harbor.local/team-a/backend/api", Digests: []reg.Digest{imageDigest, indexDigest}},
+				{Name: "harbor.local/team-a/frontend", Digests: []reg.Digest{imageDigest, indexDigest}},
+			},
+		},
+		"linking the platform manifest that is tagged on it's own": {
+			host: hl.SlashJoin(harborHost, "team-a", "frontend"),
+			routes: func() map[string]mockRoute {
+				pushed := time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC)
+				routes := successRoutes()
+				routes["GET "+artifactsURL("team-a", "frontend")] = jsonRoute([]reg.HarborArtifact{
+					{
+						Digest: multiArchIndex, ManifestMediaType: reg.MediaTypeOCIIndex, PushTime: pushed,
+						Tags:       []reg.HarborTag{{Name: "stable"}},
+						References: []reg.HarborReference{{ChildDigest: multiArchChildren[0], Platform: &reg.Platform{OS: "linux", Architecture: "amd64"}}},
+					},
+					{Digest: multiArchChildren[0], ManifestMediaType: reg.MediaTypeOCIManifest, PushTime: pushed, Tags: []reg.HarborTag{{Name: "stable-amd64"}}},
+				}, nil)
+				return routes
+			},
+			expectRepositories: []reg.Repository{
+				{Name: "harbor.local/team-a/frontend", Digests: []reg.Digest{
+					{
+						Name: multiArchIndex, Tag: []string{"stable"}, MediaType: reg.MediaTypeOCIIndex, Platforms: []string{"linux/amd64"},
+						Created: time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC), Uploaded: time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+					},
+					{
+						Name: multiArchChildren[0], Tag: []string{"stable-amd64"}, MediaType: reg.MediaTypeOCIManifest, ParentDigests: []string{multiArchIndex},
+						Platforms: []string{"linux/amd64"},
+						Created:   time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC), Uploaded: time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+					},
+				}},
+			},
+		},
+		"only repositories under the project and prefix": {
+			host:   hl.SlashJoin(harborHost, "team-a", "backend"),
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "harbor.local/team-a/backend/api", Digests: []reg.Digest{imageDigest, indexDigest}},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			harbor, err := reg.NewHarbor(tc.host, mHc, reg.Option{})
+			assert.NoError(t, err)
+			repositories, err := harbor.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHarbor_Delete(t *testing.T) {
+	sampleRepo := reg.Repository{
+		Name: "harbor.local/team-a/backend/api",
+		Digests: []reg.Digest{
+			{Name: "sha256:1111111111111111111111111111111111111111111111111111111111111111", Tag: []string{"latest"}},
+			{Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Tag: []string{"v0.9.0"}},
+		},
+	}
+	artifactsURL := hl.SlashJoin(harborAPIURL, "projects", "team-a", "repositories", "backend%252Fapi", "artifacts")
+
+	testCases := map[string]struct {
+		repo           reg.Repository
+		mockHTTPClient func(*mh.MockIHttpClient)
+		expectErrMsg   string
+	}{
+		"deleting by artifact digest": {
+			repo: sampleRepo,
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(artifactsURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusOK}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(artifactsURL, sampleRepo.Digests[1].Name)}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusOK}, nil),
+				)
+			},
+		},
+		"artifact is protected by immutable tag rule": {
+			repo: sampleRepo,
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(artifactsURL, sampleRepo.Digests[0].Name)}, gomock.Any()).
+					Times(1).DoAndReturn(func(_ context.Context, r http.Request, errFields *reg.ErrorsField) (*http.Response, error) {
+					errFields.Errors = []reg.ErrorField{{Code: "PRECONDITION", Message: "the operation isn't allowed as the tag is immutable"}}
+					return &http.Response{StatusCode: nethttp.StatusPreconditionFailed}, nil
+				})
+			},
+			expectErrMsg: "[PRECONDITION] [the operation isn't allowed as the tag is immutable]",
+		},
+		"repository without project": {
+			repo:           reg.Repository{Name: "harbor.local/nginx", Digests: sampleRepo.Digests},
+			mockHTTPClient: func(m *mh.MockIHttpClient) {},
+			expectErrMsg:   "invalid harbor repository harbor.local/nginx, it must be prefixed by project",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			tc.mockHTTPClient(mHc)
+
+			harbor, err := reg.NewHarbor(harborHost, mHc, reg.Option{})
+			assert.NoError(t, err)
+
+			err = harbor.Delete(context.Background(), tc.repo)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@
 package mock_registry
 
 import (
+	context "context"
 	reflect "reflect"
 
 	registry "github.com/iomarmochtar/cir-rotator/pkg/registry"
@@ -41,30 +42,30 @@ func (m *MockImageRegistry) EXPECT() *MockImageRegistryMockRecorder {
 }
 
 // Catalog mocks base method.
-func (m *MockImageRegistry) Catalog() ([]registry.Repository, error) {
+func (m *MockImageRegistry) Catalog(ctx context.Context) ([]registry.Repository, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Catalog")
+	ret := m.ctrl.Call(m, "Catalog", ctx)
 	ret0, _ := ret[0].([]registry.Repository)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Catalog indicates an expected call of Catalog.
-func (mr *MockImageRegistryMockRecorder) Catalog() *gomock.Call {
+func (mr *MockImageRegistryMockRecorder) Catalog(ctx any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Catalog", reflect.TypeOf((*MockImageRegistry)(nil).Catalog))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Catalog", reflect.TypeOf((*MockImageRegistry)(nil).Catalog), ctx)
 }
 
 // Delete mocks base method.
-func (m *MockImageRegistry) Delete(repo registry.Repository) error {
+func (m *MockImageRegistry) Delete(ctx context.Context, repo registry.Repository) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", repo)
+	ret := m.ctrl.Call(m, "Delete", ctx, repo)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Delete indicates an expected call of Delete.
-func (mr *MockImageRegistryMockRecorder) Delete(repo any) *gomock.Call {
+func (mr *MockImageRegistryMockRecorder) Delete(ctx, repo any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockImageRegistry)(nil).Delete), repo)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockImageRegistry)(nil).Delete), ctx, repo)
 }
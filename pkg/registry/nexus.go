@@ -0,0 +1,232 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+// nexusAPIPath the path of rest api under the nexus instance, the docker repository is under /repository/<name>
+const nexusAPIPath = "/service/rest/v1"
+
+type NexusChecksum struct {
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+type NexusAsset struct {
+	ID             string        `json:"id"`
+	Path           string        `json:"path"`
+	DownloadURL    string        `json:"downloadUrl"`
+	ContentType    string        `json:"contentType"`
+	Checksum       NexusChecksum `json:"checksum"`
+	FileSize       uint          `json:"fileSize"`
+	BlobCreated    time.Time     `json:"blobCreated"`
+	LastModified   time.Time     `json:"lastModified"`
+	LastDownloaded time.Time     `json:"lastDownloaded"`
+}
+
+type NexusComponent struct {
+	ID         string       `json:"id"`
+	Repository string       `json:"repository"`
+	Format     string       `json:"format"`
+	Name       string       `json:"name"`
+	Version    string       `json:"version"`
+	Assets     []NexusAsset `json:"assets"`
+}
+
+type NexusSearchResponse struct {
+	Items             []NexusComponent `json:"items"`
+	ContinuationToken string           `json:"continuationToken"`
+}
+
+// Err nexus is not replying the error in json body, so it's only determined by the status code
+func (NexusSearchResponse) Err() error {
+	return nil
+}
+
+// Nexus is using the components search api of nexus 3 for docker (hosted) repository, each of component is an image tag
+// and it's manifest asset has the blob created and last downloaded time
+type Nexus struct {
+	host       string
+	repository string
+	prefix     string
+	baseURL    string
+	apiURL     string
+	hc         http.IHttpClient
+}
+
+// NewNexus the host must be followed by the name of docker repository in nexus and it can be followed by image prefix,
+// eg: nexus.company.com/docker-hosted or nexus.company.com/docker-hosted/team-a
+func NewNexus(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 3)
+	if len(hostSplt) == 1 {
+		return nil, fmt.Errorf("you must specified repository name after nexus host, eg: nexus.company.com/docker-hosted")
+	}
+
+	n := &Nexus{
+		host:       hostSplt[0],
+		repository: hostSplt[1],
+		baseURL:    fmt.Sprintf("https://%s", hostSplt[0]),
+		hc:         hc,
+	}
+	n.apiURL = n.baseURL + nexusAPIPath
+	if len(hostSplt) == 3 {
+		n.prefix = strings.Trim(hostSplt[2], "/")
+	}
+	// the docker repository is served by the same instance of the api endpoint
+	if opt.Endpoint != "" {
+		n.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+		n.baseURL = strings.TrimSuffix(n.apiURL, nexusAPIPath)
+	}
+	return n, nil
+}
+
+// Catalog list all docker components of the repository, the components (tags) of the same image are grouped by it's manifest digest
+func (n *Nexus) Catalog(ctx context.Context) ([]Repository, error) {
+	components, err := n.search(ctx, url.Values{})
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing components")
+	}
+
+	var repositories []Repository
+	repoIndex := map[string]int{}
+	for _, component := range components {
+		if n.prefix != "" && component.Name != n.prefix && !strings.HasPrefix(component.Name, n.prefix+"/") {
+			continue
+		}
+
+		idx, ok := repoIndex[component.Name]
+		if !ok {
+			log.Debug().Str("repo", component.Name).Msg("processing")
+			idx = len(repositories)
+			repoIndex[component.Name] = idx
+			repositories = append(repositories, Repository{Name: h.SlashJoin(n.host, n.repository, component.Name)})
+		}
+
+		if repositories[idx].Digests, err = n.addDigest(ctx, repositories[idx].Digests, component); err != nil {
+			return nil, err
+		}
+	}
+
+	//nolint:prealloc
+	var result []Repository
+	for _, repository := range repositories {
+		if len(repository.Digests) == 0 {
+			log.Debug().Str("repo", repository.Name).Msg("not found any digests found, skipping")
+			continue
+		}
+		result = append(result, repository)
+	}
+	return result, nil
+}
+
+// addDigest the created and uploaded time is the earliest blob created of the tags and the pulled time is the latest download of them,
+// the file size of manifest asset is not the image size so it's calculated from the manifest content
+func (n *Nexus) addDigest(ctx context.Context, digests []Digest, component NexusComponent) ([]Digest, error) {
+	manifestPath := fmt.Sprintf("v2/%s/manifests/%s", component.Name, component.Version)
+	var asset *NexusAsset
+	for ida := range component.Assets {
+		if component.Assets[ida].Path == manifestPath {
+			asset = &component.Assets[ida]
+			break
+		}
+	}
+	// the platform manifest of an index is stored by it's digest
+	if asset == nil || asset.Checksum.SHA256 == "" || strings.HasPrefix(component.Version, "sha256:") {
+		log.Debug().Str("repo", component.Name).Str("version", component.Version).Msg("no tag manifest for component, skipping")
+		return digests, nil
+	}
+
+	name := fmt.Sprintf("sha256:%s", asset.Checksum.SHA256)
+	created := asset.BlobCreated.UTC()
+	pulled := asset.LastDownloaded.UTC()
+	for idx := range digests {
+		if digests[idx].Name != name {
+			continue
+		}
+		digests[idx].Tag = append(digests[idx].Tag, component.Version)
+		if created.Before(digests[idx].Uploaded) {
+			digests[idx].Created = created
+			digests[idx].Uploaded = created
+		}
+		if pulled.After(digests[idx].PulledAt()) {
+			digests[idx].Pulled = pulledTime(pulled)
+		}
+		return digests, nil
+	}
+
+	size, err := manifestSize(ctx, n.hc, func(reference string) string {
+		return h.SlashJoin(n.baseURL, "repository", n.repository, "v2", component.Name, "manifests", reference)
+	}, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(digests, Digest{
+		Name:           name,
+		ImageSizeBytes: size,
+		Tag:            []string{component.Version},
+		Created:        created,
+		Uploaded:       created,
+		Pulled:         pulledTime(pulled),
+	}), nil
+}
+
+// Delete by deleting the components of all tags of the digest, the component id is searched by the image name and tag
+func (n *Nexus) Delete(ctx context.Context, repository Repository) error {
+	imageName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/%s/", n.host, n.repository))
+	for _, digest := range repository.Digests {
+		if len(digest.Tag) == 0 {
+			return fmt.Errorf("untagged digest %s@%s cannot be deleted through nexus api", repository.Name, digest.Name)
+		}
+
+		for _, tag := range digest.Tag {
+			components, err := n.search(ctx, url.Values{"name": {imageName}, "version": {tag}})
+			if err != nil {
+				return errors.Wrapf(err, "while searching component %s:%s", imageName, tag)
+			}
+			if len(components) == 0 {
+				return fmt.Errorf("no nexus component found for %s:%s", repository.Name, tag)
+			}
+
+			for _, component := range components {
+				componentURL := h.SlashJoin(n.apiURL, "components", url.PathEscape(component.ID))
+				log.Debug().Str("url", componentURL).Msg("deleting component")
+				if _, err = callAPI(ctx, n.hc, http.Request{Method: nethttp.MethodDelete, URL: componentURL}, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// search the docker components of repository by the additional query, the pages are followed by continuation token
+func (n *Nexus) search(ctx context.Context, query url.Values) ([]NexusComponent, error) {
+	query.Set("repository", n.repository)
+	query.Set("format", "docker")
+
+	var components []NexusComponent
+	for {
+		var obj NexusSearchResponse
+		if _, err := callAPI(ctx, n.hc, http.Request{Method: nethttp.MethodGet, URL: fmt.Sprintf("%s/search?%s", n.apiURL, query.Encode())}, &obj); err != nil {
+			return nil, err
+		}
+		components = append(components, obj.Items...)
+
+		if obj.ContinuationToken == "" {
+			return components, nil
+		}
+		query.Set("continuationToken", obj.ContinuationToken)
+	}
+}
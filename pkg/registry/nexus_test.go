@@ -0,0 +1,205 @@
+package registry_test
+
+import (
+	"context"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	nexusAPIURL    = "https://nexus.company.io/service/rest/v1"
+	nexusSearchURL = hl.SlashJoin(nexusAPIURL, "search") + "?"
+	nexusDigests   = []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+)
+
+func TestNexus_Catalog(t *testing.T) {
+	imageSize := uint(1472 + 2811478 + 1048576)
+	appDigests := []reg.Digest{
+		// the earliest blob created and the latest download of the tags
+		{
+			Name:           nexusDigests[0],
+			ImageSizeBytes: imageSize,
+			Tag:            []string{"1.0.0", "latest"},
+			Created:        time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Pulled:         pulledAt(time.Date(2023, time.April, 1, 10, 0, 0, 0, time.UTC)),
+		},
+		// the manifest list size is the sum of it's platform images and never downloaded
+		{
+			Name:           nexusDigests[1],
+			ImageSizeBytes: imageSize * 2,
+			Tag:            []string{"multi-arch"},
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+		},
+	}
+
+	// successRoutes the api and the docker repository are served under the base url of nexus
+	successRoutes := func(baseURL string) map[string]mockRoute {
+		searchURL := hl.SlashJoin(baseURL, "service/rest/v1/search") + "?"
+		routes := map[string]mockRoute{
+			"GET " + searchURL + "format=docker&repository=docker-hosted": fixtureRoute("nexus/search.json", nethttp.StatusOK, nil),
+			"GET " + searchURL + "continuationToken=88491cd1d185dd136f143f20c4e7d50c&format=docker&repository=docker-hosted": jsonRoute(reg.NexusSearchResponse{
+				Items: []reg.NexusComponent{{
+					ID:      "ZG9ja2VyLWhvc3RlZDpmZDFhYjQ0ZTk1Y2M5ZTk5",
+					Name:    "team-b/web",
+					Version: "v1",
+					Assets: []reg.NexusAsset{{
+						Path:        "v2/team-b/web/manifests/v1",
+						Checksum:    reg.NexusChecksum{SHA256: "1111111111111111111111111111111111111111111111111111111111111111"},
+						BlobCreated: time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+					}},
+				}},
+			}, nil),
+		}
+		for _, repoName := range []string{"team-a/app", "team-b/web"} {
+			for k, v := range manifestRoutes(hl.SlashJoin(baseURL, "repository/docker-hosted/v2"), repoName) {
+				routes[k] = v
+			}
+		}
+		return routes
+	}
+
+	testCases := map[string]struct {
+		host               string
+		endpoint           string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"unauthorized": {
+			host: "nexus.company.io/docker-hosted",
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + nexusSearchURL + "format=docker&repository=docker-hosted": fixtureRoute("", nethttp.StatusUnauthorized, nil),
+				}
+			},
+			expectErrMsg: "while listing components: got status code 401 for GET https://nexus.company.io/service/rest/v1/search?format=docker&repository=docker-hosted",
+		},
+		"following continuation token and skipping the platform manifest": {
+			host:   "nexus.company.io/docker-hosted",
+			routes: func() map[string]mockRoute { return successRoutes("https://nexus.company.io") },
+			expectRepositories: []reg.Repository{
+				{Name: "nexus.company.io/docker-hosted/team-a/app", Digests: appDigests},
+				{Name: "nexus.company.io/docker-hosted/team-b/web", Digests: []reg.Digest{{
+					Name:           nexusDigests[0],
+					ImageSizeBytes: imageSize,
+					Tag:            []string{"v1"},
+					Created:        time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+					Uploaded:       time.Date(2023, time.February, 1, 8, 0, 0, 0, time.UTC),
+				}}},
+			},
+		},
+		"the docker repository is under the endpoint": {
+			host:     "nexus.company.io/docker-hosted/team-a",
+			endpoint: "http://localhost:8081/service/rest/v1/",
+			routes:   func() map[string]mockRoute { return successRoutes("http://localhost:8081") },
+			expectRepositories: []reg.Repository{
+				{Name: "nexus.company.io/docker-hosted/team-a/app", Digests: appDigests},
+			},
+		},
+		"only images under the prefix": {
+			host:   "nexus.company.io/docker-hosted/team-a",
+			routes: func() map[string]mockRoute { return successRoutes("https://nexus.company.io") },
+			expectRepositories: []reg.Repository{
+				{Name: "nexus.company.io/docker-hosted/team-a/app", Digests: appDigests},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			nexus, err := reg.NewNexus(tc.host, mHc, reg.Option{Endpoint: tc.endpoint})
+			assert.NoError(t, err)
+			repositories, err := nexus.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNexus_Delete(t *testing.T) {
+	searchRoute := func(tag, id string) (string, mockRoute) {
+		return "GET " + nexusSearchURL + "format=docker&name=team-a%2Fapp&repository=docker-hosted&version=" + tag,
+			jsonRoute(reg.NexusSearchResponse{Items: []reg.NexusComponent{{ID: id, Name: "team-a/app", Version: tag}}}, nil)
+	}
+
+	testCases := map[string]struct {
+		digests      []reg.Digest
+		expectErrMsg string
+		expectDelete []string
+	}{
+		"deleting components of all tags": {
+			digests: []reg.Digest{{Name: nexusDigests[0], Tag: []string{"1.0.0", "latest"}}},
+			expectDelete: []string{
+				hl.SlashJoin(nexusAPIURL, "components", "ZG9ja2VyLWhvc3RlZDo2ZjFmMmU0YzA3YjQ1YjFk"),
+				hl.SlashJoin(nexusAPIURL, "components", "ZG9ja2VyLWhvc3RlZDpiNjE1ZjQzZjYwNjM3YzQx"),
+			},
+		},
+		"component is not found": {
+			digests:      []reg.Digest{{Name: nexusDigests[1], Tag: []string{"multi-arch"}}},
+			expectErrMsg: "no nexus component found for nexus.company.io/docker-hosted/team-a/app:multi-arch",
+		},
+		"untagged digest": {
+			digests:      []reg.Digest{{Name: nexusDigests[0]}},
+			expectErrMsg: "untagged digest nexus.company.io/docker-hosted/team-a/app@sha256:1111111111111111111111111111111111111111111111111111111111111111 cannot be deleted through nexus api",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var deleted []string
+			deleteRoute := func(r http.Request, _ any) (*http.Response, error) {
+				deleted = append(deleted, r.URL)
+				return &http.Response{StatusCode: nethttp.StatusNoContent}, nil
+			}
+			routes := map[string]mockRoute{
+				"GET " + nexusSearchURL + "format=docker&name=team-a%2Fapp&repository=docker-hosted&version=multi-arch": jsonRoute(reg.NexusSearchResponse{}, nil),
+			}
+			for tag, id := range map[string]string{"1.0.0": "ZG9ja2VyLWhvc3RlZDo2ZjFmMmU0YzA3YjQ1YjFk", "latest": "ZG9ja2VyLWhvc3RlZDpiNjE1ZjQzZjYwNjM3YzQx"} {
+				key, route := searchRoute(tag, id)
+				routes[key] = route
+				routes["DELETE "+hl.SlashJoin(nexusAPIURL, "components", id)] = deleteRoute
+			}
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, routes)
+
+			nexus, err := reg.NewNexus("nexus.company.io/docker-hosted", mHc, reg.Option{})
+			assert.NoError(t, err)
+
+			err = nexus.Delete(context.Background(), reg.Repository{Name: "nexus.company.io/docker-hosted/team-a/app", Digests: tc.digests})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectDelete, deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const (
+	ociLayoutFile        = "oci-layout"
+	ociIndexFile         = "index.json"
+	ociBlobsDir          = "blobs"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+var reOCIDigest = regexp.MustCompile(`^([a-z0-9]+(?:[.+_-][a-z0-9]+)*):([a-zA-Z0-9=_-]+)$`)
+
+// OCIIndexEntry is the manifest descriptor in index.json, the tag is taken from it's ref name annotation
+type OCIIndexEntry struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        uint              `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// OCILayout is treating a directory tree as registry, each directory that contains oci-layout file is a repository.
+// it's not calling any api, so the http client is not used
+type OCILayout struct {
+	root string
+	mu   sync.Mutex
+	refs map[string]*ociBlobRefs
+}
+
+// ociBlobRefs the blobs that are referenced by the manifests of index.json, it's collected once for each image layout
+// so the kept manifests are not reread and the blobs directory is not rescanned for every deleted digest
+type ociBlobRefs struct {
+	// manifests the blobs of each manifest in index.json, including it's own blob and the child manifests
+	manifests map[string][]string
+	// counts the number of manifests in index.json that are referencing the blob
+	counts map[string]int
+}
+
+// NewOCILayout the host is the root directory of image layouts, eg: /srv/mirror
+func NewOCILayout(host string, _ http.IHttpClient, _ Option) (ImageRegistry, error) {
+	root := filepath.Clean(host)
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading oci layout root")
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("oci layout root %s is not a directory", root)
+	}
+	return &OCILayout{root: root, refs: map[string]*ociBlobRefs{}}, nil
+}
+
+// Catalog walk the root directory for the image layouts, the repository name is the path of image layout
+func (o *OCILayout) Catalog(ctx context.Context) ([]Repository, error) {
+	var repositories []Repository
+	err := filepath.WalkDir(o.root, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ociBlobsDir && isOCILayout(filepath.Dir(dir)) {
+			return filepath.SkipDir
+		}
+		if !isOCILayout(dir) {
+			return nil
+		}
+
+		repoName := filepath.ToSlash(dir)
+		log.Debug().Str("repo", repoName).Msg("processing")
+		digests, err := o.digests(dir)
+		if err != nil {
+			return errors.Wrapf(err, "while reading image layout %s", repoName)
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", repoName).Msg("not found any digests found, skipping")
+			return nil
+		}
+		repositories = append(repositories, Repository{Name: repoName, Digests: digests})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repositories, nil
+}
+
+// digests the entries of index.json are grouped by the digest, the entry without ref name annotation is untagged
+func (o *OCILayout) digests(dir string) ([]Digest, error) {
+	entries, _, err := readOCIIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []Digest
+	digestIndex := map[string]int{}
+	children := map[string][]Descriptor{}
+	for _, entry := range entries {
+		tag := entry.Annotations[ociRefNameAnnotation]
+		if idx, ok := digestIndex[entry.Digest]; ok {
+			if tag != "" {
+				digests[idx].Tag = append(digests[idx].Tag, tag)
+			}
+			continue
+		}
+
+		digest, manifests, err := describeOCIBlob(dir, entry.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if digest.MediaType == "" {
+			digest.MediaType = entry.MediaType
+		}
+		if tag != "" {
+			digest.Tag = []string{tag}
+		}
+		children[entry.Digest] = manifests
+		digestIndex[entry.Digest] = len(digests)
+		digests = append(digests, digest)
+	}
+	linkIndexChildren(digests, children)
+	return digests, nil
+}
+
+// Delete removing the entries of digests from index.json then the blobs that are not referenced anymore are removed
+func (o *OCILayout) Delete(_ context.Context, repository Repository) error {
+	dir := filepath.FromSlash(repository.Name)
+	if rel, err := filepath.Rel(o.root, dir); err != nil || strings.HasPrefix(rel, "..") || !isOCILayout(dir) {
+		return fmt.Errorf("%s is not an image layout under %s", repository.Name, o.root)
+	}
+
+	entries, raw, err := readOCIIndex(dir)
+	if err != nil {
+		return err
+	}
+	refs, err := o.blobRefs(dir, entries)
+	if err != nil {
+		return errors.Wrap(err, "while collecting referenced blobs")
+	}
+
+	deleted := map[string]bool{}
+	for _, digest := range repository.Digests {
+		deleted[digest.Name] = true
+	}
+
+	var keptEntries []json.RawMessage
+	var removed []string
+	for idx, entry := range entries {
+		if !deleted[entry.Digest] {
+			keptEntries = append(keptEntries, raw[idx])
+			continue
+		}
+		log.Debug().Str("repo", repository.Name).Str("digest", entry.Digest).Msg("removing index entry")
+		removed = append(removed, entry.Digest)
+	}
+
+	if err = writeOCIIndex(dir, keptEntries); err != nil {
+		return err
+	}
+	return refs.release(dir, removed)
+}
+
+// blobRefs the referenced blobs of image layout, the blobs that are not referenced by any manifest are removed while collecting them.
+// the image layout is only changed by the deletion, and each repository is deleted by one worker
+func (o *OCILayout) blobRefs(dir string, entries []OCIIndexEntry) (*ociBlobRefs, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if refs, ok := o.refs[dir]; ok {
+		return refs, nil
+	}
+
+	refs := &ociBlobRefs{manifests: map[string][]string{}, counts: map[string]int{}}
+	for _, entry := range entries {
+		if _, ok := refs.manifests[entry.Digest]; ok {
+			continue
+		}
+		referenced := map[string]bool{}
+		if err := markOCIBlob(dir, entry.Digest, true, referenced); err != nil {
+			return nil, err
+		}
+		refs.manifests[entry.Digest] = make([]string, 0, len(referenced))
+		for blob := range referenced {
+			refs.manifests[entry.Digest] = append(refs.manifests[entry.Digest], blob)
+			refs.counts[blob]++
+		}
+	}
+
+	if err := gcOCIBlobs(dir, refs.counts); err != nil {
+		return nil, err
+	}
+	o.refs[dir] = refs
+	return refs, nil
+}
+
+// release the blobs of removed manifests are removed if they are not referenced by the other manifests anymore
+func (r *ociBlobRefs) release(dir string, removed []string) error {
+	for _, name := range removed {
+		blobs, ok := r.manifests[name]
+		if !ok {
+			continue
+		}
+		delete(r.manifests, name)
+		for _, blob := range blobs {
+			if r.counts[blob]--; r.counts[blob] > 0 {
+				continue
+			}
+			delete(r.counts, blob)
+			blobPath, err := ociBlobPath(dir, blob)
+			if err != nil {
+				return err
+			}
+			log.Debug().Str("path", blobPath).Msg("removing unreferenced blob")
+			if err = os.Remove(blobPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isOCILayout(dir string) bool {
+	for _, name := range []string{ociLayoutFile, ociIndexFile} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// readOCIIndex returning the manifest entries of index.json and it's raw json, so the unknown fields are kept while rewriting it
+func readOCIIndex(dir string) ([]OCIIndexEntry, []json.RawMessage, error) {
+	data, err := os.ReadFile(filepath.Clean(filepath.Join(dir, ociIndexFile)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var index struct {
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, nil, errors.Wrap(err, "while parsing index.json")
+	}
+
+	entries := make([]OCIIndexEntry, len(index.Manifests))
+	for idx, raw := range index.Manifests {
+		if err = json.Unmarshal(raw, &entries[idx]); err != nil {
+			return nil, nil, errors.Wrap(err, "while parsing index.json entry")
+		}
+	}
+	return entries, index.Manifests, nil
+}
+
+// writeOCIIndex replacing the manifests of index.json, it's written to temporary file first so it's never left half written
+func writeOCIIndex(dir string, manifests []json.RawMessage) error {
+	path := filepath.Join(dir, ociIndexFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+
+	var index map[string]json.RawMessage
+	if err = json.Unmarshal(data, &index); err != nil {
+		return errors.Wrap(err, "while parsing index.json")
+	}
+	if manifests == nil {
+		manifests = []json.RawMessage{}
+	}
+	if index["manifests"], err = json.Marshal(manifests); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(index); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// describeOCIBlob the size and created time of manifest, for index the size is the sum of it's child
+// and the created time is the latest one of them. the uploaded time is following the created time.
+// the child manifests of index are returned as well
+func describeOCIBlob(dir, name string) (digest Digest, children []Descriptor, err error) {
+	var manifest ManifestResponse
+	if err = readOCIBlob(dir, name, &manifest); err != nil {
+		return digest, nil, errors.Wrapf(err, "while reading manifest %s", name)
+	}
+
+	digest.Name = name
+	digest.MediaType = manifest.MediaType
+	digest.ArtifactType = manifest.GetArtifactType()
+	if manifest.Subject != nil {
+		digest.Subject = manifest.Subject.Digest
+	}
+	if manifest.IsIndex() {
+		digest.Platforms = manifest.Platforms()
+		for _, child := range manifest.Manifests {
+			childDigest, _, err := describeOCIBlob(dir, child.Digest)
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Warn().Str("repo", filepath.ToSlash(dir)).Str("digest", child.Digest).Err(err).Msg("child manifest is not found in blobs, skipping")
+				continue
+			}
+			if err != nil {
+				return digest, nil, err
+			}
+			digest.ImageSizeBytes += childDigest.ImageSizeBytes
+			if childDigest.Created.After(digest.Created) {
+				digest.Created = childDigest.Created
+			}
+		}
+		digest.Uploaded = digest.Created
+		return digest, manifest.Manifests, nil
+	}
+
+	digest.ImageSizeBytes = manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		digest.ImageSizeBytes += layer.Size
+	}
+
+	var config ImageConfigResponse
+	if err = readOCIBlob(dir, manifest.Config.Digest, &config); err != nil {
+		return digest, nil, errors.Wrapf(err, "while reading config blob %s", manifest.Config.Digest)
+	}
+	digest.Created = config.Created
+	digest.Uploaded = config.Created
+	digest.Platforms = config.Platforms()
+	return digest, nil, nil
+}
+
+// markOCIBlob marking the blob as referenced, for manifest it's config, layers and child are marked as well.
+// the child manifest that is not found in blobs is not marked
+func markOCIBlob(dir, name string, isManifest bool, referenced map[string]bool) error {
+	if referenced[name] {
+		return nil
+	}
+	if !isManifest {
+		referenced[name] = true
+		return nil
+	}
+
+	var manifest ManifestResponse
+	if err := readOCIBlob(dir, name, &manifest); err != nil {
+		return errors.Wrapf(err, "while reading manifest %s", name)
+	}
+	referenced[name] = true
+	if manifest.Config.Digest != "" {
+		referenced[manifest.Config.Digest] = true
+	}
+	for _, layer := range manifest.Layers {
+		referenced[layer.Digest] = true
+	}
+	for _, child := range manifest.Manifests {
+		err := markOCIBlob(dir, child.Digest, true, referenced)
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Str("repo", filepath.ToSlash(dir)).Str("digest", child.Digest).Err(err).Msg("child manifest is not found in blobs, skipping")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcOCIBlobs removing the blobs that are not referenced
+func gcOCIBlobs(dir string, referenced map[string]int) error {
+	blobsDir := filepath.Join(dir, ociBlobsDir)
+	algorithms, err := os.ReadDir(blobsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		blobs, err := os.ReadDir(filepath.Join(blobsDir, algorithm.Name()))
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			if blob.IsDir() || referenced[fmt.Sprintf("%s:%s", algorithm.Name(), blob.Name())] > 0 {
+				continue
+			}
+			blobPath := filepath.Join(blobsDir, algorithm.Name(), blob.Name())
+			log.Debug().Str("path", blobPath).Msg("removing unreferenced blob")
+			if err = os.Remove(blobPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func ociBlobPath(dir, name string) (string, error) {
+	matched := reOCIDigest.FindStringSubmatch(name)
+	if len(matched) != 3 {
+		return "", fmt.Errorf("invalid digest %s", name)
+	}
+	return filepath.Clean(filepath.Join(dir, ociBlobsDir, matched[1], matched[2])), nil
+}
+
+func readOCIBlob(dir, name string, obj any) error {
+	path, err := ociBlobPath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
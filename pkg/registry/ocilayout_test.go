@@ -0,0 +1,314 @@
+package registry_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// ociBlob writing the content into the blob store of image layout and returning it's descriptor
+func ociBlob(t *testing.T, dir, mediaType string, content any) reg.Descriptor {
+	data, ok := content.([]byte)
+	if !ok {
+		var err error
+		data, err = json.Marshal(content)
+		assert.NoError(t, err)
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", hash), data, 0o600))
+	return reg.Descriptor{MediaType: mediaType, Digest: "sha256:" + hash, Size: uint(len(data))}
+}
+
+// ociImage writing the image manifest, config and it's layers
+func ociImage(t *testing.T, dir string, created time.Time, layers ...string) reg.Descriptor {
+	manifest := map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     reg.MediaTypeOCIManifest,
+		"config":        ociBlob(t, dir, "application/vnd.oci.image.config.v1+json", map[string]any{"created": created}),
+	}
+	var descriptors []reg.Descriptor
+	for _, layer := range layers {
+		descriptors = append(descriptors, ociBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", []byte(layer)))
+	}
+	manifest["layers"] = descriptors
+	return ociBlob(t, dir, reg.MediaTypeOCIManifest, manifest)
+}
+
+// ociLayout writing oci-layout and index.json files, the tag is set as ref name annotation if it's not empty
+func ociLayout(t *testing.T, dir string, entries map[string][]reg.Descriptor, order ...string) {
+	manifests := []map[string]any{}
+	for _, tag := range order {
+		for _, desc := range entries[tag] {
+			entry := map[string]any{"mediaType": desc.MediaType, "digest": desc.Digest, "size": desc.Size}
+			if tag != "" {
+				entry["annotations"] = map[string]string{"org.opencontainers.image.ref.name": tag}
+			}
+			manifests = append(manifests, entry)
+		}
+	}
+	index, _ := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"manifests":     manifests,
+		"annotations":   map[string]string{"org.opencontainers.image.title": "mirror"},
+	})
+	assert.NoError(t, os.MkdirAll(dir, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), index, 0o600))
+}
+
+type ociFixture struct {
+	root, appDir string
+	image, old   reg.Descriptor
+	index        reg.Descriptor
+	sharedLayer  string
+	oldOnlyBlobs []string
+	imageSize    uint
+	oldImageSize uint
+	imageCreated time.Time
+	oldCreated   time.Time
+}
+
+// newOCIFixture image layout of team-a/app that has an image with 2 tags, untagged old image and an index of the image.
+// team-b/empty has no manifest and docs is not an image layout
+func newOCIFixture(t *testing.T) ociFixture {
+	f := ociFixture{
+		root:         t.TempDir(),
+		imageCreated: time.Date(2023, time.March, 10, 7, 15, 30, 0, time.UTC),
+		oldCreated:   time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+	}
+	f.appDir = filepath.Join(f.root, "team-a", "app")
+
+	f.image = ociImage(t, f.appDir, f.imageCreated, "shared-layer", "image-layer")
+	f.old = ociImage(t, f.appDir, f.oldCreated, "shared-layer", "old-layer")
+	indexChild := f.image
+	indexChild.Platform = &reg.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	f.index = ociBlob(t, f.appDir, reg.MediaTypeOCIIndex, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     reg.MediaTypeOCIIndex,
+		"manifests":     []reg.Descriptor{indexChild},
+	})
+
+	for _, desc := range []reg.Descriptor{f.image, f.old} {
+		data, err := os.ReadFile(filepath.Join(f.appDir, "blobs", "sha256", desc.Digest[len("sha256:"):]))
+		assert.NoError(t, err)
+		var manifest reg.ManifestResponse
+		assert.NoError(t, json.Unmarshal(data, &manifest))
+		size := manifest.Config.Size + manifest.Layers[0].Size + manifest.Layers[1].Size
+		if desc.Digest == f.image.Digest {
+			f.imageSize = size
+			f.sharedLayer = manifest.Layers[0].Digest
+		} else {
+			f.oldImageSize = size
+			f.oldOnlyBlobs = []string{desc.Digest, manifest.Config.Digest, manifest.Layers[1].Digest}
+		}
+	}
+
+	ociLayout(t, f.appDir, map[string][]reg.Descriptor{
+		"latest":     {f.image},
+		"v1.0.0":     {f.image},
+		"":           {f.old},
+		"multi-arch": {f.index},
+	}, "latest", "v1.0.0", "", "multi-arch")
+	ociLayout(t, filepath.Join(f.root, "team-b", "empty"), nil)
+	assert.NoError(t, os.MkdirAll(filepath.Join(f.root, "docs"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(f.root, "docs", "index.json"), []byte(`{}`), 0o600))
+	return f
+}
+
+func (f ociFixture) blobExists(digest string) bool {
+	_, err := os.Stat(filepath.Join(f.appDir, "blobs", "sha256", digest[len("sha256:"):]))
+	return err == nil
+}
+
+func TestNewOCILayout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	_, err := reg.NewOCILayout(file, nil, reg.Option{})
+	assert.EqualError(t, err, fmt.Sprintf("oci layout root %s is not a directory", file))
+
+	_, err = reg.NewOCILayout(filepath.Join(file, "not-exists"), nil, reg.Option{})
+	assert.ErrorContains(t, err, "while reading oci layout root")
+}
+
+func TestOCILayout_Catalog(t *testing.T) {
+	f := newOCIFixture(t)
+
+	ociReg, err := reg.NewOCILayout(f.root, nil, reg.Option{})
+	assert.NoError(t, err)
+	repositories, err := ociReg.Catalog(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []reg.Repository{{
+		Name: filepath.ToSlash(f.appDir),
+		Digests: []reg.Digest{
+			{
+				Name: f.image.Digest, ImageSizeBytes: f.imageSize, Tag: []string{"latest", "v1.0.0"}, Created: f.imageCreated, Uploaded: f.imageCreated,
+				MediaType: reg.MediaTypeOCIManifest, Platforms: []string{"linux/arm64/v8"}, ParentDigests: []string{f.index.Digest},
+			},
+			{Name: f.old.Digest, ImageSizeBytes: f.oldImageSize, Created: f.oldCreated, Uploaded: f.oldCreated, MediaType: reg.MediaTypeOCIManifest},
+			{
+				Name: f.index.Digest, ImageSizeBytes: f.imageSize, Tag: []string{"multi-arch"}, Created: f.imageCreated, Uploaded: f.imageCreated,
+				MediaType: reg.MediaTypeOCIIndex, Platforms: []string{"linux/arm64/v8"},
+			},
+		},
+	}}, repositories)
+
+	// the missing blob is reported
+	assert.NoError(t, os.Remove(filepath.Join(f.appDir, "blobs", "sha256", f.old.Digest[len("sha256:"):])))
+	_, err = ociReg.Catalog(context.Background())
+	assert.ErrorContains(t, err, fmt.Sprintf("while reading image layout %s: while reading manifest %s", filepath.ToSlash(f.appDir), f.old.Digest))
+}
+
+func TestOCILayout_CatalogMissingChild(t *testing.T) {
+	f := newOCIFixture(t)
+	ociLayout(t, f.appDir, map[string][]reg.Descriptor{"multi-arch": {f.index}}, "multi-arch")
+	assert.NoError(t, os.Remove(filepath.Join(f.appDir, "blobs", "sha256", f.image.Digest[len("sha256:"):])))
+
+	ociReg, err := reg.NewOCILayout(f.root, nil, reg.Option{})
+	assert.NoError(t, err)
+	repositories, err := ociReg.Catalog(context.Background())
+	assert.NoError(t, err)
+
+	// the missing platform manifest is skipped, so it has no size
+	assert.Equal(t, []reg.Repository{{
+		Name: filepath.ToSlash(f.appDir),
+		Digests: []reg.Digest{{
+			Name: f.index.Digest, Tag: []string{"multi-arch"}, MediaType: reg.MediaTypeOCIIndex, Platforms: []string{"linux/arm64/v8"},
+		}},
+	}}, repositories)
+}
+
+func TestOCILayout_Delete(t *testing.T) {
+	testCases := map[string]struct {
+		setup         func(t *testing.T, f ociFixture)
+		digests       func(f ociFixture) []reg.Digest
+		repoName      func(f ociFixture) string
+		expectErrMsg  func(f ociFixture) string
+		expectEntries func(f ociFixture) []string
+		expectRemoved func(f ociFixture) []string
+		expectKept    func(f ociFixture) []string
+	}{
+		"removing the entry and it's unreferenced blobs": {
+			digests:       func(f ociFixture) []reg.Digest { return []reg.Digest{{Name: f.old.Digest}} },
+			expectEntries: func(f ociFixture) []string { return []string{f.image.Digest, f.image.Digest, f.index.Digest} },
+			expectRemoved: func(f ociFixture) []string { return f.oldOnlyBlobs },
+			expectKept:    func(f ociFixture) []string { return []string{f.sharedLayer, f.image.Digest, f.index.Digest} },
+		},
+		"keeping the blobs that are referenced by the kept index": {
+			digests: func(f ociFixture) []reg.Digest {
+				return []reg.Digest{{Name: f.image.Digest, Tag: []string{"latest", "v1.0.0"}}}
+			},
+			expectEntries: func(f ociFixture) []string { return []string{f.old.Digest, f.index.Digest} },
+			expectKept:    func(f ociFixture) []string { return []string{f.image.Digest, f.sharedLayer, f.old.Digest} },
+		},
+		"removing all of entries": {
+			digests: func(f ociFixture) []reg.Digest {
+				return []reg.Digest{{Name: f.image.Digest}, {Name: f.old.Digest}, {Name: f.index.Digest}}
+			},
+			expectEntries: func(_ ociFixture) []string { return nil },
+			expectRemoved: func(f ociFixture) []string {
+				return append([]string{f.image.Digest, f.index.Digest, f.sharedLayer}, f.oldOnlyBlobs...)
+			},
+		},
+		"the child manifest that is missing in blobs is skipped": {
+			setup: func(t *testing.T, f ociFixture) {
+				ociLayout(t, f.appDir, map[string][]reg.Descriptor{"": {f.old}, "multi-arch": {f.index}}, "", "multi-arch")
+				assert.NoError(t, os.Remove(filepath.Join(f.appDir, "blobs", "sha256", f.image.Digest[len("sha256:"):])))
+			},
+			digests:       func(f ociFixture) []reg.Digest { return []reg.Digest{{Name: f.index.Digest}} },
+			expectEntries: func(f ociFixture) []string { return []string{f.old.Digest} },
+			expectRemoved: func(f ociFixture) []string { return []string{f.index.Digest} },
+			expectKept:    func(f ociFixture) []string { return append([]string{f.sharedLayer}, f.oldOnlyBlobs...) },
+		},
+		"not an image layout under the root": {
+			digests:  func(f ociFixture) []reg.Digest { return []reg.Digest{{Name: f.old.Digest}} },
+			repoName: func(f ociFixture) string { return filepath.ToSlash(filepath.Join(f.root, "docs")) },
+			expectErrMsg: func(f ociFixture) string {
+				return fmt.Sprintf("%s/docs is not an image layout under %s", filepath.ToSlash(f.root), f.root)
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			f := newOCIFixture(t)
+			repoName := filepath.ToSlash(f.appDir)
+			if tc.repoName != nil {
+				repoName = tc.repoName(f)
+			}
+			if tc.setup != nil {
+				tc.setup(t, f)
+			}
+
+			ociReg, err := reg.NewOCILayout(f.root, nil, reg.Option{})
+			assert.NoError(t, err)
+			err = ociReg.Delete(context.Background(), reg.Repository{Name: repoName, Digests: tc.digests(f)})
+			if tc.expectErrMsg != nil {
+				assert.EqualError(t, err, tc.expectErrMsg(f))
+				return
+			}
+			assert.NoError(t, err)
+
+			data, err := os.ReadFile(filepath.Join(f.appDir, "index.json"))
+			assert.NoError(t, err)
+			var index struct {
+				Manifests   []reg.OCIIndexEntry `json:"manifests"`
+				Annotations map[string]string   `json:"annotations"`
+			}
+			assert.NoError(t, json.Unmarshal(data, &index))
+			var entries []string
+			for _, entry := range index.Manifests {
+				entries = append(entries, entry.Digest)
+			}
+			assert.Equal(t, tc.expectEntries(f), entries)
+			// the other fields are kept
+			assert.Equal(t, map[string]string{"org.opencontainers.image.title": "mirror"}, index.Annotations)
+
+			if tc.expectRemoved != nil {
+				for _, digest := range tc.expectRemoved(f) {
+					assert.False(t, f.blobExists(digest), "blob %s must be removed", digest)
+				}
+			}
+			if tc.expectKept != nil {
+				for _, digest := range tc.expectKept(f) {
+					assert.True(t, f.blobExists(digest), "blob %s must be kept", digest)
+				}
+			}
+		})
+	}
+}
+
+func TestOCILayout_DeleteOneByOne(t *testing.T) {
+	f := newOCIFixture(t)
+	ociReg, err := reg.NewOCILayout(f.root, nil, reg.Option{})
+	assert.NoError(t, err)
+
+	// the digests are deleted one by one as it's done by the app, the referenced blobs are collected in the first deletion
+	repoName := filepath.ToSlash(f.appDir)
+	for _, digest := range []string{f.index.Digest, f.old.Digest} {
+		assert.NoError(t, ociReg.Delete(context.Background(), reg.Repository{Name: repoName, Digests: []reg.Digest{{Name: digest}}}))
+		assert.False(t, f.blobExists(digest))
+		assert.True(t, f.blobExists(f.sharedLayer))
+	}
+	for _, digest := range f.oldOnlyBlobs {
+		assert.False(t, f.blobExists(digest), "blob %s must be removed", digest)
+	}
+
+	assert.NoError(t, ociReg.Delete(context.Background(), reg.Repository{Name: repoName, Digests: []reg.Digest{{Name: f.image.Digest}}}))
+	for _, digest := range []string{f.image.Digest, f.sharedLayer} {
+		assert.False(t, f.blobExists(digest), "blob %s must be removed", digest)
+	}
+	entries, err := os.ReadDir(filepath.Join(f.appDir, "blobs", "sha256"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,215 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+)
+
+const quayPageSize = 100
+
+// QuayError is the error response of quay api
+type QuayError struct {
+	ErrorType    string `json:"error_type"`
+	ErrorMessage string `json:"error_message"`
+}
+
+func (e QuayError) Err() error {
+	if e.ErrorType == "" && e.ErrorMessage == "" {
+		return nil
+	}
+	return fmt.Errorf("[%s] [%s]", e.ErrorType, e.ErrorMessage)
+}
+
+type QuayRepository struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type QuayRepositoriesResponse struct {
+	Repositories []QuayRepository `json:"repositories"`
+	NextPage     string           `json:"next_page"`
+	QuayError
+}
+
+type QuayTag struct {
+	Name           string `json:"name"`
+	StartTS        int64  `json:"start_ts"`
+	ManifestDigest string `json:"manifest_digest"`
+	IsManifestList bool   `json:"is_manifest_list"`
+	// Size is null for the manifest list
+	Size *uint `json:"size"`
+}
+
+type QuayTagsResponse struct {
+	Tags          []QuayTag `json:"tags"`
+	Page          int       `json:"page"`
+	HasAdditional bool      `json:"has_additional"`
+	QuayError
+}
+
+// Quay is the quay.io or red hat quay registry that using it's rest api (/api/v1), the size of manifest list is
+// not provided so it's calculated from the registry manifest
+type Quay struct {
+	host      string
+	namespace string
+	prefix    string
+	apiURL    string
+	headers   map[string]string
+	hc        http.IHttpClient
+	registry  Generic
+}
+
+// NewQuay the host must be followed by namespace (organization or user) and it can be followed by repository prefix,
+// eg: quay.io/my-org or quay.io/my-org/team-a. the password is the oauth application token that is sent as bearer token
+// to the api, the same credential is used for the registry by $oauthtoken username
+func NewQuay(host string, hc http.IHttpClient, opt Option) (ImageRegistry, error) {
+	hostSplt := strings.SplitN(strings.Trim(host, "/"), "/", 3)
+	if len(hostSplt) == 1 {
+		return nil, fmt.Errorf("you must specified namespace after registry host, eg: quay.io/my-org")
+	}
+
+	q := &Quay{
+		host:      hostSplt[0],
+		namespace: hostSplt[1],
+		apiURL:    fmt.Sprintf("https://%s/api/v1", hostSplt[0]),
+		headers:   map[string]string{},
+		hc:        hc,
+		registry:  Generic{host: hostSplt[0], baseURL: fmt.Sprintf("https://%s", hostSplt[0]), hc: hc},
+	}
+	if len(hostSplt) == 3 {
+		q.prefix = strings.Trim(hostSplt[2], "/")
+	}
+	if opt.Endpoint != "" {
+		q.apiURL = strings.TrimSuffix(opt.Endpoint, "/")
+	}
+	if opt.Password != "" {
+		q.headers["Authorization"] = fmt.Sprintf("Bearer %s", opt.Password)
+	}
+	return q, nil
+}
+
+// Catalog list repositories of the namespace then it's active tags, the tags are grouped by manifest digest
+func (q Quay) Catalog(ctx context.Context) ([]Repository, error) {
+	var repoNames []string
+	pageURL := fmt.Sprintf("%s/repository?namespace=%s", q.apiURL, url.QueryEscape(q.namespace))
+	for nextPage := ""; ; {
+		reqURL := pageURL
+		if nextPage != "" {
+			reqURL = fmt.Sprintf("%s&next_page=%s", pageURL, url.QueryEscape(nextPage))
+		}
+
+		var obj QuayRepositoriesResponse
+		if _, err := callAPI(ctx, q.hc, http.Request{Method: nethttp.MethodGet, URL: reqURL, Headers: q.headers}, &obj); err != nil {
+			return nil, errors.Wrap(err, "while listing repositories")
+		}
+		for _, repo := range obj.Repositories {
+			repoNames = append(repoNames, repo.Name)
+		}
+		if nextPage = obj.NextPage; nextPage == "" {
+			break
+		}
+	}
+
+	var repositories []Repository
+	for _, repoName := range repoNames {
+		if q.prefix != "" && repoName != q.prefix && !strings.HasPrefix(repoName, q.prefix+"/") {
+			continue
+		}
+
+		log.Debug().Str("repo", repoName).Msg("processing")
+		digests, err := q.digests(ctx, repoName)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(digests) == 0 {
+			log.Debug().Str("repo", repoName).Msg("not found any digests found, skipping")
+			continue
+		}
+		repositories = append(repositories, Repository{Name: h.SlashJoin(q.host, q.namespace, repoName), Digests: digests})
+	}
+	return repositories, nil
+}
+
+// digests the tag's start time is when it's pushed, the earliest one is used for the digest that has multiple tags
+func (q Quay) digests(ctx context.Context, repoName string) ([]Digest, error) {
+	var tags []QuayTag
+	for page := 1; ; page++ {
+		tagsURL := fmt.Sprintf("%s/?onlyActiveTags=true&limit=%d&page=%d", q.url(repoName, "tag"), quayPageSize, page)
+		var obj QuayTagsResponse
+		if _, err := callAPI(ctx, q.hc, http.Request{Method: nethttp.MethodGet, URL: tagsURL, Headers: q.headers}, &obj); err != nil {
+			return nil, errors.Wrapf(err, "while listing tags of %s", repoName)
+		}
+		tags = append(tags, obj.Tags...)
+		if !obj.HasAdditional {
+			break
+		}
+	}
+
+	var digests []Digest
+	digestIndex := map[string]int{}
+	for _, tag := range tags {
+		pushed := time.Unix(tag.StartTS, 0).UTC()
+		if idx, ok := digestIndex[tag.ManifestDigest]; ok {
+			digests[idx].Tag = append(digests[idx].Tag, tag.Name)
+			if pushed.Before(digests[idx].Created) {
+				digests[idx].Created = pushed
+				digests[idx].Uploaded = pushed
+			}
+			continue
+		}
+
+		var size uint
+		if tag.Size != nil {
+			size = *tag.Size
+		} else if tag.IsManifestList {
+			var err error
+			if size, err = q.registry.size(ctx, h.SlashJoin(q.namespace, repoName), tag.ManifestDigest); err != nil {
+				return nil, err
+			}
+		}
+
+		digestIndex[tag.ManifestDigest] = len(digests)
+		digests = append(digests, Digest{
+			Name:           tag.ManifestDigest,
+			ImageSizeBytes: size,
+			Tag:            []string{tag.Name},
+			Created:        pushed,
+			Uploaded:       pushed,
+		})
+	}
+	return digests, nil
+}
+
+// Delete by deleting all tags of the digest, the manifest will be garbage collected by quay after the time machine expiration
+func (q Quay) Delete(ctx context.Context, repository Repository) error {
+	repoName := strings.TrimPrefix(repository.Name, fmt.Sprintf("%s/%s/", q.host, q.namespace))
+	for _, digest := range repository.Digests {
+		if len(digest.Tag) == 0 {
+			return fmt.Errorf("untagged digest %s@%s cannot be deleted through quay api", repository.Name, digest.Name)
+		}
+
+		for _, tag := range digest.Tag {
+			tagURL := h.SlashJoin(q.url(repoName, "tag"), url.PathEscape(tag))
+			log.Debug().Str("url", tagURL).Msg("deleting tag")
+			if err := deleteTag(ctx, q.hc, http.Request{Method: nethttp.MethodDelete, URL: tagURL, Headers: q.headers}, &QuayError{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (q Quay) url(repoName string, paths ...string) string {
+	return fmt.Sprintf("%s/repository/%s", q.apiURL, h.SlashJoin(append([]string{q.namespace, repoName}, paths...)...))
+}
@@ -0,0 +1,190 @@
+package registry_test
+
+import (
+	"context"
+	nethttp "net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+
+	hl "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	mh "github.com/iomarmochtar/cir-rotator/pkg/http/mock_http"
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	quayAPIURL   = "https://quay.io/api/v1"
+	quayRepoURL  = hl.SlashJoin(quayAPIURL, "repository")
+	quayTagQuery = "/?onlyActiveTags=true&limit=100&page="
+	quayDigests  = []string{
+		"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+		"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+	}
+)
+
+func TestQuay_Catalog(t *testing.T) {
+	imageSize := uint(1472 + 2811478 + 1048576)
+	expectedDigests := []reg.Digest{
+		// the earliest tag's push time is used
+		{
+			Name:           quayDigests[0],
+			ImageSizeBytes: 3861526,
+			Tag:            []string{"latest", "v1.0.0"},
+			Created:        time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+		},
+		// the manifest list size is the sum of it's platform images
+		{
+			Name:           quayDigests[1],
+			ImageSizeBytes: imageSize * 2,
+			Tag:            []string{"multi-arch"},
+			Created:        time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+			Uploaded:       time.Date(2023, time.January, 5, 8, 0, 0, 0, time.UTC),
+		},
+	}
+
+	successRoutes := func() map[string]mockRoute {
+		routes := map[string]mockRoute{
+			"GET " + quayRepoURL + "?namespace=my-org": jsonRoute(
+				reg.QuayRepositoriesResponse{Repositories: []reg.QuayRepository{{Namespace: "my-org", Name: "team-a/app"}}, NextPage: "gAAAAAB-page2"}, nil,
+			),
+			"GET " + quayRepoURL + "?namespace=my-org&next_page=gAAAAAB-page2": jsonRoute(
+				reg.QuayRepositoriesResponse{Repositories: []reg.QuayRepository{{Namespace: "my-org", Name: "team-b/web"}, {Namespace: "my-org", Name: "team-b/empty"}}}, nil,
+			),
+			"GET " + hl.SlashJoin(quayRepoURL, "my-org/team-a/app/tag") + quayTagQuery + "1": fixtureRoute("quay/tags.json", nethttp.StatusOK, nil),
+			// the tags are in multiple pages
+			"GET " + hl.SlashJoin(quayRepoURL, "my-org/team-b/web/tag") + quayTagQuery + "1": jsonRoute(
+				reg.QuayTagsResponse{Tags: []reg.QuayTag{{Name: "latest", StartTS: 1678435200, ManifestDigest: quayDigests[0], Size: new(uint)}}, Page: 1, HasAdditional: true}, nil,
+			),
+			"GET " + hl.SlashJoin(quayRepoURL, "my-org/team-b/web/tag") + quayTagQuery + "2": jsonRoute(
+				reg.QuayTagsResponse{Tags: []reg.QuayTag{{Name: "v1", StartTS: 1678348800, ManifestDigest: quayDigests[0], Size: new(uint)}}, Page: 2}, nil,
+			),
+			"GET " + hl.SlashJoin(quayRepoURL, "my-org/team-b/empty/tag") + quayTagQuery + "1": jsonRoute(reg.QuayTagsResponse{Page: 1}, nil),
+		}
+		for k, v := range manifestRoutes("https://quay.io/v2", "my-org/team-a/app") {
+			routes[k] = v
+		}
+		return routes
+	}
+
+	testCases := map[string]struct {
+		host               string
+		routes             func() map[string]mockRoute
+		expectErrMsg       string
+		expectRepositories []reg.Repository
+	}{
+		"unauthorized token": {
+			host: "quay.io/my-org",
+			routes: func() map[string]mockRoute {
+				return map[string]mockRoute{
+					"GET " + quayRepoURL + "?namespace=my-org": fixtureRoute("quay/error_unauthorized.json", nethttp.StatusUnauthorized, nil),
+				}
+			},
+			expectErrMsg: "while listing repositories: [invalid_token] [Unauthorized]",
+		},
+		"following pagination of repositories and tags": {
+			host:   "quay.io/my-org",
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "quay.io/my-org/team-a/app", Digests: expectedDigests},
+				{Name: "quay.io/my-org/team-b/web", Digests: []reg.Digest{{
+					Name:     quayDigests[0],
+					Tag:      []string{"latest", "v1"},
+					Created:  time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+					Uploaded: time.Date(2023, time.March, 9, 8, 0, 0, 0, time.UTC),
+				}}},
+			},
+		},
+		"only repositories under the prefix": {
+			host:   "quay.io/my-org/team-a",
+			routes: successRoutes,
+			expectRepositories: []reg.Repository{
+				{Name: "quay.io/my-org/team-a/app", Digests: expectedDigests},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			mockRoutes(mHc, tc.routes())
+
+			quay, err := reg.NewQuay(tc.host, mHc, reg.Option{Username: "$oauthtoken", Password: "oauth-secret"})
+			assert.NoError(t, err)
+			repositories, err := quay.Catalog(context.Background())
+
+			assert.Equal(t, tc.expectRepositories, repositories)
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestQuay_Delete(t *testing.T) {
+	tagURL := hl.SlashJoin(quayRepoURL, "my-org/team-a/app/tag")
+
+	testCases := map[string]struct {
+		digests        []reg.Digest
+		mockHTTPClient func(*mh.MockIHttpClient)
+		expectErrMsg   string
+	}{
+		"deleting all tags of digest by bearer token": {
+			digests: []reg.Digest{{Name: quayDigests[0], Tag: []string{"latest", "v1.0.0"}}, {Name: quayDigests[1], Tag: []string{"multi-arch"}}},
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				headers := map[string]string{"Authorization": "Bearer oauth-secret"}
+				gomock.InOrder(
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(tagURL, "latest"), Headers: headers}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusNoContent}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(tagURL, "v1.0.0"), Headers: headers}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusNoContent}, nil),
+					m.EXPECT().Do(gomock.Any(), http.Request{Method: nethttp.MethodDelete, URL: hl.SlashJoin(tagURL, "multi-arch"), Headers: headers}, gomock.Any()).
+						Times(1).Return(&http.Response{StatusCode: nethttp.StatusNoContent}, nil),
+				)
+			},
+		},
+		"tag is already deleted": {
+			digests: []reg.Digest{{Name: quayDigests[0], Tag: []string{"latest"}}},
+			mockHTTPClient: func(m *mh.MockIHttpClient) {
+				m.EXPECT().Do(gomock.Any(), gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(_ context.Context, _ http.Request, obj *reg.QuayError) (*http.Response, error) {
+					obj.ErrorType = "not_found"
+					obj.ErrorMessage = "Not Found"
+					return &http.Response{StatusCode: nethttp.StatusNotFound}, nil
+				})
+			},
+		},
+		"untagged digest": {
+			digests:        []reg.Digest{{Name: quayDigests[0]}},
+			mockHTTPClient: func(_ *mh.MockIHttpClient) {},
+			expectErrMsg:   "untagged digest quay.io/my-org/team-a/app@sha256:1111111111111111111111111111111111111111111111111111111111111111 cannot be deleted through quay api",
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mHc := mh.NewMockIHttpClient(ctrl)
+			tc.mockHTTPClient(mHc)
+
+			quay, err := reg.NewQuay("quay.io/my-org", mHc, reg.Option{Username: "$oauthtoken", Password: "oauth-secret"})
+			assert.NoError(t, err)
+
+			err = quay.Delete(context.Background(), reg.Repository{Name: "quay.io/my-org/team-a/app", Digests: tc.digests})
+			if tc.expectErrMsg != "" {
+				assert.EqualError(t, err, tc.expectErrMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
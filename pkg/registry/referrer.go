@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"regexp"
+	"time"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+)
+
+const (
+	ArtifactTypeCosignSignature   = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	ArtifactTypeCosignAttestation = "application/vnd.dev.cosign.artifact.att.v1+json"
+	ArtifactTypeCosignSBOM        = "application/vnd.dev.cosign.artifact.sbom.v1+json"
+	ArtifactTypeNotarySignature   = "application/vnd.cncf.notary.signature"
+
+	annotationCreated = "org.opencontainers.image.created"
+)
+
+var (
+	// reReferrerTag the tag schema of referrers, sha256-<hex> is the fallback of referrers api
+	// and the suffixed ones are cosign's signature, attestation and sbom
+	reReferrerTag       = regexp.MustCompile(`^([a-z0-9]+)-([a-f0-9]{64})(?:\.(sig|att|sbom))?$`)
+	cosignArtifactTypes = map[string]string{
+		"sig":  ArtifactTypeCosignSignature,
+		"att":  ArtifactTypeCosignAttestation,
+		"sbom": ArtifactTypeCosignSBOM,
+	}
+)
+
+// IsSignature the artifact is a signature of it's subject (cosign or notation)
+func (d Digest) IsSignature() bool {
+	return d.ArtifactType == ArtifactTypeCosignSignature || d.ArtifactType == ArtifactTypeNotarySignature
+}
+
+// LinkReferrers filling the referrers of digests in the same repository. the subject of artifact is either taken from
+// the referrers api (manifest subject) or the tag schema (sha256-<hex>[.sig|.att|.sbom]) as the fallback,
+// the orphaned artifact (the subject is not exist in repository) has no subject, so it can be deleted as the ordinary digest
+func LinkReferrers(digests []Digest) {
+	digestIndex := map[string]int{}
+	for idx := range digests {
+		digestIndex[digests[idx].Name] = idx
+	}
+
+	for idx := range digests {
+		digest := &digests[idx]
+		if digest.Subject != "" {
+			if _, ok := digestIndex[digest.Subject]; !ok {
+				digest.Subject = ""
+			}
+			continue
+		}
+		for _, tag := range digest.Tag {
+			matched := reReferrerTag.FindStringSubmatch(tag)
+			if len(matched) != 4 {
+				continue
+			}
+			if digest.ArtifactType == "" {
+				digest.ArtifactType = cosignArtifactTypes[matched[3]]
+			}
+			if subject := matched[1] + ":" + matched[2]; subject != digest.Name {
+				if _, ok := digestIndex[subject]; ok {
+					digest.Subject = subject
+				}
+			}
+			break
+		}
+	}
+
+	for _, digest := range digests {
+		idx, ok := digestIndex[digest.Subject]
+		if !ok {
+			continue
+		}
+		subject := &digests[idx]
+		if !h.IsInList(digest.Name, subject.Referrers) {
+			subject.Referrers = append(subject.Referrers, digest.Name)
+		}
+		if digest.IsSignature() {
+			subject.HasSignature = true
+		}
+	}
+}
+
+// referrerCreated the created time of artifact is taken from it's annotation if it's not available in config blob
+func referrerCreated(annotations map[string]string) time.Time {
+	created, err := time.Parse(time.RFC3339, annotations[annotationCreated])
+	if err != nil {
+		return time.Time{}
+	}
+	return created.UTC()
+}
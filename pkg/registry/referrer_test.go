@@ -0,0 +1,81 @@
+package registry_test
+
+import (
+	"testing"
+
+	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkReferrers(t *testing.T) {
+	imageDigest := "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	orphanedDigest := "sha256:9999999999999999999999999999999999999999999999999999999999999999"
+
+	testCases := map[string]struct {
+		digests        []reg.Digest
+		expectedResult []reg.Digest
+	}{
+		"cosign tag schema": {
+			digests: []reg.Digest{
+				{Name: imageDigest, Tag: []string{"latest"}},
+				{Name: "sha256:2222222222222222222222222222222222222222222222222222222222222222", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111.sig"}},
+				{Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111.att"}},
+			},
+			expectedResult: []reg.Digest{
+				{
+					Name: imageDigest, Tag: []string{"latest"}, HasSignature: true,
+					Referrers: []string{"sha256:2222222222222222222222222222222222222222222222222222222222222222", "sha256:3333333333333333333333333333333333333333333333333333333333333333"},
+				},
+				{
+					Name: "sha256:2222222222222222222222222222222222222222222222222222222222222222", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111.sig"},
+					Subject: imageDigest, ArtifactType: reg.ArtifactTypeCosignSignature,
+				},
+				{
+					Name: "sha256:3333333333333333333333333333333333333333333333333333333333333333", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111.att"},
+					Subject: imageDigest, ArtifactType: reg.ArtifactTypeCosignAttestation,
+				},
+			},
+		},
+		"the subject of orphaned artifact is not set": {
+			digests: []reg.Digest{
+				{Name: imageDigest, Tag: []string{"sha256-9999999999999999999999999999999999999999999999999999999999999999.sig"}},
+			},
+			expectedResult: []reg.Digest{
+				{Name: imageDigest, Tag: []string{"sha256-9999999999999999999999999999999999999999999999999999999999999999.sig"}, ArtifactType: reg.ArtifactTypeCosignSignature},
+			},
+		},
+		"referrers api and the fallback tag": {
+			digests: []reg.Digest{
+				{Name: imageDigest, Tag: []string{"v1.0.0"}},
+				{Name: "sha256:5555555555555555555555555555555555555555555555555555555555555555", Subject: imageDigest, ArtifactType: "application/spdx+json"},
+				{Name: "sha256:6666666666666666666666666666666666666666666666666666666666666666", Subject: "sha256:5555555555555555555555555555555555555555555555555555555555555555", ArtifactType: reg.ArtifactTypeNotarySignature},
+				{Name: "sha256:7777777777777777777777777777777777777777777777777777777777777777", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111"}, MediaType: reg.MediaTypeOCIIndex},
+				{Name: orphanedDigest, Subject: "sha256:8888888888888888888888888888888888888888888888888888888888888888"},
+			},
+			expectedResult: []reg.Digest{
+				{
+					Name: imageDigest, Tag: []string{"v1.0.0"},
+					Referrers: []string{"sha256:5555555555555555555555555555555555555555555555555555555555555555", "sha256:7777777777777777777777777777777777777777777777777777777777777777"},
+				},
+				{
+					Name: "sha256:5555555555555555555555555555555555555555555555555555555555555555", Subject: imageDigest, ArtifactType: "application/spdx+json",
+					Referrers: []string{"sha256:6666666666666666666666666666666666666666666666666666666666666666"}, HasSignature: true,
+				},
+				{Name: "sha256:6666666666666666666666666666666666666666666666666666666666666666", Subject: "sha256:5555555555555555555555555555555555555555555555555555555555555555", ArtifactType: reg.ArtifactTypeNotarySignature},
+				{Name: "sha256:7777777777777777777777777777777777777777777777777777777777777777", Tag: []string{"sha256-1111111111111111111111111111111111111111111111111111111111111111"}, MediaType: reg.MediaTypeOCIIndex, Subject: imageDigest},
+				{Name: orphanedDigest},
+			},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			reg.LinkReferrers(tc.digests)
+			assert.Equal(t, tc.expectedResult, tc.digests)
+
+			// linking it again is not duplicating the referrers
+			reg.LinkReferrers(tc.digests)
+			assert.Equal(t, tc.expectedResult, tc.digests)
+		})
+	}
+}
@@ -1,38 +1,118 @@
 package registry
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	nethttp "net/http"
 	"time"
 
 	"regexp"
 
 	"github.com/iomarmochtar/cir-rotator/pkg/http"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
 )
 
 const (
 	GoogleContainerRegistry = "gcr"
+	GoogleArtifactRegistry  = "artifactregistry"
+	GenericRegistry         = "generic"
+	HarborRegistry          = "harbor"
+	AmazonECR               = "ecr"
+	AzureContainerRegistry  = "acr"
+	GitHubContainerRegistry = "ghcr"
+	GitLabRegistry          = "gitlab"
+	QuayRegistry            = "quay"
+	DockerHubRegistry       = "dockerhub"
+	NexusRegistry           = "nexus"
+	ArtifactoryRegistry     = "artifactory"
+	OCILayoutRegistry       = "oci-layout"
+
+	// errCodeManifestUnknown the error code of distribution spec for the manifest or tag that is not found
+	errCodeManifestUnknown = "MANIFEST_UNKNOWN"
 )
 
 type (
-	oauthTokenSource func(saFilePath string) (oauth2.TokenSource, error)
-	registryGen      func(host string, httpClient http.IHttpClient) (ImageRegistry, error)
+	oauthTokenSource   func(saFilePath string) (oauth2.TokenSource, error)
+	refreshTokenSource func(allowInsecureSSL bool) (http.RefreshTokenSource, error)
+	registryGen        func(host string, httpClient http.IHttpClient, opt Option) (ImageRegistry, error)
 )
 
 var (
-	reGcrMatcher   = regexp.MustCompile(`([a-z]+\.)?(gcr\.io|pkg\.dev)`)
-	RegistryMapper = map[string]registryGen{
+	reGarMatcher    = regexp.MustCompile(`^[a-z0-9-]+-docker\.pkg\.dev(/|$)`)
+	reGcrMatcher    = regexp.MustCompile(`([a-z]+\.)?(gcr\.io|pkg\.dev)`)
+	reHarborMatcher = regexp.MustCompile(`^([a-z0-9-]+\.)*harbor\.`)
+	reEcrMatcher    = regexp.MustCompile(`\.dkr\.ecr(-fips)?\.[a-z0-9-]+\.amazonaws\.com`)
+	reAcrMatcher    = regexp.MustCompile(`^[a-z0-9]+\.azurecr\.(io|cn|us)`)
+	reGhcrMatcher   = regexp.MustCompile(`^ghcr\.io(/|$)`)
+	reGitlabMatcher = regexp.MustCompile(`^gitlab\.`)
+	reQuayMatcher   = regexp.MustCompile(`^quay\.`)
+	reHubMatcher    = regexp.MustCompile(`^(docker\.io|index\.docker\.io|registry-1\.docker\.io|hub\.docker\.com)(/|$)`)
+	reNexusMatcher  = regexp.MustCompile(`^nexus\.`)
+	reJfrogMatcher  = regexp.MustCompile(`^artifactory\.|^[a-z0-9-]+\.jfrog\.io(/|$)`)
+	RegistryMapper  = map[string]registryGen{
 		GoogleContainerRegistry: NewGCR,
+		GoogleArtifactRegistry:  NewArtifactRegistry,
+		GenericRegistry:         NewGeneric,
+		HarborRegistry:          NewHarbor,
+		AmazonECR:               NewECR,
+		AzureContainerRegistry:  NewACR,
+		GitHubContainerRegistry: NewGHCR,
+		GitLabRegistry:          NewGitLab,
+		QuayRegistry:            NewQuay,
+		DockerHubRegistry:       NewDockerHub,
+		NexusRegistry:           NewNexus,
+		ArtifactoryRegistry:     NewArtifactory,
+		OCILayoutRegistry:       NewOCILayout,
 	}
 	TokenSourceMapper = map[string]oauthTokenSource{
 		GoogleContainerRegistry: gcrOauthSource,
+		GoogleArtifactRegistry:  artifactRegistryOauthSource,
+	}
+	// RefreshTokenMapper the registries that exchanging the refresh token in bearer challenge if basic auth is not set
+	RefreshTokenMapper = map[string]refreshTokenSource{
+		AzureContainerRegistry: acrRefreshTokenSource,
+	}
+	// OwnClientRegistries are calling the api by their own client and credential chain, so the http client is not initiated
+	OwnClientRegistries = map[string]bool{
+		AmazonECR:         true,
+		OCILayoutRegistry: true,
 	}
 	SupportedContainerRegistryList = []string{
 		GoogleContainerRegistry,
+		GoogleArtifactRegistry,
+		GenericRegistry,
+		HarborRegistry,
+		AmazonECR,
+		AzureContainerRegistry,
+		GitHubContainerRegistry,
+		GitLabRegistry,
+		QuayRegistry,
+		DockerHubRegistry,
+		NexusRegistry,
+		ArtifactoryRegistry,
+		OCILayoutRegistry,
 	}
 )
 
+// Option is the additional parameters for initiating the image registry
+type Option struct {
+	// WorkerCount max of concurrent requests for the registry that is supporting parallel listing
+	WorkerCount int
+	// Endpoint custom api endpoint for the registry that is using it's own api (eg: ecr, artifactregistry, ghcr, gitlab, quay, dockerhub, nexus, artifactory),
+	// for generic registry it's the scheme and host of registry api (eg: http://registry.local:5000)
+	Endpoint string
+	// Username and Password the credential for the registry that is sending it in it's own auth header (eg: gitlab's token, quay's oauth token, docker hub login)
+	Username string
+	Password string
+	// Retry, RateLimit and AllowInsecure are applied to the client of registry that is calling the api by it's own client (eg: ecr),
+	// the other registries are using the http client that is already initiated by them
+	Retry         http.RetryOption
+	RateLimit     http.RateLimitOption
+	AllowInsecure bool
+}
+
 type ErrorField struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -42,12 +122,62 @@ type ErrorsField struct {
 	Errors []ErrorField `json:"errors"`
 }
 
+// Err returning the first error that found in response body if any
+func (e ErrorsField) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("[%s] [%s]", e.Errors[0].Code, e.Errors[0].Message)
+}
+
 type Digest struct {
 	ImageSizeBytes uint      `json:"size"`
 	Tag            []string  `json:"tags"`
 	Created        time.Time `json:"created"`
 	Uploaded       time.Time `json:""`
-	Name           string    `json:"digest"`
+	// Pulled the last pull time, it's not set if the registry is not providing it or never pulled
+	Pulled    *time.Time `json:"pulled,omitempty"`
+	Name      string     `json:"digest"`
+	MediaType string     `json:"media_type,omitempty"`
+	// Platforms the platform (os/arch[/variant]) of image, for index it's the platforms of it's child
+	Platforms []string `json:"platforms,omitempty"`
+	// ParentDigests the digests of index in the same repository that are referencing it
+	ParentDigests []string `json:"parent_digests,omitempty"`
+	// Subject the digest of image that is referred by this artifact (signature, sbom, attestation, etc)
+	Subject      string `json:"subject,omitempty"`
+	ArtifactType string `json:"artifact_type,omitempty"`
+	// Referrers the digests of artifacts in the same repository that are referring to it
+	Referrers    []string `json:"referrers,omitempty"`
+	HasSignature bool     `json:"has_signature,omitempty"`
+	// Locked the digest is locked for deletion in registry (eg: acr's deleteEnabled is false), it's listed for linking it's
+	// platform manifests but it's not deleted
+	Locked bool `json:"locked,omitempty"`
+}
+
+// IsIndex the digest is either docker's manifest list or oci image index
+func (d Digest) IsIndex() bool {
+	return d.MediaType == MediaTypeDockerManifestList || d.MediaType == MediaTypeOCIIndex
+}
+
+// IsTopLevel the digest is neither the platform manifest of an index nor the artifact that is referring to other digest
+func (d Digest) IsTopLevel() bool {
+	return len(d.ParentDigests) == 0 && d.Subject == ""
+}
+
+// PulledAt the last pull time, it's zero if it's not set
+func (d Digest) PulledAt() time.Time {
+	if d.Pulled == nil {
+		return time.Time{}
+	}
+	return *d.Pulled
+}
+
+// pulledTime the pull time of digest, the zero one is not set
+func pulledTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
 
 type Repository struct {
@@ -57,28 +187,107 @@ type Repository struct {
 
 //go:generate mockgen -destination mock_registry/mock_registry.go -source registry.go ImageRegistry
 type ImageRegistry interface {
-	Catalog() ([]Repository, error)
-	Delete(repo Repository) error
+	Catalog(ctx context.Context) ([]Repository, error)
+	Delete(ctx context.Context, repo Repository) error
 }
 
 // deleteImage shorthand for deleting image
-func deleteImage(hc http.IHttpClient, url string) (err error) {
+func deleteImage(ctx context.Context, hc http.IHttpClient, url string) (err error) {
+	var errResp ErrorsField
+	if err = hc.DeleteMarshalReturnObj(ctx, url, &errResp); err != nil {
+		return err
+	}
+
+	if len(errResp.Errors) != 0 {
+		return errors.New(errResp.Errors[0].Message)
+	}
+
+	return nil
+}
+
+// deleteImageTag deleting the tag by it's url, the tag that is not found is counted as deleted. it can be deleted by the
+// previous run that is stopped in the middle of the digest (eg: resumed by journal) then the rest of it can be continued
+func deleteImageTag(ctx context.Context, hc http.IHttpClient, url string) (err error) {
 	var errResp ErrorsField
-	if err = hc.DeleteMarshalReturnObj(url, &errResp); err != nil {
+	if err = hc.DeleteMarshalReturnObj(ctx, url, &errResp); err != nil {
 		return err
 	}
 
 	if len(errResp.Errors) != 0 {
+		if errResp.Errors[0].Code == errCodeManifestUnknown {
+			log.Debug().Str("url", url).Msg("tag is already deleted")
+			return nil
+		}
 		return errors.New(errResp.Errors[0].Message)
 	}
 
 	return nil
 }
 
+// deleteTag deleting the tag by the api request, the tag that is not found is counted as deleted as well as deleteImageTag
+func deleteTag(ctx context.Context, hc http.IHttpClient, r http.Request, obj errorResponse) error {
+	resp, err := hc.Do(ctx, r, obj)
+	if err != nil {
+		return err
+	}
+	return checkDeletedTag(resp, r, obj)
+}
+
+// checkDeletedTag returning the error of tag deletion response, the tag that is not found is not an error
+func checkDeletedTag(resp *http.Response, r http.Request, obj errorResponse) error {
+	if resp.StatusCode == nethttp.StatusNotFound {
+		log.Debug().Str("url", r.URL).Msg("tag is already deleted")
+		return nil
+	}
+
+	_, err := checkResponse(resp, r, obj)
+	return err
+}
+
 func GetImageRegistryTypeByHostname(host string) (string, error) {
+	if reGarMatcher.MatchString(host) {
+		return GoogleArtifactRegistry, nil
+	}
+
 	if reGcrMatcher.Match([]byte(host)) {
 		return GoogleContainerRegistry, nil
 	}
 
+	if reEcrMatcher.MatchString(host) {
+		return AmazonECR, nil
+	}
+
+	if reAcrMatcher.MatchString(host) {
+		return AzureContainerRegistry, nil
+	}
+
+	if reGhcrMatcher.MatchString(host) {
+		return GitHubContainerRegistry, nil
+	}
+
+	if reGitlabMatcher.MatchString(host) {
+		return GitLabRegistry, nil
+	}
+
+	if reQuayMatcher.MatchString(host) {
+		return QuayRegistry, nil
+	}
+
+	if reHubMatcher.MatchString(host) {
+		return DockerHubRegistry, nil
+	}
+
+	if reNexusMatcher.MatchString(host) {
+		return NexusRegistry, nil
+	}
+
+	if reJfrogMatcher.MatchString(host) {
+		return ArtifactoryRegistry, nil
+	}
+
+	if reHarborMatcher.MatchString(host) {
+		return HarborRegistry, nil
+	}
+
 	return "", fmt.Errorf("unknown matcher registry handler by host %s", host)
 }
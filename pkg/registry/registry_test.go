@@ -1,7 +1,9 @@
 package registry_test
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	reg "github.com/iomarmochtar/cir-rotator/pkg/registry"
 	"github.com/stretchr/testify/assert"
@@ -17,9 +19,53 @@ func TestGetImageRegistryTypeByHostname(t *testing.T) {
 			input:  "asia.gcr.io",
 			expect: reg.GoogleContainerRegistry,
 		},
-		"gcr: valid artifact registry domain": {
-			input:  "asia-southeast2-docker.pkg.dev",
-			expect: reg.GoogleContainerRegistry,
+		"artifactregistry: docker repository domain": {
+			input:  "asia-southeast2-docker.pkg.dev/gcp-proj/parent-repo",
+			expect: reg.GoogleArtifactRegistry,
+		},
+		"harbor: hostname": {
+			input:  "harbor.company.io",
+			expect: reg.HarborRegistry,
+		},
+		"harbor: sub domain with project": {
+			input:  "core.harbor.domain/library",
+			expect: reg.HarborRegistry,
+		},
+		"ecr: private registry": {
+			input:  "123456789012.dkr.ecr.ap-southeast-1.amazonaws.com/team-a",
+			expect: reg.AmazonECR,
+		},
+		"acr: azurecr.io": {
+			input:  "myregistry.azurecr.io/team-a",
+			expect: reg.AzureContainerRegistry,
+		},
+		"ghcr: with owner": {
+			input:  "ghcr.io/my-org",
+			expect: reg.GitHubContainerRegistry,
+		},
+		"gitlab: with group": {
+			input:  "gitlab.company.io/my-group",
+			expect: reg.GitLabRegistry,
+		},
+		"quay: with namespace": {
+			input:  "quay.io/my-org",
+			expect: reg.QuayRegistry,
+		},
+		"dockerhub: with namespace": {
+			input:  "docker.io/my-org",
+			expect: reg.DockerHubRegistry,
+		},
+		"nexus: with repository": {
+			input:  "nexus.company.io/docker-hosted",
+			expect: reg.NexusRegistry,
+		},
+		"artifactory: self hosted": {
+			input:  "artifactory.company.io/docker-local",
+			expect: reg.ArtifactoryRegistry,
+		},
+		"artifactory: jfrog cloud": {
+			input:  "company.jfrog.io/docker-local",
+			expect: reg.ArtifactoryRegistry,
 		},
 		"type generic": {
 			input:        "some.where.io",
@@ -38,3 +84,20 @@ func TestGetImageRegistryTypeByHostname(t *testing.T) {
 		})
 	}
 }
+
+func TestDigest_Pulled(t *testing.T) {
+	// the pull time is not written if it's not provided by the registry
+	data, err := json.Marshal(reg.Digest{Name: "sha256:1111"})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"pulled"`)
+	assert.True(t, reg.Digest{}.PulledAt().IsZero())
+
+	pulled := time.Date(2023, time.April, 1, 10, 0, 0, 0, time.UTC)
+	data, err = json.Marshal(reg.Digest{Name: "sha256:1111", Pulled: &pulled})
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"pulled":"2023-04-01T10:00:00Z"`)
+
+	var digest reg.Digest
+	assert.NoError(t, json.Unmarshal(data, &digest))
+	assert.Equal(t, pulled, digest.PulledAt())
+}
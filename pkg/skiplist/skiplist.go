@@ -0,0 +1,204 @@
+package skiplist
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	h "github.com/iomarmochtar/cir-rotator/pkg/helpers"
+)
+
+const (
+	// regexPrefix the prefix of rule that is a regular expression
+	regexPrefix = "regex:"
+	// globChars the wildcards of glob rule, * is matching any characters (including /) and ? is matching a character
+	globChars   = "*?"
+	commentChar = "#"
+)
+
+// errNoTag the rule that has no tag, it was never matched by the previous versions so it's ignored instead of failing the skip list
+var errNoTag = errors.New("has no tag")
+
+// Kind the syntax of skip rule
+type Kind string
+
+const (
+	// KindTag the image tag (repo:tag)
+	KindTag Kind = "tag"
+	// KindDigest the digest reference (repo@sha256:<hex>)
+	KindDigest Kind = "digest"
+	// KindGlob the wildcard of image tag or digest reference (eg: */base-image:*)
+	KindGlob Kind = "glob"
+	// KindRegex the regular expression of image tag or digest reference that is prefixed by regex:
+	KindRegex Kind = "regex"
+)
+
+// Rule a line in skip list
+type Rule struct {
+	Line int
+	Text string
+	Kind Kind
+
+	pattern *regexp.Regexp
+	// prefix the literal prefix of pattern, the image that is not started by it is not evaluated by the pattern
+	prefix string
+	// ref the image reference of tag or digest rule that is looked up, the tag of digest reference (repo:tag@sha256:<hex>) is removed
+	ref string
+}
+
+// String the rule and it's position for reporting which rule is matched
+func (r Rule) String() string {
+	return fmt.Sprintf("%s (line %d)", r.Text, r.Line)
+}
+
+// Matcher matching the image tags and digests with the skip rules. the tag and digest rules are looked up by their
+// image reference, the patterns are evaluated for the images that are started by their literal prefix only
+type Matcher struct {
+	rules    []Rule
+	refs     map[string]int
+	patterns []int
+}
+
+// ReadFile parse the skip list file
+func ReadFile(path string) (Matcher, error) {
+	lines, err := h.ReadLines(path)
+	if err != nil {
+		return Matcher{}, err
+	}
+	matcher, err := New(lines)
+	if err != nil {
+		return Matcher{}, fmt.Errorf("error while parsing skip list %s: %w", path, err)
+	}
+	return matcher, nil
+}
+
+// New parse the lines of skip list, the blank line, comment (started by #) and the rule without tag are ignored
+func New(lines []string) (Matcher, error) {
+	m := Matcher{refs: map[string]int{}}
+	for idx, line := range lines {
+		text := strings.TrimSpace(line)
+		// the comment after the rule must be separated by space
+		if pos := strings.Index(text, " "+commentChar); pos != -1 {
+			text = strings.TrimSpace(text[:pos])
+		}
+		if text == "" || strings.HasPrefix(text, commentChar) {
+			continue
+		}
+
+		rule, err := parseRule(idx+1, text)
+		if errors.Is(err, errNoTag) {
+			log.Warn().Int("line", idx+1).Str("rule", text).Msgf("rule has no tag, it must be repo:tag, repo@sha256:<hex>, glob or %s<pattern>. ignoring it", regexPrefix)
+			continue
+		}
+		if err != nil {
+			return Matcher{}, fmt.Errorf("invalid rule at line %d: %w", idx+1, err)
+		}
+		m.rules = append(m.rules, rule)
+		ruleIdx := len(m.rules) - 1
+		if rule.pattern != nil {
+			m.patterns = append(m.patterns, ruleIdx)
+		} else if _, exists := m.refs[rule.ref]; !exists {
+			m.refs[rule.ref] = ruleIdx
+		}
+	}
+	return m, nil
+}
+
+func parseRule(line int, text string) (Rule, error) {
+	rule := Rule{Line: line, Text: text}
+	switch {
+	case strings.HasPrefix(text, regexPrefix):
+		rule.Kind = KindRegex
+		expr := strings.TrimPrefix(text, regexPrefix)
+		unanchored, err := regexp.Compile(expr)
+		if err != nil {
+			return rule, err
+		}
+		// the literal prefix is not available if the expression is anchored
+		rule.prefix, _ = unanchored.LiteralPrefix()
+		rule.pattern = regexp.MustCompile("^(?:" + expr + ")$")
+	case strings.ContainsAny(text, globChars):
+		rule.Kind = KindGlob
+		rule.prefix = text[:strings.IndexAny(text, globChars)]
+		var expr strings.Builder
+		for _, char := range text {
+			switch char {
+			case '*':
+				expr.WriteString(".*")
+			case '?':
+				expr.WriteString(".")
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(char)))
+			}
+		}
+		rule.pattern = regexp.MustCompile("^" + expr.String() + "$")
+	case strings.Contains(text, "@"):
+		rule.Kind = KindDigest
+		repo, digest, _ := strings.Cut(text, "@")
+		if algorithm, hex, found := strings.Cut(digest, ":"); repo == "" || !found || algorithm == "" || hex == "" {
+			return rule, fmt.Errorf("%s is not a valid digest reference, it must be repo@sha256:<hex>", text)
+		}
+		// the tag is ignored since the digest is pinned, the colon before the first slash is the port of registry host
+		if idx := strings.LastIndex(repo, ":"); idx > strings.LastIndex(repo, "/") {
+			repo = repo[:idx]
+		}
+		rule.ref = repo + "@" + digest
+	default:
+		rule.Kind = KindTag
+		if idx := strings.LastIndex(text, ":"); idx <= strings.LastIndex(text, "/") || idx == len(text)-1 {
+			return rule, errNoTag
+		}
+		rule.ref = text
+	}
+	return rule, nil
+}
+
+// IsEmpty there is no rule in skip list
+func (m Matcher) IsEmpty() bool {
+	return len(m.rules) == 0
+}
+
+// Rules the parsed rules in the order of skip list
+func (m Matcher) Rules() []Rule {
+	return m.rules
+}
+
+// Match returning the first rule in skip list that is matched with the digest (repo@digest) or one of it's tags (repo:tag)
+func (m Matcher) Match(repository, digest string, tags []string) (Rule, bool) {
+	refs := make([]string, 0, len(tags)+1)
+	refs = append(refs, repository+"@"+digest)
+	for _, tag := range tags {
+		refs = append(refs, repository+":"+tag)
+	}
+
+	matched := -1
+	for _, ref := range refs {
+		if idx, ok := m.refs[ref]; ok && (matched == -1 || idx < matched) {
+			matched = idx
+		}
+	}
+	for _, idx := range m.patterns {
+		// the patterns are in the order of skip list, so the next one is not the first matched rule
+		if matched != -1 && idx > matched {
+			break
+		}
+		rule := m.rules[idx]
+		for _, ref := range refs {
+			if strings.HasPrefix(ref, rule.prefix) && rule.pattern.MatchString(ref) {
+				matched = idx
+				break
+			}
+		}
+		if matched == idx {
+			break
+		}
+	}
+
+	if matched == -1 {
+		return Rule{}, false
+	}
+	return m.rules[matched], true
+}
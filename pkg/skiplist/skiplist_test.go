@@ -0,0 +1,146 @@
+package skiplist_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/iomarmochtar/cir-rotator/pkg/skiplist"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFile(t *testing.T) {
+	matcher, err := skiplist.ReadFile(filepath.Join("..", "..", "testdata", "skip_list_rules.txt"))
+	assert.NoError(t, err)
+
+	var rules []string
+	var kinds []skiplist.Kind
+	for _, rule := range matcher.Rules() {
+		rules = append(rules, rule.String())
+		kinds = append(kinds, rule.Kind)
+	}
+	assert.Equal(t, []string{
+		"asia.gcr.io/parent/app:latest (line 3)",
+		"asia.gcr.io/parent/app@sha256:1111111111111111111111111111111111111111111111111111111111111111 (line 4)",
+		"*/base-image:* (line 5)",
+		`regex:asia\.gcr\.io/parent/.*:v[0-9]+\.[0-9]+\.[0-9]+-rc[0-9]+ (line 8)`,
+		"asia.gcr.io/parent/app:release-?? (line 9)",
+		"asia.gcr.io/parent/web:v1@sha256:3333333333333333333333333333333333333333333333333333333333333333 (line 10)",
+	}, rules)
+	assert.Equal(t, []skiplist.Kind{skiplist.KindTag, skiplist.KindDigest, skiplist.KindGlob, skiplist.KindRegex, skiplist.KindGlob, skiplist.KindDigest}, kinds)
+
+	_, err = skiplist.ReadFile(filepath.Join("..", "..", "testdata", "not_found.txt"))
+	assert.EqualError(t, err, "open ../../testdata/not_found.txt: no such file or directory")
+}
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		lines          []string
+		expectedErrMsg string
+	}{
+		"blank lines and comments only": {
+			lines: []string{"", "   ", "# comment", "  # indented comment"},
+		},
+		"invalid regex": {
+			lines:          []string{"# comment", "regex:app:v(1"},
+			expectedErrMsg: "invalid rule at line 2: error parsing regexp: missing closing ): `app:v(1`",
+		},
+		"invalid digest reference": {
+			lines:          []string{"asia.gcr.io/parent/app@latest"},
+			expectedErrMsg: "invalid rule at line 1: asia.gcr.io/parent/app@latest is not a valid digest reference, it must be repo@sha256:<hex>",
+		},
+		"rule without tag is ignored": {
+			lines: []string{"registry.local:5000/app", "registry.local:5000/app:"},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			matcher, err := skiplist.New(tc.lines)
+			if tc.expectedErrMsg != "" {
+				assert.EqualError(t, err, tc.expectedErrMsg)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, matcher.IsEmpty())
+		})
+	}
+}
+
+func TestMatcher_Match(t *testing.T) {
+	matcher, err := skiplist.ReadFile(filepath.Join("..", "..", "testdata", "skip_list_rules.txt"))
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		repository   string
+		digest       string
+		tags         []string
+		expectedRule string
+	}{
+		"matched by tag": {
+			repository:   "asia.gcr.io/parent/app",
+			digest:       "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:         []string{"v1", "latest"},
+			expectedRule: "asia.gcr.io/parent/app:latest (line 3)",
+		},
+		"matched by digest reference without any tag": {
+			repository:   "asia.gcr.io/parent/app",
+			digest:       "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			expectedRule: "asia.gcr.io/parent/app@sha256:1111111111111111111111111111111111111111111111111111111111111111 (line 4)",
+		},
+		"matched by digest reference that has tag": {
+			repository:   "asia.gcr.io/parent/web",
+			digest:       "sha256:3333333333333333333333333333333333333333333333333333333333333333",
+			tags:         []string{"v2"},
+			expectedRule: "asia.gcr.io/parent/web:v1@sha256:3333333333333333333333333333333333333333333333333333333333333333 (line 10)",
+		},
+		"the first rule in skip list is reported": {
+			repository:   "asia.gcr.io/parent/app",
+			digest:       "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			tags:         []string{"release-01", "latest"},
+			expectedRule: "asia.gcr.io/parent/app:latest (line 3)",
+		},
+		"glob is matching any characters": {
+			repository:   "asia.gcr.io/parent/team-a/base-image",
+			digest:       "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:         []string{"ubuntu-22.04"},
+			expectedRule: "*/base-image:* (line 5)",
+		},
+		"matched by regex": {
+			repository:   "asia.gcr.io/parent/worker",
+			digest:       "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:         []string{"v1.2.0-rc1"},
+			expectedRule: `regex:asia\.gcr\.io/parent/.*:v[0-9]+\.[0-9]+\.[0-9]+-rc[0-9]+ (line 8)`,
+		},
+		"regex must match the whole reference": {
+			repository: "asia.gcr.io/parent/worker",
+			digest:     "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:       []string{"v1.2.0-rc1-hotfix"},
+		},
+		"glob with single character wildcard": {
+			repository:   "asia.gcr.io/parent/app",
+			digest:       "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:         []string{"release-01"},
+			expectedRule: "asia.gcr.io/parent/app:release-?? (line 9)",
+		},
+		"not matched": {
+			repository: "asia.gcr.io/parent/app",
+			digest:     "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			tags:       []string{"release-123", "v1"},
+		},
+		"the same tag in the other repository": {
+			repository: "asia.gcr.io/other/app",
+			digest:     "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			tags:       []string{"latest"},
+		},
+	}
+
+	for title, tc := range testCases {
+		t.Run(title, func(t *testing.T) {
+			rule, matched := matcher.Match(tc.repository, tc.digest, tc.tags)
+			assert.Equal(t, tc.expectedRule != "", matched)
+			if matched {
+				assert.Equal(t, tc.expectedRule, rule.String())
+			}
+		})
+	}
+}